@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+	util "github.com/yradsmikham/bedrock-cli/util"
+)
+
+// Ways terraform and the CLI can authenticate with Azure
+const (
+	AUTHSP   = "sp"   // Service principal with a client secret
+	AUTHCLI  = "cli"  // Account logged in with `az login`
+	AUTHMSI  = "msi"  // Managed identity of the machine running the CLI
+	AUTHOIDC = "oidc" // Workload identity federation
+)
+
+var authMode = AUTHSP
+var createSP bool
+var spRole string
+var spScope string
+
+// newServicePrincipal creates the service principal of an environment, replaced in tests
+var newServicePrincipal = util.CreateServicePrincipal
+
+// servicePrincipalFile records the service principal created with --create-sp in the directory of the
+// environments using it, it is deleted once none of them is deployed anymore
+const servicePrincipalFile = "service-principal.json"
+
+// createdServicePrincipal is the service principal created by the running Init, until it is recorded
+var createdServicePrincipal *JournalEntry
+
+// Variables telling the terraform azurerm provider how to authenticate
+var authVariables = map[string]string{
+	AUTHCLI:  "ARM_USE_CLI",
+	AUTHMSI:  "ARM_USE_MSI",
+	AUTHOIDC: "ARM_USE_OIDC",
+}
+
+// Variables holding an OIDC token (or a way to request one) for workload identity federation
+var oidcTokenVariables = []string{"ARM_OIDC_TOKEN", "ARM_OIDC_TOKEN_FILE_PATH", "ACTIONS_ID_TOKEN_REQUEST_URL", "SYSTEM_OIDCREQUESTURI"}
+
+func validateAuthMode(mode string) (err error) {
+	switch mode {
+	case AUTHSP, AUTHCLI, AUTHMSI, AUTHOIDC:
+		return err
+	}
+	return errors.New("Unsupported authentication mode '" + mode + "', use sp, cli, msi or oidc")
+}
+
+// setAuthEnvironment sets the ARM_USE_* variable of the authentication mode for terraform
+func setAuthEnvironment(mode string) {
+	for authentication, variable := range authVariables {
+		if authentication == mode {
+			os.Setenv(variable, "true")
+		} else {
+			os.Unsetenv(variable)
+		}
+	}
+}
+
+// verifyAuthVariables looks up the credentials of an authentication mode other than a service principal.
+// No client secret is required, --sp and --secret are only used for the identity of the AKS clusters.
+func verifyAuthVariables(mode string) (err error) {
+	if value := os.Getenv("ARM_SUBSCRIPTION_ID"); value != "" {
+		subscription = value
+	}
+	if value := os.Getenv("ARM_TENANT_ID"); value != "" {
+		tenant = value
+	}
+
+	switch mode {
+	case AUTHCLI:
+		// Default to the subscription and tenant of the Azure CLI login
+		if subscription == "" || tenant == "" {
+			account, err := showAzureAccount(NewDoctorContext())
+			if err != nil {
+				log.Error(emoji.Sprintf(":confounded: The Azure CLI is not logged in. Please run 'az login' before creating the environment."))
+				return err
+			}
+			if subscription == "" {
+				subscription = account.ID
+			}
+			if tenant == "" {
+				tenant = account.TenantID
+			}
+		}
+		log.Info(emoji.Sprintf(":key: Authenticating with the Azure CLI login"))
+	case AUTHMSI:
+		if os.Getenv("ARM_CLIENT_ID") != "" {
+			log.Info(emoji.Sprintf(":key: Authenticating with the user assigned managed identity %s", os.Getenv("ARM_CLIENT_ID")))
+		} else {
+			log.Info(emoji.Sprintf(":key: Authenticating with the system assigned managed identity"))
+		}
+	case AUTHOIDC:
+		if os.Getenv("ARM_CLIENT_ID") == "" {
+			log.Error(emoji.Sprintf(":confounded: Workload identity federation requires the ARM_CLIENT_ID environment variable of the federated application."))
+			return fmt.Errorf("ARM_CLIENT_ID needs to be specified for oidc authentication")
+		}
+		found := false
+		for _, variable := range oidcTokenVariables {
+			if os.Getenv(variable) != "" {
+				found = true
+			}
+		}
+		if !found {
+			log.Warn(emoji.Sprintf(":warning: No OIDC token was found in ARM_OIDC_TOKEN or ARM_OIDC_TOKEN_FILE_PATH, terraform may be unable to authenticate"))
+		}
+		log.Info(emoji.Sprintf(":key: Authenticating with workload identity federation for %s", os.Getenv("ARM_CLIENT_ID")))
+	}
+
+	if subscription == "" {
+		log.Error(emoji.Sprintf(":confounded: A Subscription environment variable was not found. Please specify the ARM_SUBSCRIPTION_ID environment variable, or use the --subscription argument when creating the environment."))
+		return fmt.Errorf("A Subscription ID needs to be specified")
+	}
+	if tenant == "" {
+		log.Error(emoji.Sprintf(":confounded: A Tenant ID environment variable was not found. Please specify the ARM_TENANT_ID environment variable, or use the --tenant argument when creating the environment."))
+		return fmt.Errorf("A Tenant ID needs to be specified")
+	}
+	os.Setenv("ARM_SUBSCRIPTION_ID", subscription)
+	os.Setenv("ARM_TENANT_ID", tenant)
+
+	// A client secret in the environment would make terraform use it instead of the selected mode
+	if os.Getenv("ARM_CLIENT_SECRET") != "" {
+		log.Warn(emoji.Sprintf(":warning: ARM_CLIENT_SECRET is set but will be ignored with --auth %s", mode))
+		os.Unsetenv("ARM_CLIENT_SECRET")
+	}
+	setAuthEnvironment(mode)
+	return err
+}
+
+// clusterServicePrincipal checks the service principal fields the templates need for the AKS clusters.
+// Fields the template does not declare are left out of the tfvars.
+func clusterServicePrincipal(config map[string]string, envPath string) (err error) {
+	for _, variable := range []string{"service_principal_id", "service_principal_secret"} {
+		value, ok := config[variable]
+		if !ok || value != "\"\"" {
+			continue
+		}
+		if templateAcceptsVariable(envPath, variable) {
+			log.Error(emoji.Sprintf(":confounded: The template needs a service principal for the AKS cluster. Please use the --sp and --secret arguments with --auth %s.", authMode))
+			return errors.New("A Service Principal for the AKS cluster needs to be specified")
+		}
+		delete(config, variable)
+	}
+	return err
+}
+
+// defaultServicePrincipalRole is the role an environment needs. The keyvault and multi-cluster
+// environments assign roles to the cluster identities, which requires Owner.
+func defaultServicePrincipalRole(envType string) string {
+	if envType == SIMPLE {
+		return "Contributor"
+	}
+	return "Owner"
+}
+
+// createServicePrincipal creates the service principal used by the AKS clusters of the environment
+// and records it in the creation journal. The service principal of an existing environment is reused.
+func createServicePrincipal(clusterName string, envType string) (err error) {
+	// The credential is in the service principal settings, or only in the tfvars with --auth other than sp
+	envPath := "bedrock/cluster/environments/" + clusterName + "/" + envType
+	for file, keys := range map[string][2]string{
+		"/bedrock-sp-config.toml": {"service_principal", "secret"},
+		"/bedrock-config.tfvars":  {"service_principal_id", "service_principal_secret"},
+	} {
+		if !fileExists(envPath + file) {
+			continue
+		}
+		config, err := ReadTfvarsFile(envPath + file)
+		if err != nil {
+			return err
+		}
+		id, password := strings.Trim(config[keys[0]], "\""), strings.Trim(config[keys[1]], "\"")
+		if id != "" && password != "" {
+			log.Info(emoji.Sprintf(":recycle: Reusing Service Principal %s of the existing environment", id))
+			servicePrincipal, secret = id, password
+			return err
+		}
+	}
+
+	role := spRole
+	if role == "" {
+		role = defaultServicePrincipalRole(envType)
+	}
+	scope := spScope
+	if scope == "" {
+		scope = "/subscriptions/" + subscription
+	}
+
+	sp, err := newServicePrincipal(resourceName("service-principal", clusterName), role, scope)
+	if err != nil {
+		return err
+	}
+	journal.Record(SERVICEPRINCIPAL, sp.AppID, scope)
+	createdServicePrincipal = &JournalEntry{Type: SERVICEPRINCIPAL, Name: sp.AppID, Scope: scope, Status: CREATED, Time: time.Now()}
+
+	// The credential is stored with the other service principal settings of the environment
+	servicePrincipal = sp.AppID
+	secret = sp.Password
+	if tenant == "" {
+		tenant = sp.Tenant
+	}
+	return err
+}
+
+// recordServicePrincipal records the service principal created by the running Init in the directory of its environments
+func recordServicePrincipal(directory string) (err error) {
+	if createdServicePrincipal == nil {
+		return err
+	}
+	content, err := json.MarshalIndent(createdServicePrincipal, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(directory+"/"+servicePrincipalFile, content, 0644); err != nil {
+		return err
+	}
+	createdServicePrincipal = nil
+	return err
+}
+
+// deleteServicePrincipal deletes the service principal created for the environments of a directory once none
+// of them is deployed anymore, terraform may still need it to destroy the others
+func deleteServicePrincipal(directory string) (err error) {
+	path := directory + "/" + servicePrincipalFile
+	if !fileExists(path) {
+		return err
+	}
+	environments, err := environmentsIn(directory)
+	if err != nil {
+		return err
+	}
+	for _, environment := range environments {
+		if fileExists(directory + "/" + environment.Name() + "/" + deployedTfvars) {
+			return err
+		}
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var entry JournalEntry
+	if err := json.Unmarshal(content, &entry); err != nil {
+		return err
+	}
+	log.Info(emoji.Sprintf(":boom: Deleting Service Principal %s created for %s", entry.Name, directory))
+	if err := deleteJournalEntry(entry); err != nil {
+		return fmt.Errorf("Could not delete Service Principal %s: %s", entry.Name, err)
+	}
+	return os.Remove(path)
+}
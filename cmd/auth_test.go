@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	util "github.com/yradsmikham/bedrock-cli/util"
+)
+
+func TestAuthEnvironment(t *testing.T) {
+	defer os.Unsetenv("ARM_USE_MSI")
+	defer os.Unsetenv("ARM_USE_OIDC")
+
+	setAuthEnvironment(AUTHOIDC)
+	if os.Getenv("ARM_USE_OIDC") != "true" || os.Getenv("ARM_USE_MSI") != "" {
+		t.Error("Expected only ARM_USE_OIDC to be set")
+	}
+	setAuthEnvironment(AUTHMSI)
+	if os.Getenv("ARM_USE_MSI") != "true" || os.Getenv("ARM_USE_OIDC") != "" {
+		t.Error("Expected only ARM_USE_MSI to be set")
+	}
+	setAuthEnvironment(AUTHSP)
+	if os.Getenv("ARM_USE_MSI") != "" {
+		t.Error("Expected ARM_USE_MSI to be cleared for service principals")
+	}
+
+	if err := validateAuthMode("password"); err == nil {
+		t.Error("Expected an unsupported authentication mode to be rejected")
+	}
+}
+
+func TestVerifyManagedIdentity(t *testing.T) {
+	for _, variable := range []string{"ARM_SUBSCRIPTION_ID", "ARM_TENANT_ID", "ARM_CLIENT_SECRET", "ARM_USE_MSI"} {
+		defer os.Setenv(variable, os.Getenv(variable))
+	}
+	defer func() { subscription, tenant, authMode = "", "", AUTHSP }()
+	os.Setenv("ARM_SUBSCRIPTION_ID", "sub")
+	os.Setenv("ARM_TENANT_ID", "tenant")
+	os.Setenv("ARM_CLIENT_SECRET", "leftover")
+
+	// No client id or secret is needed with a managed identity
+	authMode = AUTHMSI
+	if err := VerifyEnvVariables("cluster", SIMPLE); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("ARM_USE_MSI") != "true" || os.Getenv("ARM_CLIENT_SECRET") != "" {
+		t.Error("Expected ARM_USE_MSI to be set and the client secret to be ignored")
+	}
+	if subscription != "sub" || tenant != "tenant" {
+		t.Errorf("Unexpected subscription %s and tenant %s", subscription, tenant)
+	}
+
+	// The service principal settings file does not contain a secret
+	config := map[string]string{}
+	servicePrincipalTemplate(config)
+	if _, ok := config["secret"]; ok || config["auth"] != "\"msi\"" {
+		t.Errorf("Unexpected service principal settings %v", config)
+	}
+}
+
+func TestClusterServicePrincipal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Fields the template does not declare are dropped
+	config := map[string]string{"service_principal_id": "\"\"", "service_principal_secret": "\"\""}
+	if err := clusterServicePrincipal(config, dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(config) != 0 {
+		t.Errorf("Expected the empty service principal fields to be removed, got %v", config)
+	}
+
+	// Fields the template requires must be given with --sp and --secret
+	ioutil.WriteFile(dir+"/variables.tf", []byte("variable \"service_principal_id\" {\n  type = string\n}\n"), 0644)
+	config = map[string]string{"service_principal_id": "\"\""}
+	if err := clusterServicePrincipal(config, dir); err == nil {
+		t.Error("Expected a missing AKS service principal to fail")
+	}
+	config = map[string]string{"service_principal_id": "\"app-id\""}
+	if err := clusterServicePrincipal(config, dir); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCreateServicePrincipal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-sp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var created []string
+	defer func(create func(string, string, string) (util.ServicePrincipal, error)) { newServicePrincipal = create }(newServicePrincipal)
+	newServicePrincipal = func(name string, role string, scope string) (util.ServicePrincipal, error) {
+		created = append(created, name+" "+role+" "+scope)
+		return util.ServicePrincipal{AppID: "app-id", Password: "password", Tenant: "tenant-id"}, nil
+	}
+	defer func() { subscription, tenant, servicePrincipal, secret, journal = "", "", "", "", nil }()
+	subscription = "sub"
+	journal = &Journal{Path: dir + "/journal.json", Cluster: "test"}
+
+	if err := createServicePrincipal("test", KEYVAULT); err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 1 || created[0] != "bedrock-test Owner /subscriptions/sub" {
+		t.Errorf("Unexpected service principal creation %v", created)
+	}
+	if servicePrincipal != "app-id" || secret != "password" || tenant != "tenant-id" {
+		t.Errorf("The credential was not stored: %s %s %s", servicePrincipal, secret, tenant)
+	}
+	if len(journal.Entries) != 1 || journal.Entries[0].Type != SERVICEPRINCIPAL || journal.Entries[0].Name != "app-id" {
+		t.Errorf("The service principal was not recorded in the journal: %+v", journal.Entries)
+	}
+
+	// Cleanup deletes the role assignments and the application of the service principal
+	defer func(runner func(string, ...string) ([]byte, error)) { journalRunner = runner }(journalRunner)
+	var commands []string
+	journalRunner = func(name string, args ...string) ([]byte, error) {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		return []byte{}, nil
+	}
+	if err := Cleanup(journal); err != nil || strings.Join(commands, ", ") != "az role assignment delete --assignee app-id --scope /subscriptions/sub, az ad app delete --id app-id" {
+		t.Errorf("Unexpected cleanup %v %v", commands, err)
+	}
+
+	// The service principal is kept with the environments until the last deployed one is destroyed
+	if err := recordServicePrincipal(dir); err != nil || createdServicePrincipal != nil {
+		t.Fatalf("The service principal was not recorded: %v", err)
+	}
+	os.MkdirAll(dir+"/"+SIMPLE, os.ModePerm)
+	ioutil.WriteFile(dir+"/"+SIMPLE+"/"+deployedTfvars, []byte{}, 0644)
+	commands = nil
+	if err := deleteServicePrincipal(dir); err != nil || len(commands) != 0 {
+		t.Errorf("Expected the service principal of a deployed environment to be kept, got %v %v", commands, err)
+	}
+	os.Remove(dir + "/" + SIMPLE + "/" + deployedTfvars)
+	if err := deleteServicePrincipal(dir); err != nil || len(commands) != 2 || fileExists(dir+"/"+servicePrincipalFile) {
+		t.Errorf("Expected the service principal to be deleted with the last environment, got %v %v", commands, err)
+	}
+	if defaultServicePrincipalRole(SIMPLE) != "Contributor" {
+		t.Error("The simple environment only needs the Contributor role")
+	}
+}
@@ -19,13 +19,13 @@ func commonInfra(servicePrincipal string, storageAccount string, accessKey strin
 		return error
 	}
 
-	log.Info(emoji.Sprintf(":white_check_mark: To proceed, run 'bedrock simulate bedrock/cluster/environments/" + commonInfraName + "'"))
+	log.Info(emoji.Sprint(":white_check_mark: To proceed, run 'bedrock simulate bedrock/cluster/environments/" + commonInfraName + "'"))
 
 	return err
 }
 
 var commonInfraCmd = &cobra.Command{
-	Use:   COMMON + " [--subscription subscription-id] [--sp service-principal-app-id] [--secret service-principal-password] [--tenant serice-principal-tenant-id] [--storage-account storage-account-name] [--access-key access-key] [--container-name storage-container-name] [--cluster-name name-of-AKS-cluster] [--region region-of-resource] [--keyvault name-of-keyvault] [--keyvault-rg name-of-resource-group-for-keyvault] [--address-space address-space] [--subnet-prefix subnet-prefixes]",
+	Use:   COMMON + " [--subscription subscription-id] [--sp service-principal-app-id] [--secret service-principal-password] [--tenant serice-principal-tenant-id] [--auth sp|cli|msi|oidc] [--create-sp] [--sp-role role] [--sp-scope scope] [--storage-account storage-account-name] [--access-key access-key] [--container-name storage-container-name] [--cluster-name name-of-AKS-cluster] [--region region-of-resource] [--keyvault name-of-keyvault] [--keyvault-rg name-of-resource-group-for-keyvault] [--address-space address-space] [--subnet-prefix subnet-prefixes] [--rollback-on-failure] [--tag key=value] [--name-prefix prefix] [--name-suffix suffix] [--name-environment environment] [--name-pattern role=pattern] [--check-names]",
 	Short: "Deploys the Bedrock Common Infra Environment",
 	Long:  `Deploys the Bedrock Common Infra Environment`,
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
@@ -40,6 +40,10 @@ func init() {
 	commonInfraCmd.Flags().StringVar(&secret, "secret", "", "Password for  Service Principal")
 	commonInfraCmd.Flags().StringVar(&subscription, "subscription", "", "Azure Subscription ID")
 	commonInfraCmd.Flags().StringVar(&tenant, "tenant", "", "Tenant ID for the Service Principal")
+	commonInfraCmd.Flags().StringVar(&authMode, "auth", AUTHSP, "How terraform authenticates with Azure: sp (service principal secret), cli (az login), msi (managed identity) or oidc (workload identity federation)")
+	commonInfraCmd.Flags().BoolVar(&createSP, "create-sp", false, "Create a service principal for the environment when none is given")
+	commonInfraCmd.Flags().StringVar(&spRole, "sp-role", "", "Role assigned to the created service principal (defaults to Owner, or Contributor for azure-simple)")
+	commonInfraCmd.Flags().StringVar(&spScope, "sp-scope", "", "Scope of the role assigned to the created service principal (defaults to the subscription)")
 	commonInfraCmd.Flags().StringVar(&storageAccount, "storage-account", "", "Storage Account Name")
 	commonInfraCmd.Flags().StringVar(&accessKey, "access-key", "", "Acces Key for the Storage Account")
 	commonInfraCmd.Flags().StringVar(&containerName, "container-name", "", "Storage Container Name")
@@ -48,5 +52,12 @@ func init() {
 	commonInfraCmd.Flags().StringVar(&addressSpace, "address-space", "10.39.0.0/24", "CIDR for cluster address space")
 	commonInfraCmd.Flags().StringVar(&subnetPrefix, "subnet-prefix", "10.39.0.0/24", "Subnet prefixes")
 	commonInfraCmd.Flags().StringVar(&keyvaultName, "keyvault", "", "Name of Key Vault")
+	commonInfraCmd.Flags().StringArrayVar(&tagFlags, "tag", []string{}, "Tag to apply to the resources created, as key=value (can be repeated)")
+	commonInfraCmd.Flags().BoolVar(&rollbackOnFailure, "rollback-on-failure", false, "Delete the resources created so far if the environment creation fails")
+	commonInfraCmd.Flags().StringVar(&namePrefix, "name-prefix", "", "Prefix added to the names of the Azure resources created")
+	commonInfraCmd.Flags().StringVar(&nameSuffix, "name-suffix", "", "Suffix added to the names of the Azure resources created")
+	commonInfraCmd.Flags().StringVar(&nameEnvironment, "name-environment", "", "Environment token (e.g. dev, prod) added to the names of the Azure resources created")
+	commonInfraCmd.Flags().StringArrayVar(&namePatternFlags, "name-pattern", []string{}, "Naming pattern of a resource, as role=pattern (e.g. keyvault={prefix}{name}-vault, can be repeated)")
+	commonInfraCmd.Flags().BoolVar(&checkNames, "check-names", false, "Check with Azure that globally unique names are available before creating resources")
 	rootCmd.AddCommand(commonInfraCmd)
 }
@@ -17,9 +17,53 @@ var resourceGroupTm string
 var regionWest string
 var regionCentral string
 var regionEast string
+var vmCountWest string
+var vmCountCentral string
+var vmCountEast string
+var vmSizeWest string
+var vmSizeCentral string
+var vmSizeEast string
+
+// regionalCluster is the location and node pool of one of the clusters of azure-multiple-clusters
+type regionalCluster struct {
+	Name    string // west, central or east, the prefix of the tfvars of the cluster
+	Region  string
+	VMCount string
+	VMSize  string
+
+	// Traffic Manager endpoint of the cluster
+	Weight      string
+	Priority    string
+	GeoMappings []string
+}
+
+// regionalClusters returns the clusters of azure-multiple-clusters from the flags, with the per-region
+// sizing overrides applied over --vm-count and --vm-size
+func regionalClusters() []regionalCluster {
+	override := func(value string, shared string) string {
+		if value != "" {
+			return value
+		}
+		return shared
+	}
+	return []regionalCluster{
+		{Name: "west", Region: regionWest, VMCount: override(vmCountWest, vmCount), VMSize: override(vmSizeWest, vmSize),
+			Weight: endpointWeightWest, Priority: endpointPriorityWest, GeoMappings: geoMappingsWest},
+		{Name: "central", Region: regionCentral, VMCount: override(vmCountCentral, vmCount), VMSize: override(vmSizeCentral, vmSize),
+			Weight: endpointWeightCentral, Priority: endpointPriorityCentral, GeoMappings: geoMappingsCentral},
+		{Name: "east", Region: regionEast, VMCount: override(vmCountEast, vmCount), VMSize: override(vmSizeEast, vmSize),
+			Weight: endpointWeightEast, Priority: endpointPriorityEast, GeoMappings: geoMappingsEast},
+	}
+}
 
 // Initializes the configuration for the given environment
 func azureMultiCluster(servicePrincipal string, secret string) (err error) {
+	if error := validateClusterSizing(regionalClusters(), skuCatalogFile); error != nil {
+		return error
+	}
+	if error := validateTrafficManager(regionalClusters()); error != nil {
+		return error
+	}
 	if _, _, error := Init(MULTIPLE, clusterName); error != nil {
 		return error
 	}
@@ -27,9 +71,9 @@ func azureMultiCluster(servicePrincipal string, secret string) (err error) {
 }
 
 var azureMultiClusterCmd = &cobra.Command{
-	Use:   MULTIPLE + " --gitops-ssh-url manifest-repo-url-in-ssh-format [--subscription subscription-id] [--sp service-principal-app-id] [--secret service-principal-password] [--tenant serice-principal-tenant-id] [--cluster-name name-of-AKS-cluster] [--resource-group-west name-of-resource-group-for-west-region] [--resource-group-east name-of-resource-group-for-east-region] [--resource-group-central name-of-resource-group-for-central-region] [--resource-group-tm name-of-resource-group-for-traffic-manager] [--vm-count number-of-nodes-to-deploy-in-cluster] [--dns-prefix DNS-prefix] [--poll-interval flux-sync-poll-interval] [--west-repo-path path-in-repo-to-sync-for-west-cluster] [--central-repo-path path-in-repo-to-sync-for-central-cluster] [--east-repo-path path-in-repo-to-sync-for-east-cluster] [--west-branch repo-branch-to-sync-with-for-west-cluster] [--central-branch repo-branch-to-sync-with-for-central-cluster] [--east-branch repo-branch-to-sync-with-for-east-cluster] [--keyvault name-of-keyvault] [--keyvault-rg name-of-resource-group-for-keyvault]",
+	Use:   MULTIPLE + " --gitops-ssh-url manifest-repo-url-in-ssh-format [--subscription subscription-id] [--sp service-principal-app-id] [--secret service-principal-password] [--tenant serice-principal-tenant-id] [--auth sp|cli|msi|oidc] [--create-sp] [--sp-role role] [--sp-scope scope] [--cluster-name name-of-AKS-cluster] [--resource-group-west name-of-resource-group-for-west-region] [--resource-group-east name-of-resource-group-for-east-region] [--resource-group-central name-of-resource-group-for-central-region] [--resource-group-tm name-of-resource-group-for-traffic-manager] [--region-west region-of-west-cluster] [--region-central region-of-central-cluster] [--region-east region-of-east-cluster] [--vm-count number-of-nodes-to-deploy-in-cluster] [--vm-size azure-vm-size] [--west-vm-count number-of-nodes] [--central-vm-count number-of-nodes] [--east-vm-count number-of-nodes] [--west-vm-size azure-vm-size] [--central-vm-size azure-vm-size] [--east-vm-size azure-vm-size] [--sku-catalog path-to-sku-catalog] [--tm-routing-method Performance|Weighted|Priority|Geographic] [--tm-probe-protocol HTTP|HTTPS|TCP] [--tm-probe-port port] [--tm-probe-path path] [--west-weight weight] [--central-weight weight] [--east-weight weight] [--west-priority priority] [--central-priority priority] [--east-priority priority] [--west-geo-mappings codes] [--central-geo-mappings codes] [--east-geo-mappings codes] [--dns-prefix DNS-prefix] [--poll-interval flux-sync-poll-interval] [--west-repo-path path-in-repo-to-sync-for-west-cluster] [--central-repo-path path-in-repo-to-sync-for-central-cluster] [--east-repo-path path-in-repo-to-sync-for-east-cluster] [--west-branch repo-branch-to-sync-with-for-west-cluster] [--central-branch repo-branch-to-sync-with-for-central-cluster] [--east-branch repo-branch-to-sync-with-for-east-cluster] [--keyvault name-of-keyvault] [--keyvault-rg name-of-resource-group-for-keyvault] [--ssh-key-type rsa|ed25519] [--force-ssh-key] [--register-deploy-key] [--verify-gitops] [--rollback-on-failure] [--tag key=value] [--name-prefix prefix] [--name-suffix suffix] [--name-environment environment] [--name-pattern role=pattern] [--check-names]",
 	Short: "Deploys Bedrock Multiple Azure Kubernetes Service (AKS) cluster configuration",
-	Long:  `Deploys Bedrock Multiple Azure Kubernetes Service (AKS) cluster configuration`,
+	Long:  `Deploys Bedrock Multiple Azure Kubernetes Service (AKS) cluster configuration. Every cluster gets --vm-count nodes of --vm-size, unless overridden for its region (e.g. --east-vm-count 5 for a larger pool in the primary region). The VM sizes are checked against the regions in the SKU catalog given with --sku-catalog. The Traffic Manager routing method, the endpoint weights, priorities or geographic mappings of the clusters and the health probe can be set with the --tm-* and per-region flags, otherwise the defaults of the template apply.`,
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 
 		return azureMultiCluster(servicePrincipal, secret)
@@ -45,6 +89,10 @@ func init() {
 	azureMultiClusterCmd.Flags().StringVar(&secret, "secret", "", "Password for the Service Principal")
 	azureMultiClusterCmd.Flags().StringVar(&gitopsSSHUrl, "gitops-ssh-url", "git@github.com:timfpark/fabrikate-cloud-native-manifests.git", "The git repo that contains the resource manifests that should be deployed in the cluster in ssh format.")
 	azureMultiClusterCmd.Flags().StringVar(&tenant, "tenant", "", "Tenant ID for the Service Principal")
+	azureMultiClusterCmd.Flags().StringVar(&authMode, "auth", AUTHSP, "How terraform authenticates with Azure: sp (service principal secret), cli (az login), msi (managed identity) or oidc (workload identity federation)")
+	azureMultiClusterCmd.Flags().BoolVar(&createSP, "create-sp", false, "Create a service principal for the environment when none is given")
+	azureMultiClusterCmd.Flags().StringVar(&spRole, "sp-role", "", "Role assigned to the created service principal (defaults to Owner, or Contributor for azure-simple)")
+	azureMultiClusterCmd.Flags().StringVar(&spScope, "sp-scope", "", "Scope of the role assigned to the created service principal (defaults to the subscription)")
 	azureMultiClusterCmd.Flags().StringVar(&subscription, "subscription", "", "Subscription ID")
 	azureMultiClusterCmd.Flags().StringVar(&clusterName, "cluster-name", "", "Name of AKS Cluster")
 	azureMultiClusterCmd.Flags().StringVar(&regionWest, "region-west", "westus2", "Region of deployment")
@@ -52,6 +100,26 @@ func init() {
 	azureMultiClusterCmd.Flags().StringVar(&regionEast, "region-east", "eastus", "Region of deployment")
 	azureMultiClusterCmd.Flags().StringVar(&vmCount, "vm-count", "3", "Number of nodes to deploy per cluster")
 	azureMultiClusterCmd.Flags().StringVar(&vmSize, "vm-size", "Standard_D4s_v3", "Azure VM size")
+	azureMultiClusterCmd.Flags().StringVar(&vmCountWest, "west-vm-count", "", "Number of nodes to deploy in the west cluster (defaults to --vm-count)")
+	azureMultiClusterCmd.Flags().StringVar(&vmCountCentral, "central-vm-count", "", "Number of nodes to deploy in the central cluster (defaults to --vm-count)")
+	azureMultiClusterCmd.Flags().StringVar(&vmCountEast, "east-vm-count", "", "Number of nodes to deploy in the east cluster (defaults to --vm-count)")
+	azureMultiClusterCmd.Flags().StringVar(&vmSizeWest, "west-vm-size", "", "Azure VM size of the west cluster (defaults to --vm-size)")
+	azureMultiClusterCmd.Flags().StringVar(&vmSizeCentral, "central-vm-size", "", "Azure VM size of the central cluster (defaults to --vm-size)")
+	azureMultiClusterCmd.Flags().StringVar(&vmSizeEast, "east-vm-size", "", "Azure VM size of the east cluster (defaults to --vm-size)")
+	azureMultiClusterCmd.Flags().StringVar(&skuCatalogFile, "sku-catalog", "skus/bedrock-skus.json", "Catalog of the VM sizes available in each region")
+	azureMultiClusterCmd.Flags().StringVar(&tmRoutingMethod, "tm-routing-method", "", "Traffic Manager routing method: Performance, Weighted, Priority or Geographic (defaults to the template's)")
+	azureMultiClusterCmd.Flags().StringVar(&tmProbeProtocol, "tm-probe-protocol", "", "Protocol of the Traffic Manager health probe: HTTP, HTTPS or TCP")
+	azureMultiClusterCmd.Flags().StringVar(&tmProbePort, "tm-probe-port", "", "Port of the Traffic Manager health probe")
+	azureMultiClusterCmd.Flags().StringVar(&tmProbePath, "tm-probe-path", "", "Path of the Traffic Manager health probe, e.g. /healthz")
+	azureMultiClusterCmd.Flags().StringVar(&endpointWeightWest, "west-weight", "", "Traffic Manager weight of the west cluster (1-1000, Weighted routing)")
+	azureMultiClusterCmd.Flags().StringVar(&endpointWeightCentral, "central-weight", "", "Traffic Manager weight of the central cluster (1-1000, Weighted routing)")
+	azureMultiClusterCmd.Flags().StringVar(&endpointWeightEast, "east-weight", "", "Traffic Manager weight of the east cluster (1-1000, Weighted routing)")
+	azureMultiClusterCmd.Flags().StringVar(&endpointPriorityWest, "west-priority", "", "Traffic Manager priority of the west cluster (1-1000, lowest first, Priority routing)")
+	azureMultiClusterCmd.Flags().StringVar(&endpointPriorityCentral, "central-priority", "", "Traffic Manager priority of the central cluster (1-1000, lowest first, Priority routing)")
+	azureMultiClusterCmd.Flags().StringVar(&endpointPriorityEast, "east-priority", "", "Traffic Manager priority of the east cluster (1-1000, lowest first, Priority routing)")
+	azureMultiClusterCmd.Flags().StringSliceVar(&geoMappingsWest, "west-geo-mappings", []string{}, "Geographic codes routed to the west cluster, e.g. GEO-NA,GEO-SA (Geographic routing)")
+	azureMultiClusterCmd.Flags().StringSliceVar(&geoMappingsCentral, "central-geo-mappings", []string{}, "Geographic codes routed to the central cluster (Geographic routing)")
+	azureMultiClusterCmd.Flags().StringSliceVar(&geoMappingsEast, "east-geo-mappings", []string{}, "Geographic codes routed to the east cluster (Geographic routing)")
 	azureMultiClusterCmd.Flags().StringVar(&dnsPrefix, "dns-prefix", "", "DNS Prefix")
 	azureMultiClusterCmd.Flags().StringVar(&gitopsPollInterval, "poll-interval", "5m", "Period at which to poll git repo for new commits")
 	azureMultiClusterCmd.Flags().StringVar(&keyvaultName, "keyvault", "", "Name of Key Vault")
@@ -62,6 +130,17 @@ func init() {
 	azureMultiClusterCmd.Flags().StringVar(&gitopsURLBranchWest, "west-branch", "master", "Path in repo to sync with")
 	azureMultiClusterCmd.Flags().StringVar(&gitopsURLBranchEast, "east-branch", "master", "Path in repo to sync with")
 	azureMultiClusterCmd.Flags().StringVar(&gitopsURLBranchCentral, "central-branch", "master", "Path in repo to sync with")
+	azureMultiClusterCmd.Flags().StringVar(&sshKeyType, "ssh-key-type", RSA, "Type of deploy key to create (rsa or ed25519)")
+	azureMultiClusterCmd.Flags().BoolVar(&sshForce, "force-ssh-key", false, "Overwrite an existing deploy key for the environment")
+	azureMultiClusterCmd.Flags().BoolVar(&registerDeployKey, "register-deploy-key", false, "Register the deploy key with the GitOps repository (requires GITHUB_TOKEN or GITLAB_TOKEN, not available on Azure DevOps)")
+	azureMultiClusterCmd.Flags().BoolVar(&verifyGitops, "verify-gitops", false, "Verify that the GitOps repository, branch and path can be reached with the deploy key")
+	azureMultiClusterCmd.Flags().StringArrayVar(&tagFlags, "tag", []string{}, "Tag to apply to the resources created, as key=value (can be repeated)")
+	azureMultiClusterCmd.Flags().BoolVar(&rollbackOnFailure, "rollback-on-failure", false, "Delete the resources created so far if the environment creation fails")
+	azureMultiClusterCmd.Flags().StringVar(&namePrefix, "name-prefix", "", "Prefix added to the names of the Azure resources created")
+	azureMultiClusterCmd.Flags().StringVar(&nameSuffix, "name-suffix", "", "Suffix added to the names of the Azure resources created")
+	azureMultiClusterCmd.Flags().StringVar(&nameEnvironment, "name-environment", "", "Environment token (e.g. dev, prod) added to the names of the Azure resources created")
+	azureMultiClusterCmd.Flags().StringArrayVar(&namePatternFlags, "name-pattern", []string{}, "Naming pattern of a resource, as role=pattern (e.g. keyvault={prefix}{name}-vault, can be repeated)")
+	azureMultiClusterCmd.Flags().BoolVar(&checkNames, "check-names", false, "Check with Azure that globally unique names are available before creating resources")
 	if error := azureMultiClusterCmd.MarkFlagRequired("gitops-ssh-url"); error != nil {
 		return
 	}
@@ -23,7 +23,7 @@ func azureSimple(servicePrincipal string, secret string) (err error) {
 }
 
 var azureSimpleCmd = &cobra.Command{
-	Use:   SIMPLE + " --gitops-ssh-url manifest-repo-url-in-ssh-format [--subscription subscription-id] [--sp service-principal-app-id] [--secret service-principal-password] [--tenant serice-principal-tenant-id] [--cluster-name name-of-AKS-cluster] [--region region-of-deployment] [--vm-count number-of-nodes-to-deploy-in-cluster] [--vnet name-of-vnet] [--dns-prefix DNS-prefix] [--poll-interval flux-sync-poll-interval] [--repo-path path-in-repo-to-sync] [--branch repo-branch-to-sync-with]",
+	Use:   SIMPLE + " --gitops-ssh-url manifest-repo-url-in-ssh-format [--subscription subscription-id] [--sp service-principal-app-id] [--secret service-principal-password] [--tenant serice-principal-tenant-id] [--auth sp|cli|msi|oidc] [--create-sp] [--sp-role role] [--sp-scope scope] [--cluster-name name-of-AKS-cluster] [--region region-of-deployment] [--vm-count number-of-nodes-to-deploy-in-cluster] [--vnet name-of-vnet] [--dns-prefix DNS-prefix] [--poll-interval flux-sync-poll-interval] [--repo-path path-in-repo-to-sync] [--branch repo-branch-to-sync-with] [--ssh-key-type rsa|ed25519] [--force-ssh-key] [--register-deploy-key] [--verify-gitops] [--rollback-on-failure] [--tag key=value] [--name-prefix prefix] [--name-suffix suffix] [--name-environment environment] [--name-pattern role=pattern] [--check-names]",
 	Short: "Deploys a Bedrock Simple Azure Kubernetes Service (AKS) cluster configuration",
 	Long:  `Deploys a Bedrock Simple Azure Kubernetes Service (AKS) cluster configuration`,
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
@@ -37,6 +37,10 @@ func init() {
 	azureSimpleCmd.Flags().StringVar(&secret, "secret", "", "Password for the Service Principal")
 	azureSimpleCmd.Flags().StringVar(&subscription, "subscription", "", "Azure Subscription ID")
 	azureSimpleCmd.Flags().StringVar(&tenant, "tenant", "", "Tenant ID for Service Principal")
+	azureSimpleCmd.Flags().StringVar(&authMode, "auth", AUTHSP, "How terraform authenticates with Azure: sp (service principal secret), cli (az login), msi (managed identity) or oidc (workload identity federation)")
+	azureSimpleCmd.Flags().BoolVar(&createSP, "create-sp", false, "Create a service principal for the environment when none is given")
+	azureSimpleCmd.Flags().StringVar(&spRole, "sp-role", "", "Role assigned to the created service principal (defaults to Owner, or Contributor for azure-simple)")
+	azureSimpleCmd.Flags().StringVar(&spScope, "sp-scope", "", "Scope of the role assigned to the created service principal (defaults to the subscription)")
 	azureSimpleCmd.Flags().StringVar(&resourceGroup, "resource-group", "", "An existing Azure Resource Group")
 	azureSimpleCmd.Flags().StringVar(&gitopsSSHUrl, "gitops-ssh-url", "git@github.com:timfpark/fabrikate-cloud-native-manifests.git", "The git repo that contains the resource manifests that should be deployed in the cluster in ssh format")
 	azureSimpleCmd.Flags().StringVar(&clusterName, "cluster-name", "", "Name of AKS Cluster")
@@ -47,6 +51,17 @@ func init() {
 	azureSimpleCmd.Flags().StringVar(&gitopsPollInterval, "poll-interval", "5m", "Period at which to poll git repo for new commits")
 	azureSimpleCmd.Flags().StringVar(&gitopsPath, "repo-path", "", "Path in repo to sync with")
 	azureSimpleCmd.Flags().StringVar(&gitopsURLBranch, "branch", "master", "Path in repo to sync with")
+	azureSimpleCmd.Flags().StringVar(&sshKeyType, "ssh-key-type", RSA, "Type of deploy key to create (rsa or ed25519)")
+	azureSimpleCmd.Flags().BoolVar(&sshForce, "force-ssh-key", false, "Overwrite an existing deploy key for the environment")
+	azureSimpleCmd.Flags().BoolVar(&registerDeployKey, "register-deploy-key", false, "Register the deploy key with the GitOps repository (requires GITHUB_TOKEN or GITLAB_TOKEN, not available on Azure DevOps)")
+	azureSimpleCmd.Flags().BoolVar(&verifyGitops, "verify-gitops", false, "Verify that the GitOps repository, branch and path can be reached with the deploy key")
+	azureSimpleCmd.Flags().StringArrayVar(&tagFlags, "tag", []string{}, "Tag to apply to the resources created, as key=value (can be repeated)")
+	azureSimpleCmd.Flags().BoolVar(&rollbackOnFailure, "rollback-on-failure", false, "Delete the resources created so far if the environment creation fails")
+	azureSimpleCmd.Flags().StringVar(&namePrefix, "name-prefix", "", "Prefix added to the names of the Azure resources created")
+	azureSimpleCmd.Flags().StringVar(&nameSuffix, "name-suffix", "", "Suffix added to the names of the Azure resources created")
+	azureSimpleCmd.Flags().StringVar(&nameEnvironment, "name-environment", "", "Environment token (e.g. dev, prod) added to the names of the Azure resources created")
+	azureSimpleCmd.Flags().StringArrayVar(&namePatternFlags, "name-pattern", []string{}, "Naming pattern of a resource, as role=pattern (e.g. keyvault={prefix}{name}-vault, can be repeated)")
+	azureSimpleCmd.Flags().BoolVar(&checkNames, "check-names", false, "Check with Azure that globally unique names are available before creating resources")
 	if error := azureSimpleCmd.MarkFlagRequired("gitops-ssh-url"); error != nil {
 		return
 	}
@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var cosmosDBName string
+var mongoDBName string
+
+var azureSingleKeyvaultCosmosCmd = &cobra.Command{
+	Use:   COSMOS + " --gitops-ssh-url manifest-repo-url-in-ssh-format [--subscription subscription-id] [--sp service-principal-app-id] [--secret service-principal-password] [--tenant serice-principal-tenant-id] [--auth sp|cli|msi|oidc] [--create-sp] [--sp-role role] [--sp-scope scope] [--common-infra-path path-to-azure-common-infra-environment] [--storage-account storage-account-name] [--access-key storage-account-access-key] [--container-name storage-container-name] [--cluster-name name-of-AKS-cluster] [--region region-of-deployment] [--vm-count number-of-nodes-to-deploy-in-cluster] [--vm-size azure-vm-size] [--dns-prefix DNS-prefix] [--poll-interval flux-sync-poll-interval] [--repo-path path-in-repo-to-sync] [--branch repo-branch-to-sync-with] [--keyvault name-of-keyvault] [--keyvault-rg name-of-resource-group-for-keyvault] [--address-space address-space] [--subnet-prefix subnet-prefixes] [--cosmos-db-name name-of-cosmos-db-account] [--mongo-db-name name-of-mongo-database] [--ssh-key-type rsa|ed25519] [--force-ssh-key] [--register-deploy-key] [--verify-gitops] [--rollback-on-failure] [--tag key=value] [--name-prefix prefix] [--name-suffix suffix] [--name-environment environment] [--name-pattern role=pattern] [--check-names]",
+	Short: "Deploys a Bedrock Azure Kubernetes Service (AKS) cluster with an Azure Key Vault and a Cosmos DB account",
+	Long:  `Deploys a Bedrock Azure Kubernetes Service (AKS) cluster with an Azure Key Vault, along with a Cosmos DB account and a MongoDB database for the applications of the cluster. Make sure a successful deployment of ` + COMMON + ` is complete before attempting to deploy this one`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		return azureSingleKeyvault(servicePrincipal, secret, commonInfraPath, COSMOS)
+	},
+}
+
+func init() {
+	keyvaultFlags(azureSingleKeyvaultCosmosCmd)
+	azureSingleKeyvaultCosmosCmd.Flags().StringVar(&cosmosDBName, "cosmos-db-name", "", "Name of the Cosmos DB account (generated from the cluster name by default)")
+	azureSingleKeyvaultCosmosCmd.Flags().StringVar(&mongoDBName, "mongo-db-name", "bedrock", "Name of the MongoDB database created in the Cosmos DB account")
+	rootCmd.AddCommand(azureSingleKeyvaultCosmosCmd)
+}
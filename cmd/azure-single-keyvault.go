@@ -16,7 +16,7 @@ var keyvaultRG string
 var commonInfraCreation bool
 
 // Initializes the configuration for the given environment
-func azureSingleKeyvault(servicePrincipal string, secret string, commonInfraPath string) (err error) {
+func azureSingleKeyvault(servicePrincipal string, secret string, commonInfraPath string, environment string) (err error) {
 	if commonInfraPath != "" {
 		log.Info(emoji.Sprintf(":star2: An Azure Common Infra enviroment has been provided"))
 		commonInfraCreation = false
@@ -34,47 +34,67 @@ func azureSingleKeyvault(servicePrincipal string, secret string, commonInfraPath
 		return err
 	}
 
-	if _, _, error := Init(KEYVAULT, clusterName); error != nil {
+	if _, _, error := Init(environment, clusterName); error != nil {
 		return error
 	}
 	return err
 }
 
 var azureSingleKeyvaultCmd = &cobra.Command{
-	Use:   KEYVAULT + " --gitops-ssh-url manifest-repo-url-in-ssh-format [--subscription subscription-id] [--sp service-principal-app-id] [--secret service-principal-password] [--tenant serice-principal-tenant-id] [--common-infra-path path-to-azure-common-infra-environment] [--storage-account storage-account-name] [--access-key storage-account-access-key] [--container-name storage-container-name] [--cluster-name name-of-AKS-cluster] [--region region-of-deployment] [--vm-count number-of-nodes-to-deploy-in-cluster] [--vm-size azure-vm-size] [--dns-prefix DNS-prefix] [--poll-interval flux-sync-poll-interval] [--repo-path path-in-repo-to-sync] [--branch repo-branch-to-sync-with] [--keyvault name-of-keyvault] [--keyvault-rg name-of-resource-group-for-keyvault] [--address-space address-space] [--subnet-prefix subnet-prefixes]",
+	Use:   KEYVAULT + " --gitops-ssh-url manifest-repo-url-in-ssh-format [--subscription subscription-id] [--sp service-principal-app-id] [--secret service-principal-password] [--tenant serice-principal-tenant-id] [--auth sp|cli|msi|oidc] [--create-sp] [--sp-role role] [--sp-scope scope] [--common-infra-path path-to-azure-common-infra-environment] [--storage-account storage-account-name] [--access-key storage-account-access-key] [--container-name storage-container-name] [--cluster-name name-of-AKS-cluster] [--region region-of-deployment] [--vm-count number-of-nodes-to-deploy-in-cluster] [--vm-size azure-vm-size] [--dns-prefix DNS-prefix] [--poll-interval flux-sync-poll-interval] [--repo-path path-in-repo-to-sync] [--branch repo-branch-to-sync-with] [--keyvault name-of-keyvault] [--keyvault-rg name-of-resource-group-for-keyvault] [--address-space address-space] [--subnet-prefix subnet-prefixes] [--ssh-key-type rsa|ed25519] [--force-ssh-key] [--register-deploy-key] [--verify-gitops] [--rollback-on-failure] [--tag key=value] [--name-prefix prefix] [--name-suffix suffix] [--name-environment environment] [--name-pattern role=pattern] [--check-names]",
 	Short: "Deploys a Bedrock Azure Kubernetes Service (AKS) cluster with an Azure Key Vault",
 	Long:  `Deploys a Bedrock Azure Kubernetes Service (AKS) cluster with an Azure Key Vault. Make sure a successful deployment of ` + COMMON + ` is complete before attempting to deploy this one`,
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 
-		return azureSingleKeyvault(servicePrincipal, secret, commonInfraPath)
+		return azureSingleKeyvault(servicePrincipal, secret, commonInfraPath, KEYVAULT)
 	},
 }
 
-func init() {
-	azureSingleKeyvaultCmd.Flags().StringVar(&resourceGroup, "resource-group", "", "An existing Azure Resource Group")
-	azureSingleKeyvaultCmd.Flags().StringVar(&servicePrincipal, "sp", "", "Service Principal App ID")
-	azureSingleKeyvaultCmd.Flags().StringVar(&subscription, "subscription", "", "Subscription ID")
-	azureSingleKeyvaultCmd.Flags().StringVar(&secret, "secret", "", "Password for the Service Principal")
-	azureSingleKeyvaultCmd.Flags().StringVar(&tenant, "tenant", "", "Tenant ID for the Service Principal")
-	azureSingleKeyvaultCmd.Flags().StringVar(&gitopsSSHUrl, "gitops-ssh-url", "git@github.com:timfpark/fabrikate-cloud-native-manifests.git", "The git repo that contains the resource manifests that should be deployed in the cluster in ssh format")
-	azureSingleKeyvaultCmd.Flags().StringVar(&commonInfraPath, "common-infra-path", "", "Successful deployment of an Azure Common Infra environment")
-	azureSingleKeyvaultCmd.Flags().StringVar(&storageAccount, "storage-account", "", "Storage Account Name")
-	azureSingleKeyvaultCmd.Flags().StringVar(&accessKey, "access-key", "", "Storage Account Access Key")
-	azureSingleKeyvaultCmd.Flags().StringVar(&containerName, "container-name", "", "Storage Container Name")
-	azureSingleKeyvaultCmd.Flags().StringVar(&clusterName, "cluster-name", "", "Name of AKS Cluster")
-	azureSingleKeyvaultCmd.Flags().StringVar(&region, "region", "westus2", "Region of deployment")
-	azureSingleKeyvaultCmd.Flags().StringVar(&vmCount, "vm-count", "3", "Number of nodes to deploy per cluster")
-	azureSingleKeyvaultCmd.Flags().StringVar(&vmSize, "vm-size", "Standard_D4s_v3", "Azure VM size")
-	azureSingleKeyvaultCmd.Flags().StringVar(&dnsPrefix, "dns-prefix", "", "DNS Prefix")
-	azureSingleKeyvaultCmd.Flags().StringVar(&gitopsPollInterval, "poll-interval", "5m", "Period at which to poll git repo for new commits")
-	azureSingleKeyvaultCmd.Flags().StringVar(&gitopsPath, "repo-path", "", "Path in repo to sync with")
-	azureSingleKeyvaultCmd.Flags().StringVar(&gitopsURLBranch, "branch", "master", "Path in repo to sync with")
-	azureSingleKeyvaultCmd.Flags().StringVar(&addressSpace, "address-space", "10.39.0.0/24", "CIDR for cluster address space")
-	azureSingleKeyvaultCmd.Flags().StringVar(&subnetPrefix, "subnet-prefix", "10.39.0.0/24", "Subnet prefixes")
-	azureSingleKeyvaultCmd.Flags().StringVar(&keyvaultName, "keyvault", "", "Name of Key Vault")
-	azureSingleKeyvaultCmd.Flags().StringVar(&keyvaultRG, "keyvault-rg", "", "Resource group of Key Vault")
-	if error := azureSingleKeyvaultCmd.MarkFlagRequired("gitops-ssh-url"); error != nil {
+// keyvaultFlags adds the flags of the single keyvault cluster environments
+func keyvaultFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&resourceGroup, "resource-group", "", "An existing Azure Resource Group")
+	cmd.Flags().StringVar(&servicePrincipal, "sp", "", "Service Principal App ID")
+	cmd.Flags().StringVar(&subscription, "subscription", "", "Subscription ID")
+	cmd.Flags().StringVar(&secret, "secret", "", "Password for the Service Principal")
+	cmd.Flags().StringVar(&tenant, "tenant", "", "Tenant ID for the Service Principal")
+	cmd.Flags().StringVar(&authMode, "auth", AUTHSP, "How terraform authenticates with Azure: sp (service principal secret), cli (az login), msi (managed identity) or oidc (workload identity federation)")
+	cmd.Flags().BoolVar(&createSP, "create-sp", false, "Create a service principal for the environment when none is given")
+	cmd.Flags().StringVar(&spRole, "sp-role", "", "Role assigned to the created service principal (defaults to Owner, or Contributor for azure-simple)")
+	cmd.Flags().StringVar(&spScope, "sp-scope", "", "Scope of the role assigned to the created service principal (defaults to the subscription)")
+	cmd.Flags().StringVar(&gitopsSSHUrl, "gitops-ssh-url", "git@github.com:timfpark/fabrikate-cloud-native-manifests.git", "The git repo that contains the resource manifests that should be deployed in the cluster in ssh format")
+	cmd.Flags().StringVar(&commonInfraPath, "common-infra-path", "", "Environment directory of a deployed Azure Common Infra, linked through its terraform state rather than copied")
+	cmd.Flags().StringVar(&storageAccount, "storage-account", "", "Storage Account Name")
+	cmd.Flags().StringVar(&accessKey, "access-key", "", "Storage Account Access Key")
+	cmd.Flags().StringVar(&containerName, "container-name", "", "Storage Container Name")
+	cmd.Flags().StringVar(&clusterName, "cluster-name", "", "Name of AKS Cluster")
+	cmd.Flags().StringVar(&region, "region", "westus2", "Region of deployment")
+	cmd.Flags().StringVar(&vmCount, "vm-count", "3", "Number of nodes to deploy per cluster")
+	cmd.Flags().StringVar(&vmSize, "vm-size", "Standard_D4s_v3", "Azure VM size")
+	cmd.Flags().StringVar(&dnsPrefix, "dns-prefix", "", "DNS Prefix")
+	cmd.Flags().StringVar(&gitopsPollInterval, "poll-interval", "5m", "Period at which to poll git repo for new commits")
+	cmd.Flags().StringVar(&gitopsPath, "repo-path", "", "Path in repo to sync with")
+	cmd.Flags().StringVar(&gitopsURLBranch, "branch", "master", "Path in repo to sync with")
+	cmd.Flags().StringVar(&addressSpace, "address-space", "10.39.0.0/24", "CIDR for cluster address space")
+	cmd.Flags().StringVar(&subnetPrefix, "subnet-prefix", "10.39.0.0/24", "Subnet prefixes")
+	cmd.Flags().StringVar(&keyvaultName, "keyvault", "", "Name of Key Vault")
+	cmd.Flags().StringVar(&keyvaultRG, "keyvault-rg", "", "Resource group of Key Vault")
+	cmd.Flags().StringVar(&sshKeyType, "ssh-key-type", RSA, "Type of deploy key to create (rsa or ed25519)")
+	cmd.Flags().BoolVar(&sshForce, "force-ssh-key", false, "Overwrite an existing deploy key for the environment")
+	cmd.Flags().BoolVar(&registerDeployKey, "register-deploy-key", false, "Register the deploy key with the GitOps repository (requires GITHUB_TOKEN or GITLAB_TOKEN, not available on Azure DevOps)")
+	cmd.Flags().BoolVar(&verifyGitops, "verify-gitops", false, "Verify that the GitOps repository, branch and path can be reached with the deploy key")
+	cmd.Flags().StringArrayVar(&tagFlags, "tag", []string{}, "Tag to apply to the resources created, as key=value (can be repeated)")
+	cmd.Flags().BoolVar(&rollbackOnFailure, "rollback-on-failure", false, "Delete the resources created so far if the environment creation fails")
+	cmd.Flags().StringVar(&namePrefix, "name-prefix", "", "Prefix added to the names of the Azure resources created")
+	cmd.Flags().StringVar(&nameSuffix, "name-suffix", "", "Suffix added to the names of the Azure resources created")
+	cmd.Flags().StringVar(&nameEnvironment, "name-environment", "", "Environment token (e.g. dev, prod) added to the names of the Azure resources created")
+	cmd.Flags().StringArrayVar(&namePatternFlags, "name-pattern", []string{}, "Naming pattern of a resource, as role=pattern (e.g. keyvault={prefix}{name}-vault, can be repeated)")
+	cmd.Flags().BoolVar(&checkNames, "check-names", false, "Check with Azure that globally unique names are available before creating resources")
+	if error := cmd.MarkFlagRequired("gitops-ssh-url"); error != nil {
 		return
 	}
+}
+
+func init() {
+	keyvaultFlags(azureSingleKeyvaultCmd)
 	rootCmd.AddCommand(azureSingleKeyvaultCmd)
 }
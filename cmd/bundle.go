@@ -0,0 +1,586 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/yradsmikham/bedrock-cli/pkg/bedrock"
+	"golang.org/x/crypto/scrypt"
+)
+
+var bundleOutput string
+var bundleSecrets string
+var bundlePassphrase string
+var importName string
+var importSkipInit bool
+
+// How the secrets of an environment are exported
+const (
+	SECRETSREDACT  = "redact"  // Left out, given again on import
+	SECRETSENCRYPT = "encrypt" // Encrypted with a passphrase
+)
+
+// Files of a bundle besides the environments
+const (
+	bundleManifestFile = "bedrock-bundle.json"
+	bundleSecretsFile  = "bedrock-secrets.enc"
+	bundleFormat       = 1
+)
+
+// bundlePassphraseVariable can hold the passphrase instead of --passphrase
+const bundlePassphraseVariable = "BEDROCK_BUNDLE_PASSPHRASE"
+
+// bundleSecretVariables are the environment variables redacted secrets are restored from on import
+var bundleSecretVariables = map[string]string{
+	"secret":                   "ARM_CLIENT_SECRET",
+	"service_principal_secret": "ARM_CLIENT_SECRET",
+	"access_key":               "AZURE_STORAGE_KEY",
+}
+
+// bundleRunner runs the commands of an import, replaced in tests
+var bundleRunner = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// BundleManifest describes the content of an environment bundle, see bedrock export
+type BundleManifest struct {
+	Format       int                 `json:"format"`
+	Name         string              `json:"name"`
+	Bedrock      string              `json:"bedrock"` // Version of the Bedrock templates
+	Created      time.Time           `json:"created"`
+	Secrets      string              `json:"secrets"` // redact or encrypt
+	Environments []BundleEnvironment `json:"environments"`
+}
+
+// BundleEnvironment is an environment type of a bundle
+type BundleEnvironment struct {
+	Type     string   `json:"type"`
+	Files    []string `json:"files"`
+	Redacted []string `json:"redacted,omitempty"` // Settings left out, as file#setting
+	Keys     []string `json:"keys,omitempty"`     // Private keys, only kept in the encrypted secrets
+}
+
+// bundleSecretValues are the values left out of the files of a bundle, encrypted into bundleSecretsFile
+type bundleSecretValues struct {
+	Settings map[string]string `json:"settings"` // By type/file#setting
+	Keys     map[string][]byte `json:"keys"`     // By type/file
+}
+
+// encryptedBundleSecrets is the content of bundleSecretsFile
+type encryptedBundleSecrets struct {
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// secretSetting reports whether a setting holds a credential, see maskSecret
+func secretSetting(setting string) bool {
+	return bedrock.SecretSetting(setting)
+}
+
+// bundledFile reports whether a file of an environment belongs in a bundle. Terraform state, plans,
+// provider binaries and outputs such as kubeconfigs stay on the machine they were created on.
+func bundledFile(file os.FileInfo) bool {
+	name := file.Name()
+	if file.IsDir() || name == deployedTfvars || strings.HasPrefix(name, "terraform.tfstate") || filepath.Ext(name) == ".tfplan" {
+		return false
+	}
+	return file.Mode().IsRegular()
+}
+
+// privateKey reports whether a file of an environment is the private half of a key pair
+func privateKey(directory string, name string) bool {
+	return fileExists(directory + "/" + name + ".pub")
+}
+
+// replaceSettings rewrites the values of a tfvars or toml file, keeping everything else as is
+func replaceSettings(content []byte, replace func(setting string, value string) string) []byte {
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if equal := strings.Index(line, "="); equal > 0 {
+			setting := strings.TrimSpace(line[:equal])
+			value := strings.TrimSpace(line[equal+1:])
+			if replaced := replace(setting, value); replaced != value {
+				line = line[:equal] + "= " + replaced
+			}
+		}
+		out.WriteString(line + "\n")
+	}
+	return out.Bytes()
+}
+
+// passphraseKey derives the key encrypting the secrets of a bundle
+func passphraseKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, 32768, 8, 1, 32)
+}
+
+// encryptBundleSecrets encrypts the secrets of a bundle with AES-GCM
+func encryptBundleSecrets(secrets *bundleSecretValues, passphrase string) (encrypted []byte, err error) {
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+	sealed := encryptedBundleSecrets{Salt: make([]byte, 16)}
+	if _, err := rand.Read(sealed.Salt); err != nil {
+		return nil, err
+	}
+	key, err := passphraseKey(passphrase, sealed.Salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sealed.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(sealed.Nonce); err != nil {
+		return nil, err
+	}
+	sealed.Data = gcm.Seal(nil, sealed.Nonce, plain, nil)
+	return json.Marshal(sealed)
+}
+
+// decryptBundleSecrets decrypts the secrets of a bundle
+func decryptBundleSecrets(encrypted []byte, passphrase string) (secrets *bundleSecretValues, err error) {
+	var sealed encryptedBundleSecrets
+	if err := json.Unmarshal(encrypted, &sealed); err != nil {
+		return nil, err
+	}
+	key, err := passphraseKey(passphrase, sealed.Salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, sealed.Nonce, sealed.Data, nil)
+	if err != nil {
+		return nil, errors.New("The secrets of the bundle could not be decrypted, check the passphrase")
+	}
+	secrets = &bundleSecretValues{}
+	return secrets, json.Unmarshal(plain, secrets)
+}
+
+// passphrase returns the passphrase of --passphrase or BEDROCK_BUNDLE_PASSPHRASE
+func passphrase() string {
+	if bundlePassphrase != "" {
+		return bundlePassphrase
+	}
+	return os.Getenv(bundlePassphraseVariable)
+}
+
+// writeBundleFile adds a file to a bundle
+func writeBundleFile(archive *tar.Writer, name string, content []byte, mode int64) (err error) {
+	header := &tar.Header{Name: name, Mode: mode, Size: int64(len(content)), ModTime: time.Now()}
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = archive.Write(content)
+	return err
+}
+
+// Export packages an environment directory into a portable bundle
+func Export(name string, output string, secrets string) (err error) {
+	name = strings.TrimSuffix(name, "/")
+	if secrets != SECRETSREDACT && secrets != SECRETSENCRYPT {
+		return errors.New("--secrets must be " + SECRETSREDACT + " or " + SECRETSENCRYPT)
+	}
+	if secrets == SECRETSENCRYPT && passphrase() == "" {
+		return errors.New("Encrypting the secrets needs --passphrase or " + bundlePassphraseVariable)
+	}
+	environments, err := environmentsIn(name)
+	if err != nil {
+		return err
+	}
+	if len(environments) == 0 {
+		return fmt.Errorf("No environments were found in %s", name)
+	}
+
+	// Absolute paths of this machine are made relative to the project directory
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	manifest := BundleManifest{Format: bundleFormat, Name: filepath.Base(name), Bedrock: BEDROCK, Created: time.Now().UTC(), Secrets: secrets}
+	hidden := &bundleSecretValues{Settings: map[string]string{}, Keys: map[string][]byte{}}
+	files := map[string][]byte{}
+
+	for _, environment := range environments {
+		directory := name + "/" + environment.Name()
+		entries, err := ioutil.ReadDir(directory)
+		if err != nil {
+			return err
+		}
+		bundled := BundleEnvironment{Type: environment.Name()}
+		for _, entry := range entries {
+			if !bundledFile(entry) {
+				continue
+			}
+			content, err := ioutil.ReadFile(directory + "/" + entry.Name())
+			if err != nil {
+				return err
+			}
+			file := environment.Name() + "/" + entry.Name()
+			if privateKey(directory, entry.Name()) {
+				bundled.Keys = append(bundled.Keys, entry.Name())
+				hidden.Keys[file] = content
+				continue
+			}
+			switch filepath.Ext(entry.Name()) {
+			case ".tfvars", ".toml":
+				content = replaceSettings(content, func(setting string, value string) string {
+					if secretSetting(setting) && value != "\"\"" {
+						redacted := entry.Name() + "#" + setting
+						if !containsString(bundled.Redacted, redacted) {
+							bundled.Redacted = append(bundled.Redacted, redacted)
+						}
+						hidden.Settings[environment.Name()+"/"+redacted] = value
+						return "\"\""
+					}
+					return value
+				})
+			}
+			content = bytes.Replace(content, []byte(workingDirectory+"/"), nil, -1)
+			bundled.Files = append(bundled.Files, entry.Name())
+			files[file] = content
+		}
+		manifest.Environments = append(manifest.Environments, bundled)
+	}
+	// Links to environments of other directories, which are not part of the bundle
+	if fileExists(name + "/" + environmentManifestFile) {
+		content, err := ioutil.ReadFile(name + "/" + environmentManifestFile)
+		if err != nil {
+			return err
+		}
+		files[environmentManifestFile] = bytes.Replace(content, []byte(workingDirectory+"/"), nil, -1)
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	compressed := gzip.NewWriter(out)
+	archive := tar.NewWriter(compressed)
+
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeBundleFile(archive, bundleManifestFile, encoded, 0644); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(files))
+	for file := range files {
+		names = append(names, file)
+	}
+	sort.Strings(names)
+	for _, file := range names {
+		if err := writeBundleFile(archive, file, files[file], 0644); err != nil {
+			return err
+		}
+	}
+	if secrets == SECRETSENCRYPT {
+		encrypted, err := encryptBundleSecrets(hidden, passphrase())
+		if err != nil {
+			return err
+		}
+		if err := writeBundleFile(archive, bundleSecretsFile, encrypted, 0600); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	if err := compressed.Close(); err != nil {
+		return err
+	}
+
+	log.Info(emoji.Sprintf(":package: Exported %d environments of %s to %s", len(manifest.Environments), name, output))
+	if secrets == SECRETSREDACT && (len(hidden.Settings) > 0 || len(hidden.Keys) > 0) {
+		log.Info(emoji.Sprintf(":closed_lock_with_key: %d secrets and %d private keys were left out, they are given again on import", len(hidden.Settings), len(hidden.Keys)))
+	}
+	return err
+}
+
+// containsString reports whether a list contains a value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// readBundle reads the manifest and files of a bundle
+func readBundle(filename string) (manifest *BundleManifest, files map[string][]byte, err error) {
+	in, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer in.Close()
+	compressed, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s is not a bundle: %s", filename, err)
+	}
+	archive := tar.NewReader(compressed)
+	files = map[string][]byte{}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		// Bundles only hold files of environment directories, anything escaping them is rejected
+		name := path.Clean(header.Name)
+		if header.Typeflag != tar.TypeReg || path.IsAbs(name) || strings.HasPrefix(name, "../") || strings.Count(name, "/") > 1 {
+			return nil, nil, fmt.Errorf("Unexpected file %s in bundle %s", header.Name, filename)
+		}
+		if files[name], err = ioutil.ReadAll(archive); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	content, ok := files[bundleManifestFile]
+	if !ok {
+		return nil, nil, fmt.Errorf("%s has no %s", filename, bundleManifestFile)
+	}
+	manifest = &BundleManifest{}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, nil, err
+	}
+	if manifest.Format != bundleFormat {
+		return nil, nil, fmt.Errorf("Bundle format %d is not supported, this CLI reads format %d", manifest.Format, bundleFormat)
+	}
+	return manifest, files, err
+}
+
+// bundleTemplates makes sure the Bedrock templates the bundle was exported with are available
+func bundleTemplates(version string) (err error) {
+	if version != BEDROCK {
+		log.Warn(emoji.Sprintf(":warning: The bundle was exported with Bedrock %s templates, this CLI uses %s", version, BEDROCK))
+	}
+	if _, err := os.Stat("bedrock/cluster/environments"); err == nil {
+		return err
+	}
+	log.Info(emoji.Sprintf(":open_file_folder: Cloning Bedrock %s", version))
+	if output, err := bundleRunner("git", "clone", "--branch", version, "https://github.com/microsoft/bedrock"); err != nil {
+		return fmt.Errorf("Could not clone Bedrock %s: %s", version, strings.TrimSpace(string(output)))
+	}
+	return err
+}
+
+// validateBundle checks that the name and the files of a bundle stay inside the environment directory
+func validateBundle(manifest *BundleManifest, files map[string][]byte, name string) error {
+	if !environmentNameRegex.MatchString(name) {
+		return fmt.Errorf("Invalid environment name %s, use --name to import the bundle under another name", name)
+	}
+	for _, environment := range manifest.Environments {
+		if _, ok := GetEnvironment(environment.Type); !ok {
+			return fmt.Errorf("Unsupported environment %s in the bundle", environment.Type)
+		}
+		for _, file := range environment.Files {
+			if file != filepath.Base(file) || file == "." || file == ".." {
+				return fmt.Errorf("Unexpected file %s in the bundle", file)
+			}
+			if _, ok := files[environment.Type+"/"+file]; !ok {
+				return fmt.Errorf("The bundle has no %s/%s", environment.Type, file)
+			}
+		}
+		for _, key := range environment.Keys {
+			if key != filepath.Base(key) || key == "." || key == ".." {
+				return fmt.Errorf("Unexpected private key %s in the bundle", key)
+			}
+		}
+	}
+	return nil
+}
+
+// Import rehydrates an environment bundle under bedrock/cluster/environments and initializes its backends.
+// It returns the environment directory.
+func Import(filename string, name string) (directory string, err error) {
+	manifest, files, err := readBundle(filename)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		name = manifest.Name
+	}
+	if err := validateBundle(manifest, files, name); err != nil {
+		return "", err
+	}
+	if err := bundleTemplates(manifest.Bedrock); err != nil {
+		return "", err
+	}
+	directory = "bedrock/cluster/environments/" + name
+	if _, err := os.Stat(directory); err == nil {
+		return "", errors.New(directory + " already exists, use --name to import the bundle under another name")
+	}
+
+	var secrets *bundleSecretValues
+	if encrypted, ok := files[bundleSecretsFile]; ok {
+		if passphrase() == "" {
+			return "", errors.New("The secrets of the bundle are encrypted, give the passphrase with --passphrase or " + bundlePassphraseVariable)
+		}
+		if secrets, err = decryptBundleSecrets(encrypted, passphrase()); err != nil {
+			return "", err
+		}
+	}
+
+	missing := 0
+	for _, environment := range manifest.Environments {
+		environmentPath := directory + "/" + environment.Type
+		if err := os.MkdirAll(environmentPath, os.ModePerm); err != nil {
+			return "", err
+		}
+		for _, file := range environment.Files {
+			content := files[environment.Type+"/"+file]
+			content = replaceSettings(content, func(setting string, value string) string {
+				if !containsString(environment.Redacted, file+"#"+setting) {
+					return value
+				}
+				if secrets != nil {
+					return secrets.Settings[environment.Type+"/"+file+"#"+setting]
+				}
+				if variable, ok := bundleSecretVariables[setting]; ok && os.Getenv(variable) != "" {
+					return "\"" + os.Getenv(variable) + "\""
+				}
+				log.Warn(emoji.Sprintf(":warning: %s/%s needs a value for %s", environment.Type, file, setting))
+				missing++
+				return value
+			})
+			if err := ioutil.WriteFile(environmentPath+"/"+file, content, 0644); err != nil {
+				return "", err
+			}
+		}
+		for _, key := range environment.Keys {
+			content, ok := []byte(nil), false
+			if secrets != nil {
+				content, ok = secrets.Keys[environment.Type+"/"+key]
+			}
+			if !ok {
+				log.Warn(emoji.Sprintf(":warning: The private key %s/%s was not exported, copy it or create a new one with --force-ssh-key", environment.Type, key))
+				continue
+			}
+			if err := ioutil.WriteFile(environmentPath+"/"+key, content, 0600); err != nil {
+				return "", err
+			}
+		}
+		// Template files that were not exported come from the Bedrock templates
+		if _, err := os.Stat("bedrock/cluster/environments/" + environment.Type); err == nil {
+			if err := copyMissingFiles("bedrock/cluster/environments/"+environment.Type, environmentPath); err != nil {
+				return "", err
+			}
+		}
+	}
+	if content, ok := files[environmentManifestFile]; ok {
+		if err := ioutil.WriteFile(directory+"/"+environmentManifestFile, content, 0644); err != nil {
+			return "", err
+		}
+		manifest, err := readEnvironmentManifest(directory)
+		if err != nil {
+			return "", err
+		}
+		for _, link := range manifest.Links {
+			if !fileExists(link.Path + "/" + link.Environment + "/bedrock-backend-config.tfvars") {
+				log.Warn(emoji.Sprintf(":warning: The linked %s environment %s is not on this machine, import it too", link.Environment, link.Path))
+			}
+		}
+	}
+	log.Info(emoji.Sprintf(":inbox_tray: Imported %s to %s", filename, directory))
+
+	if missing > 0 {
+		log.Warn(emoji.Sprintf(":warning: %d secrets are missing, set them (ARM_CLIENT_SECRET, AZURE_STORAGE_KEY) and run terraform init before deploying", missing))
+		return directory, err
+	}
+	if importSkipInit {
+		return directory, err
+	}
+	environments, err := environmentsIn(directory)
+	if err != nil {
+		return directory, err
+	}
+	for _, environment := range environments {
+		env, err := authEnvironment(directory, environment.Name())
+		if err != nil {
+			return directory, err
+		}
+		prefix := "[" + name + "/" + environment.Name() + "] "
+		log.Info(emoji.Sprintf(":package: %sTerraform Init Starting...", prefix))
+		if err := terraformRunner(directory+"/"+environment.Name(), env, &prefixWriter{prefix: prefix}, terraformInitArgs(environment)...); err != nil {
+			return directory, fmt.Errorf("terraform init of %s: %s", environment.Name(), err)
+		}
+	}
+	log.Info(emoji.Sprintf(":white_check_mark: To proceed, run 'bedrock simulate %s'", directory))
+	return directory, err
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export <environment-path> [-o bundle.tar.gz] [--secrets redact|encrypt] [--passphrase passphrase]",
+	Short: "Package an environment into a portable bundle",
+	Long:  `Package the tfvars, templates and public keys of an environment directory into a bundle that can be imported on another machine with bedrock import. Terraform state, provider binaries and outputs are left out. Secrets and private keys are left out of the bundle, or encrypted with --secrets encrypt and a passphrase (--passphrase or BEDROCK_BUNDLE_PASSPHRASE).`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		output := bundleOutput
+		if output == "" {
+			output = filepath.Base(strings.TrimSuffix(args[0], "/")) + ".tar.gz"
+		}
+		return Export(args[0], output, bundleSecrets)
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <bundle> [--name environment-name] [--passphrase passphrase] [--skip-init]",
+	Short: "Create an environment from a bundle made with bedrock export",
+	Long:  `Create an environment under bedrock/cluster/environments from a bundle made with bedrock export, and run terraform init to connect it to its backend. Secrets that were left out of the bundle are taken from ARM_CLIENT_SECRET and AZURE_STORAGE_KEY, encrypted secrets are decrypted with --passphrase or BEDROCK_BUNDLE_PASSPHRASE.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		_, err = Import(args[0], importName)
+		return err
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringVarP(&bundleOutput, "output", "o", "", "File the bundle is written to (defaults to <environment-name>.tar.gz)")
+	exportCmd.Flags().StringVar(&bundleSecrets, "secrets", SECRETSREDACT, "How secrets and private keys are exported: redact (left out) or encrypt")
+	exportCmd.Flags().StringVar(&bundlePassphrase, "passphrase", "", "Passphrase encrypting the secrets")
+	importCmd.Flags().StringVar(&importName, "name", "", "Name of the environment (defaults to the name it was exported with)")
+	importCmd.Flags().StringVar(&bundlePassphrase, "passphrase", "", "Passphrase decrypting the secrets")
+	importCmd.Flags().BoolVar(&importSkipInit, "skip-init", false, "Do not run terraform init")
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
+}
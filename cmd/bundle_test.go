@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// bundleEntries lists the files of a bundle with their content
+func bundleEntries(t *testing.T, filename string) map[string]string {
+	in, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	compressed, err := gzip.NewReader(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := tar.NewReader(compressed)
+	entries := map[string]string{}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(archive)
+		entries[header.Name] = string(content)
+	}
+}
+
+func TestBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	workingDirectory, _ := os.Getwd()
+	defer os.Chdir(workingDirectory)
+	os.Chdir(dir)
+	cwd, _ := os.Getwd()
+
+	defer func(registry []EnvironmentType) { environmentRegistry = registry }(environmentRegistry)
+	environmentRegistry = nil
+	RegisterEnvironment(&templateEnvironment{name: "test-common", backend: true})
+	RegisterEnvironment(&templateEnvironment{name: "test-app", dependencies: []string{"test-common"}})
+
+	// The Bedrock templates are already cloned
+	os.MkdirAll("bedrock/cluster/environments/test-app", os.ModePerm)
+	ioutil.WriteFile("bedrock/cluster/environments/test-app/variables.tf", []byte("variable \"cluster_name\" {}\n"), 0644)
+
+	source := "bedrock/cluster/environments/source"
+	deployFixture(t, "bedrock/cluster/environments", map[string][]string{"source": {"test-common", "test-app"}})
+	app := source + "/test-app"
+	ioutil.WriteFile(app+"/bedrock-config.tfvars", []byte("cluster_name = \"source\"\nservice_principal_secret = \"sp-secret\"\nkubeconfig_path = \""+cwd+"/output\"\n"), 0644)
+	ioutil.WriteFile(app+"/main.tf", []byte("module \"aks\" {}\n"), 0644)
+	ioutil.WriteFile(app+"/deploy-key", []byte("private key"), 0600)
+	ioutil.WriteFile(app+"/deploy-key.pub", []byte("ssh-rsa public"), 0644)
+	ioutil.WriteFile(app+"/terraform.tfstate", []byte("{}"), 0644)
+	ioutil.WriteFile(app+"/"+deployedTfvars, []byte("cluster_name = \"source\"\n"), 0644)
+	os.MkdirAll(app+"/.terraform/plugins", os.ModePerm)
+	ioutil.WriteFile(app+"/.terraform/plugins/terraform-provider-azurerm", []byte("binary"), 0777)
+	ioutil.WriteFile(source+"/test-common/bedrock-backend-config.tfvars", []byte("access_key = \"storage-key\"\ncontainer_name = \"tfstate\"\nkey = \"tfstate-common\"\nstorage_account_name = \"state\"\n"), 0644)
+
+	var initialized []string
+	defer func(runner func(string, []string, io.Writer, ...string) error) { terraformRunner = runner }(terraformRunner)
+	terraformRunner = func(directory string, env []string, out io.Writer, args ...string) error {
+		initialized = append(initialized, directory+" "+strings.Join(args, " "))
+		return nil
+	}
+
+	// Secrets, private keys, state and provider binaries are left out of a redacted bundle
+	if err := Export(source, "redacted.tar.gz", SECRETSREDACT); err != nil {
+		t.Fatal(err)
+	}
+	entries := bundleEntries(t, "redacted.tar.gz")
+	for _, file := range []string{bundleManifestFile, "test-app/bedrock-config.tfvars", "test-app/main.tf", "test-app/deploy-key.pub", "test-common/bedrock-backend-config.tfvars"} {
+		if _, ok := entries[file]; !ok {
+			t.Errorf("Expected %s in the bundle", file)
+		}
+	}
+	for file, content := range entries {
+		if strings.Contains(file, "deploy-key") && !strings.HasSuffix(file, ".pub") || strings.Contains(file, "tfstate") || strings.Contains(file, ".terraform") || strings.Contains(file, "deployed") {
+			t.Errorf("Unexpected file %s in the bundle", file)
+		}
+		for _, secret := range []string{"sp-secret", "storage-key", "private key", cwd} {
+			if strings.Contains(content, secret) {
+				t.Errorf("%s of the bundle contains %q", file, secret)
+			}
+		}
+	}
+	if !strings.Contains(entries["test-app/bedrock-config.tfvars"], "kubeconfig_path = \"output\"") {
+		t.Errorf("Expected paths relative to the project directory:\n%s", entries["test-app/bedrock-config.tfvars"])
+	}
+
+	// Redacted secrets come from the environment on import
+	os.Setenv("ARM_CLIENT_SECRET", "new-secret")
+	os.Setenv("AZURE_STORAGE_KEY", "new-key")
+	defer os.Unsetenv("ARM_CLIENT_SECRET")
+	defer os.Unsetenv("AZURE_STORAGE_KEY")
+	directory, err := Import("redacted.tar.gz", "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, _ := ReadTfvarsFile(directory + "/test-app/bedrock-config.tfvars")
+	if config["service_principal_secret"] != "\"new-secret\"" || config["cluster_name"] != "\"source\"" {
+		t.Errorf("Unexpected imported tfvars %v", config)
+	}
+	backend, _ := ReadTfvarsFile(directory + "/test-common/bedrock-backend-config.tfvars")
+	if backend["access_key"] != "\"new-key\"" {
+		t.Errorf("Expected the storage key to be restored, got %v", backend)
+	}
+	if !fileExists(directory+"/test-app/variables.tf") || fileExists(directory+"/test-app/deploy-key") {
+		t.Error("Expected the missing template files to be added, without a private key")
+	}
+	if len(initialized) != 2 || !strings.HasSuffix(initialized[0], "test-common init -input=false -backend-config=./bedrock-backend-config.tfvars") {
+		t.Errorf("Expected the backends to be initialized, got %v", initialized)
+	}
+	if _, err := Import("redacted.tar.gz", "copy"); err == nil {
+		t.Error("Expected an existing environment not to be overwritten")
+	}
+	if _, err := Import("redacted.tar.gz", "../escape"); err == nil || fileExists("bedrock/cluster/escape") {
+		t.Error("Expected a name escaping the environments directory to be rejected")
+	}
+	manifest, files, _ := readBundle("redacted.tar.gz")
+	manifest.Environments[0].Files = append(manifest.Environments[0].Files, "../../escape")
+	if err := validateBundle(manifest, files, "copy"); err == nil {
+		t.Error("Expected a file escaping the environment directory to be rejected")
+	}
+
+	// Encrypted secrets and keys need the passphrase
+	defer func() { bundlePassphrase = "" }()
+	os.Unsetenv("ARM_CLIENT_SECRET")
+	os.Unsetenv("AZURE_STORAGE_KEY")
+	bundlePassphrase = "correct horse"
+	if err := Export(source, "encrypted.tar.gz", SECRETSENCRYPT); err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range bundleEntries(t, "encrypted.tar.gz") {
+		if strings.Contains(content, "sp-secret") || strings.Contains(content, "private key") {
+			t.Errorf("%s of the encrypted bundle contains a secret in plain text", file)
+		}
+	}
+	bundlePassphrase = "wrong"
+	if _, err := Import("encrypted.tar.gz", "encrypted"); err == nil {
+		t.Error("Expected a wrong passphrase to be rejected")
+	}
+	bundlePassphrase = "correct horse"
+	if directory, err = Import("encrypted.tar.gz", "encrypted"); err != nil {
+		t.Fatal(err)
+	}
+	config, _ = ReadTfvarsFile(directory + "/test-app/bedrock-config.tfvars")
+	key, _ := ioutil.ReadFile(directory + "/test-app/deploy-key")
+	if config["service_principal_secret"] != "\"sp-secret\"" || string(key) != "private key" {
+		t.Errorf("Expected the secrets to be decrypted, got %v and %q", config, key)
+	}
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Config file that is read when --config is not given
+const defaultConfigFile = "bedrock.yaml"
+
+var configFile string
+
+// cliConfig holds the settings of the config file. It is separate from the global viper
+// instance, which is used to read the bedrock-sp-config of environments.
+var cliConfig = viper.New()
+
+// loadConfigFile sets the flags of cmd that were not given on the command line from the config file.
+// Settings use the flag names as keys, e.g. `region: westus2` or `tag: [owner=me, environment=dev]`.
+func loadConfigFile(cmd *cobra.Command) (err error) {
+	path := configFile
+	if path == "" {
+		if !fileExists(defaultConfigFile) {
+			return err
+		}
+		path = defaultConfigFile
+	}
+
+	cliConfig.SetConfigFile(path)
+	if err := cliConfig.ReadInConfig(); err != nil {
+		log.Error(emoji.Sprintf(":no_entry_sign: Unable to read config file %s: %s", path, err))
+		return err
+	}
+	log.Info(emoji.Sprintf(":gear: Using config file %s", path))
+
+	var errs []string
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Changed || !cliConfig.IsSet(flag.Name) {
+			return
+		}
+		values := []string{cliConfig.GetString(flag.Name)}
+		if strings.HasSuffix(flag.Value.Type(), "Array") || strings.HasSuffix(flag.Value.Type(), "Slice") {
+			values = cliConfig.GetStringSlice(flag.Name)
+		}
+		for _, value := range values {
+			if err := cmd.Flags().Set(flag.Name, value); err != nil {
+				errs = append(errs, flag.Name+": "+err.Error())
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return errors.New("Invalid settings in config file " + path + ": " + strings.Join(errs, ", "))
+	}
+	return err
+}
@@ -1,9 +1,16 @@
 package cmd
 
+import "github.com/yradsmikham/bedrock-cli/pkg/bedrock"
+
 // A dictionary of all Bedrock environments
 const (
 	SIMPLE   = "azure-simple"            // Refers to Bedrock Azure Simple env
 	KEYVAULT = "azure-single-keyvault"   // Refers to Bedrock Azure Single Keyvault env
 	MULTIPLE = "azure-multiple-clusters" // Refers to Bedrock Azure Multiple Clusters env
 	COMMON   = "azure-common-infra"      // Refers to Azure Common Infra env
+
+	COSMOS = "azure-single-keyvault-cosmos-mongo-db-simple" // Refers to Bedrock Azure Single Keyvault env with Cosmos DB
 )
+
+// BEDROCK is the version of the Bedrock repo the CLI templates are pinned to
+const BEDROCK = bedrock.TemplatesVersion
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+var costEstimate bool
+var pricingFile string
+
+// PricingTable holds the prices the cost estimate is based on, see pricing/bedrock-pricing.json
+type PricingTable struct {
+	Currency      string             `json:"currency"`
+	Updated       string             `json:"updated"`
+	HoursPerMonth float64            `json:"hours_per_month"`
+	VMSizes       map[string]float64 `json:"vm_sizes"`  // Price per hour of a node of the VM size
+	Resources     map[string]float64 `json:"resources"` // Price per month of a resource type, or of a type/sku
+}
+
+// ResourceCost is the monthly cost of a resource before and after the plan is applied
+type ResourceCost struct {
+	Address string
+	Details string // e.g. 3 x Standard_D4s_v3
+	Current float64
+	Planned float64
+}
+
+// CostEstimate is the monthly cost of the resources of one environment
+type CostEstimate struct {
+	Environment string
+	Resources   []ResourceCost
+	Unpriced    []string // VM sizes missing from the pricing table
+}
+
+// Totals returns the monthly cost of the environment before and after the plan is applied
+func (estimate *CostEstimate) Totals() (current float64, planned float64) {
+	for _, resource := range estimate.Resources {
+		current += resource.Current
+		planned += resource.Planned
+	}
+	return current, planned
+}
+
+// loadPricingTable reads a pricing table from a local file
+func loadPricingTable(filename string) (table *PricingTable, err error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the pricing table: %s", err)
+	}
+	table = &PricingTable{}
+	if err := json.Unmarshal(content, table); err != nil {
+		return nil, fmt.Errorf("Could not parse the pricing table %s: %s", filename, err)
+	}
+	if table.HoursPerMonth == 0 {
+		table.HoursPerMonth = 730
+	}
+	return table, err
+}
+
+// planNumber reads a number from a plan value, which can be a JSON number or a string
+func planNumber(value interface{}) float64 {
+	switch value := value.(type) {
+	case float64:
+		return value
+	case string:
+		number, _ := strconv.ParseFloat(value, 64)
+		return number
+	}
+	return 0
+}
+
+// nodePools returns the VM size and node count of the node pools of an AKS cluster or node pool resource
+func nodePools(resourceType string, values map[string]interface{}) (pools []map[string]interface{}) {
+	if resourceType == "azurerm_kubernetes_cluster_node_pool" {
+		return []map[string]interface{}{values}
+	}
+	// agent_pool_profile with the azurerm provider the Bedrock templates pin, default_node_pool with newer ones
+	for _, block := range []string{"agent_pool_profile", "default_node_pool"} {
+		if list, ok := values[block].([]interface{}); ok {
+			for _, pool := range list {
+				if pool, ok := pool.(map[string]interface{}); ok {
+					pools = append(pools, pool)
+				}
+			}
+		}
+	}
+	return pools
+}
+
+// resourceCost returns the monthly cost of a resource from its planned or current values
+func resourceCost(table *PricingTable, resourceType string, values map[string]interface{}) (cost float64, details string, unpriced []string, priced bool) {
+	if values == nil {
+		return 0, "", nil, false
+	}
+	if sku, ok := values["sku"].(string); ok {
+		cost, priced = table.Resources[resourceType+"/"+sku]
+	}
+	if !priced {
+		cost, priced = table.Resources[resourceType]
+	}
+
+	for _, pool := range nodePools(resourceType, values) {
+		size, _ := pool["vm_size"].(string)
+		count := planNumber(pool["node_count"])
+		if count == 0 {
+			count = planNumber(pool["count"])
+		}
+		if count == 0 {
+			count = planNumber(pool["min_count"])
+		}
+		if details != "" {
+			details += ", "
+		}
+		details += fmt.Sprintf("%g x %s", count, size)
+		price, ok := table.VMSizes[size]
+		if !ok {
+			details += " (no price)"
+			unpriced = append(unpriced, size)
+			continue
+		}
+		cost += count * price * table.HoursPerMonth
+		priced = true
+	}
+	return cost, details, unpriced, priced
+}
+
+// terraformPlanChanges is the part of `terraform show -json` listing the planned changes of every resource
+type terraformPlanChanges struct {
+	ResourceChanges []struct {
+		Address string `json:"address"`
+		Mode    string `json:"mode"`
+		Type    string `json:"type"`
+		Change  struct {
+			Before map[string]interface{} `json:"before"`
+			After  map[string]interface{} `json:"after"`
+		} `json:"change"`
+	} `json:"resource_changes"`
+}
+
+// estimatePlanCost estimates the monthly cost of the resources of a plan, before and after it is applied
+func estimatePlanCost(environment string, plan []byte, table *PricingTable) (estimate CostEstimate, err error) {
+	estimate.Environment = environment
+	var parsed terraformPlanChanges
+	if err := json.Unmarshal(plan, &parsed); err != nil {
+		return estimate, err
+	}
+
+	unpriced := map[string]bool{}
+	for _, change := range parsed.ResourceChanges {
+		if change.Mode == "data" {
+			continue
+		}
+		current, currentDetails, currentUnpriced, currentPriced := resourceCost(table, change.Type, change.Change.Before)
+		planned, details, plannedUnpriced, plannedPriced := resourceCost(table, change.Type, change.Change.After)
+		for _, size := range append(currentUnpriced, plannedUnpriced...) {
+			unpriced[size] = true
+		}
+		if !currentPriced && !plannedPriced {
+			continue
+		}
+		if currentDetails != details && currentDetails != "" {
+			details = currentDetails + " -> " + details
+		}
+		estimate.Resources = append(estimate.Resources, ResourceCost{Address: change.Address, Details: details, Current: current, Planned: planned})
+	}
+	for size := range unpriced {
+		estimate.Unpriced = append(estimate.Unpriced, size)
+	}
+	sort.Strings(estimate.Unpriced)
+	return estimate, err
+}
+
+// writeCostEstimate writes the monthly cost of every environment and resource, with the change the plan makes
+func writeCostEstimate(out io.Writer, estimates []CostEstimate, table *PricingTable) {
+	fmt.Fprintf(out, "Estimated monthly cost in %s, based on the prices of %s\n\n", table.Currency, table.Updated)
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	row := func(cells ...string) {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", cells[0], cells[1], cells[2], cells[3], cells[4])
+	}
+	amount := func(value float64) string { return strconv.FormatFloat(value, 'f', 2, 64) }
+	delta := func(current, planned float64) string { return fmt.Sprintf("%+.2f", planned-current) }
+
+	var allCurrent, allPlanned float64
+	for _, estimate := range estimates {
+		row(estimate.Environment, "", "CURRENT", "PLANNED", "DELTA")
+		for _, resource := range estimate.Resources {
+			row("  "+resource.Address, resource.Details, amount(resource.Current), amount(resource.Planned), delta(resource.Current, resource.Planned))
+		}
+		current, planned := estimate.Totals()
+		allCurrent += current
+		allPlanned += planned
+		row("  total", "", amount(current), amount(planned), delta(current, planned))
+		row("", "", "", "", "")
+	}
+	if len(estimates) > 1 {
+		row("TOTAL", "", amount(allCurrent), amount(allPlanned), delta(allCurrent, allPlanned))
+	}
+	writer.Flush()
+
+	for _, estimate := range estimates {
+		for _, size := range estimate.Unpriced {
+			fmt.Fprintf(out, "%s: no price for VM size %s, its nodes are not included\n", estimate.Environment, size)
+		}
+	}
+}
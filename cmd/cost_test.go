@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const costPlanJSON = `{
+  "resource_changes": [
+    {
+      "address": "module.west.module.aks.azurerm_kubernetes_cluster.cluster",
+      "mode": "managed",
+      "type": "azurerm_kubernetes_cluster",
+      "change": {
+        "before": {"agent_pool_profile": [{"count": 3, "vm_size": "Standard_D4s_v3"}]},
+        "after": {"agent_pool_profile": [{"count": 5, "vm_size": "Standard_D4s_v3"}]}
+      }
+    },
+    {
+      "address": "azurerm_key_vault.keyvault",
+      "mode": "managed",
+      "type": "azurerm_key_vault",
+      "change": {"before": null, "after": {"name": "my-kv"}}
+    },
+    {
+      "address": "azurerm_public_ip.tm",
+      "mode": "managed",
+      "type": "azurerm_public_ip",
+      "change": {"before": {"sku": "Standard"}, "after": {"sku": "Standard"}}
+    },
+    {
+      "address": "azurerm_subnet.subnet",
+      "mode": "managed",
+      "type": "azurerm_subnet",
+      "change": {"before": null, "after": {"name": "subnet"}}
+    },
+    {
+      "address": "azurerm_kubernetes_cluster_node_pool.gpu",
+      "mode": "managed",
+      "type": "azurerm_kubernetes_cluster_node_pool",
+      "change": {"before": null, "after": {"node_count": 1, "vm_size": "Standard_NC6"}}
+    },
+    {
+      "address": "data.azurerm_key_vault.keyvault",
+      "mode": "data",
+      "type": "azurerm_key_vault",
+      "change": {"before": null, "after": {"name": "my-kv"}}
+    }
+  ]
+}`
+
+func TestCostEstimate(t *testing.T) {
+	table, err := loadPricingTable("../pricing/bedrock-pricing.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	estimate, err := estimatePlanCost("my-cluster/azure-multiple-clusters", []byte(costPlanJSON), table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(estimate.Resources) != 4 {
+		t.Fatalf("Expected the cluster, keyvault, public IP and node pool to be priced, got %v", estimate.Resources)
+	}
+	if estimate.Resources[0].Details != "3 x Standard_D4s_v3 -> 5 x Standard_D4s_v3" {
+		t.Errorf("Unexpected node pool details %q", estimate.Resources[0].Details)
+	}
+	current, planned := estimate.Totals()
+	if fmt.Sprintf("%.2f %.2f", current, planned) != "424.13 704.75" {
+		t.Errorf("Unexpected totals %.2f %.2f", current, planned)
+	}
+	if estimate.Resources[3].Details != "1 x Standard_NC6 (no price)" || len(estimate.Unpriced) != 1 || estimate.Unpriced[0] != "Standard_NC6" {
+		t.Errorf("Expected Standard_NC6 to have no price, got %v", estimate.Unpriced)
+	}
+
+	var out bytes.Buffer
+	writeCostEstimate(&out, []CostEstimate{estimate}, table)
+	for _, expected := range []string{"Estimated monthly cost in USD", "+280.32", "+280.62", "no price for VM size Standard_NC6"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in the estimate:\n%s", expected, out.String())
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"errors"
 	"os/exec"
 
 	"github.com/kyokomi/emoji"
@@ -48,10 +49,13 @@ func Demo(servicePrincipal string, secret string) (err error) {
 }
 
 var demoCmd = &cobra.Command{
-	Use:   "demo --sp service-principal-app-id --secret service-principal-password --gitops-ssh-url manifest-repo-url-in-ssh-format",
+	Use:   "demo [--sp service-principal-app-id --secret service-principal-password | --create-sp] --gitops-ssh-url manifest-repo-url-in-ssh-format",
 	Short: "Demo an Azure Kubernetes Service (AKS) cluster using Terraform",
 	Long:  `Demo an Azure Kubernetes Service (AKS) cluster using Terraform`,
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if !createSP && (servicePrincipal == "" || secret == "") {
+			return errors.New("You need to specify --sp and --secret, or use --create-sp")
+		}
 		return Demo(servicePrincipal, secret)
 	},
 }
@@ -59,12 +63,7 @@ var demoCmd = &cobra.Command{
 func init() {
 	demoCmd.Flags().StringVar(&servicePrincipal, "sp", "", "Service Principal App Id")
 	demoCmd.Flags().StringVar(&secret, "secret", "", "Password for the Service Principal")
+	demoCmd.Flags().BoolVar(&createSP, "create-sp", false, "Create a Contributor service principal for the demo cluster")
 	demoCmd.Flags().StringVar(&gitopsSSHUrl, "gitops-ssh-url", "git@github.com:timfpark/fabrikate-cloud-native-manifests.git", "The git repo that contains the resource manifests that should be deployed in the cluster in ssh format.")
-	if error := demoCmd.MarkFlagRequired("sp"); error != nil {
-		return
-	}
-	if error := demoCmd.MarkFlagRequired("secret"); error != nil {
-		return
-	}
 	rootCmd.AddCommand(demoCmd)
 }
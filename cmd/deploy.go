@@ -1,143 +1,415 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
-	"os/exec"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
 	"github.com/kyokomi/emoji"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/yradsmikham/bedrock-cli/pkg/bedrock"
 	util "github.com/yradsmikham/bedrock-cli/util"
 )
 
-// Deploy a bedrock environment by executing `terraform apply`
-func Deploy(name string) (err error) {
-	log.Info(emoji.Sprintf(":rocket: Starting Environment Deployment!"))
+var deployParallelism int
+var deployAll bool
+
+// Status of an environment at the end of a deployment
+const (
+	DEPLOYED = "deployed"
+	FAILED   = "failed"
+	SKIPPED  = "skipped"
+)
+
+// deployedTfvars is the copy of bedrock-config.tfvars made at the last successful deploy
+const deployedTfvars = bedrock.DeployedFile
 
+// deployPlan is the file the plan checked against the policies is saved to until it is applied
+const deployPlan = "bedrock-deploy.tfplan"
+
+// deployPolicies are the policy rules the plans are checked against before they are applied
+var deployPolicies []PolicyRule
+
+// terraformRunner runs terraform for the deployments, replaced in tests
+var terraformRunner = util.TerraformRun
+
+// postDeployLock serializes the post deploy hooks, which update the shared local kubeconfig
+var postDeployLock sync.Mutex
+
+// environmentsIn returns the environment types found in an environment directory, dependencies first
+func environmentsIn(name string) (environments []EnvironmentType, err error) {
 	files, err := ioutil.ReadDir(name)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	found := map[string]bool{}
 	for _, f := range files {
-		log.Info(emoji.Sprintf(":eyes: Searching for azure-common-infra environment..."))
-		if f.Name() == COMMON {
-			log.Info(emoji.Sprintf(":round_pushpin: Azure-common-infra environment found!"))
-			setEnv(name, COMMON)
-
-			// Terraform Init
-			if error := util.TerraformInitBackend(name + "/azure-common-infra"); error != nil {
-				return error
-			}
+		found[f.Name()] = f.IsDir()
+	}
+	for _, environment := range Environments() {
+		if found[environment.Name()] {
+			environments = append(environments, environment)
+		}
+	}
+	return deploymentOrder(environments), err
+}
 
-			// Terraform Plan
-			if error := util.TerraformApply(name + "/azure-common-infra"); error != nil {
-				return error
-			}
+// terraformInitArgs returns the arguments of a non interactive terraform init of an environment
+func terraformInitArgs(environment EnvironmentType) []string {
+	args := []string{"init", "-input=false"}
+	if environment.Backend() {
+		args = append(args, "-backend-config=./bedrock-backend-config.tfvars")
+	}
+	return args
+}
+
+// deployTask is the deployment of one environment type of an environment directory
+type deployTask struct {
+	ID          string // e.g. my-cluster/azure-common-infra
+	Cluster     string // Environment directory, e.g. bedrock/cluster/environments/my-cluster
+	Environment EnvironmentType
+	DependsOn   []string
+}
+
+// Directory of the terraform template of the task
+func (task *deployTask) Directory() string {
+	return task.Cluster + "/" + task.Environment.Name()
+}
+
+// deployResult is the outcome of a deployTask
+type deployResult struct {
+	ID       string
+	Status   string
+	Duration time.Duration
+	Err      error
+}
 
-			break
+// backendState identifies the remote terraform state of an environment. Copies of the same
+// common infra in several environment directories share their state and are deployed once.
+func backendState(directory string) string {
+	if !fileExists(directory + "/bedrock-backend-config.tfvars") {
+		return ""
+	}
+	config, err := ReadTfvarsFile(directory + "/bedrock-backend-config.tfvars")
+	if err != nil || config["key"] == "" {
+		return ""
+	}
+	return config["storage_account_name"] + "/" + config["container_name"] + "/" + config["key"]
+}
+
+// deploymentGraph builds the tasks deploying the given environment directories. Environments depend
+// on the environments of the same directory they need, e.g. azure-common-infra, or on the environments
+// linked in their environment manifest when those are deployed too.
+func deploymentGraph(names []string) (tasks []*deployTask, err error) {
+	states := map[string]string{}
+	links := map[*deployTask][]string{}
+	for _, name := range names {
+		environments, err := environmentsIn(name)
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := readEnvironmentManifest(name)
+		if err != nil {
+			return nil, err
+		}
+		ids := map[string]string{}
+		for _, environment := range environments {
+			task := &deployTask{ID: filepath.Base(name) + "/" + environment.Name(), Cluster: name, Environment: environment}
+			if environment.Backend() {
+				state := backendState(task.Directory())
+				if id, ok := states[state]; ok && state != "" {
+					log.Info(emoji.Sprintf(":link: %s shares its terraform state with %s and is deployed once", task.ID, id))
+					ids[environment.Name()] = id
+					continue
+				}
+				states[state] = task.ID
+			}
+			for _, dependency := range environment.Dependencies() {
+				if id, ok := ids[dependency]; ok {
+					task.DependsOn = append(task.DependsOn, id)
+				} else if link := manifest.Link(dependency); link != nil {
+					links[task] = append(links[task], backendState(link.Path+"/"+dependency))
+				}
+			}
+			ids[environment.Name()] = task.ID
+			tasks = append(tasks, task)
 		}
 	}
 
-	// Run Terraform Init on everything else (e.g. azure-single-keyvault, azure-multi-cluster)
-	for _, f := range files {
-		if f.Name() == SIMPLE {
-			log.Info(emoji.Sprintf(":dancers: Deploying Azure-Simple Environment"))
-			setEnv(name, SIMPLE)
-			// Terraform Init
-			if error := util.TerraformInit(name + "/azure-simple"); error != nil {
-				return error
+	// Linked environments are not deployed again, they only go first when they are part of the deployment
+	for task, linked := range links {
+		for _, state := range linked {
+			if id, ok := states[state]; ok && state != "" {
+				task.DependsOn = append(task.DependsOn, id)
 			}
+		}
+	}
+	return tasks, err
+}
+
+// runDeploymentGraph runs the tasks with at most parallelism of them at a time, each one once its
+// dependencies are deployed. A failure skips the tasks depending on it, other tasks keep going.
+func runDeploymentGraph(tasks []*deployTask, parallelism int, run func(task *deployTask) error) (results []deployResult) {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	status := map[string]string{}
+	started := map[string]bool{}
+	done := make(chan deployResult)
+	running := 0
 
-			// Terraform Plan
-			if error := util.TerraformApply(name + "/azure-simple"); error != nil {
-				return error
+	for len(results) < len(tasks) {
+		// Skipping a task can unblock the tasks listed before it, so scan until nothing changes
+		for changed := true; changed; {
+			changed = false
+			for _, task := range tasks {
+				if started[task.ID] || running >= parallelism {
+					continue
+				}
+				ready := true
+				var blocked []string
+				for _, dependency := range task.DependsOn {
+					switch status[dependency] {
+					case DEPLOYED:
+					case FAILED, SKIPPED:
+						blocked = append(blocked, dependency)
+					default:
+						ready = false
+					}
+				}
+				if len(blocked) > 0 {
+					started[task.ID] = true
+					status[task.ID] = SKIPPED
+					changed = true
+					log.Warn(emoji.Sprintf(":fast_forward: [%s] Skipped, %s did not deploy", task.ID, strings.Join(blocked, ", ")))
+					results = append(results, deployResult{ID: task.ID, Status: SKIPPED, Err: errors.New("Depends on " + strings.Join(blocked, ", "))})
+					continue
+				}
+				if !ready {
+					continue
+				}
+				started[task.ID] = true
+				changed = true
+				running++
+				go func(task *deployTask) {
+					start := time.Now()
+					err := run(task)
+					result := deployResult{ID: task.ID, Status: DEPLOYED, Duration: time.Since(start), Err: err}
+					if err != nil {
+						result.Status = FAILED
+					}
+					done <- result
+				}(task)
 			}
+		}
 
-			// Add to local kubeconfig
-			log.Info(emoji.Sprintf(":mailbox_with_mail: Found Kubeconfig output. Merging into local kubeconfig."))
-			mergeConfigCmd := exec.Command("/bin/sh", "-c", "KUBECONFIG=./output/bedrock_kube_config:~/.kube/config kubectl config view --flatten > merged-config && mv merged-config ~/.kube/config")
-			mergeConfigCmd.Dir = name + "/azure-simple"
-			if output, err := mergeConfigCmd.CombinedOutput(); err != nil {
-				log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, output))
-				return err
+		if running == 0 {
+			// Whatever is left waits on tasks that are not part of the graph or on each other
+			for _, task := range tasks {
+				if !started[task.ID] {
+					started[task.ID] = true
+					status[task.ID] = SKIPPED
+					results = append(results, deployResult{ID: task.ID, Status: SKIPPED, Err: errors.New("Unresolved dependencies " + strings.Join(task.DependsOn, ", "))})
+				}
 			}
+			continue
+		}
+
+		result := <-done
+		running--
+		status[result.ID] = result.Status
+		results = append(results, result)
+	}
+	return results
+}
+
+// prefixWriter logs the output of terraform line by line, prefixed with the environment it belongs to
+type prefixWriter struct {
+	prefix string
+	buffer []byte
+}
 
-			break
+func (w *prefixWriter) Write(p []byte) (n int, err error) {
+	w.buffer = append(w.buffer, p...)
+	for {
+		newline := strings.IndexByte(string(w.buffer), '\n')
+		if newline < 0 {
+			return len(p), err
 		}
-		if f.Name() == KEYVAULT {
-			log.Info(emoji.Sprintf(":dancers: Deploying Azure-Single-Keyvault Environment"))
-			setEnv(name, KEYVAULT)
+		log.Info(w.prefix + strings.TrimRight(string(w.buffer[:newline]), "\r"))
+		w.buffer = w.buffer[newline+1:]
+	}
+}
 
-			// Terraform Init
-			if error := util.TerraformInitBackend(name + "/azure-single-keyvault"); error != nil {
-				return error
-			}
+// stepStarted is logged when a terraform step of an environment starts
+var stepStarted = map[string]string{
+	"init":    ":package: %sTerraform Init Starting...",
+	"plan":    ":scroll: %sTerraform Plan Starting...",
+	"apply":   ":hammer: %sTerraform Apply Starting...",
+	"destroy": ":bomb: %sTerraform Destroy Starting...",
+}
 
-			// Terraform Plan
-			if error := util.TerraformApply(name + "/azure-single-keyvault"); error != nil {
-				return error
-			}
+// logEvent logs the progress of the bedrock API operations, with the id of the environment in front
+func logEvent(event bedrock.Event) {
+	prefix := "[" + event.Environment + "] "
+	switch event.Type {
+	case bedrock.EventStarted:
+		if message, ok := stepStarted[event.Step]; ok {
+			log.Info(emoji.Sprintf(message, prefix))
+		} else {
+			log.Info(emoji.Sprintf(":gear: %s%s", prefix, event.Message))
+		}
+	case bedrock.EventOutput:
+		log.Info(prefix + event.Message)
+	case bedrock.EventWarning:
+		log.Warn(emoji.Sprintf(":warning: %s%s", prefix, event.Message))
+	}
+}
 
-			// Add to local kubeconfig
-			log.Info(emoji.Sprintf(":mailbox_with_mail: Found Kubeconfig output. Merging into local kubeconfig."))
-			mergeConfigCmd := exec.Command("/bin/sh", "-c", "KUBECONFIG=./output/bedrock_kube_config:~/.kube/config kubectl config view --flatten > merged-config && mv merged-config ~/.kube/config")
-			mergeConfigCmd.Dir = name + "/azure-single-keyvault"
-			if output, err := mergeConfigCmd.CombinedOutput(); err != nil {
-				log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, output))
-				return err
-			}
+// bedrockClient runs the operations of the bedrock API with terraformRunner, logging their progress
+func bedrockClient() *bedrock.Client {
+	return bedrock.New(
+		bedrock.WithTerraform(bedrock.TerraformFunc(func(ctx context.Context, directory string, env []string, out io.Writer, args ...string) error {
+			return terraformRunner(directory, env, out, args...)
+		})),
+		bedrock.WithEvents(logEvent),
+	)
+}
 
-			break
+// apiEnvironment is the environment of a task for the bedrock API
+func (task *deployTask) apiEnvironment() *bedrock.Environment {
+	return &bedrock.Environment{Name: filepath.Base(task.Cluster), Type: task.Environment.Name(), Path: task.Directory(), Backend: task.Environment.Backend()}
+}
+
+// deployEnvironment runs terraform init and apply for a task, with the credentials of its environment
+func deployEnvironment(task *deployTask) (err error) {
+	client, environment := bedrockClient(), task.apiEnvironment()
+	options := bedrock.ApplyOptions{}
+	if len(deployPolicies) > 0 {
+		// Apply the plan that was checked, rather than planning again
+		log.Info(emoji.Sprintf(":scroll: [%s] Checking the plan against the policies...", task.ID))
+		defer os.Remove(task.Directory() + "/" + deployPlan)
+		plan, err := client.Plan(context.Background(), environment, bedrock.PlanOptions{PlanFile: deployPlan})
+		if err != nil {
+			return err
+		}
+		if err := checkPolicies(deployPolicies, task.ID, task.Directory(), plan.JSON, &prefixWriter{prefix: "[" + task.ID + "] "}); err != nil {
+			return err
 		}
-		if f.Name() == MULTIPLE {
-			log.Info(emoji.Sprintf(":dancers: Deploying Azure-Multiple-Clusters Environment"))
-			setEnv(name, MULTIPLE)
+		options = bedrock.ApplyOptions{PlanFile: deployPlan, SkipInit: true}
+	}
+	if _, err := client.Apply(context.Background(), environment, options); err != nil {
+		return err
+	}
 
-			// Terraform Init
-			if error := util.TerraformInitBackend(name + "/azure-multiple-clusters"); error != nil {
-				return error
-			}
+	// e.g. add the cluster credentials to the local kubeconfig
+	postDeployLock.Lock()
+	defer postDeployLock.Unlock()
+	return task.Environment.PostDeploy(task.Directory())
+}
 
-			// Terraform Plan
-			if error := util.TerraformApply(name + "/azure-multiple-clusters"); error != nil {
-				return error
-			}
+// destroyEnvironment runs terraform init and destroy for a task, with the credentials of its environment
+func destroyEnvironment(task *deployTask) (err error) {
+	if err := bedrockClient().Destroy(context.Background(), task.apiEnvironment(), bedrock.DestroyOptions{}); err != nil {
+		return err
+	}
+	return deleteServicePrincipal(task.Cluster)
+}
 
-			// TO-DO: For multiple cluster, must add each cluster individually
-			log.Info(emoji.Sprintf(":mailbox_with_mail: Found Kubeconfig output. Merging into local kubeconfig."))
-			mergeConfigCmd := exec.Command("/bin/sh", "-c", "for f in output/*kube_config; do KUBECONFIG=$f:~/.kube/config kubectl config view --flatten > merged-config && mv merged-config ~/.kube/config; done")
-			mergeConfigCmd.Dir = name + "/azure-multiple-clusters"
-			if output, err := mergeConfigCmd.CombinedOutput(); err != nil {
-				log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, output))
-				return err
-			}
+// writeDeploySummary writes a table with the outcome of every environment
+func writeDeploySummary(out io.Writer, results []deployResult) {
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ENVIRONMENT\tSTATUS\tDURATION\tERROR")
+	for _, result := range results {
+		message := ""
+		if result.Err != nil {
+			message = result.Err.Error()
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", result.ID, result.Status, result.Duration.Round(time.Second), message)
+	}
+	table.Flush()
+}
 
-			break
+// allEnvironments lists the environment directories under bedrock/cluster/environments
+func allEnvironments() (names []string) {
+	directories, _ := ioutil.ReadDir("bedrock/cluster/environments")
+	for _, directory := range directories {
+		name := "bedrock/cluster/environments/" + directory.Name()
+		if directory.IsDir() {
+			if environments, _ := environmentsIn(name); len(environments) > 0 {
+				names = append(names, name)
+			}
 		}
 	}
+	return names
+}
 
-	if err == nil {
-		log.Info(emoji.Sprintf(":raised_hands: Completed Terraform environment deployment!"))
+// DeployAll deploys environment directories, running independent environments in parallel
+func DeployAll(names []string, parallelism int) (err error) {
+	log.Info(emoji.Sprintf(":rocket: Starting Environment Deployment!"))
+
+	if deployPolicies, err = loadPolicies(policyDir); err != nil {
+		return err
 	}
+	tasks, err := deploymentGraph(names)
+	if err != nil {
+		return err
+	}
+	if len(tasks) == 0 {
+		return errors.New("No environments to deploy were found in " + strings.Join(names, ", "))
+	}
+
+	results := runDeploymentGraph(tasks, parallelism, deployEnvironment)
+	fmt.Println()
+	writeDeploySummary(os.Stdout, results)
 
+	failed := 0
+	for _, result := range results {
+		if result.Status != DEPLOYED {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d environments were not deployed", failed, len(results))
+	}
+	log.Info(emoji.Sprintf(":raised_hands: Completed Terraform environment deployment!"))
 	return err
 }
 
+// Deploy a bedrock environment by executing `terraform apply`
+func Deploy(name string) (err error) {
+	return DeployAll([]string{name}, deployParallelism)
+}
+
 var deployCmd = &cobra.Command{
-	Use:   "deploy <environment-name>",
+	Use:   "deploy <environment-name>... [--all] [--parallelism n] [--policy-dir directory]",
 	Short: "Deploy the bedrock environment using Terraform",
-	Long:  `Deploy the bedrock environment deployment using terraform init and apply and adds the cluster credentials to the local kubeconfig.`,
+	Long:  `Deploy the bedrock environment deployment using terraform init and apply and adds the cluster credentials to the local kubeconfig. Environments such as azure-common-infra are deployed before the environments depending on them, other environments are deployed in parallel. The plan of each environment is checked against the policy rules in --policy-dir before it is applied, and environments breaking a deny rule are not deployed.`,
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 
-		var name = "unique-environment-name"
+		var names = []string{"unique-environment-name"}
 
-		if len(args) > 0 {
-			name = args[0]
+		if deployAll {
+			names = allEnvironments()
+		} else if len(args) > 0 {
+			names = args
 		}
-		return Deploy(name)
+		return DeployAll(names, deployParallelism)
 	},
 }
 
 func init() {
+	deployCmd.Flags().IntVar(&deployParallelism, "parallelism", 4, "Number of environments deployed at the same time")
+	deployCmd.Flags().BoolVar(&deployAll, "all", false, "Deploy every environment under bedrock/cluster/environments")
+	deployCmd.Flags().StringVar(&policyDir, "policy-dir", "policies", "Directory with the policy rules the plans are checked against")
 	rootCmd.AddCommand(deployCmd)
 }
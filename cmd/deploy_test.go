@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// deployFixture creates environment directories with the given environment types
+func deployFixture(t *testing.T, dir string, environments map[string][]string) {
+	for cluster, types := range environments {
+		for _, environment := range types {
+			path := dir + "/" + cluster + "/" + environment
+			os.MkdirAll(path, os.ModePerm)
+			ioutil.WriteFile(path+"/bedrock-sp-config.toml", []byte("auth = \"sp\"\nservice_principal = \"app-"+cluster+"\"\nsecret = \"secret\"\nsubscription = \"sub\"\ntenant_id = \"tenant\"\n"), 0644)
+			ioutil.WriteFile(path+"/bedrock-config.tfvars", []byte("cluster_name = \""+cluster+"\"\n"), 0644)
+			if environment == "test-common" {
+				// Every copy of the common infra uses the same state
+				ioutil.WriteFile(path+"/bedrock-backend-config.tfvars", []byte("storage_account_name = \"state\"\ncontainer_name = \"tfstate\"\nkey = \"tfstate-common\"\n"), 0644)
+			}
+		}
+	}
+}
+
+func TestDeployGraph(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(registry []EnvironmentType) { environmentRegistry = registry }(environmentRegistry)
+	environmentRegistry = nil
+	RegisterEnvironment(&templateEnvironment{name: "test-common", backend: true})
+	RegisterEnvironment(&templateEnvironment{name: "test-app", dependencies: []string{"test-common"}})
+	deployFixture(t, dir, map[string][]string{
+		"one":   {"test-common", "test-app"},
+		"two":   {"test-common", "test-app"},
+		"three": {"test-app"},
+	})
+
+	var lock sync.Mutex
+	var calls []string
+	running, maxRunning := 0, 0
+	failApply := ""
+	defer func(runner func(string, []string, io.Writer, ...string) error) { terraformRunner = runner }(terraformRunner)
+	terraformRunner = func(directory string, env []string, out io.Writer, args ...string) error {
+		lock.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		id := strings.TrimPrefix(directory, dir+"/")
+		calls = append(calls, id+" "+args[0])
+		if !strings.Contains(strings.Join(env, " "), "ARM_CLIENT_ID=app-"+strings.Split(id, "/")[0]) {
+			t.Errorf("%s did not get the credentials of its environment: %v", id, env)
+		}
+		lock.Unlock()
+
+		out.Write([]byte("output of " + args[0] + "\n"))
+		time.Sleep(10 * time.Millisecond)
+
+		lock.Lock()
+		defer lock.Unlock()
+		running--
+		if args[0] == "apply" && id == failApply {
+			return errors.New("exit status 1")
+		}
+		return nil
+	}
+
+	names := []string{dir + "/one", dir + "/two", dir + "/three"}
+	tasks, err := deploymentGraph(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The copy of the common infra in two shares the state of the one in one
+	if len(tasks) != 4 {
+		t.Fatalf("Expected 4 tasks, got %d", len(tasks))
+	}
+
+	results := runDeploymentGraph(tasks, 2, deployEnvironment)
+	status := map[string]string{}
+	for _, result := range results {
+		status[result.ID] = result.Status
+	}
+	if len(status) != 4 || status["one/test-common"] != DEPLOYED || status["two/test-app"] != DEPLOYED || status["three/test-app"] != DEPLOYED {
+		t.Errorf("Unexpected results %v", status)
+	}
+	if maxRunning > 2 {
+		t.Errorf("%d deployments ran at the same time with a parallelism of 2", maxRunning)
+	}
+	applied := strings.Join(calls, ",")
+	if strings.Index(applied, "one/test-common apply") > strings.Index(applied, "two/test-app init") {
+		t.Errorf("The common infra was not deployed first: %s", applied)
+	}
+
+	// A failure skips the dependents but not the other environments
+	calls = nil
+	failApply = "one/test-common"
+	results = runDeploymentGraph(tasks, 4, deployEnvironment)
+	status = map[string]string{}
+	for _, result := range results {
+		status[result.ID] = result.Status
+	}
+	if status["one/test-common"] != FAILED || status["one/test-app"] != SKIPPED || status["two/test-app"] != SKIPPED || status["three/test-app"] != DEPLOYED {
+		t.Errorf("Unexpected results after a failure %v", status)
+	}
+
+	var summary bytes.Buffer
+	writeDeploySummary(&summary, results)
+	if !strings.Contains(summary.String(), "one/test-app") || !strings.Contains(summary.String(), "Depends on one/test-common") {
+		t.Errorf("Unexpected summary:\n%s", summary.String())
+	}
+}
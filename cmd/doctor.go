@@ -0,0 +1,407 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// CheckStatus is the outcome of a single preflight check
+type CheckStatus string
+
+// Possible outcomes of a preflight check
+const (
+	PASS CheckStatus = "pass"
+	WARN CheckStatus = "warn"
+	FAIL CheckStatus = "fail"
+)
+
+// CheckResult holds the outcome of a preflight check and a hint on how to fix it
+type CheckResult struct {
+	Name        string
+	Status      CheckStatus
+	Message     string
+	Remediation string
+}
+
+// DoctorContext provides the system access used by the preflight checks, so that it can be replaced in tests
+type DoctorContext struct {
+	LookPath     func(file string) (string, error)
+	Run          func(name string, args ...string) ([]byte, error)
+	Getenv       func(key string) string
+	Auth         string // Authentication mode terraform will use, see auth.go
+	Subscription string
+	Region       string
+	VMSize       string
+	VMCount      string
+}
+
+// DoctorCheck is a named preflight check
+type DoctorCheck struct {
+	Name  string
+	Check func(ctx *DoctorContext) CheckResult
+}
+
+// Minimum tool versions required by the pinned Bedrock templates
+var minimumToolVersions = map[string]string{
+	"terraform": "0.12.6",
+	"az":        "2.0.70",
+	"helm":      "2.14.0",
+	"kubectl":   "1.14.0",
+}
+
+// Number of vCPUs for the VM sizes commonly used with Bedrock
+var vmSizeCores = map[string]int{
+	"Standard_D2s_v3":  2,
+	"Standard_D4s_v3":  4,
+	"Standard_D8s_v3":  8,
+	"Standard_D16s_v3": 16,
+	"Standard_DS2_v2":  2,
+	"Standard_DS3_v2":  4,
+	"Standard_DS4_v2":  8,
+}
+
+var versionRegex = regexp.MustCompile(`(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// NewDoctorContext returns a context that runs checks against the local system
+func NewDoctorContext() *DoctorContext {
+	return &DoctorContext{
+		LookPath: exec.LookPath,
+		Run: func(name string, args ...string) ([]byte, error) {
+			return exec.Command(name, args...).CombinedOutput()
+		},
+		Getenv:       os.Getenv,
+		Auth:         authMode,
+		Subscription: subscription,
+		Region:       region,
+		VMSize:       vmSize,
+		VMCount:      vmCount,
+	}
+}
+
+// parseVersion extracts the first semantic version found in the output of a command
+func parseVersion(output string) (version []int, ok bool) {
+	match := versionRegex.FindStringSubmatch(output)
+	if match == nil {
+		return nil, false
+	}
+	for _, part := range match[1:] {
+		number, _ := strconv.Atoi(part)
+		version = append(version, number)
+	}
+	return version, true
+}
+
+// versionAtLeast reports whether version is greater or equal to minimum
+func versionAtLeast(version []int, minimum []int) bool {
+	for i := range minimum {
+		if version[i] != minimum[i] {
+			return version[i] > minimum[i]
+		}
+	}
+	return true
+}
+
+// toolVersionCheck verifies that a tool is installed and meets the minimum version
+func toolVersionCheck(tool string, remediation string, args ...string) DoctorCheck {
+	return DoctorCheck{
+		Name: tool,
+		Check: func(ctx *DoctorContext) CheckResult {
+			result := CheckResult{Name: tool, Remediation: remediation}
+			if _, err := ctx.LookPath(tool); err != nil {
+				result.Status = FAIL
+				result.Message = tool + " was not found in PATH"
+				return result
+			}
+
+			output, err := ctx.Run(tool, args...)
+			if err != nil {
+				result.Status = WARN
+				result.Message = "Unable to determine the version of " + tool + ": " + strings.TrimSpace(string(output))
+				return result
+			}
+			version, ok := parseVersion(string(output))
+			if !ok {
+				result.Status = WARN
+				result.Message = "Unable to determine the version of " + tool
+				return result
+			}
+			minimum, _ := parseVersion(minimumToolVersions[tool])
+			found := versionRegex.FindString(string(output))
+			if !versionAtLeast(version, minimum) {
+				result.Status = FAIL
+				result.Message = tool + " " + found + " is older than the required " + minimumToolVersions[tool]
+				return result
+			}
+
+			result.Status = PASS
+			result.Message = tool + " " + found
+			return result
+		},
+	}
+}
+
+// azureAccount is the subset of `az account show` used by the checks
+type azureAccount struct {
+	ID       string `json:"id"`
+	TenantID string `json:"tenantId"`
+	User     struct {
+		Name string `json:"name"`
+	} `json:"user"`
+}
+
+func showAzureAccount(ctx *DoctorContext) (account azureAccount, err error) {
+	output, err := ctx.Run("az", "account", "show", "--output", "json")
+	if err != nil {
+		return account, errors.New(strings.TrimSpace(string(output)))
+	}
+	err = json.Unmarshal(output, &account)
+	return account, err
+}
+
+// wantedSubscription is the subscription the CLI will deploy into
+func wantedSubscription(ctx *DoctorContext) string {
+	if value := ctx.Getenv("ARM_SUBSCRIPTION_ID"); value != "" {
+		return value
+	}
+	return ctx.Subscription
+}
+
+func azureLoginCheck(ctx *DoctorContext) CheckResult {
+	result := CheckResult{Name: "az login"}
+	account, err := showAzureAccount(ctx)
+	if err != nil {
+		result.Status = FAIL
+		result.Message = "The Azure CLI is not logged in"
+		result.Remediation = "Run 'az login' (or 'az login --service-principal') before creating an environment"
+		return result
+	}
+
+	wanted := wantedSubscription(ctx)
+	if wanted != "" && wanted != account.ID {
+		result.Status = FAIL
+		result.Message = "The Azure CLI is using subscription " + account.ID + " but the environment targets " + wanted
+		result.Remediation = "Run 'az account set --subscription " + wanted + "'"
+		return result
+	}
+
+	result.Status = PASS
+	result.Message = "Logged in as " + account.User.Name + " on subscription " + account.ID
+	return result
+}
+
+func armVariablesCheck(ctx *DoctorContext) CheckResult {
+	result := CheckResult{Name: "ARM variables"}
+	clientID := ctx.Getenv("ARM_CLIENT_ID")
+	clientSecret := ctx.Getenv("ARM_CLIENT_SECRET")
+
+	// Modes other than a service principal do not need a client secret
+	switch ctx.Auth {
+	case AUTHCLI:
+		result.Status = PASS
+		result.Message = "Terraform will use the Azure CLI login"
+		return result
+	case AUTHMSI:
+		result.Status = PASS
+		result.Message = "Terraform will use the managed identity of this machine"
+		return result
+	case AUTHOIDC:
+		if clientID == "" || ctx.Getenv("ARM_TENANT_ID") == "" {
+			result.Status = FAIL
+			result.Message = "ARM_CLIENT_ID and ARM_TENANT_ID are required for workload identity federation"
+			result.Remediation = "Export the client id and tenant of the federated application"
+			return result
+		}
+		for _, variable := range oidcTokenVariables {
+			if ctx.Getenv(variable) != "" {
+				result.Status = PASS
+				result.Message = "Terraform will use workload identity federation for " + clientID
+				return result
+			}
+		}
+		result.Status = WARN
+		result.Message = "No OIDC token was found in the environment"
+		result.Remediation = "Export ARM_OIDC_TOKEN or ARM_OIDC_TOKEN_FILE_PATH, or run on a build agent that provides one"
+		return result
+	}
+
+	if (clientID == "") != (clientSecret == "") {
+		result.Status = FAIL
+		result.Message = "Only one of ARM_CLIENT_ID and ARM_CLIENT_SECRET is set"
+		result.Remediation = "Export both ARM_CLIENT_ID and ARM_CLIENT_SECRET, or unset both and use --sp and --secret"
+		return result
+	}
+	if clientID == "" {
+		result.Status = WARN
+		result.Message = "No service principal found in the ARM_* environment variables"
+		result.Remediation = "Export ARM_SUBSCRIPTION_ID, ARM_CLIENT_ID, ARM_CLIENT_SECRET and ARM_TENANT_ID, or pass --subscription, --sp, --secret and --tenant"
+		return result
+	}
+
+	account, err := showAzureAccount(ctx)
+	if err != nil {
+		result.Status = WARN
+		result.Message = "Unable to compare the ARM_* variables with the Azure CLI context"
+		result.Remediation = "Run 'az login' so that both terraform and az target the same account"
+		return result
+	}
+	if subscriptionID := ctx.Getenv("ARM_SUBSCRIPTION_ID"); subscriptionID != "" && subscriptionID != account.ID {
+		result.Status = WARN
+		result.Message = "ARM_SUBSCRIPTION_ID (" + subscriptionID + ") differs from the Azure CLI subscription (" + account.ID + ")"
+		result.Remediation = "Run 'az account set --subscription " + subscriptionID + "' so terraform and az create resources in the same subscription"
+		return result
+	}
+	if tenantID := ctx.Getenv("ARM_TENANT_ID"); tenantID != "" && tenantID != account.TenantID {
+		result.Status = WARN
+		result.Message = "ARM_TENANT_ID (" + tenantID + ") differs from the Azure CLI tenant (" + account.TenantID + ")"
+		result.Remediation = "Log in to the matching tenant with 'az login --tenant " + tenantID + "'"
+		return result
+	}
+
+	result.Status = PASS
+	result.Message = "ARM_* variables match the Azure CLI context"
+	return result
+}
+
+func sshKeygenCheck(ctx *DoctorContext) CheckResult {
+	result := CheckResult{Name: "ssh-keygen"}
+	path, err := ctx.LookPath("ssh-keygen")
+	if err != nil {
+		result.Status = WARN
+		result.Message = "ssh-keygen was not found in PATH"
+		result.Remediation = "Deploy keys are generated by the CLI, but install OpenSSH to inspect or convert them"
+		return result
+	}
+	result.Status = PASS
+	result.Message = path
+	return result
+}
+
+// azureUsage is the subset of `az vm list-usage` used by the quota check
+type azureUsage struct {
+	CurrentValue json.Number `json:"currentValue"`
+	Limit        json.Number `json:"limit"`
+	Name         struct {
+		Value string `json:"value"`
+	} `json:"name"`
+}
+
+func quotaCheck(ctx *DoctorContext) CheckResult {
+	result := CheckResult{Name: "vCPU quota", Remediation: "Request a quota increase for " + ctx.Region + " or choose a smaller --vm-size / --vm-count"}
+
+	output, err := ctx.Run("az", "vm", "list-usage", "--location", ctx.Region, "--output", "json")
+	if err != nil {
+		result.Status = WARN
+		result.Message = "Unable to read the compute quota for " + ctx.Region
+		result.Remediation = "Make sure the Azure CLI is logged in and " + ctx.Region + " is a valid region"
+		return result
+	}
+	var usages []azureUsage
+	if err := json.Unmarshal(output, &usages); err != nil {
+		result.Status = WARN
+		result.Message = "Unable to parse the compute quota for " + ctx.Region
+		return result
+	}
+
+	cores, known := vmSizeCores[ctx.VMSize]
+	count, err := strconv.Atoi(ctx.VMCount)
+	if !known || err != nil {
+		result.Status = WARN
+		result.Message = "Unable to determine the number of vCPUs needed for " + ctx.VMCount + " x " + ctx.VMSize
+		return result
+	}
+	required := int64(cores * count)
+
+	for _, usage := range usages {
+		if usage.Name.Value != "cores" {
+			continue
+		}
+		current, _ := usage.CurrentValue.Int64()
+		limit, _ := usage.Limit.Int64()
+		available := limit - current
+		message := strconv.FormatInt(available, 10) + " regional vCPUs available in " + ctx.Region + ", " + strconv.FormatInt(required, 10) + " required"
+		if available < required {
+			result.Status = FAIL
+			result.Message = message
+			return result
+		}
+		result.Status = PASS
+		result.Message = message
+		return result
+	}
+
+	result.Status = WARN
+	result.Message = "No regional vCPU quota was reported for " + ctx.Region
+	return result
+}
+
+// DefaultDoctorChecks returns the preflight checks run by `bedrock doctor`
+func DefaultDoctorChecks() []DoctorCheck {
+	return []DoctorCheck{
+		toolVersionCheck("terraform", "Install terraform "+minimumToolVersions["terraform"]+" or newer from https://www.terraform.io/downloads.html", "version"),
+		toolVersionCheck("az", "Install the Azure CLI "+minimumToolVersions["az"]+" or newer from https://aka.ms/InstallAzureCli", "--version"),
+		toolVersionCheck("helm", "Install helm "+minimumToolVersions["helm"]+" or newer from https://helm.sh", "version", "--client", "--short"),
+		toolVersionCheck("kubectl", "Install kubectl "+minimumToolVersions["kubectl"]+" or newer with 'az aks install-cli'", "version", "--client"),
+		{Name: "az login", Check: azureLoginCheck},
+		{Name: "ARM variables", Check: armVariablesCheck},
+		{Name: "ssh-keygen", Check: sshKeygenCheck},
+		{Name: "vCPU quota", Check: quotaCheck},
+	}
+}
+
+// Doctor runs the given preflight checks and reports their outcome
+func Doctor(ctx *DoctorContext, checks []DoctorCheck) (results []CheckResult, err error) {
+	log.Info(emoji.Sprintf(":stethoscope: Running preflight checks for Bedrock %s", BEDROCK))
+
+	failures := 0
+	for _, check := range checks {
+		result := check.Check(ctx)
+		results = append(results, result)
+
+		switch result.Status {
+		case PASS:
+			log.Info(emoji.Sprintf(":white_check_mark: %s: %s", result.Name, result.Message))
+		case WARN:
+			log.Warn(emoji.Sprintf(":warning: %s: %s", result.Name, result.Message))
+		default:
+			failures++
+			log.Error(emoji.Sprintf(":x: %s: %s", result.Name, result.Message))
+		}
+		if result.Status != PASS && result.Remediation != "" {
+			log.Info(emoji.Sprintf("   :bulb: %s", result.Remediation))
+		}
+	}
+
+	if failures > 0 {
+		return results, errors.New(strconv.Itoa(failures) + " preflight check(s) failed")
+	}
+	log.Info(emoji.Sprintf(":raised_hands: All required preflight checks passed!"))
+	return results, err
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor [--subscription subscription-id] [--auth sp|cli|msi|oidc] [--region region-of-deployment] [--vm-size azure-vm-size] [--vm-count number-of-nodes-to-deploy-in-cluster]",
+	Short: "Check that the local machine is ready to create Bedrock environments",
+	Long:  `Check the versions of the required tools, the Azure CLI login, the ARM_* environment variables and the regional vCPU quota, and suggest how to fix any problems`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		_, err = Doctor(NewDoctorContext(), DefaultDoctorChecks())
+		return err
+	},
+}
+
+func init() {
+	doctorCmd.Flags().StringVar(&subscription, "subscription", "", "Azure Subscription ID")
+	doctorCmd.Flags().StringVar(&authMode, "auth", AUTHSP, "How terraform authenticates with Azure: sp, cli, msi or oidc")
+	doctorCmd.Flags().StringVar(&region, "region", "westus2", "Region of deployment")
+	doctorCmd.Flags().StringVar(&vmSize, "vm-size", "Standard_D4s_v3", "Azure VM size")
+	doctorCmd.Flags().StringVar(&vmCount, "vm-count", "3", "Number of nodes to deploy per cluster")
+	rootCmd.AddCommand(doctorCmd)
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fakeDoctorContext returns canned output for commands instead of running them
+func fakeDoctorContext(tools map[string]string, env map[string]string) *DoctorContext {
+	return &DoctorContext{
+		LookPath: func(file string) (string, error) {
+			if _, ok := tools[file]; ok {
+				return "/usr/bin/" + file, nil
+			}
+			return "", errors.New("executable file not found in $PATH")
+		},
+		Run: func(name string, args ...string) ([]byte, error) {
+			output, ok := tools[name+" "+strings.Join(args, " ")]
+			if !ok {
+				return []byte("command failed"), errors.New("exit status 1")
+			}
+			return []byte(output), nil
+		},
+		Getenv: func(key string) string {
+			return env[key]
+		},
+		Region:  "westus2",
+		VMSize:  "Standard_D4s_v3",
+		VMCount: "3",
+	}
+}
+
+func TestDoctorToolVersions(t *testing.T) {
+	ctx := fakeDoctorContext(map[string]string{
+		"terraform":                     "",
+		"terraform version":             "Terraform v0.12.6\n",
+		"helm":                          "",
+		"helm version --client --short": "Client: v2.9.1+g20adb27\n",
+		"kubectl":                       "",
+		"kubectl version --client":      "Client Version: v1.15.0\n",
+		"az account show --output json": `{"id": "sub", "tenantId": "tenant", "user": {"name": "dev"}}`,
+		"az vm list-usage --location westus2 --output json": `[{"currentValue": "90", "limit": "100", "name": {"value": "cores"}}]`,
+	}, map[string]string{})
+
+	expected := map[string]CheckStatus{
+		"terraform":     PASS,
+		"az":            FAIL,
+		"helm":          FAIL,
+		"kubectl":       PASS,
+		"az login":      PASS,
+		"ARM variables": WARN,
+		"ssh-keygen":    WARN,
+		"vCPU quota":    FAIL,
+	}
+
+	results, err := Doctor(ctx, DefaultDoctorChecks())
+	if err == nil {
+		t.Error("Expected doctor to report failed checks")
+	}
+	for _, result := range results {
+		if result.Status != expected[result.Name] {
+			t.Errorf("Check %s returned %s, expected %s: %s", result.Name, result.Status, expected[result.Name], result.Message)
+		}
+		if result.Status != PASS && result.Remediation == "" {
+			t.Errorf("Check %s did not provide a remediation hint", result.Name)
+		}
+	}
+}
+
+func TestDoctorAzureContext(t *testing.T) {
+	account := `{"id": "sub-a", "tenantId": "tenant-a", "user": {"name": "dev"}}`
+	ctx := fakeDoctorContext(map[string]string{"az account show --output json": account}, map[string]string{
+		"ARM_SUBSCRIPTION_ID": "sub-b",
+		"ARM_CLIENT_ID":       "client",
+		"ARM_CLIENT_SECRET":   "secret",
+		"ARM_TENANT_ID":       "tenant-a",
+	})
+
+	if result := azureLoginCheck(ctx); result.Status != FAIL {
+		t.Errorf("Expected az login check to fail on a subscription mismatch, got %s", result.Status)
+	}
+	if result := armVariablesCheck(ctx); result.Status != WARN {
+		t.Errorf("Expected ARM variables check to warn on a subscription mismatch, got %s", result.Status)
+	}
+
+	ctx.Getenv = func(key string) string {
+		return map[string]string{"ARM_CLIENT_ID": "client"}[key]
+	}
+	if result := armVariablesCheck(ctx); result.Status != FAIL {
+		t.Errorf("Expected ARM variables check to fail without ARM_CLIENT_SECRET, got %s", result.Status)
+	}
+
+	// Workload identity federation needs a tenant but no secret
+	ctx.Auth = AUTHOIDC
+	if result := armVariablesCheck(ctx); result.Status != FAIL {
+		t.Errorf("Expected ARM variables check to fail without ARM_TENANT_ID, got %s", result.Status)
+	}
+	ctx.Auth = AUTHCLI
+	if result := armVariablesCheck(ctx); result.Status != PASS {
+		t.Errorf("Expected ARM variables check to pass with the Azure CLI login, got %s", result.Status)
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	cases := map[string]bool{
+		"Terraform v0.12.6":  true,
+		"Terraform v0.11.14": false,
+		"azure-cli 2.0.70 *": true,
+		"v0.9":               false,
+		"v10.0.0":            true,
+	}
+	minimum, _ := parseVersion("0.12.6")
+	for output, want := range cases {
+		version, ok := parseVersion(output)
+		if !ok {
+			t.Errorf("Unable to parse version from %q", output)
+			continue
+		}
+		if got := versionAtLeast(version, minimum); got != want {
+			t.Errorf("versionAtLeast(%q, 0.12.6) = %t, expected %t", output, got, want)
+		}
+	}
+}
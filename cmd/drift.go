@@ -0,0 +1,333 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/yradsmikham/bedrock-cli/pkg/bedrock"
+)
+
+var driftOutput string
+
+// driftPlan is the file the refresh-only plan is saved to while the drift is read from it
+const driftPlan = "bedrock-drift.tfplan"
+
+// driftTerraformVersion is the first terraform version with refresh-only plans and resource_drift in their JSON
+const driftTerraformVersion = "0.15.4"
+
+// ResourceDrift is a resource changed or deleted outside of terraform since it was last applied
+type ResourceDrift struct {
+	Address    string   `json:"address"`
+	Deleted    bool     `json:"deleted"`
+	Attributes []string `json:"attributes,omitempty"` // e.g. tags.owner: "team-a" -> "team-b"
+}
+
+// DriftReport is the drift found in one environment
+type DriftReport struct {
+	Environment string          `json:"environment"`
+	Resources   []ResourceDrift `json:"resources"`
+	Variables   []string        `json:"variables"` // Differences between bedrock-config.tfvars and the last deployed values
+	Error       string          `json:"error,omitempty"`
+}
+
+// Drifted reports whether the environment no longer matches what was deployed
+func (report *DriftReport) Drifted() bool {
+	return len(report.Resources) > 0 || len(report.Variables) > 0
+}
+
+// terraformPlanJSON is the part of `terraform show -json` describing changes made outside of terraform
+type terraformPlanJSON struct {
+	ResourceDrift []struct {
+		Address string `json:"address"`
+		Change  struct {
+			Actions         []string    `json:"actions"`
+			Before          interface{} `json:"before"`
+			After           interface{} `json:"after"`
+			BeforeSensitive interface{} `json:"before_sensitive"`
+			AfterSensitive  interface{} `json:"after_sensitive"`
+		} `json:"change"`
+	} `json:"resource_drift"`
+}
+
+// flattenAttributes adds the leaves of a resource's attributes to values, keyed by their dotted path
+func flattenAttributes(path string, value interface{}, values map[string]string) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			flattenAttributes(strings.TrimPrefix(path+"."+key, "."), child, values)
+		}
+	case []interface{}:
+		for i, child := range value {
+			flattenAttributes(strings.TrimPrefix(fmt.Sprintf("%s.%d", path, i), "."), child, values)
+		}
+	default:
+		encoded, _ := json.Marshal(value)
+		values[path] = string(encoded)
+	}
+}
+
+// sensitiveAttribute reports whether an attribute, or the block containing it, is marked sensitive
+func sensitiveAttribute(path string, sensitive map[string]string) bool {
+	for marked, value := range sensitive {
+		if value == "true" && (path == marked || strings.HasPrefix(path, marked+".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDrift reads the resources changed outside of terraform from the output of `terraform show -json`
+func parseDrift(plan []byte) (resources []ResourceDrift, err error) {
+	var parsed terraformPlanJSON
+	if err := json.Unmarshal(plan, &parsed); err != nil {
+		return nil, err
+	}
+
+	for _, drift := range parsed.ResourceDrift {
+		resource := ResourceDrift{Address: drift.Address}
+		for _, action := range drift.Change.Actions {
+			if action == "delete" {
+				resource.Deleted = true
+			}
+		}
+		if !resource.Deleted {
+			before, after, sensitive := map[string]string{}, map[string]string{}, map[string]string{}
+			flattenAttributes("", drift.Change.Before, before)
+			flattenAttributes("", drift.Change.After, after)
+			flattenAttributes("", drift.Change.BeforeSensitive, sensitive)
+			flattenAttributes("", drift.Change.AfterSensitive, sensitive)
+			for path := range after {
+				if _, ok := before[path]; !ok {
+					before[path] = "null"
+				}
+			}
+			for path, value := range before {
+				changed, ok := after[path]
+				if !ok {
+					changed = "null"
+				}
+				if value == changed {
+					continue
+				}
+				if sensitiveAttribute(path, sensitive) {
+					resource.Attributes = append(resource.Attributes, path+" (sensitive)")
+				} else {
+					resource.Attributes = append(resource.Attributes, path+": "+value+" -> "+changed)
+				}
+			}
+			sort.Strings(resource.Attributes)
+			if len(resource.Attributes) == 0 {
+				continue
+			}
+		}
+		resources = append(resources, resource)
+	}
+	return resources, err
+}
+
+// tfvarsDrift compares bedrock-config.tfvars against the values recorded at the last deploy
+func tfvarsDrift(directory string) (diff []string, err error) {
+	if !fileExists(directory + "/" + deployedTfvars) {
+		return nil, errors.New("No record of a deploy with bedrock deploy, the tfvars are not compared")
+	}
+	deployed, err := ReadTfvarsFile(directory + "/" + deployedTfvars)
+	if err != nil {
+		return nil, err
+	}
+	current, err := ReadTfvarsFile(directory + "/bedrock-config.tfvars")
+	if err != nil {
+		return nil, err
+	}
+
+	settings := []string{}
+	for setting := range deployed {
+		settings = append(settings, setting)
+	}
+	for setting := range current {
+		if _, ok := deployed[setting]; !ok {
+			settings = append(settings, setting)
+		}
+	}
+	sort.Strings(settings)
+
+	for _, setting := range settings {
+		value, ok := current[setting]
+		previous, wasDeployed := deployed[setting]
+		switch {
+		case !wasDeployed:
+			diff = append(diff, "+ "+setting+" = "+maskSecret(setting, value))
+		case !ok:
+			diff = append(diff, "- "+setting+" = "+maskSecret(setting, previous))
+		case bedrock.SnapshotValue(setting, value) != bedrock.SnapshotValue(setting, previous):
+			diff = append(diff, "~ "+setting+" = "+maskSecret(setting, previous)+" -> "+maskSecret(setting, value))
+		}
+	}
+	return diff, err
+}
+
+// checkDriftTerraformVersion fails when the terraform found in the PATH cannot run refresh-only plans.
+// Deploying the environments only needs the version checked by bedrock doctor.
+func checkDriftTerraformVersion() (err error) {
+	var out bytes.Buffer
+	if err := terraformRunner(".", nil, &out, "version"); err != nil {
+		return fmt.Errorf("Unable to determine the terraform version: %s", strings.TrimSpace(out.String()))
+	}
+	version, ok := parseVersion(out.String())
+	if !ok {
+		return errors.New("Unable to determine the terraform version")
+	}
+	if minimum, _ := parseVersion(driftTerraformVersion); !versionAtLeast(version, minimum) {
+		log.Error(emoji.Sprintf(":no_entry_sign: bedrock drift runs refresh-only plans, which terraform %s does not support", versionRegex.FindString(out.String())))
+		return fmt.Errorf("bedrock drift needs terraform %s or newer", driftTerraformVersion)
+	}
+	return err
+}
+
+// environmentDrift runs a refresh-only plan of an environment and reads the drift from it
+func environmentDrift(name string, environment EnvironmentType) (report DriftReport) {
+	report.Environment = filepath.Base(name) + "/" + environment.Name()
+	directory := name + "/" + environment.Name()
+	prefix := "[" + report.Environment + "] "
+
+	if diff, err := tfvarsDrift(directory); err != nil {
+		log.Warn(emoji.Sprintf(":warning: %s%s", prefix, err))
+	} else {
+		report.Variables = diff
+	}
+
+	env, err := authEnvironment(name, environment.Name())
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	out := &prefixWriter{prefix: prefix}
+	if err := terraformRunner(directory, env, out, terraformInitArgs(environment)...); err != nil {
+		report.Error = "terraform init: " + err.Error()
+		return report
+	}
+
+	log.Info(emoji.Sprintf(":mag: %sRefreshing the terraform state...", prefix))
+	defer os.Remove(directory + "/" + driftPlan)
+	if err := terraformRunner(directory, env, out, "plan", "-refresh-only", "-input=false", "-lock=false", "-var-file=./bedrock-config.tfvars", "-out="+driftPlan); err != nil {
+		report.Error = "terraform plan: " + err.Error()
+		return report
+	}
+	var plan bytes.Buffer
+	if err := terraformRunner(directory, env, &plan, "show", "-json", driftPlan); err != nil {
+		report.Error = "terraform show: " + err.Error()
+		return report
+	}
+	resources, err := parseDrift(plan.Bytes())
+	if err != nil {
+		report.Error = "Could not read the plan: " + err.Error()
+		return report
+	}
+	report.Resources = resources
+	return report
+}
+
+// writeDriftReport writes the drift of every environment as text
+func writeDriftReport(out io.Writer, reports []DriftReport) {
+	for _, report := range reports {
+		switch {
+		case report.Error != "":
+			fmt.Fprintf(out, "%s: could not check for drift: %s\n", report.Environment, report.Error)
+		case !report.Drifted():
+			fmt.Fprintf(out, "%s: no drift\n", report.Environment)
+		default:
+			fmt.Fprintf(out, "%s: drift detected\n", report.Environment)
+		}
+		for _, resource := range report.Resources {
+			if resource.Deleted {
+				fmt.Fprintf(out, "  - %s was deleted outside of terraform\n", resource.Address)
+				continue
+			}
+			fmt.Fprintf(out, "  ~ %s\n", resource.Address)
+			for _, attribute := range resource.Attributes {
+				fmt.Fprintf(out, "      %s\n", attribute)
+			}
+		}
+		if len(report.Variables) > 0 {
+			fmt.Fprintf(out, "  bedrock-config.tfvars changed since the last deploy:\n")
+			for _, variable := range report.Variables {
+				fmt.Fprintf(out, "      %s\n", variable)
+			}
+		}
+	}
+}
+
+// Drift checks the environments of an environment directory for changes made since they were deployed
+func Drift(name string, output string) (err error) {
+	if output != "text" && output != "json" {
+		return errors.New("Unknown output format " + output + ", use text or json")
+	}
+	environments, err := environmentsIn(name)
+	if err != nil {
+		return err
+	}
+	if len(environments) == 0 {
+		return errors.New("No environments were found in " + name)
+	}
+
+	if err := checkDriftTerraformVersion(); err != nil {
+		return err
+	}
+
+	log.Info(emoji.Sprintf(":mag: Checking %s for drift", name))
+	reports := []DriftReport{}
+	for _, environment := range environments {
+		reports = append(reports, environmentDrift(name, environment))
+	}
+
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reports); err != nil {
+			return err
+		}
+	} else {
+		writeDriftReport(os.Stdout, reports)
+	}
+
+	drifted, failed := 0, 0
+	for _, report := range reports {
+		if report.Error != "" {
+			failed++
+		} else if report.Drifted() {
+			drifted++
+		}
+	}
+	if drifted > 0 {
+		return fmt.Errorf("Drift detected in %d of %d environments", drifted, len(reports))
+	}
+	if failed > 0 {
+		return fmt.Errorf("Could not check %d of %d environments for drift", failed, len(reports))
+	}
+	log.Info(emoji.Sprintf(":white_check_mark: No drift found in %s", name))
+	return err
+}
+
+var driftCmd = &cobra.Command{
+	Use:   "drift <environment-path> [-o text|json]",
+	Short: "Detect changes made to a deployed environment outside of the CLI",
+	Long:  `Detect changes made to a deployed environment outside of the CLI. Runs a refresh-only terraform plan of every environment in the environment directory and reports the changed attributes of each resource and the resources deleted out of band, along with the changes to bedrock-config.tfvars since the last bedrock deploy. Exits with a non-zero status when drift is found.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		return Drift(args[0], driftOutput)
+	},
+}
+
+func init() {
+	driftCmd.Flags().StringVarP(&driftOutput, "output", "o", "text", "Output format, text or json")
+	rootCmd.AddCommand(driftCmd)
+}
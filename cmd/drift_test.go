@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const driftPlanJSON = `{
+  "format_version": "1.0",
+  "resource_drift": [
+    {
+      "address": "module.aks.azurerm_kubernetes_cluster.cluster",
+      "change": {
+        "actions": ["update"],
+        "before": {"name": "my-cluster", "tags": {"owner": "team-a"}, "node_count": 3, "kube_config": [{"password": "old"}]},
+        "after": {"name": "my-cluster", "tags": {"owner": "team-b", "env": "dev"}, "node_count": 3, "kube_config": [{"password": "new"}]},
+        "before_sensitive": {"kube_config": true},
+        "after_sensitive": {"kube_config": true}
+      }
+    },
+    {
+      "address": "azurerm_resource_group.cluster",
+      "change": {"actions": ["delete"], "before": {"name": "my-cluster-rg"}, "after": null}
+    },
+    {
+      "address": "azurerm_subnet.unchanged",
+      "change": {"actions": ["update"], "before": {"name": "subnet"}, "after": {"name": "subnet"}}
+    }
+  ]
+}`
+
+func TestParseDrift(t *testing.T) {
+	resources, err := parseDrift([]byte(driftPlanJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 2 {
+		t.Fatalf("Expected 2 drifted resources, got %v", resources)
+	}
+	expected := []string{"kube_config.0.password (sensitive)", "tags.env: null -> \"dev\"", "tags.owner: \"team-a\" -> \"team-b\""}
+	if strings.Join(resources[0].Attributes, ",") != strings.Join(expected, ",") {
+		t.Errorf("Unexpected attributes %v", resources[0].Attributes)
+	}
+	if !resources[1].Deleted || resources[1].Address != "azurerm_resource_group.cluster" {
+		t.Errorf("Expected the resource group to be deleted, got %v", resources[1])
+	}
+}
+
+func TestDrift(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-drift")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(registry []EnvironmentType) { environmentRegistry = registry }(environmentRegistry)
+	environmentRegistry = nil
+	RegisterEnvironment(&templateEnvironment{name: "test-common", backend: true})
+	RegisterEnvironment(&templateEnvironment{name: "test-app", dependencies: []string{"test-common"}})
+	deployFixture(t, dir, map[string][]string{"one": {"test-common", "test-app"}})
+
+	var shows []string
+	drifted := map[string]bool{}
+	version := "Terraform v0.12.29\n"
+	defer func(runner func(string, []string, io.Writer, ...string) error) { terraformRunner = runner }(terraformRunner)
+	terraformRunner = func(directory string, env []string, out io.Writer, args ...string) error {
+		switch args[0] {
+		case "version":
+			out.Write([]byte(version))
+		case "apply":
+		case "show":
+			shows = append(shows, directory)
+			if drifted[directory] {
+				out.Write([]byte(driftPlanJSON))
+			} else {
+				out.Write([]byte(`{"format_version": "1.0"}`))
+			}
+		}
+		return nil
+	}
+
+	// Nothing changed since the deploy
+	if err := DeployAll([]string{dir + "/one"}, 2); err != nil {
+		t.Fatal(err)
+	}
+	// Refresh-only plans are not supported by the terraform the templates were written for
+	if err := Drift(dir+"/one", "text"); err == nil || err.Error() != "bedrock drift needs terraform 0.15.4 or newer" || len(shows) != 0 {
+		t.Errorf("Expected the terraform version to be rejected, got %v", err)
+	}
+	version = "Terraform v1.0.11\non linux_amd64\n"
+	if err := Drift(dir+"/one", "text"); err != nil {
+		t.Errorf("Expected no drift, got %s", err)
+	}
+	if len(shows) != 2 {
+		t.Errorf("Expected a plan of both environments, got %v", shows)
+	}
+
+	// A changed variable is drift
+	ioutil.WriteFile(dir+"/one/test-app/bedrock-config.tfvars", []byte("cluster_name = \"renamed\"\n"), 0644)
+	diff, err := tfvarsDrift(dir + "/one/test-app")
+	if err != nil || len(diff) != 1 || diff[0] != "~ cluster_name = \"one\" -> \"renamed\"" {
+		t.Errorf("Unexpected tfvars drift %v: %v", diff, err)
+	}
+	if err := Drift(dir+"/one", "text"); err == nil {
+		t.Error("Expected the changed tfvars to be reported as drift")
+	}
+
+	// Changes made in the portal are drift
+	ioutil.WriteFile(dir+"/one/test-app/bedrock-config.tfvars", []byte("cluster_name = \"one\"\n"), 0644)
+	drifted[dir+"/one/test-common"] = true
+	if err := Drift(dir+"/one", "json"); err == nil || err.Error() != "Drift detected in 1 of 2 environments" {
+		t.Errorf("Unexpected result %v", err)
+	}
+
+	var report bytes.Buffer
+	writeDriftReport(&report, []DriftReport{{Environment: "one/test-common", Resources: []ResourceDrift{{Address: "azurerm_resource_group.cluster", Deleted: true}}}})
+	if !strings.Contains(report.String(), "azurerm_resource_group.cluster was deleted outside of terraform") {
+		t.Errorf("Unexpected report:\n%s", report.String())
+	}
+}
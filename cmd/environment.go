@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"os/exec"
+
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// EnvironmentType is a type of Bedrock environment the CLI can create, simulate and deploy
+type EnvironmentType interface {
+	Name() string                                                               // Name of the template in bedrock/cluster/environments
+	Title() string                                                              // Name used in messages
+	Command() *cobra.Command                                                    // Command creating the environment
+	Dependencies() []string                                                     // Environments that need to be deployed first
+	RequiredVariables() []string                                                // Variables that need a value in bedrock-config.tfvars
+	OptionalVariables() map[string][]string                                     // Variables only set when the template declares them, with the flags setting them
+	Backend() bool                                                              // Whether the terraform state is kept in a storage account
+	GitOps() bool                                                               // Whether the environment deploys clusters synced with Flux
+	CreateResourceGroups(clusterName string) error                              // Creates the resource groups when --resource-group is not given
+	Prepare(environmentPath string, clusterName string) error                   // Sets up the dependencies before the tfvars are generated
+	GenerateTfvars(config map[string]string, clusterName string, sshKey string) // Adds the bedrock-config.tfvars settings
+	PostDeploy(envPath string) error                                            // Runs after terraform apply
+}
+
+// templateEnvironment is an environment type backed by a template of the Bedrock repo
+type templateEnvironment struct {
+	name              string
+	title             string
+	command           *cobra.Command
+	dependencies      []string
+	requiredVariables []string
+	optionalVariables map[string][]string
+	backend           bool
+	gitops            bool
+	resourceGroups    func(clusterName string) error
+	prepare           func(environmentPath string, clusterName string) error
+	template          func(config map[string]string, clusterName string, sshKey string)
+	postDeploy        func(envPath string) error
+}
+
+func (e *templateEnvironment) Name() string                           { return e.name }
+func (e *templateEnvironment) Title() string                          { return e.title }
+func (e *templateEnvironment) Command() *cobra.Command                { return e.command }
+func (e *templateEnvironment) Dependencies() []string                 { return e.dependencies }
+func (e *templateEnvironment) RequiredVariables() []string            { return e.requiredVariables }
+func (e *templateEnvironment) OptionalVariables() map[string][]string { return e.optionalVariables }
+func (e *templateEnvironment) Backend() bool                          { return e.backend }
+func (e *templateEnvironment) GitOps() bool                           { return e.gitops }
+
+func (e *templateEnvironment) CreateResourceGroups(clusterName string) error {
+	return e.resourceGroups(clusterName)
+}
+
+func (e *templateEnvironment) Prepare(environmentPath string, clusterName string) error {
+	if e.prepare == nil {
+		return nil
+	}
+	return e.prepare(environmentPath, clusterName)
+}
+
+func (e *templateEnvironment) GenerateTfvars(config map[string]string, clusterName string, sshKey string) {
+	e.template(config, clusterName, sshKey)
+}
+
+func (e *templateEnvironment) PostDeploy(envPath string) error {
+	if e.postDeploy == nil {
+		return nil
+	}
+	return e.postDeploy(envPath)
+}
+
+// multipleOptionalVariables are the settings of azure-multiple-clusters that not every version of the template
+// declares. The locations are also used for the resource groups, so --region-* flags apply without them.
+// The Traffic Manager settings are only written when their flag is given.
+func multipleOptionalVariables() map[string][]string {
+	variables := map[string][]string{"traffic_manager_resource_group_location": nil}
+	for _, cluster := range []string{"west", "central", "east"} {
+		variables[cluster+"_resource_group_location"] = nil
+		variables[cluster+"_agent_vm_count"] = []string{cluster + "-vm-count", cluster + "-vm-size"}
+		variables[cluster+"_agent_vm_size"] = []string{cluster + "-vm-count", cluster + "-vm-size"}
+	}
+	for variable, flag := range trafficManagerVariables {
+		variables[variable] = []string{flag}
+	}
+	return variables
+}
+
+// Registered environment types, in the order they are registered
+var environmentRegistry []EnvironmentType
+
+// RegisterEnvironment adds an environment type to the CLI
+func RegisterEnvironment(environment EnvironmentType) {
+	for i, registered := range environmentRegistry {
+		if registered.Name() == environment.Name() {
+			environmentRegistry[i] = environment
+			return
+		}
+	}
+	environmentRegistry = append(environmentRegistry, environment)
+}
+
+// GetEnvironment returns the registered environment type with the given name
+func GetEnvironment(name string) (environment EnvironmentType, ok bool) {
+	for _, environment := range environmentRegistry {
+		if environment.Name() == name {
+			return environment, true
+		}
+	}
+	return nil, false
+}
+
+// Environments returns the registered environment types
+func Environments() []EnvironmentType {
+	return environmentRegistry
+}
+
+// environmentNames returns the names of the registered environment types
+func environmentNames() (names []string) {
+	for _, environment := range environmentRegistry {
+		names = append(names, environment.Name())
+	}
+	return names
+}
+
+// environmentDependents returns the environment types that depend on the given one
+func environmentDependents(name string) (dependents []string) {
+	for _, environment := range environmentRegistry {
+		for _, dependency := range environment.Dependencies() {
+			if dependency == name {
+				dependents = append(dependents, environment.Name())
+			}
+		}
+	}
+	return dependents
+}
+
+// dependsOn reports whether an environment type needs the given environment to be deployed first
+func dependsOn(environment EnvironmentType, name string) bool {
+	for _, dependency := range environment.Dependencies() {
+		if dependency == name {
+			return true
+		}
+	}
+	return false
+}
+
+// deploymentOrder sorts environment types so that dependencies come before the environments using them
+func deploymentOrder(environments []EnvironmentType) (ordered []EnvironmentType) {
+	included := map[string]bool{}
+	for _, environment := range environments {
+		included[environment.Name()] = true
+	}
+	added := map[string]bool{}
+	for len(ordered) < len(environments) {
+		progress := false
+		for _, environment := range environments {
+			if added[environment.Name()] {
+				continue
+			}
+			ready := true
+			for _, dependency := range environment.Dependencies() {
+				if included[dependency] && !added[dependency] {
+					ready = false
+				}
+			}
+			if ready {
+				ordered = append(ordered, environment)
+				added[environment.Name()] = true
+				progress = true
+			}
+		}
+		// Circular dependencies keep their registration order
+		if !progress {
+			for _, environment := range environments {
+				if !added[environment.Name()] {
+					ordered = append(ordered, environment)
+					added[environment.Name()] = true
+				}
+			}
+		}
+	}
+	return ordered
+}
+
+// mergeKubeconfig adds the credentials written by terraform to output/ to the local kubeconfig
+func mergeKubeconfig(envPath string) (err error) {
+	log.Info(emoji.Sprintf(":mailbox_with_mail: Found Kubeconfig output. Merging into local kubeconfig."))
+	mergeConfigCmd := exec.Command("/bin/sh", "-c", "for f in output/*kube_config; do KUBECONFIG=$f:~/.kube/config kubectl config view --flatten > merged-config && mv merged-config ~/.kube/config; done")
+	mergeConfigCmd.Dir = envPath
+	if output, err := mergeConfigCmd.CombinedOutput(); err != nil {
+		log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, output))
+		return err
+	}
+	return err
+}
+
+func init() {
+	RegisterEnvironment(&templateEnvironment{
+		name:              SIMPLE,
+		title:             "Azure Simple cluster",
+		command:           azureSimpleCmd,
+		requiredVariables: []string{"resource_group_name", "cluster_name", "ssh_public_key", "gitops_ssh_url"},
+		gitops:            true,
+		resourceGroups:    createClusterResourceGroup,
+		template:          azureSimpleTemplate,
+		postDeploy:        mergeKubeconfig,
+	})
+	RegisterEnvironment(&templateEnvironment{
+		name:              KEYVAULT,
+		title:             "Azure Single Keyvault cluster",
+		command:           azureSingleKeyvaultCmd,
+		dependencies:      []string{COMMON},
+		requiredVariables: []string{"resource_group_name", "cluster_name", "ssh_public_key", "gitops_ssh_url", "keyvault_name", "keyvault_resource_group"},
+		backend:           true,
+		gitops:            true,
+		resourceGroups:    createClusterResourceGroup,
+		prepare:           prepareKeyvaultEnvironment,
+		template:          azureSingleKVTemplate,
+		postDeploy:        mergeKubeconfig,
+	})
+	RegisterEnvironment(&templateEnvironment{
+		name:              MULTIPLE,
+		title:             "Azure Multiple cluster",
+		command:           azureMultiClusterCmd,
+		dependencies:      []string{COMMON},
+		requiredVariables: []string{"cluster_name", "ssh_public_key", "gitops_ssh_url", "keyvault_name", "keyvault_resource_group", "traffic_manager_profile_name"},
+		optionalVariables: multipleOptionalVariables(),
+		backend:           true,
+		gitops:            true,
+		resourceGroups:    createRegionalResourceGroups,
+		prepare:           prepareMultipleEnvironment,
+		template:          azureMultipleTemplate,
+		postDeploy:        mergeKubeconfig,
+	})
+	RegisterEnvironment(&templateEnvironment{
+		name:              COMMON,
+		title:             "Azure Common Infra",
+		command:           commonInfraCmd,
+		requiredVariables: []string{"global_resource_group_name", "keyvault_name", "vnet_name", "subnet_name"},
+		backend:           true,
+		resourceGroups:    createCommonResourceGroup,
+		prepare:           prepareCommonInfra,
+		template:          azureCommonInfraTemplate,
+	})
+	RegisterEnvironment(&templateEnvironment{
+		name:              COSMOS,
+		title:             "Azure Single Keyvault cluster with Cosmos DB",
+		command:           azureSingleKeyvaultCosmosCmd,
+		dependencies:      []string{COMMON},
+		requiredVariables: []string{"resource_group_name", "cluster_name", "ssh_public_key", "gitops_ssh_url", "keyvault_name", "keyvault_resource_group", "cosmos_db_name", "mongo_db_name"},
+		backend:           true,
+		gitops:            true,
+		resourceGroups:    createClusterResourceGroup,
+		prepare:           prepareKeyvaultEnvironment,
+		template:          azureSingleKVCosmosTemplate,
+		postDeploy:        mergeKubeconfig,
+	})
+}
@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestEnvironmentRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-environments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Environments are found in a cluster directory with their dependencies first
+	for _, environment := range []string{MULTIPLE, COMMON, "not-an-environment"} {
+		os.MkdirAll(dir+"/"+environment, os.ModePerm)
+	}
+	environments, err := environmentsIn(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(environments) != 2 || environments[0].Name() != COMMON || environments[1].Name() != MULTIPLE {
+		t.Errorf("Unexpected environments %v", environmentList(environments))
+	}
+
+	// A registered environment type is available to the commands without further changes
+	defer func(registry []EnvironmentType) { environmentRegistry = registry }(environmentRegistry)
+	RegisterEnvironment(&templateEnvironment{
+		name:              "azure-test",
+		command:           &cobra.Command{Use: "azure-test"},
+		dependencies:      []string{COMMON},
+		requiredVariables: []string{"cluster_name"},
+		template: func(config map[string]string, clusterName string, sshKey string) {
+			config["cluster_name"] = "\"" + clusterName + "\""
+		},
+	})
+	if _, ok := environmentCommands()["azure-test"]; !ok {
+		t.Error("The registered environment has no command")
+	}
+	if dependents := environmentDependents(COMMON); dependents[len(dependents)-1] != "azure-test" {
+		t.Errorf("Unexpected dependents %v", dependents)
+	}
+
+	// Required variables need a value
+	os.MkdirAll(dir+"/azure-test", os.ModePerm)
+	if err := generateTfvars(dir+"/azure-test", "azure-test", "", ""); err == nil {
+		t.Error("Expected an empty cluster_name to be rejected")
+	}
+	if err := generateTfvars(dir+"/azure-test", "azure-test", "test", ""); err != nil {
+		t.Fatal(err)
+	}
+	config, _ := ReadTfvarsFile(dir + "/azure-test/bedrock-config.tfvars")
+	if config["cluster_name"] != "\"test\"" {
+		t.Errorf("Unexpected tfvars %v", config)
+	}
+}
+
+func TestKeyvaultVariants(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-cosmos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { keyvaultName, keyvaultRG = "", "" }()
+	keyvaultName, keyvaultRG = "shared-kv", "shared-kv-rg"
+
+	// A template that does not declare the Cosmos DB settings is not the one the environment was written for
+	cosmos, _ := GetEnvironment(COSMOS)
+	declareVariables(dir, cosmos.RequiredVariables()[:len(cosmos.RequiredVariables())-1]...)
+	if err := generateTfvars(dir, COSMOS, "my-cluster", "ssh-rsa key"); err == nil || !strings.Contains(err.Error(), "mongo_db_name") {
+		t.Errorf("Expected the undeclared mongo_db_name to be reported, got %v", err)
+	}
+	declareVariables(dir, cosmos.RequiredVariables()...)
+	if err := generateTfvars(dir, COSMOS, "my-cluster", "ssh-rsa key"); err != nil {
+		t.Fatal(err)
+	}
+	config, _ := ReadTfvarsFile(dir + "/bedrock-config.tfvars")
+	if config["cosmos_db_name"] != "\"my-cluster-cosmos\"" || config["mongo_db_name"] != "\"bedrock\"" || config["keyvault_name"] != "\"shared-kv\"" {
+		t.Errorf("Unexpected tfvars %v", config)
+	}
+
+	// Cosmos DB only tells whether a name exists
+	defer func(runner func(string, ...string) ([]byte, error)) { namingRunner = runner }(namingRunner)
+	namingRunner = func(name string, args ...string) ([]byte, error) {
+		return []byte("true\n"), nil
+	}
+	if available, _, err := nameAvailable(COSMOSDB, "my-cluster-cosmos"); available || err != nil {
+		t.Errorf("Expected an existing Cosmos DB account to be unavailable: %v", err)
+	}
+}
+
+// declareVariables writes a variables.tf declaring the given variables
+func declareVariables(dir string, variables ...string) {
+	declarations := ""
+	for _, variable := range variables {
+		declarations += "variable \"" + variable + "\" {}\n"
+	}
+	ioutil.WriteFile(dir+"/variables.tf", []byte(declarations), 0644)
+}
+
+func environmentList(environments []EnvironmentType) (names []string) {
+	for _, environment := range environments {
+		names = append(names, environment.Name())
+	}
+	return names
+}
+
+func TestMultipleClusterSizing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-multiple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(count, size, eastCount, eastSize, east string) {
+		vmCount, vmSize, vmCountEast, vmSizeEast, regionEast = count, size, eastCount, eastSize, east
+	}(vmCount, vmSize, vmCountEast, vmSizeEast, regionEast)
+	defer func() { keyvaultName, keyvaultRG = "", "" }()
+	keyvaultName, keyvaultRG = "shared-kv", "shared-kv-rg"
+	vmCount, vmSize, regionEast = "2", "Standard_D2s_v3", "eastus"
+	vmCountEast, vmSizeEast = "5", "Standard_D8s_v3"
+
+	// Sizing and locations flow into the tfvars the template declares, with the east override
+	multiple, _ := GetEnvironment(MULTIPLE)
+	declareVariables(dir, append(multiple.RequiredVariables(), "east_resource_group_location", "east_agent_vm_count", "east_agent_vm_size")...)
+	if err := generateTfvars(dir, MULTIPLE, "my-cluster", "ssh-rsa key"); err != nil {
+		t.Fatal(err)
+	}
+	config, _ := ReadTfvarsFile(dir + "/bedrock-config.tfvars")
+	expected := map[string]string{
+		"agent_vm_count":               "\"2\"",
+		"agent_vm_size":                "\"Standard_D2s_v3\"",
+		"east_resource_group_location": "\"eastus\"",
+		"east_agent_vm_count":          "\"5\"",
+		"east_agent_vm_size":           "\"Standard_D8s_v3\"",
+	}
+	for setting, value := range expected {
+		if config[setting] != value {
+			t.Errorf("Expected %s = %s, got %v", setting, value, config[setting])
+		}
+	}
+	if _, ok := config["west_resource_group_location"]; ok {
+		t.Error("Expected the undeclared west_resource_group_location to be left out")
+	}
+
+	// A per-region flag the template has no variable for cannot be applied
+	defer resetFlags(azureMultiClusterCmd)
+	azureMultiClusterCmd.Flags().Set("west-vm-count", "4")
+	if err := generateTfvars(dir, MULTIPLE, "my-cluster", "ssh-rsa key"); err == nil || err.Error() != "--west-vm-count is not supported by the azure-multiple-clusters template" {
+		t.Errorf("Expected the undeclared west_agent_vm_count to be reported, got %v", err)
+	}
+	azureMultiClusterCmd.Flags().Set("tm-routing-method", "Weighted")
+	if err := generateTfvars(dir, MULTIPLE, "my-cluster", "ssh-rsa key"); err == nil || err.Error() != "--tm-routing-method is not supported by the azure-multiple-clusters template" {
+		t.Errorf("Expected the undeclared traffic_manager_routing_method to be reported, got %v", err)
+	}
+
+	// Sizes are checked against the regions of the SKU catalog
+	if err := validateClusterSizing(regionalClusters(), "../skus/bedrock-skus.json"); err != nil {
+		t.Errorf("Expected the sizing to be valid: %s", err)
+	}
+	clusters := regionalClusters()
+	clusters[0].VMSize = "Standard_NC6"
+	clusters[1].Region = "atlantis"
+	clusters[2].VMCount = "0"
+	err = validateClusterSizing(clusters, "../skus/bedrock-skus.json")
+	if err == nil || !strings.Contains(err.Error(), "Standard_NC6 is not available in westus2") || !strings.Contains(err.Error(), "atlantis is not in the SKU catalog") || !strings.Contains(err.Error(), "east node count 0") {
+		t.Errorf("Unexpected validation result %v", err)
+	}
+
+	// Without a catalog only the node counts are checked
+	if err := validateClusterSizing(clusters[:1], dir+"/missing.json"); err != nil {
+		t.Errorf("Expected sizes not to be checked without a catalog: %s", err)
+	}
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"strings"
+
+	util "github.com/yradsmikham/bedrock-cli/util"
+)
+
+var verifyGitops bool
+
+// verifyGitopsConfig checks the GitOps repository, branches and paths configured in the tfvars of an environment
+func verifyGitopsConfig(envPath string, env string) (err error) {
+	config, err := ReadTfvarsFile(envPath + "/bedrock-config.tfvars")
+	if err != nil {
+		return err
+	}
+	return verifyGitopsRepository(envPath, env, func(key string) string {
+		return strings.Trim(config[key], "\"")
+	})
+}
+
+// verifyGitopsFlags checks the GitOps repository, branches and paths given with the flags, before the
+// tfvars of the environment are written
+func verifyGitopsFlags(envPath string, env string) (err error) {
+	flags := map[string]string{
+		"gitops_ssh_url":            gitopsSSHUrl,
+		"gitops_ssh_key":            "deploy-key",
+		"gitops_url_branch":         gitopsURLBranch,
+		"gitops_path":               gitopsPath,
+		"gitops_west_url_branch":    gitopsURLBranchWest,
+		"gitops_west_path":          gitopsPathWest,
+		"gitops_central_url_branch": gitopsURLBranchCentral,
+		"gitops_central_path":       gitopsPathCentral,
+		"gitops_east_url_branch":    gitopsURLBranchEast,
+		"gitops_east_path":          gitopsPathEast,
+	}
+	return verifyGitopsRepository(envPath, env, func(key string) string {
+		return flags[key]
+	})
+}
+
+// verifyGitopsRepository checks that every cluster of an environment can sync with its branch and path
+func verifyGitopsRepository(envPath string, env string, value func(key string) string) (err error) {
+	// Each cluster of azure-multiple-clusters syncs with its own branch and path
+	branches := []string{value("gitops_url_branch")}
+	paths := []string{value("gitops_path")}
+	if env == MULTIPLE {
+		branches, paths = nil, nil
+		for _, cluster := range []string{"west", "central", "east"} {
+			branches = append(branches, value("gitops_"+cluster+"_url_branch"))
+			paths = append(paths, value("gitops_"+cluster+"_path"))
+		}
+	}
+
+	for i := range branches {
+		if err := util.VerifyGitopsRepository(value("gitops_ssh_url"), envPath+"/"+value("gitops_ssh_key"), branches[i], paths[i]); err != nil {
+			return err
+		}
+	}
+	return err
+}
@@ -1,37 +1,47 @@
 package cmd
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/kyokomi/emoji"
 	. "github.com/logrusorgru/aurora"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
+var infoOutput string
+
 var emojiList = []string{
 	":boom:", ":sparkles:", ":alien:", ":cat:", ":honeybee:", ":globe_with_meridians:", ":new_moon:", ":full_moon:", ":earth_americas:", ":earth_asia:", ":tropical_fish:", ":penguin:", ":baby_chick:", ":koala:", ":zap:", ":cyclone:", ":dog:", ":bear:", ":panda_face:", ":maple_leaf:", ":mushroom:", ":full_moon_with_face:", ":crescent_moon:", ":snowflake:", ":frog:", ":monkey_face:", ":snail:", ":rabbit2:", ":new_moon_with_face:", ":bulb:", ":floppy_disk:", ":tennis:", ":gem:", ":baby_bottle:", ":birthday:", ":green_apple:", ":basketball:", ":coffee:", ":tangerine:", ":soccer:", ":game_die:", ":tea:", ":cookie:", ":tomato:", ":lemon:", ":pizza:", ":apple:", ":doughnut:", ":package:", ":dvd:", ":baseball:", ":dart:",
 }
+
+// Descriptions and prerequisites of each environment. Commands and values are quoted with backticks.
+// Flags, variables, dependencies and examples are generated from the commands and templates.
 var infoMap = map[string]map[string][]string{
 	SIMPLE: {
 		"info": []string{
-			Bold(Green(SIMPLE)).String() + " environment is a non-production ready template provided to easily try out Bedrock on Azure",
+			"`" + SIMPLE + "` environment is a non-production ready template provided to easily try out Bedrock on Azure",
 			"Deploys a single cluster (with Flux) using a service principal of your choice",
 		},
 		"pre-reqs": []string{
-			"Service Principal: You can generate an azure service principal using the " + Bold(Green("az ad sp create-for-rbac --subscription <id | name>")).String() + " command",
+			"Service Principal: You can generate an azure service principal using the `az ad sp create-for-rbac --subscription <id | name>` command, or pass `--create-sp` to have the CLI create one",
 			"A Kubernetes manifest repository",
 		},
-		"examples": []string{
-			Bold(Yellow("bedrock azure-simple --secret=84e3017a-some-guid-abcd-d9142d8a3375 --sp=558e824d-some-guid-abcd-ccdb7269d6e0")).String(),
-			Bold(Yellow("bedrock azure-simple --gitops-ssh-url=https://github.com/samiyaakhtar/hello-bedrock-manifest --secret=84e3017a-some-guid-abcd-d9142d8a3375 --sp=558e824d-some-guid-abcd-ccdb7269d6e0")).String(),
-		},
 	},
 	MULTIPLE: {
 		"info": []string{
-			Bold(Green(MULTIPLE)).String() + " environment deploys three redundant clusters (with Flux on each cluster) and an Azure Keyvault, each behind Azure Traffic Manager, which is configured with rules for routing traffic to one of the three clusters",
+			"`" + MULTIPLE + "` environment deploys three redundant clusters (with Flux on each cluster) and an Azure Keyvault, each behind Azure Traffic Manager, which is configured with rules for routing traffic to one of the three clusters",
 			"The Public IP for each AKS cluster will be provisioned in the Resource Group for each region",
 			"A Traffic Manager Rule will be created for each Public IP Address so that the Traffic Manager knows about and can route traffic accordingly",
 			"By default, the multiple cluster template has configurations set up for aks-eastus, aks-westus and aks-centralus. If your regional requirements differ, modify these names to match",
@@ -39,42 +49,208 @@ var infoMap = map[string]map[string][]string{
 			"Each cluster uses its own gitops path (although each cluster can still point to the same path)",
 		},
 		"pre-reqs": []string{
-			"Dependent on a successful deploment of " + Bold(Green(COMMON)).String(),
-			"Service Principal needs to have Owner privileges on the Azure subscription",
+			"Service Principal needs to have Owner privileges on the Azure subscription (`--create-sp` creates one with the Owner role)",
 			"Traffic Manager's following properties are required: Profile name, DNS name, resource group name and resource group location",
 			"A Kubernetes manifest repository",
 		},
 	},
 	KEYVAULT: {
 		"info": []string{
-			Bold(Green(KEYVAULT)).String() + " environment deploys a single production level AKS cluster configured with Flux and Azure Keyvault",
+			"`" + KEYVAULT + "` environment deploys a single production level AKS cluster configured with Flux and Azure Keyvault",
 		},
 		"pre-reqs": []string{
-			"Dependent on a successful deploment of " + Bold(Green(COMMON)).String(),
-			"Service Principal needs to have Owner privileges on the Azure subscription",
+			"Service Principal needs to have Owner privileges on the Azure subscription (`--create-sp` creates one with the Owner role)",
 			"A Kubernetes manifest repository",
 		},
-		"examples": []string{
-			Bold(Yellow("bedrock azure-single-keyvault --sp 558e824d-some-guid-abcd-ccdb7269d6e0 --secret 84e3017a-some-guid-abcd-d9142d8a3375 --subscription 7060bca0-some-guid-abcd-4bb1e9facfac --common-infra-path bedrock/cluster/environments/keen-montalcini/azure-common-infra")).String(),
-			Bold(Yellow("bedrock azure-single-keyvault --sp 558e824d-some-guid-abcd-ccdb7269d6e0 --secret 84e3017a-some-guid-abcd-d9142d8a3375 --subscription 7060bca0-some-guid-abcd-4bb1e9facfac --tenant 72f988bf-some-guid-abcd-2d7cd011db47")).String(),
-			Bold(Yellow("bedrock azure-single-keyvault --sp 558e824d-some-guid-abcd-ccdb7269d6e0 --secret 84e3017a-some-guid-abcd-d9142d8a3375 --subscription 7060bca0-some-guid-abcd-4bb1e9facfac --tenant 72f988bf-some-guid-abcd-2d7cd011db47 --gitops-ssh-url https://github.com/samiyaakhtar/hello-bedrock-manifest")).String(),
+	},
+	COSMOS: {
+		"info": []string{
+			"`" + COSMOS + "` environment deploys a single production level AKS cluster configured with Flux and Azure Keyvault, along with a Cosmos DB account and a MongoDB database",
+			"The Cosmos DB account is named after the cluster unless `--cosmos-db-name` is given",
+		},
+		"pre-reqs": []string{
+			"Service Principal needs to have Owner privileges on the Azure subscription (`--create-sp` creates one with the Owner role)",
+			"A Kubernetes manifest repository",
 		},
 	},
 	COMMON: {
 		"info": []string{
-			Bold(Green(COMMON)).String() + " environment is a production ready template to setup common permanent elements of your infrastructure like vnets, keyvault, and a common resource group for them",
-			"Dependency environment for other environments like the " + KEYVAULT + " and " + MULTIPLE,
+			"`" + COMMON + "` environment is a production ready template to setup common permanent elements of your infrastructure like vnets, keyvault, and a common resource group for them",
 			"Creates a resource group for your deployment, a VNET and subnet(s), and an Azure Key Vault with the appropriate access policies",
 		},
 		"pre-reqs": []string{
-			"A storage account in Azure: set the following fields as environment variables or pass as parameters: " + Bold(Green("AZURE_STORAGE_ACCOUNT")).String() + ", " + Bold(Green("AZURE_STORAGE_KEY")).String() + ", " + Bold(Green("ARM_SUBSCRIPTION_ID")).String() + ", " + Bold(Green("ARM_CLIENT_ID")).String() + ", " + Bold(Green("ARM_CLIENT_SECRET")).String() + ", " + Bold(Green("ARM_TENANT_ID")).String(),
-		},
-		"examples": []string{
-			Bold(Yellow("bedrock azure-common-infra --sp 558e824d-some-guid-abcd-ccdb7269d6e0 --tenant 72f988bf-some-guid-abcd-2d7cd011db47")).String(),
+			"A storage account in Azure: set the following fields as environment variables or pass as parameters: `AZURE_STORAGE_ACCOUNT`, `AZURE_STORAGE_KEY`, `ARM_SUBSCRIPTION_ID`, `ARM_CLIENT_ID`, `ARM_CLIENT_SECRET`, `ARM_TENANT_ID`",
 		},
 	},
 }
 
+// FlagInfo describes a command line flag of an environment
+type FlagInfo struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Default     string `json:"default"`
+	Required    bool   `json:"required"`
+	Description string `json:"description"`
+}
+
+// VariableInfo describes a variable of the terraform template of an environment
+type VariableInfo struct {
+	Name        string `json:"name"`
+	Type        string `json:"type,omitempty"`
+	Default     string `json:"default,omitempty"`
+	Required    bool   `json:"required"`
+	Description string `json:"description,omitempty"`
+	Generated   bool   `json:"generated"` // Set by the CLI in bedrock-config.tfvars
+}
+
+// EnvironmentInfo describes an environment, its flags and its template variables
+type EnvironmentInfo struct {
+	Name          string         `json:"name"`
+	Description   []string       `json:"description"`
+	Prerequisites []string       `json:"prerequisites"`
+	Dependencies  []string       `json:"dependencies"`
+	Dependents    []string       `json:"dependents"`
+	Backend       bool           `json:"backend"`
+	Flags         []FlagInfo     `json:"flags"`
+	Variables     []VariableInfo `json:"variables"`
+	Examples      []string       `json:"examples"`
+}
+
+var variableRegex = regexp.MustCompile(`(?m)^\s*variable\s+"([^"]+)"\s*\{`)
+var variableTypeRegex = regexp.MustCompile(`(?m)^\s*type\s*=\s*"?([^"\n]+?)"?\s*$`)
+var variableDefaultRegex = regexp.MustCompile(`(?m)^\s*default\s*=\s*(.+?)\s*$`)
+var variableDescriptionRegex = regexp.MustCompile(`(?m)^\s*description\s*=\s*"(.*)"\s*$`)
+
+// templateVariables reads the variable declarations of the terraform files of a template
+func templateVariables(templatePath string) (variables map[string]VariableInfo, err error) {
+	variables = map[string]VariableInfo{}
+	files, err := filepath.Glob(templatePath + "/*.tf")
+	if err != nil {
+		return variables, err
+	}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return variables, err
+		}
+		text := string(content)
+		for _, match := range variableRegex.FindAllStringSubmatchIndex(text, -1) {
+			// The body of the block ends at the matching closing brace
+			depth, end := 1, match[1]
+			for ; end < len(text) && depth > 0; end++ {
+				switch text[end] {
+				case '{':
+					depth++
+				case '}':
+					depth--
+				}
+			}
+			body := text[match[1]:end]
+			variable := VariableInfo{Name: text[match[2]:match[3]], Type: "string", Required: true}
+			if m := variableTypeRegex.FindStringSubmatch(body); m != nil {
+				variable.Type = m[1]
+			}
+			if m := variableDefaultRegex.FindStringSubmatch(body); m != nil {
+				variable.Default = m[1]
+				variable.Required = false
+			}
+			if m := variableDescriptionRegex.FindStringSubmatch(body); m != nil {
+				variable.Description = m[1]
+			}
+			variables[variable.Name] = variable
+		}
+	}
+	return variables, err
+}
+
+// flagPlaceholder returns the value shown for a flag in the usage of a command, e.g. manifest-repo-url-in-ssh-format
+func flagPlaceholder(cmd *cobra.Command, name string) string {
+	fields := strings.Fields(strings.NewReplacer("[", " ", "]", " ").Replace(cmd.Use))
+	for i, field := range fields {
+		if field == "--"+name && i+1 < len(fields) && !strings.HasPrefix(fields[i+1], "--") {
+			return "<" + fields[i+1] + ">"
+		}
+	}
+	return "<" + name + ">"
+}
+
+// environmentExamples builds example command lines from the flags the command actually has
+func environmentExamples(name string, cmd *cobra.Command, flags []FlagInfo) (examples []string) {
+	required := "bedrock " + name
+	for _, flag := range flags {
+		if flag.Required {
+			required += " --" + flag.Name + " " + flagPlaceholder(cmd, flag.Name)
+		}
+	}
+	credentials := ""
+	for _, flag := range []string{"sp", "secret", "tenant"} {
+		if cmd.Flags().Lookup(flag) != nil {
+			credentials += " --" + flag + " " + flagPlaceholder(cmd, flag)
+		}
+	}
+	examples = append(examples, required+credentials)
+	if cmd.Flags().Lookup("auth") != nil && cmd.Flags().Lookup("create-sp") != nil {
+		examples = append(examples, required+" --auth cli --create-sp")
+	}
+	if cmd.Flags().Lookup("common-infra-path") != nil {
+		examples = append(examples, required+credentials+" --common-infra-path bedrock/cluster/environments/<cluster-name>")
+	}
+	return examples
+}
+
+// GetEnvironmentInfo describes an environment from its command, template and tfvars generation
+func GetEnvironmentInfo(name string) (info EnvironmentInfo, err error) {
+	environment, ok := GetEnvironment(name)
+	if !ok {
+		return info, errors.New("The environment you specified is not of the following: " + strings.Join(environmentNames(), ", "))
+	}
+	cmd := environment.Command()
+	info = EnvironmentInfo{
+		Name:          name,
+		Description:   infoMap[name]["info"],
+		Prerequisites: infoMap[name]["pre-reqs"],
+		Dependencies:  environment.Dependencies(),
+		Dependents:    environmentDependents(name),
+		Backend:       environment.Backend(),
+		Flags:         []FlagInfo{},
+		Variables:     []VariableInfo{},
+	}
+	for _, dependency := range info.Dependencies {
+		info.Prerequisites = append([]string{"Dependent on a successful deployment of `" + dependency + "`"}, info.Prerequisites...)
+	}
+
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		_, required := flag.Annotations[cobra.BashCompOneRequiredFlag]
+		info.Flags = append(info.Flags, FlagInfo{Name: flag.Name, Type: flag.Value.Type(), Default: flag.DefValue, Required: required, Description: flag.Usage})
+	})
+
+	// Variables declared by the template (once the Bedrock repo is cloned) and the ones the CLI sets
+	variables, err := templateVariables("bedrock/cluster/environments/" + name)
+	if err != nil {
+		return info, err
+	}
+	generated := map[string]string{}
+	environment.GenerateTfvars(generated, "", "")
+	for variable := range generated {
+		entry, declared := variables[variable]
+		if !declared {
+			entry = VariableInfo{Name: variable, Type: "string"}
+			for _, required := range environment.RequiredVariables() {
+				entry.Required = entry.Required || required == variable
+			}
+		}
+		entry.Generated = true
+		variables[variable] = entry
+	}
+	for _, variable := range variables {
+		info.Variables = append(info.Variables, variable)
+	}
+	sort.Slice(info.Variables, func(i, j int) bool { return info.Variables[i].Name < info.Variables[j].Name })
+
+	info.Examples = environmentExamples(name, cmd, info.Flags)
+	return info, err
+}
+
 // GetEmoji function generates random emojies for info display
 func GetEmoji() (emoji string) {
 	rand.Seed(time.Now().UnixNano())
@@ -82,43 +258,143 @@ func GetEmoji() (emoji string) {
 	return emojiList[randNum]
 }
 
-// Info function will generation information per environment
-func Info(env string) (err error) {
+var quotedRegex = regexp.MustCompile("`([^`]*)`")
+
+// highlight renders the quoted parts of a description in color for the terminal
+func highlight(text string) string {
+	return quotedRegex.ReplaceAllStringFunc(text, func(quoted string) string {
+		return Bold(Green(strings.Trim(quoted, "`"))).String()
+	})
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
+
+// writeMarkdown writes the reference of the environments as markdown
+func writeMarkdown(out io.Writer, environments []EnvironmentInfo) {
+	for _, info := range environments {
+		fmt.Fprintf(out, "## %s\n\n", info.Name)
+		for _, line := range info.Description {
+			fmt.Fprintf(out, "%s.\n", strings.TrimSuffix(line, "."))
+		}
+		if len(info.Dependencies) > 0 {
+			fmt.Fprintf(out, "\nDepends on: `%s`\n", strings.Join(info.Dependencies, "`, `"))
+		}
+		if len(info.Dependents) > 0 {
+			fmt.Fprintf(out, "\nRequired by: `%s`\n", strings.Join(info.Dependents, "`, `"))
+		}
+		fmt.Fprintf(out, "\n### Pre-requisites\n\n")
+		for _, line := range info.Prerequisites {
+			fmt.Fprintf(out, "- %s\n", line)
+		}
+		fmt.Fprintf(out, "\n### Flags\n\n| Flag | Type | Default | Required | Description |\n| --- | --- | --- | --- | --- |\n")
+		for _, flag := range info.Flags {
+			fmt.Fprintf(out, "| `--%s` | %s | %s | %s | %s |\n", flag.Name, flag.Type, markdownCode(flag.Default), yesNo(flag.Required), flag.Description)
+		}
+		fmt.Fprintf(out, "\n### Variables\n\n| Variable | Type | Default | Required | Set by the CLI | Description |\n| --- | --- | --- | --- | --- | --- |\n")
+		for _, variable := range info.Variables {
+			fmt.Fprintf(out, "| `%s` | %s | %s | %s | %s | %s |\n", variable.Name, variable.Type, markdownCode(variable.Default), yesNo(variable.Required), yesNo(variable.Generated), variable.Description)
+		}
+		fmt.Fprintf(out, "\n### Examples\n\n```bash\n%s\n```\n\n", strings.Join(info.Examples, "\n"))
+	}
+}
+
+func markdownCode(value string) string {
+	if value == "" || value == "[]" {
+		return ""
+	}
+	return "`" + strings.Replace(value, "|", "\\|", -1) + "`"
+}
+
+// writeText writes the description of an environment for the terminal
+func writeText(out io.Writer, info EnvironmentInfo) {
 	var emojiStr = GetEmoji()
 
-	fmt.Println()
-	for _, element := range infoMap[env]["info"] {
-		fmt.Println(emoji.Sprintf("%s %s", emojiStr, element))
+	fmt.Fprintln(out)
+	for _, element := range info.Description {
+		fmt.Fprintln(out, emoji.Sprintf("%s %s", emojiStr, highlight(element)))
 	}
-	fmt.Println(Bold(Cyan("\n    Pre-Requisites")))
-	for _, element := range infoMap[env]["pre-reqs"] {
-		fmt.Println(emoji.Sprintf("%s %s", emojiStr, element))
+	fmt.Fprintln(out, Bold(Cyan("\n    Pre-Requisites")))
+	for _, element := range info.Prerequisites {
+		fmt.Fprintln(out, emoji.Sprintf("%s %s", emojiStr, highlight(element)))
 	}
-	if len(infoMap[env]["examples"]) > 0 {
-		fmt.Println(Bold(Red("\n    Examples")))
-		for _, element := range infoMap[env]["examples"] {
-			fmt.Println(emoji.Sprintf("%s %s", emojiStr, element))
+	fmt.Fprintln(out, Bold(Magenta("\n    Required Flags")))
+	for _, flag := range info.Flags {
+		if flag.Required {
+			fmt.Fprintln(out, emoji.Sprintf("%s --%s: %s", emojiStr, Bold(Green(flag.Name)), flag.Description))
 		}
 	}
-	return nil
+	fmt.Fprintln(out, Bold(Red("\n    Examples")))
+	for _, element := range info.Examples {
+		fmt.Fprintln(out, emoji.Sprintf("%s %s", emojiStr, Bold(Yellow(element))))
+	}
+	fmt.Fprintln(out, emoji.Sprintf("\n%s All flags and template variables: %s", emojiStr, Bold(Green("bedrock info "+info.Name+" -o markdown"))))
+}
+
+// Info function will generation information per environment, or for all of them when env is empty
+func Info(env string, output string) (err error) {
+	names := environmentNames()
+	if env != "" {
+		names = []string{env}
+	}
+	var environments []EnvironmentInfo
+	for _, name := range names {
+		info, err := GetEnvironmentInfo(name)
+		if err != nil {
+			return err
+		}
+		environments = append(environments, info)
+	}
+
+	switch output {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if env != "" {
+			return encoder.Encode(environments[0])
+		}
+		dependencies := map[string][]string{}
+		for _, environment := range Environments() {
+			if len(environment.Dependencies()) > 0 {
+				dependencies[environment.Name()] = environment.Dependencies()
+			}
+		}
+		return encoder.Encode(map[string]interface{}{"environments": environments, "dependencies": dependencies})
+	case "markdown":
+		writeMarkdown(os.Stdout, environments)
+	case "text":
+		for _, info := range environments {
+			writeText(os.Stdout, info)
+		}
+	default:
+		return errors.New("Unsupported output format '" + output + "', use text, json or markdown")
+	}
+	return err
 }
 
 var infoCmd = &cobra.Command{
-	Use:   "info <environment_name>",
+	Use:   "info [environment_name] [-o text|json|markdown]",
 	Short: "Get details about an environment",
-	Long:  `Get details about an environment`,
+	Long:  `Get details about an environment: its pre-requisites, dependencies, flags and template variables. Without an environment name, all environments are described.`,
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 		if len(args) == 0 {
-			return errors.New("You need to specify an environment: " + SIMPLE + ", " + MULTIPLE + ", " + KEYVAULT + ", " + COMMON)
-
+			if infoOutput == "text" {
+				return errors.New("You need to specify an environment: " + strings.Join(environmentNames(), ", "))
+			}
+			return Info("", infoOutput)
 		}
-		if !((args[0] == SIMPLE) || (args[0] == MULTIPLE) || args[0] == KEYVAULT || args[0] == COMMON) {
-			return errors.New("The environment you specified is not of the following: " + SIMPLE + ", " + MULTIPLE + ", " + KEYVAULT + ", " + COMMON)
+		if _, ok := GetEnvironment(args[0]); !ok {
+			return errors.New("The environment you specified is not of the following: " + strings.Join(environmentNames(), ", "))
 		}
-		return Info(args[0])
+		return Info(args[0], infoOutput)
 	},
 }
 
 func init() {
+	infoCmd.Flags().StringVarP(&infoOutput, "output", "o", "text", "Output format: text, json or markdown")
 	rootCmd.AddCommand(infoCmd)
 }
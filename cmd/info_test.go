@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEnvironmentInfo(t *testing.T) {
+	info, err := GetEnvironmentInfo(SIMPLE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	required := map[string]bool{}
+	for _, flag := range info.Flags {
+		required[flag.Name] = flag.Required
+	}
+	if !required["gitops-ssh-url"] || required["sp"] {
+		t.Errorf("Unexpected required flags %v", required)
+	}
+	generated := false
+	for _, variable := range info.Variables {
+		if variable.Name == "gitops_ssh_url" && variable.Generated {
+			generated = true
+		}
+	}
+	if !generated {
+		t.Error("Expected gitops_ssh_url to be listed as set by the CLI")
+	}
+	if !strings.Contains(info.Examples[0], "--gitops-ssh-url <manifest-repo-url-in-ssh-format>") {
+		t.Errorf("Unexpected example %s", info.Examples[0])
+	}
+
+	// Dependencies are listed in both directions
+	common, err := GetEnvironmentInfo(COMMON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(common.Dependents) != 3 || common.Backend != true {
+		t.Errorf("Unexpected common infra dependents %v", common.Dependents)
+	}
+	keyvault, _ := GetEnvironmentInfo(KEYVAULT)
+	if len(keyvault.Dependencies) != 1 || !strings.Contains(keyvault.Prerequisites[0], COMMON) {
+		t.Errorf("Unexpected keyvault dependencies %v", keyvault.Prerequisites)
+	}
+
+	if _, err := GetEnvironmentInfo("azure-unknown"); err == nil {
+		t.Error("Expected an unknown environment to fail")
+	}
+
+	var out bytes.Buffer
+	writeMarkdown(&out, []EnvironmentInfo{info})
+	if !strings.Contains(out.String(), "| `--gitops-ssh-url` | string | `git@github.com:timfpark/fabrikate-cloud-native-manifests.git` | yes |") {
+		t.Errorf("Unexpected markdown %s", out.String())
+	}
+}
+
+func TestTemplateVariables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(dir+"/variables.tf", []byte(`variable "agent_vm_count" {
+  type    = string
+  default = "3"
+}
+
+variable "ssh_public_key" {
+  type        = "string"
+  description = "Public key of the cluster nodes"
+}
+
+variable "tags" {
+  type = map(string)
+  default = {
+    owner = "bedrock"
+  }
+}
+`), 0644)
+	variables, err := templateVariables(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(variables) != 3 {
+		t.Fatalf("Expected 3 variables, got %v", variables)
+	}
+	if v := variables["agent_vm_count"]; v.Required || v.Default != "\"3\"" {
+		t.Errorf("Unexpected agent_vm_count %+v", v)
+	}
+	if v := variables["ssh_public_key"]; !v.Required || v.Type != "string" || v.Description != "Public key of the cluster nodes" {
+		t.Errorf("Unexpected ssh_public_key %+v", v)
+	}
+	if v := variables["tags"]; v.Required || v.Type != "map(string)" {
+		t.Errorf("Unexpected tags %+v", v)
+	}
+}
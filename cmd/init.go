@@ -3,17 +3,22 @@ package cmd
 import "C"
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/docker/docker/pkg/namesgenerator"
 	"github.com/kyokomi/emoji"
 	log "github.com/sirupsen/logrus"
+	"github.com/yradsmikham/bedrock-cli/pkg/bedrock"
 	util "github.com/yradsmikham/bedrock-cli/util"
 )
 
@@ -35,6 +40,11 @@ func nameGenerator() (name string) {
 
 // Init function initializes the configuration for a given environment
 func Init(environment string, clusterName string) (cluster string, resourceList []string, err error) {
+	env, ok := GetEnvironment(environment)
+	if !ok {
+		return "", nil, fmt.Errorf("Unsupported environment %s, use one of: %s", environment, strings.Join(environmentNames(), ", "))
+	}
+
 	//resources := []string{}
 	requiredSystemTools := []string{"git", "helm", "sh", "curl", "terraform", "az"}
 	for _, tool := range requiredSystemTools {
@@ -47,10 +57,16 @@ func Init(environment string, clusterName string) (cluster string, resourceList
 
 	// Check if Bedrock Repo is already cloned
 	log.Info(emoji.Sprintf(":open_file_folder: Checking for Bedrock"))
-	if output, err := exec.Command("git", "clone", "--branch", "v0.12.0", "https://github.com/microsoft/bedrock").CombinedOutput(); output != nil || err != nil {
+	if output, err := exec.Command("git", "clone", "--branch", BEDROCK, "https://github.com/microsoft/bedrock").CombinedOutput(); output != nil || err != nil {
 		log.Info(emoji.Sprintf(":star: Bedrock Repo already cloned"))
 	}
 
+	// Environment types can target templates that a given Bedrock version does not ship
+	if _, err := os.Stat("bedrock/cluster/environments/" + environment); os.IsNotExist(err) {
+		log.Error(emoji.Sprintf(":no_entry_sign: The %s template was not found in bedrock/cluster/environments", environment))
+		return "", nil, fmt.Errorf("The %s template is not part of Bedrock %s", environment, BEDROCK)
+	}
+
 	// If cluster name not provided, generate a random cluster name
 	if clusterName == "" {
 		randomClusterName := nameGenerator()
@@ -58,108 +74,208 @@ func Init(environment string, clusterName string) (cluster string, resourceList
 		clusterName = randomClusterName
 	}
 
+	// Validate the generated resource names before anything gets created
+	if naming, err = newNamingConvention(); err != nil {
+		return "", nil, err
+	}
+	if err := validateNames(environment, clusterName); err != nil {
+		return "", nil, err
+	}
+
+	// Tags given with --tag replace the ones from the config file
+	if tags, err = parseTags(tagFlags); err != nil {
+		return "", nil, err
+	}
+
+	// Record every resource that gets created so it can be rolled back if a later step fails
+	if journal == nil {
+		journal = NewJournal(clusterName)
+		defer func() {
+			if err != nil {
+				rollback(journal)
+			} else if journal.Pending() > 0 {
+				log.Info(emoji.Sprintf(":memo: Resources created for this environment are recorded in %s", journal.Path))
+			}
+			journal, createdServicePrincipal = nil, nil
+		}()
+	}
+
 	// Set Environment Variables
 	if error := VerifyEnvVariables(clusterName, environment); error != nil {
 		return "", nil, error
 	}
 
-	// Check if resource group exists, if it doesn't create it
-	if resourceGroup == "" {
-		if environment == COMMON {
-			// Create the resource group
-			log.Info(emoji.Sprintf(":construction: Creating new resource group: %s", clusterName+"-kv-rg"))
-			output, err := exec.Command("az", "group", "create", "--name", clusterName+"-kv-rg", "--location", region).CombinedOutput()
-			if err != nil {
-				log.Error(emoji.Sprintf(":no_entry_sign: There was an error with creating the resource group!"))
-				log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, output))
-				panic(fmt.Errorf("Please try again"))
-			}
-			resources = append(resources, clusterName+"-kv-rg")
-		} else if environment == MULTIPLE {
-			if resourceGroupWest == "" || resourceGroupCentral == "" || resourceGroupEast == "" {
-				// Create resource groups for every region
-				log.Info(emoji.Sprintf(":construction: Creating new resource group: %s", clusterName+"-west-rg, "+clusterName+"-central-rg, "+clusterName+"-east-rg"))
-				outputWest, westRgCreationErr := exec.Command("az", "group", "create", "--name", clusterName+"-west-rg", "--location", regionWest).CombinedOutput()
-				if westRgCreationErr != nil {
-					log.Error(emoji.Sprintf(":no_entry_sign: There was an error with creating the resource group!"))
-					log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, outputWest))
-					panic(fmt.Errorf("Please try again"))
-				}
-				resourceGroupWest = clusterName + "-west-rg"
-				outputCentral, centralRgCreationErr := exec.Command("az", "group", "create", "--name", clusterName+"-central-rg", "--location", regionCentral).CombinedOutput()
-				if centralRgCreationErr != nil {
-					log.Error(emoji.Sprintf(":no_entry_sign: There was an error with creating the resource group!"))
-					log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, outputCentral))
-					panic(fmt.Errorf("Please try again"))
-				}
-				resourceGroupEast = clusterName + "-east-rg"
-				outputEast, eastRgCreationErr := exec.Command("az", "group", "create", "--name", clusterName+"-east-rg", "--location", regionEast).CombinedOutput()
-				if eastRgCreationErr != nil {
-					log.Error(emoji.Sprintf(":no_entry_sign: There was an error with creating the resource group!"))
-					log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, outputEast))
-					panic(fmt.Errorf("Please try again"))
-				}
-				resourceGroupCentral = clusterName + "-central-rg"
-
-				if resourceGroupTm == "" {
-					output, trafficManagerErr := exec.Command("az", "group", "create", "--name", clusterName+"-tm-rg", "--location", regionEast).CombinedOutput()
-					if trafficManagerErr != nil {
-						log.Error(emoji.Sprintf(":no_entry_sign: There was an error with creating the resource group!"))
-						log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, output))
-						panic(fmt.Errorf("Please try again"))
-					}
-					resourceGroupTm = clusterName + "-tm-rg"
-				}
+	// Rerunning Init for an existing environment reconciles it instead of recreating it
+	environmentPath := "bedrock/cluster/environments/" + clusterName
+	fullEnvironmentPath := environmentPath + "/" + environment
+	if fileExists(fullEnvironmentPath + "/bedrock-config.tfvars") {
+		log.Info(emoji.Sprintf(":recycle: Environment %s already exists, reconciling it with the given flags", fullEnvironmentPath))
+		if err := loadBackendConfig(fullEnvironmentPath); err != nil {
+			return "", nil, err
+		}
+	} else if checkNames {
+		if err := checkNameAvailability(environment, clusterName); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// Copy Terraform Template
+	if _, err := os.Stat(environmentPath); os.IsNotExist(err) {
+		journal.Record(DIRECTORY, environmentPath, "")
+	}
+	if error := os.MkdirAll(environmentPath, os.ModePerm); error != nil {
+		return "", nil, error
+	}
+
+	if _, err := os.Stat(fullEnvironmentPath); err == nil {
+		// Only add template files that are missing so local changes to the template are kept
+		log.Info(emoji.Sprintf(":flashlight: Updating Environment %s", environmentPath))
+		if err := copyMissingFiles("bedrock/cluster/environments/"+environment, fullEnvironmentPath); err != nil {
+			return "", nil, err
+		}
+	} else {
+		log.Info(emoji.Sprintf(":flashlight: Creating New Environment %s", environmentPath))
+		if output, err := exec.Command("cp", "-r", "bedrock/cluster/environments/"+environment, environmentPath).CombinedOutput(); err != nil {
+			log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, output))
+			return "", nil, err
+		}
+	}
+
+	// The service principal is deleted with the environments, not only when the creation is rolled back
+	if err := recordServicePrincipal(environmentPath); err != nil {
+		return "", nil, err
+	}
+
+	// Generate SSH keys, reusing the existing deploy key since it is already known to the manifest repository
+	newKey := false
+	if env.GitOps() {
+		keyPath := fullEnvironmentPath + "/deploy-key"
+		newKey = !fileExists(keyPath) || sshForce
+		if newKey {
+			if SSHKey, err = SSH(fullEnvironmentPath, "deploy-key", sshKeyOptions()); err != nil {
+				return "", nil, err
 			}
-			resources = append(resources, resourceGroupWest, resourceGroupEast, resourceGroupCentral, resourceGroupTm)
 		} else {
-			// Create the resource group
-			log.Info(emoji.Sprintf(":construction: Creating new resource group: %s", clusterName+"-rg"))
-			output, err := exec.Command("az", "group", "create", "--name", clusterName+"-rg", "--location", region).CombinedOutput()
+			log.Info(emoji.Sprintf(":recycle: Reusing existing deploy key %s", keyPath))
+			publicKey, err := ioutil.ReadFile(keyPath + ".pub")
+			if err != nil {
+				return "", nil, err
+			}
+			SSHKey = string(publicKey)
+		}
+		if registerDeployKey && (newKey || !fileExists(keyPath+".json")) {
+			registration, err := RegisterDeployKey(fullEnvironmentPath+"/deploy-key", "bedrock-"+clusterName+"-"+environment, gitopsSSHUrl, SSHKey)
 			if err != nil {
-				log.Error(emoji.Sprintf(":no_entry_sign: There was an error with creating the resource group!"))
-				log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, output))
-				panic(fmt.Errorf("Please try again"))
+				return "", nil, err
 			}
-			//resourceGroup = clusterName + "-rg"
-			resources = append(resources, clusterName+"-rg")
+			journal.Record(DEPLOYKEY, registration.ID, gitopsSSHUrl)
+		}
+	}
+
+	// Verify that Flux will be able to sync with the GitOps repository before any Azure resource is created
+	if verifyGitops && env.GitOps() {
+		if newKey && !registerDeployKey {
+			return "", nil, fmt.Errorf("--verify-gitops needs the new deploy key to be registered with the GitOps repository, add --register-deploy-key")
+		}
+		if err := verifyGitopsFlags(fullEnvironmentPath, environment); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// Check if resource group exists, if it doesn't create it
+	if resourceGroup == "" {
+		if err := env.CreateResourceGroups(clusterName); err != nil {
+			return "", nil, err
 		}
 	} else {
 		log.Info(emoji.Sprintf(":mag_right: Verifying Resource Group..."))
 		output, _ := exec.Command("az", "group", "show", "--name", resourceGroup).CombinedOutput()
 		if strings.Contains(string(output), "could not be found") {
 			log.Error(emoji.Sprintf(":question: The resource group specified does not exist!"))
-			panic(fmt.Errorf("Please specify an existing resource group, or do not use the '--resource-group' to auto-generate one"))
+			return "", nil, fmt.Errorf("Please specify an existing resource group, or do not use the '--resource-group' to auto-generate one")
 		}
 	}
 
-	// Copy Terraform Template
-	environmentPath := "bedrock/cluster/environments/" + clusterName
-	if error := os.MkdirAll(environmentPath, os.ModePerm); error != nil {
-		return "", nil, error
+	// Create bedrock-config.tfvars
+	if err := addConfigTemplate(environment, fullEnvironmentPath, environmentPath, clusterName, SSHKey); err != nil {
+		return "", nil, err
 	}
 
-	log.Info(emoji.Sprintf(":flashlight: Creating New Environment %s", environmentPath))
-	if output, err := exec.Command("cp", "-r", "bedrock/cluster/environments/"+environment, environmentPath).CombinedOutput(); err != nil {
+	return clusterName, resources, err
+}
+
+// createResourceGroup creates a resource group, unless it already exists, and records it in the creation journal
+func createResourceGroup(name string, location string) (err error) {
+	if output, err := exec.Command("az", "group", "exists", "--name", name).Output(); err == nil && strings.TrimSpace(string(output)) == "true" {
+		log.Info(emoji.Sprintf(":recycle: Resource group %s already exists, reusing it", name))
+		resources = append(resources, name)
+		return err
+	}
+
+	log.Info(emoji.Sprintf(":construction: Creating new resource group: %s", name))
+	entry := journal.Begin(RESOURCEGROUP, name, "")
+	output, err := exec.Command("az", append([]string{"group", "create", "--name", name, "--location", location}, util.TagArgs(tags)...)...).CombinedOutput()
+	if err != nil {
+		log.Error(emoji.Sprintf(":no_entry_sign: There was an error with creating the resource group!"))
 		log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, output))
-		return "", nil, err
+		return fmt.Errorf("Unable to create resource group %s", name)
 	}
+	journal.Complete(entry)
+	resources = append(resources, name)
+	return err
+}
 
-	// Generate SSH keys
-	fullEnvironmentPath := environmentPath + "/" + environment
-	if environment != COMMON {
-		SSHKey, _ = SSH(fullEnvironmentPath, "deploy-key")
+// createClusterResourceGroup creates the resource group of an environment with a single cluster
+func createClusterResourceGroup(clusterName string) (err error) {
+	return createResourceGroup(resourceName("resource-group", clusterName), region)
+}
+
+// createCommonResourceGroup creates the resource group of the keyvault and network of the common infra
+func createCommonResourceGroup(clusterName string) (err error) {
+	return createResourceGroup(resourceName("keyvault-resource-group", clusterName), region)
+}
+
+// createRegionalResourceGroups creates a resource group for every region and one for Traffic Manager
+func createRegionalResourceGroups(clusterName string) (err error) {
+	if resourceGroupWest != "" && resourceGroupCentral != "" && resourceGroupEast != "" {
+		return err
 	}
+	if err := createResourceGroup(resourceName("west-resource-group", clusterName), regionWest); err != nil {
+		return err
+	}
+	resourceGroupWest = resourceName("west-resource-group", clusterName)
+	if err := createResourceGroup(resourceName("central-resource-group", clusterName), regionCentral); err != nil {
+		return err
+	}
+	resourceGroupCentral = resourceName("central-resource-group", clusterName)
+	if err := createResourceGroup(resourceName("east-resource-group", clusterName), regionEast); err != nil {
+		return err
+	}
+	resourceGroupEast = resourceName("east-resource-group", clusterName)
 
-	// Create bedrock-config.tfvars
-	if err := addConfigTemplate(environment, fullEnvironmentPath, environmentPath, clusterName, SSHKey); err != nil {
-		return "", nil, err
+	if resourceGroupTm == "" {
+		if err := createResourceGroup(resourceName("tm-resource-group", clusterName), regionEast); err != nil {
+			return err
+		}
+		resourceGroupTm = resourceName("tm-resource-group", clusterName)
 	}
-	return clusterName, resources, err
+	return err
 }
 
 // VerifyEnvVariables function verifies that SP is set
 func VerifyEnvVariables(clusterName string, envType string) (err error) {
+	if err := validateAuthMode(authMode); err != nil {
+		return err
+	}
+	if authMode != AUTHSP {
+		if err := verifyAuthVariables(authMode); err != nil {
+			return err
+		}
+		if createSP && servicePrincipal == "" {
+			return createServicePrincipal(clusterName, envType)
+		}
+		return err
+	}
 
 	_, subscriptionExists := os.LookupEnv("ARM_SUBSCRIPTION_ID")
 	if subscriptionExists {
@@ -168,11 +284,18 @@ func VerifyEnvVariables(clusterName string, envType string) (err error) {
 	} else {
 		if subscription == "" {
 			log.Error(emoji.Sprintf(":confounded: A Subscription environment variable was not found. Please specify the ARM_SUBSCRIPTION_ID environment variable, or use the --subscription argument when creating the environment."))
-			panic(fmt.Errorf("A Subscription ID needs to be specified"))
+			return errors.New("A Subscription ID needs to be specified")
 		} else {
 			os.Setenv("ARM_SUBSCRIPTION_ID", subscription)
 		}
 	}
+
+	// The created service principal is also used by terraform
+	if _, spExists := os.LookupEnv("ARM_CLIENT_ID"); createSP && !spExists && servicePrincipal == "" {
+		if err := createServicePrincipal(clusterName, envType); err != nil {
+			return err
+		}
+	}
 	_, spExists := os.LookupEnv("ARM_CLIENT_ID")
 	if spExists {
 		log.Info(emoji.Sprintf(":globe_with_meridians: A Service Principal was found in the environment variables."))
@@ -180,7 +303,7 @@ func VerifyEnvVariables(clusterName string, envType string) (err error) {
 	} else {
 		if servicePrincipal == "" {
 			log.Error(emoji.Sprintf(":confounded: A Service Principal environment variable was not found. Please specify the ARM_CLIENT_ID environment variable, or use the --sp argument when creating the environment."))
-			panic(fmt.Errorf("A Service Principal needs to be specified"))
+			return errors.New("A Service Principal needs to be specified")
 		} else {
 			os.Setenv("ARM_CLIENT_ID", servicePrincipal)
 		}
@@ -192,7 +315,7 @@ func VerifyEnvVariables(clusterName string, envType string) (err error) {
 	} else {
 		if secret == "" {
 			log.Error(emoji.Sprintf(":confounded: A Service Principal Secret environment variable was not found. Please specify the ARM_CLIENT_SECRET environment variable, or use the --secret argument when creating the environment."))
-			panic(fmt.Errorf("A Service Principal Password needs to be specified"))
+			return errors.New("A Service Principal Password needs to be specified")
 		} else {
 			os.Setenv("ARM_CLIENT_SECRET", secret)
 		}
@@ -204,31 +327,33 @@ func VerifyEnvVariables(clusterName string, envType string) (err error) {
 	} else {
 		if tenant == "" {
 			log.Error(emoji.Sprintf(":confounded: A Service Principal Tenant ID environment variable was not found. Please specify the ARM_TENANT_ID environment variable, or use the --tenant argument when creating the environment."))
-			panic(fmt.Errorf("A Service Principal Tenant ID needs to be specified"))
+			return errors.New("A Service Principal Tenant ID needs to be specified")
 		} else {
 			os.Setenv("ARM_TENANT_ID", tenant)
 		}
 	}
+	setAuthEnvironment(AUTHSP)
 	return err
 }
 
 // GetEnvVariables function retrieves values from environment variables or sets them
 func GetEnvVariables(clusterName string, envType string) (err error) {
-	revisedClusterName := strings.Replace(clusterName, "-", "", -1)
+	storageName := resourceName("storage-account", clusterName)
+	storageRG := resourceName("storage-resource-group", clusterName)
+	container := resourceName("storage-container", clusterName)
 
-	if envType == COMMON || envType == KEYVAULT || envType == MULTIPLE {
+	// Environments keeping their terraform state in a storage account need one, and the common keyvault
+	if env, ok := GetEnvironment(envType); ok && env.Backend() {
 		if storageAccount == "" {
 			_, exists := os.LookupEnv("AZURE_STORAGE_ACCOUNT")
 
 			if exists {
 				storageAccount = os.Getenv("AZURE_STORAGE_ACCOUNT")
 			} else {
-				error := util.CreateStorageAccount(revisedClusterName, clusterName+"-storage-rg", "centralus")
-				resources = append(resources, clusterName+"-storage-rg")
-				if error != nil {
+				if error := createStorageAccount(storageName, storageRG); error != nil {
 					return error
 				}
-				storageAccount = revisedClusterName
+				storageAccount = storageName
 			}
 		}
 		if accessKey == "" {
@@ -237,7 +362,7 @@ func GetEnvVariables(clusterName string, envType string) (err error) {
 			if exists {
 				accessKey = os.Getenv("AZURE_STORAGE_KEY")
 			} else {
-				key, error := util.GetAccessKeys(revisedClusterName, clusterName+"-storage-rg")
+				key, error := util.GetAccessKeys(storageName, storageRG)
 				if error != nil {
 					return error
 				}
@@ -250,28 +375,76 @@ func GetEnvVariables(clusterName string, envType string) (err error) {
 			if exists {
 				containerName = os.Getenv("AZURE_CONTAINER")
 			} else {
-				if error := util.CreateStorageContainer(clusterName+"-container", revisedClusterName, accessKey); error != nil {
+				if error := createStorageContainer(container, storageName); error != nil {
 					return error
 				}
-				containerName = clusterName + "-container"
+				containerName = container
 			}
 		}
 		if keyvaultName == "" {
-			keyvaultName = clusterName + "-kv"
+			keyvaultName = resourceName("keyvault", clusterName)
 		}
 		if keyvaultRG == "" {
-			keyvaultRG = clusterName + "-kv-rg"
+			keyvaultRG = resourceName("keyvault-resource-group", clusterName)
 		}
 	}
 	if vnet == "" {
-		vnet = clusterName + "-vnet"
+		vnet = resourceName("vnet", clusterName)
 	}
 	if subnet == "" {
-		subnet = clusterName + "-subnet"
+		subnet = resourceName("subnet", clusterName)
 	}
 	if dnsPrefix == "" {
-		dnsPrefix = clusterName
+		dnsPrefix = resourceName("dns-prefix", clusterName)
+	}
+	return err
+}
+
+// createStorageAccount creates the storage account of the terraform state and its resource group. Only the
+// ones that did not exist yet are recorded in the creation journal, so that a rollback never deletes others.
+func createStorageAccount(name string, resourceGroup string) (err error) {
+	groupExists := false
+	if output, err := exec.Command("az", "group", "exists", "--name", resourceGroup).Output(); err == nil && strings.TrimSpace(string(output)) == "true" {
+		groupExists = true
+	}
+	resources = append(resources, resourceGroup)
+	if groupExists {
+		output, err := exec.Command("az", "storage", "account", "check-name", "--name", name, "--query", "nameAvailable", "--output", "tsv").CombinedOutput()
+		if err != nil {
+			log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, output))
+			return fmt.Errorf("Unable to check the storage account %s", name)
+		}
+		if strings.TrimSpace(string(output)) == "false" {
+			log.Info(emoji.Sprintf(":recycle: Storage account %s already exists, reusing it", name))
+			return err
+		}
 	}
+
+	groupEntry := -1
+	if !groupExists {
+		groupEntry = journal.Begin(RESOURCEGROUP, resourceGroup, "")
+	}
+	accountEntry := journal.Begin(STORAGEACCOUNT, name, resourceGroup)
+	if err := util.CreateStorageAccount(name, resourceGroup, "centralus", tags); err != nil {
+		return err
+	}
+	journal.Complete(groupEntry)
+	journal.Complete(accountEntry)
+	return err
+}
+
+// createStorageContainer creates the container of the terraform state, unless it already exists, and records
+// it in the creation journal
+func createStorageContainer(name string, storageAccount string) (err error) {
+	if output, err := exec.Command("az", "storage", "container", "exists", "--name", name, "--account-name", storageAccount, "--account-key", accessKey, "--query", "exists", "--output", "tsv").Output(); err == nil && strings.TrimSpace(string(output)) == "true" {
+		log.Info(emoji.Sprintf(":recycle: Storage container %s already exists, reusing it", name))
+		return err
+	}
+	entry := journal.Begin(STORAGECONTAINER, name, storageAccount)
+	if err := util.CreateStorageContainer(name, storageAccount, accessKey); err != nil {
+		return err
+	}
+	journal.Complete(entry)
 	return err
 }
 
@@ -341,6 +514,15 @@ func CopyFile(source string, dest string) (err error) {
 	return
 }
 
+// fileExists checks if a file exists
+func fileExists(filename string) bool {
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return false
+	}
+	return !info.IsDir()
+}
+
 // CopyDir is a function that copies an entire directory to another directory
 func CopyDir(source string, dest string) (err error) {
 
@@ -382,6 +564,107 @@ func CopyDir(source string, dest string) (err error) {
 	return
 }
 
+// copyMissingFiles copies the files of source that do not exist in dest yet
+func copyMissingFiles(source string, dest string) (err error) {
+	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		target := dest + strings.TrimPrefix(path, source)
+		if _, err := os.Stat(target); err == nil {
+			return nil
+		}
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode())
+		}
+		log.Info(emoji.Sprintf(":page_facing_up: Adding missing template file %s", target))
+		return CopyFile(path, target)
+	})
+}
+
+// loadBackendConfig reuses the storage settings of an existing environment instead of creating new ones
+func loadBackendConfig(envPath string) (err error) {
+	if !fileExists(envPath + "/bedrock-backend-config.tfvars") {
+		return err
+	}
+	config, err := ReadTfvarsFile(envPath + "/bedrock-backend-config.tfvars")
+	if err != nil {
+		return err
+	}
+	if storageAccount == "" {
+		storageAccount = strings.Trim(config["storage_account_name"], "\"")
+	}
+	if accessKey == "" {
+		accessKey = strings.Trim(config["access_key"], "\"")
+	}
+	if containerName == "" {
+		containerName = strings.Trim(config["container_name"], "\"")
+	}
+	return err
+}
+
+// writeTfvars writes the settings sorted by name and reports whether the file changed
+func writeTfvars(filename string, config map[string]string) (changed bool, err error) {
+	settings := make([]string, 0, len(config))
+	for setting := range config {
+		settings = append(settings, setting)
+	}
+	sort.Strings(settings)
+
+	var content strings.Builder
+	for _, setting := range settings {
+		content.WriteString(setting + " = " + config[setting] + "\n")
+	}
+
+	if existing, err := ioutil.ReadFile(filename); err == nil && string(existing) == content.String() {
+		return false, nil
+	}
+	return true, ioutil.WriteFile(filename, []byte(content.String()), 0644)
+}
+
+// maskSecret hides the value of sensitive settings in logs
+func maskSecret(setting string, value string) string {
+	if secretSetting(setting) {
+		return "\"********\""
+	}
+	return value
+}
+
+// reconcileTfvars merges newly generated settings into an existing configuration.
+// base holds the values generated the previous time: a setting whose generated value
+// did not change keeps its existing (possibly hand edited) value, while a setting whose
+// generated value changed because of a new flag is updated.
+func reconcileTfvars(existing map[string]string, base map[string]string, generated map[string]string) (merged map[string]string, diff []string) {
+	merged = make(map[string]string)
+	for setting, value := range existing {
+		merged[setting] = value
+	}
+
+	settings := make([]string, 0, len(generated))
+	for setting := range generated {
+		settings = append(settings, setting)
+	}
+	sort.Strings(settings)
+
+	for _, setting := range settings {
+		value := generated[setting]
+		current, exists := existing[setting]
+		switch {
+		case !exists:
+			merged[setting] = value
+			diff = append(diff, "+ "+setting+" = "+maskSecret(setting, value))
+		case current == value:
+		case base == nil:
+			// No record of what was generated before, so keep the existing value
+			diff = append(diff, "  "+setting+" = "+maskSecret(setting, current)+" (kept, flags would set "+maskSecret(setting, value)+")")
+		case bedrock.SnapshotValue(setting, base[setting]) != bedrock.SnapshotValue(setting, value):
+			merged[setting] = value
+			diff = append(diff, "~ "+setting+" = "+maskSecret(setting, current)+" -> "+maskSecret(setting, value))
+		}
+	}
+	return merged, diff
+}
+
 // Generate bedrock-config.tfvars (and bedrock-config.toml) and bedrock-backend-config.tfvars (if appropriate)
 func generateTfvars(envPath string, envType string, clusterName string, sshKey string) (err error) {
 
@@ -389,63 +672,126 @@ func generateTfvars(envPath string, envType string, clusterName string, sshKey s
 	backendConfigMap := make(map[string]string)
 	spConfigMap := make(map[string]string)
 
-	backendTfvarsFile, _ := os.Create(envPath + "/bedrock-backend-config.tfvars")
-	tfvarsFile, _ := os.Create(envPath + "/bedrock-config.tfvars")
-	spTomlFile, _ := os.Create(envPath + "/bedrock-sp-config.toml")
-	log.Info(emoji.Sprintf(":page_with_curl: Create Bedrock config file " + envPath + "/bedrock-config.tfvars"))
-
 	// Supported environments
-	if envType == SIMPLE {
-		azureSimpleTemplate(configMap, clusterName, sshKey)
-		servicePrincipalTemplate(spConfigMap)
+	env, ok := GetEnvironment(envType)
+	if !ok {
+		return fmt.Errorf("Unsupported environment %s", envType)
 	}
-	if envType == COMMON {
-		backendTemplate(backendConfigMap, clusterName, COMMON)
-		azureCommonInfraTemplate(configMap, clusterName, sshKey)
-		servicePrincipalTemplate(spConfigMap)
-	}
-	if envType == KEYVAULT {
-		backendTemplate(backendConfigMap, clusterName, KEYVAULT)
-		azureSingleKVTemplate(configMap, clusterName, sshKey)
-		servicePrincipalTemplate(spConfigMap)
-	}
-	if envType == MULTIPLE {
-		azureMultipleTemplate(configMap, clusterName, sshKey)
-		servicePrincipalTemplate(spConfigMap)
+	env.GenerateTfvars(configMap, clusterName, sshKey)
+	servicePrincipalTemplate(spConfigMap)
+	if env.Backend() {
+		backendTemplate(backendConfigMap, clusterName, envType)
 	}
 
-	// Iterate through backend config
-	for setting, value := range backendConfigMap {
-		if _, err := backendTfvarsFile.WriteString(setting + " = " + value + "\n"); err != nil {
+	// Without a service principal login, the AKS service principal can only come from --sp and --secret
+	if authMode != AUTHSP {
+		if err := clusterServicePrincipal(configMap, envPath); err != nil {
 			return err
 		}
 	}
-	backendTfvarsFile.Close()
 
-	// Iterate through normal config (terraform.tfvars)
-	for setting, value := range configMap {
-		if _, err := tfvarsFile.WriteString(setting + " = " + value + "\n"); err != nil {
-			return err
+	// Settings of template variants are left out when the template does not declare them, unless a flag
+	// asked for them: that flag could not be applied
+	optional := make([]string, 0, len(env.OptionalVariables()))
+	for variable := range env.OptionalVariables() {
+		optional = append(optional, variable)
+	}
+	sort.Strings(optional)
+	for _, variable := range optional {
+		if _, ok := configMap[variable]; !ok || templateAcceptsVariable(envPath, variable) {
+			continue
+		}
+		for _, flag := range env.OptionalVariables()[variable] {
+			if env.Command() != nil && env.Command().Flags().Changed(flag) {
+				log.Error(emoji.Sprintf(":no_entry_sign: The %s template of Bedrock %s does not declare %s, --%s cannot be applied", envType, BEDROCK, variable, flag))
+				return fmt.Errorf("--%s is not supported by the %s template", flag, envType)
+			}
 		}
+		log.Warn(emoji.Sprintf(":warning: The %s template does not declare %s, leaving it out of the Bedrock config file", envType, variable))
+		delete(configMap, variable)
+	}
+
+	// Catch missing settings before terraform does. A required setting the template does not declare
+	// means the template of this Bedrock version is not the one the environment type was written for.
+	templates, _ := filepath.Glob(envPath + "/*.tf")
+	for _, variable := range env.RequiredVariables() {
+		if value, ok := configMap[variable]; !ok || value == "\"\"" {
+			log.Error(emoji.Sprintf(":confounded: The %s environment needs a value for %s", envType, variable))
+			return fmt.Errorf("Missing value for %s", variable)
+		}
+		if len(templates) > 0 && !templateAcceptsVariable(envPath, variable) {
+			log.Error(emoji.Sprintf(":confounded: The %s template of Bedrock %s does not declare %s", envType, BEDROCK, variable))
+			return fmt.Errorf("The %s template does not declare %s", envType, variable)
+		}
+	}
+
+	// Only emit tags when the template has a variable for them, terraform rejects undeclared variables
+	if len(tags) > 0 && templateAcceptsVariable(envPath, "tags") {
+		configMap["tags"] = tagsTemplate(tags)
 	}
-	tfvarsFile.Close()
 
+	// Backend config and service principal settings are fully derived from flags and environment variables
+	if _, err := writeTfvars(envPath+"/bedrock-backend-config.tfvars", backendConfigMap); err != nil {
+		return err
+	}
 	// Generate the toml file will be used to extract environment variables via "viper"
-	for setting, value := range spConfigMap {
-		if _, err := spTomlFile.WriteString(setting + " = " + value + "\n"); err != nil {
+	if _, err := writeTfvars(envPath+"/bedrock-sp-config.toml", spConfigMap); err != nil {
+		return err
+	}
+
+	// Merge into an existing bedrock-config.tfvars, keeping manual edits. The generated values are
+	// kept alongside so that the next run can tell manual edits from changed flags.
+	tfvarsPath := envPath + "/bedrock-config.tfvars"
+	generatedPath := envPath + "/.bedrock-config.generated.tfvars"
+	if fileExists(tfvarsPath) {
+		existing, err := ReadTfvarsFile(tfvarsPath)
+		if err != nil {
+			return err
+		}
+		var base map[string]string
+		if fileExists(generatedPath) {
+			if base, err = ReadTfvarsFile(generatedPath); err != nil {
+				return err
+			}
+		}
+		merged, diff := reconcileTfvars(existing, base, configMap)
+		changed, err := writeTfvars(tfvarsPath, merged)
+		if err != nil {
 			return err
 		}
+		if changed {
+			log.Info(emoji.Sprintf(":pencil2: Updated Bedrock config file %s:", tfvarsPath))
+		} else {
+			log.Info(emoji.Sprintf(":ok_hand: Bedrock config file %s is up to date", tfvarsPath))
+		}
+		for _, line := range diff {
+			log.Info("    " + line)
+		}
+	} else {
+		log.Info(emoji.Sprintf(":page_with_curl: Create Bedrock config file %s", tfvarsPath))
+		if _, err := writeTfvars(tfvarsPath, configMap); err != nil {
+			return err
+		}
+	}
+	// Secrets are only kept as digests in the generated values
+	generated := make(map[string]string)
+	for setting, value := range configMap {
+		generated[setting] = bedrock.SnapshotValue(setting, value)
 	}
-	spTomlFile.Close()
+	_, err = writeTfvars(generatedPath, generated)
 
 	return err
 }
 
 func servicePrincipalTemplate(config map[string]string) {
+	config["auth"] = "\"" + authMode + "\""
 	config["subscription"] = "\"" + subscription + "\""
-	config["service_principal"] = "\"" + servicePrincipal + "\""
-	config["secret"] = "\"" + secret + "\""
 	config["tenant_id"] = "\"" + tenant + "\""
+	// Other authentication modes have no secret to persist
+	if authMode == AUTHSP {
+		config["service_principal"] = "\"" + servicePrincipal + "\""
+		config["secret"] = "\"" + secret + "\""
+	}
 }
 
 func backendTemplate(config map[string]string, clusterName string, env string) {
@@ -457,8 +803,8 @@ func backendTemplate(config map[string]string, clusterName string, env string) {
 }
 
 func azureSimpleTemplate(config map[string]string, clusterName string, sshKey string) {
-	config["resource_group_name"] = "\"" + clusterName + "-rg\""
-	config["cluster_name"] = "\"" + clusterName + "\""
+	config["resource_group_name"] = "\"" + resourceName("resource-group", clusterName) + "\""
+	config["cluster_name"] = "\"" + resourceName("cluster", clusterName) + "\""
 	config["dns_prefix"] = "\"" + dnsPrefix + "\""
 	config["service_principal_id"] = "\"" + servicePrincipal + "\""
 	config["service_principal_secret"] = "\"" + secret + "\""
@@ -483,8 +829,8 @@ func azureCommonInfraTemplate(config map[string]string, clusterName string, sshK
 }
 
 func azureSingleKVTemplate(config map[string]string, clusterName string, sshKey string) {
-	config["resource_group_name"] = "\"" + clusterName + "-rg\""
-	config["cluster_name"] = "\"" + clusterName + "\""
+	config["resource_group_name"] = "\"" + resourceName("resource-group", clusterName) + "\""
+	config["cluster_name"] = "\"" + resourceName("cluster", clusterName) + "\""
 	config["agent_vm_size"] = "\"" + vmSize + "\""
 	config["service_principal_id"] = "\"" + servicePrincipal + "\""
 	config["service_principal_secret"] = "\"" + secret + "\""
@@ -504,11 +850,32 @@ func azureSingleKVTemplate(config map[string]string, clusterName string, sshKey
 	config["subnet_prefixes"] = "\"" + subnetPrefix + "\""
 }
 
+func azureSingleKVCosmosTemplate(config map[string]string, clusterName string, sshKey string) {
+	azureSingleKVTemplate(config, clusterName, sshKey)
+	name := cosmosDBName
+	if name == "" {
+		name = resourceName("cosmos-account", clusterName)
+	}
+	config["cosmos_db_name"] = "\"" + name + "\""
+	config["mongo_db_name"] = "\"" + mongoDBName + "\""
+}
+
 func azureMultipleTemplate(config map[string]string, clusterName string, sshKey string) {
-	config["agent_vm_count"] = "\"" + "3" + "\""
-	config["agent_vm_size"] = "\"" + "Standard_D4s_v3" + "\""
-	config["cluster_name"] = "\"" + clusterName + "\""
-	config["dns_prefix"] = "\"" + clusterName + "\""
+	config["agent_vm_count"] = "\"" + vmCount + "\""
+	config["agent_vm_size"] = "\"" + vmSize + "\""
+	for _, cluster := range regionalClusters() {
+		config[cluster.Name+"_resource_group_location"] = "\"" + cluster.Region + "\""
+		// Templates without per-region node pools only take agent_vm_count and agent_vm_size
+		if cluster.VMCount != vmCount || cluster.VMSize != vmSize {
+			config[cluster.Name+"_agent_vm_count"] = "\"" + cluster.VMCount + "\""
+			config[cluster.Name+"_agent_vm_size"] = "\"" + cluster.VMSize + "\""
+		}
+	}
+	// The Traffic Manager resource group is created in the east region, see createRegionalResourceGroups
+	config["traffic_manager_resource_group_location"] = "\"" + regionEast + "\""
+	trafficManagerTemplate(config, regionalClusters())
+	config["cluster_name"] = "\"" + resourceName("cluster", clusterName) + "\""
+	config["dns_prefix"] = "\"" + dnsPrefix + "\""
 	config["keyvault_resource_group"] = "\"" + keyvaultRG + "\""
 	config["keyvault_name"] = "\"" + keyvaultName + "\""
 	config["service_principal_id"] = "\"" + servicePrincipal + "\""
@@ -516,18 +883,14 @@ func azureMultipleTemplate(config map[string]string, clusterName string, sshKey
 	config["ssh_public_key"] = "\"" + sshKey + "\""
 	config["gitops_ssh_url"] = "\"" + gitopsSSHUrl + "\""
 	config["gitops_ssh_key"] = "\"" + "deploy-key" + "\""
-	config["traffic_manager_profile_name"] = "\"" + clusterName + "-tm\""
-	config["traffic_manager_dns_name"] = "\"" + clusterName + "-tm\""
+	config["traffic_manager_profile_name"] = "\"" + resourceName("traffic-manager", clusterName) + "\""
+	config["traffic_manager_dns_name"] = "\"" + resourceName("traffic-manager", clusterName) + "\""
 	config["traffic_manager_resource_group_name"] = "\"" + resourceGroupTm + "\""
-	//config["traffic_manager_resource_group_location"] = "\"" + regionWest + "\""
 	config["west_resource_group_name"] = "\"" + resourceGroupWest + "\""
-	//config["west_resource_group_location"] = "\"" + "westus2" + "\""
 	config["gitops_west_path"] = "\"" + gitopsPathWest + "\""
 	config["east_resource_group_name"] = "\"" + resourceGroupEast + "\""
-	//config["east_resource_group_location"] = "\"" + regionEast + "\""
 	config["gitops_east_path"] = "\"" + gitopsPathEast + "\""
 	config["central_resource_group_name"] = "\"" + resourceGroupCentral + "\""
-	//config["central_resource_group_location"] = "\"" + regionCentral + "\""
 	config["gitops_central_path"] = "\"" + gitopsPathCentral + "\""
 	config["gitops_central_url_branch"] = "\"" + gitopsURLBranchCentral + "\""
 	config["gitops_east_url_branch"] = "\"" + gitopsURLBranchEast + "\""
@@ -538,141 +901,67 @@ func azureMultipleTemplate(config map[string]string, clusterName string, sshKey
 func addConfigTemplate(environment string, fullEnvironmentPath string, environmentPath string, clusterName string, sshKey string) (err error) {
 	sshKey = strings.TrimSuffix(sshKey, "\n")
 
-	if environment == SIMPLE {
-
-		if error := GetEnvVariables(clusterName, SIMPLE); error != nil {
-			return error
-		}
-		if error := generateTfvars(fullEnvironmentPath, SIMPLE, clusterName, sshKey); error != nil {
-			return error
-		}
-
-		log.Info(emoji.Sprintf(":raised_hands: Azure Simple cluster environment " + fullEnvironmentPath + " has been successfully created!"))
-		log.Info(emoji.Sprintf(":white_check_mark: To proceed, run 'bedrock simulate " + environmentPath + "'"))
-
+	env, ok := GetEnvironment(environment)
+	if !ok {
 		return err
 	}
-
-	if environment == COMMON {
-
-		if error := GetEnvVariables(clusterName, COMMON); error != nil {
-			return error
-		}
-		if error := generateTfvars(fullEnvironmentPath, COMMON, clusterName, sshKey); error != nil {
-			return error
-		}
-
-		commonInfraPath = environmentPath
-
-		log.Info(emoji.Sprintf(":raised_hands: Azure Common Infra environment " + fullEnvironmentPath + " has been successfully created!"))
-
-		return err
+	if error := env.Prepare(environmentPath, clusterName); error != nil {
+		return error
+	}
+	if error := GetEnvVariables(clusterName, environment); error != nil {
+		return error
+	}
+	if error := generateTfvars(fullEnvironmentPath, environment, clusterName, sshKey); error != nil {
+		return error
 	}
 
-	if environment == KEYVAULT {
-
-		// When common infra is a dependency but does not exist, create one
-		if commonInfraPath == "" {
-			log.Info(emoji.Sprintf(":two_men_holding_hands: Common Infra path is not set, creating one now..."))
-			_, _, error := Init(COMMON, clusterName)
-
-			if error != nil {
-				return error
-			}
-		} else {
-			log.Info(emoji.Sprintf(":two_men_holding_hands: Contents of Azure Common Infra are being copied..."))
-			if error := CopyDir(commonInfraPath, environmentPath); error != nil {
-				return error
-			}
-
-			if _, err := os.Stat(environmentPath + "/" + COMMON + "/" + ".terraform"); err == nil {
-				chmodCmd := exec.Command("chmod", "-R", "777", ".terraform")
-				chmodCmd.Dir = string(environmentPath) + "/" + COMMON
-				if output, err := chmodCmd.CombinedOutput(); err != nil {
-					log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, output))
-					return err
-				}
-			} else {
-				log.Info(emoji.Sprintf(":two_men_holding_hands: Terraform Init has not occurred for Azure Common Infra"))
-			}
+	log.Info(emoji.Sprint(":raised_hands: " + env.Title() + " environment " + fullEnvironmentPath + " has been successfully created!"))
+	// Environments that other environments depend on are usually created along with them
+	if len(environmentDependents(environment)) == 0 {
+		log.Info(emoji.Sprint(":white_check_mark: To proceed, run 'bedrock simulate " + environmentPath + "'"))
+	}
 
-			configOutput, error := ReadTfvarsFile(environmentPath + "/" + COMMON + "/" + "bedrock-config.tfvars")
-			if error != nil {
-				log.Error(emoji.Sprintf(":no_entry_sign: %s", err))
-				return err
-			}
-			subnet = configOutput["subnet_name"][1 : len(configOutput["subnet_name"])-1]
-			vnet = configOutput["vnet_name"][1 : len(configOutput["vnet_name"])-1]
-			keyvaultName = configOutput["keyvault_name"][1 : len(configOutput["keyvault_name"])-1]
-			keyvaultRG = configOutput["global_resource_group_name"][1 : len(configOutput["global_resource_group_name"])-1]
-		}
+	return err
+}
 
-		log.Info(emoji.Sprintf(":family: Common Infra path is set to %s", commonInfraPath))
+// prepareCommonInfra makes the environments created next in the same directory use this common infra
+func prepareCommonInfra(environmentPath string, clusterName string) (err error) {
+	commonInfraPath = environmentPath
+	return err
+}
 
-		if error := GetEnvVariables(clusterName, KEYVAULT); error != nil {
-			return error
-		}
-		if error := generateTfvars(fullEnvironmentPath, KEYVAULT, clusterName, sshKey); error != nil {
+// prepareKeyvaultEnvironment uses the given common infra, or creates one
+func prepareKeyvaultEnvironment(environmentPath string, clusterName string) (err error) {
+	// When common infra is a dependency but does not exist, create one
+	if commonInfraPath == "" {
+		log.Info(emoji.Sprintf(":two_men_holding_hands: Common Infra path is not set, creating one now..."))
+		if _, _, error := Init(COMMON, clusterName); error != nil {
 			return error
 		}
-
-		log.Info(emoji.Sprintf(":raised_hands: Azure Single Keyvault cluster environment " + fullEnvironmentPath + " has been successfully created!"))
-		log.Info(emoji.Sprintf(":white_check_mark: To proceed, run 'bedrock simulate " + environmentPath + "'"))
-
-		return err
+	} else if error := linkCommonInfra(environmentPath); error != nil {
+		return error
 	}
 
-	if environment == MULTIPLE {
-
-		if commonInfraPath != "" {
-			log.Info(emoji.Sprintf(":two_men_holding_hands: Contents of Azure Common Infra are being copied..."))
-			if error := CopyDir(commonInfraPath, environmentPath); error != nil {
-				return error
-			}
-
-			if _, err := os.Stat(environmentPath + "/" + COMMON + "/" + ".terraform"); err == nil {
-				chmodCmd := exec.Command("chmod", "-R", "777", ".terraform")
-				chmodCmd.Dir = string(environmentPath) + "/" + COMMON
-				if output, err := chmodCmd.CombinedOutput(); err != nil {
-					log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, output))
-					return err
-				}
-			} else {
-				log.Info(emoji.Sprintf(":two_men_holding_hands: Terraform Init has not occurred for Azure Common Infra"))
-			}
-
-			configOutput, error := ReadTfvarsFile(environmentPath + "/" + COMMON + "/" + "bedrock-config.tfvars")
-			if error != nil {
-				log.Error(emoji.Sprintf(":no_entry_sign: %s", err))
-				return err
-			}
-			subnet = configOutput["subnet_name"][1 : len(configOutput["subnet_name"])-1]
-			vnet = configOutput["vnet_name"][1 : len(configOutput["vnet_name"])-1]
-			keyvaultName = configOutput["keyvault_name"][1 : len(configOutput["keyvault_name"])-1]
-			keyvaultRG = configOutput["global_resource_group_name"][1 : len(configOutput["global_resource_group_name"])-1]
-		}
-
-		// When keyvault is not specified and common infra does not exist, create one
-		if keyvaultName == "" && keyvaultRG == "" && commonInfraPath == "" {
-			log.Info(emoji.Sprintf(":two_men_holding_hands: Common Infra path is not set, creating new Azure Common Infra environment"))
-			if _, _, error := Init(COMMON, clusterName); error != nil {
-				return error
-			}
-		}
-
-		log.Info(emoji.Sprintf(":family: Common Infra path is set to %s", commonInfraPath))
+	log.Info(emoji.Sprintf(":family: Common Infra path is set to %s", commonInfraPath))
+	return err
+}
 
-		if error := GetEnvVariables(clusterName, MULTIPLE); error != nil {
+// prepareMultipleEnvironment uses the given common infra or keyvault, or creates a common infra
+func prepareMultipleEnvironment(environmentPath string, clusterName string) (err error) {
+	if commonInfraPath != "" {
+		if error := linkCommonInfra(environmentPath); error != nil {
 			return error
 		}
-		if error := generateTfvars(fullEnvironmentPath, MULTIPLE, clusterName, sshKey); error != nil {
+	}
+
+	// When keyvault is not specified and common infra does not exist, create one
+	if keyvaultName == "" && keyvaultRG == "" && commonInfraPath == "" {
+		log.Info(emoji.Sprintf(":two_men_holding_hands: Common Infra path is not set, creating new Azure Common Infra environment"))
+		if _, _, error := Init(COMMON, clusterName); error != nil {
 			return error
 		}
-
-		log.Info(emoji.Sprintf(":raised_hands: Azure Multiple cluster environment " + fullEnvironmentPath + " has been successfully created!"))
-		log.Info(emoji.Sprintf(":white_check_mark: To proceed, run 'bedrock simulate " + environmentPath + "'"))
-
-		return err
 	}
+
+	log.Info(emoji.Sprintf(":family: Common Infra path is set to %s", commonInfraPath))
 	return err
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/kyokomi/emoji"
 	log "github.com/sirupsen/logrus"
+	"github.com/yradsmikham/bedrock-cli/pkg/bedrock"
 )
 
 func TestInit(t *testing.T) {
@@ -68,3 +69,33 @@ func unique(intSlice []string) []string {
 	}
 	return list
 }
+
+func TestReconcileTfvars(t *testing.T) {
+	base := map[string]string{"agent_vm_count": "\"3\"", "gitops_path": "\"prod\"", "vnet_name": "\"test-vnet\"", "secret": bedrock.SnapshotValue("secret", "\"secret\"")}
+	existing := map[string]string{"agent_vm_count": "\"5\"", "gitops_path": "\"prod\"", "vnet_name": "\"test-vnet\"", "custom": "\"kept\"", "secret": "\"edited\""}
+	generated := map[string]string{"agent_vm_count": "\"3\"", "gitops_path": "\"staging\"", "vnet_name": "\"test-vnet\"", "dns_prefix": "\"test\"", "secret": "\"secret\""}
+
+	merged, diff := reconcileTfvars(existing, base, generated)
+	expected := map[string]string{
+		"agent_vm_count": "\"5\"",       // edited by hand, flag unchanged
+		"gitops_path":    "\"staging\"", // changed through a flag
+		"vnet_name":      "\"test-vnet\"",
+		"dns_prefix":     "\"test\"", // new setting
+		"custom":         "\"kept\"",
+		"secret":         "\"edited\"", // only the digest of the generated secret is kept
+	}
+	for setting, value := range expected {
+		if merged[setting] != value {
+			t.Errorf("%s = %s, expected %s", setting, merged[setting], value)
+		}
+	}
+	if len(diff) != 2 {
+		t.Errorf("Expected 2 changes to be reported, got %v", diff)
+	}
+
+	// Without a record of the previous generation, existing values are never overwritten
+	merged, _ = reconcileTfvars(existing, nil, generated)
+	if merged["gitops_path"] != "\"prod\"" || merged["dns_prefix"] != "\"test\"" {
+		t.Errorf("Existing values were overwritten without a previous generation: %v", merged)
+	}
+}
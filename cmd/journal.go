@@ -0,0 +1,281 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	util "github.com/yradsmikham/bedrock-cli/util"
+)
+
+// Types of resources recorded in a creation journal
+const (
+	RESOURCEGROUP    = "resource-group"
+	STORAGEACCOUNT   = "storage-account"
+	STORAGECONTAINER = "storage-container"
+	DIRECTORY        = "directory"
+	DEPLOYKEY        = "deploy-key"
+	SERVICEPRINCIPAL = "service-principal"
+)
+
+// Status of a journal entry
+const (
+	PENDING = "pending" // Creation was started but did not complete
+	CREATED = "created"
+	DELETED = "deleted"
+)
+
+// Directory the creation journals are stored in, replaced in tests
+var journalDirectory = "bedrock/journals"
+
+var rollbackOnFailure bool
+
+// journal records the resources created by the running Init
+var journal *Journal
+
+// stdin is where confirmation prompts are read from
+var stdin io.Reader = os.Stdin
+
+// journalRunner runs the az commands used to clean up resources
+var journalRunner = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// JournalEntry is a single resource created by Init
+type JournalEntry struct {
+	Type   string    `json:"type"`
+	Name   string    `json:"name"`
+	Scope  string    `json:"scope,omitempty"` // Resource group, storage account or repository of the resource
+	Status string    `json:"status"`
+	Time   time.Time `json:"time"`
+}
+
+// Journal is the list of resources created for an environment, persisted after every change
+type Journal struct {
+	Path    string         `json:"-"`
+	Cluster string         `json:"cluster"`
+	Entries []JournalEntry `json:"entries"`
+}
+
+// NewJournal creates an empty journal for the given cluster
+func NewJournal(cluster string) *Journal {
+	path := journalDirectory + "/" + cluster + "-" + time.Now().Format("20060102150405") + ".json"
+	return &Journal{Path: path, Cluster: cluster}
+}
+
+// ReadJournal loads a journal from disk
+func ReadJournal(path string) (journal *Journal, err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	journal = &Journal{Path: path}
+	err = json.Unmarshal(content, journal)
+	return journal, err
+}
+
+// Save persists the journal to disk
+func (journal *Journal) Save() (err error) {
+	if err := os.MkdirAll(filepath.Dir(journal.Path), os.ModePerm); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(journal, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(journal.Path, content, 0600)
+}
+
+// The journal methods below do nothing on a nil journal, so helpers can be used outside of Init
+
+// Begin records that the creation of a resource is starting and returns its index
+func (journal *Journal) Begin(resourceType string, name string, scope string) int {
+	if journal == nil {
+		return -1
+	}
+	journal.Entries = append(journal.Entries, JournalEntry{Type: resourceType, Name: name, Scope: scope, Status: PENDING, Time: time.Now()})
+	if err := journal.Save(); err != nil {
+		log.Warn(emoji.Sprintf(":warning: Unable to save the creation journal %s: %s", journal.Path, err))
+	}
+	return len(journal.Entries) - 1
+}
+
+// Complete records that the creation of a resource succeeded
+func (journal *Journal) Complete(index int) {
+	if journal == nil || index < 0 {
+		return
+	}
+	journal.Entries[index].Status = CREATED
+	journal.Entries[index].Time = time.Now()
+	if err := journal.Save(); err != nil {
+		log.Warn(emoji.Sprintf(":warning: Unable to save the creation journal %s: %s", journal.Path, err))
+	}
+}
+
+// Record begins and completes an entry for a resource that has already been created
+func (journal *Journal) Record(resourceType string, name string, scope string) {
+	journal.Complete(journal.Begin(resourceType, name, scope))
+}
+
+// Pending returns the number of entries that have not been cleaned up yet
+func (journal *Journal) Pending() (count int) {
+	for _, entry := range journal.Entries {
+		if entry.Status != DELETED {
+			count++
+		}
+	}
+	return count
+}
+
+// createdEntry finds the journal recording that the CLI created a resource, which was not deleted since.
+// journal is nil when the resource was not created by the CLI.
+func createdEntry(resourceType string, name string) (journal *Journal, index int, err error) {
+	paths, err := filepath.Glob(journalDirectory + "/*.json")
+	if err != nil {
+		return nil, -1, err
+	}
+	for _, path := range paths {
+		journal, err := ReadJournal(path)
+		if err != nil {
+			return nil, -1, err
+		}
+		for i, entry := range journal.Entries {
+			if entry.Type == resourceType && entry.Name == name && entry.Status == CREATED {
+				return journal, i, nil
+			}
+		}
+	}
+	return nil, -1, err
+}
+
+// notFound reports whether the output of az says the resource does not exist
+func notFound(output string) bool {
+	return strings.Contains(output, "could not be found") || strings.Contains(output, "NotFound") || strings.Contains(output, "does not exist")
+}
+
+// deleteJournalEntry removes a single resource
+func deleteJournalEntry(entry JournalEntry) (err error) {
+	var output []byte
+	switch entry.Type {
+	case RESOURCEGROUP:
+		output, err = journalRunner("az", "group", "delete", "--name", entry.Name, "--yes")
+	case STORAGEACCOUNT:
+		output, err = journalRunner("az", "storage", "account", "delete", "--name", entry.Name, "--resource-group", entry.Scope, "--yes")
+	case STORAGECONTAINER:
+		output, err = journalRunner("az", "storage", "container", "delete", "--name", entry.Name, "--account-name", entry.Scope)
+	case SERVICEPRINCIPAL:
+		// Deleting the application deletes its service principal, but its role assignments would be left behind
+		if output, err = journalRunner("az", "role", "assignment", "delete", "--assignee", entry.Name, "--scope", entry.Scope); err != nil && !notFound(string(output)) {
+			break
+		}
+		output, err = journalRunner("az", "ad", "app", "delete", "--id", entry.Name)
+	case DIRECTORY:
+		return os.RemoveAll(entry.Name)
+	case DEPLOYKEY:
+		repo, err := util.ParseGitopsURL(entry.Scope)
+		if err != nil {
+			return err
+		}
+		provider, err := newDeployKeyProvider(repo)
+		if err != nil {
+			return err
+		}
+		return provider.RemoveDeployKey(repo, entry.Name)
+	default:
+		return errors.New("Unknown resource type " + entry.Type)
+	}
+	if err != nil && notFound(string(output)) {
+		return nil
+	}
+	if err != nil {
+		return errors.New(strings.TrimSpace(string(output)))
+	}
+	return err
+}
+
+// Cleanup deletes the resources of the journal in reverse order of creation.
+// Progress is saved after every deletion so an interrupted cleanup can be resumed.
+func Cleanup(journal *Journal) (err error) {
+	log.Info(emoji.Sprintf(":wastebasket: Cleaning up %d resource(s) recorded in %s", journal.Pending(), journal.Path))
+
+	failures := 0
+	for i := len(journal.Entries) - 1; i >= 0; i-- {
+		entry := journal.Entries[i]
+		if entry.Status == DELETED {
+			continue
+		}
+		log.Info(emoji.Sprintf(":boom: Deleting %s %s", entry.Type, entry.Name))
+		if err := deleteJournalEntry(entry); err != nil {
+			failures++
+			log.Error(emoji.Sprintf(":no_entry_sign: Unable to delete %s %s: %s", entry.Type, entry.Name, err))
+			continue
+		}
+		journal.Entries[i].Status = DELETED
+		journal.Entries[i].Time = time.Now()
+		if err := journal.Save(); err != nil {
+			return err
+		}
+	}
+
+	if failures > 0 {
+		log.Error(emoji.Sprintf(":no_entry_sign: To retry, run 'bedrock cleanup %s'", journal.Path))
+		return errors.New(strconv.Itoa(failures) + " resource(s) could not be deleted")
+	}
+	log.Info(emoji.Sprintf(":raised_hands: All resources recorded in %s have been deleted!", journal.Path))
+	return err
+}
+
+// confirm asks a yes/no question on stdin
+func confirm(question string) bool {
+	log.Info(emoji.Sprintf(":question: %s [y/N]", question))
+	answer, _ := bufio.NewReader(stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// rollback is called when Init fails and offers to clean up what was created so far
+func rollback(journal *Journal) {
+	if journal.Pending() == 0 {
+		os.Remove(journal.Path)
+		return
+	}
+	log.Error(emoji.Sprintf(":warning: Environment creation failed after creating %d resource(s), see %s", journal.Pending(), journal.Path))
+	if rollbackOnFailure || confirm("Would you like to delete the resources that were created?") {
+		if err := Cleanup(journal); err != nil {
+			log.Error(emoji.Sprintf(":no_entry_sign: %s", err))
+		}
+		return
+	}
+	log.Info(emoji.Sprintf(":bulb: To delete them later, run 'bedrock cleanup %s'", journal.Path))
+}
+
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup <journal>",
+	Short: "Delete the resources recorded in a creation journal",
+	Long:  `Delete the resources recorded in a creation journal in reverse order of creation. An interrupted cleanup can be resumed by running the command again.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if len(args) == 0 {
+			return errors.New("You need to specify the path of a journal in " + journalDirectory)
+		}
+		journal, err := ReadJournal(args[0])
+		if err != nil {
+			return err
+		}
+		return Cleanup(journal)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cleanupCmd)
+}
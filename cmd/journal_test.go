@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestJournalCleanup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var commands []string
+	failing := "az group delete --name test-west-rg --yes"
+	defaultRunner := journalRunner
+	journalRunner = func(name string, args ...string) ([]byte, error) {
+		command := name + " " + strings.Join(args, " ")
+		commands = append(commands, command)
+		if command == failing {
+			return []byte("ERROR: operation interrupted"), errors.New("exit status 1")
+		}
+		if strings.Contains(command, "test-tm-rg") {
+			return []byte("ERROR: Resource group 'test-tm-rg' could not be found."), errors.New("exit status 3")
+		}
+		return []byte{}, nil
+	}
+	defer func() { journalRunner = defaultRunner }()
+
+	environmentPath := dir + "/environments/test"
+	os.MkdirAll(environmentPath, os.ModePerm)
+
+	journal := &Journal{Path: dir + "/journal.json", Cluster: "test"}
+	journal.Record(RESOURCEGROUP, "test-west-rg", "")
+	journal.Record(RESOURCEGROUP, "test-central-rg", "")
+	journal.Begin(RESOURCEGROUP, "test-tm-rg", "")
+	journal.Record(DIRECTORY, environmentPath, "")
+
+	saved, err := ReadJournal(journal.Path)
+	if err != nil || len(saved.Entries) != 4 || saved.Entries[2].Status != PENDING {
+		t.Fatalf("Journal was not persisted: %+v %v", saved, err)
+	}
+
+	// The first cleanup is interrupted by a failing deletion
+	if err := Cleanup(saved); err == nil {
+		t.Error("Expected cleanup to report the failed deletion")
+	}
+	expected := []string{
+		"az group delete --name test-tm-rg --yes",
+		"az group delete --name test-central-rg --yes",
+		failing,
+	}
+	if strings.Join(commands, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Resources were not deleted in reverse order:\n%s", strings.Join(commands, "\n"))
+	}
+	if _, err := os.Stat(environmentPath); !os.IsNotExist(err) {
+		t.Error("Environment directory was not deleted")
+	}
+
+	// Resuming only retries what is left
+	resumed, _ := ReadJournal(journal.Path)
+	if resumed.Pending() != 1 {
+		t.Errorf("Expected 1 resource left to clean up, found %d", resumed.Pending())
+	}
+	commands = nil
+	failing = ""
+	if err := Cleanup(resumed); err != nil {
+		t.Error("Resumed cleanup failed:", err)
+	}
+	if len(commands) != 1 || commands[0] != "az group delete --name test-west-rg --yes" {
+		t.Errorf("Resumed cleanup ran unexpected commands: %v", commands)
+	}
+}
+
+func TestRollbackOnFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defaultRunner := journalRunner
+	journalRunner = func(name string, args ...string) ([]byte, error) {
+		return []byte{}, nil
+	}
+	defer func() { journalRunner = defaultRunner }()
+
+	// Declining the prompt keeps the journal for a later `bedrock cleanup`
+	stdin = strings.NewReader("n\n")
+	defer func() { stdin = os.Stdin }()
+	journal := &Journal{Path: dir + "/journal.json", Cluster: "test"}
+	journal.Record(RESOURCEGROUP, "test-rg", "")
+	rollback(journal)
+	if journal.Pending() != 1 {
+		t.Error("Resources were deleted although the prompt was declined")
+	}
+
+	rollbackOnFailure = true
+	defer func() { rollbackOnFailure = false }()
+	rollback(journal)
+	if journal.Pending() != 0 {
+		t.Error("Resources were not deleted with --rollback-on-failure")
+	}
+}
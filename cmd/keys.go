@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	util "github.com/yradsmikham/bedrock-cli/util"
+)
+
+var registerDeployKey bool
+
+// DeployKeyRegistration records where a deploy key was registered so that it can be rotated later
+type DeployKeyRegistration struct {
+	Provider   string `json:"provider"`
+	Repository string `json:"repository"`
+	Title      string `json:"title"`
+	ID         string `json:"id"`
+}
+
+// newDeployKeyProvider returns the API client for the host of the repository, using the token from the environment
+var newDeployKeyProvider = func(repo util.GitRepository) (provider util.DeployKeyProvider, err error) {
+	// Azure DevOps only has SSH keys of users, which would give Flux the access of the token owner
+	if repo.Provider == util.AZUREDEVOPS {
+		return nil, errors.New("Azure DevOps has no read-only deploy keys, add the public key to the repository manually instead of using --register-deploy-key")
+	}
+	tokens := map[string]string{
+		util.GITHUB: "GITHUB_TOKEN",
+		util.GITLAB: "GITLAB_TOKEN",
+	}
+	token := os.Getenv(tokens[repo.Provider])
+	if token == "" {
+		return nil, errors.New("Please set the " + tokens[repo.Provider] + " environment variable to register deploy keys with " + repo.Host)
+	}
+
+	if repo.Provider == util.GITLAB {
+		return util.GitLabProvider{Token: token}, err
+	}
+	return util.GitHubProvider{BaseURL: util.GitHubAPIURL(repo.Host), Token: token}, err
+}
+
+func readDeployKeyRegistration(keyPath string) (registration DeployKeyRegistration, err error) {
+	content, err := ioutil.ReadFile(keyPath + ".json")
+	if err != nil {
+		return registration, err
+	}
+	err = json.Unmarshal(content, &registration)
+	return registration, err
+}
+
+// RegisterDeployKey registers the public key at keyPath with the repository of the gitops url
+func RegisterDeployKey(keyPath string, title string, gitopsURL string, publicKey string) (registration DeployKeyRegistration, err error) {
+	repo, err := util.ParseGitopsURL(gitopsURL)
+	if err != nil {
+		log.Error(emoji.Sprintf(":no_entry_sign: %s", err))
+		return registration, err
+	}
+	provider, err := newDeployKeyProvider(repo)
+	if err != nil {
+		log.Error(emoji.Sprintf(":no_entry_sign: %s", err))
+		return registration, err
+	}
+
+	log.Info(emoji.Sprintf(":closed_lock_with_key: Registering deploy key %s with %s", title, repo.Path()))
+	id, err := provider.AddDeployKey(repo, title, publicKey)
+	if err != nil {
+		log.Error(emoji.Sprintf(":no_entry_sign: There was an error registering the deploy key: %s", err))
+		return registration, err
+	}
+
+	registration = DeployKeyRegistration{Provider: repo.Provider, Repository: repo.Path(), Title: title, ID: id}
+	content, err := json.MarshalIndent(registration, "", "  ")
+	if err != nil {
+		return registration, err
+	}
+	if err := ioutil.WriteFile(keyPath+".json", content, 0600); err != nil {
+		return registration, err
+	}
+
+	log.Info(emoji.Sprintf(":key: Deploy key has been added to %s", repo.Path()))
+	return registration, err
+}
+
+// RotateDeployKeys replaces the deploy key of every sub-environment of an environment and re-registers it
+func RotateDeployKeys(name string) (err error) {
+	files, err := ioutil.ReadDir(name)
+	if err != nil {
+		return err
+	}
+
+	rotated := 0
+	for _, f := range files {
+		envPath := name + "/" + f.Name()
+		keyPath := envPath + "/deploy-key"
+		if !f.IsDir() || !fileExists(keyPath) {
+			continue
+		}
+
+		config, err := ReadTfvarsFile(envPath + "/bedrock-config.tfvars")
+		if err != nil {
+			return err
+		}
+		gitopsURL := strings.Trim(config["gitops_ssh_url"], "\"")
+		previous, previousErr := readDeployKeyRegistration(keyPath)
+		title := previous.Title
+		if title == "" {
+			title = "bedrock-" + strings.Replace(strings.TrimPrefix(name, "bedrock/cluster/environments/"), "/", "-", -1) + "-" + f.Name()
+		}
+
+		log.Info(emoji.Sprintf(":arrows_counterclockwise: Rotating deploy key for %s", envPath))
+		options := sshKeyOptions()
+		options.Force = true
+		options.Passphrase = ""
+		// The new key is only swapped in once it is registered, the cluster keeps a working key otherwise
+		newKeyPath := keyPath + ".new"
+		publicKey, err := SSH(envPath, "deploy-key.new", options)
+		if err != nil {
+			return err
+		}
+		if _, err := RegisterDeployKey(newKeyPath, title, gitopsURL, publicKey); err != nil {
+			for _, suffix := range []string{"", ".pub", ".json"} {
+				os.Remove(newKeyPath + suffix)
+			}
+			return err
+		}
+		for _, suffix := range []string{"", ".pub", ".json"} {
+			if err := os.Rename(newKeyPath+suffix, keyPath+suffix); err != nil {
+				return err
+			}
+		}
+
+		// Only remove the previous key once the new one is registered so Flux never loses access
+		if previousErr == nil && previous.ID != "" {
+			repo, _ := util.ParseGitopsURL(gitopsURL)
+			provider, err := newDeployKeyProvider(repo)
+			if err == nil {
+				err = provider.RemoveDeployKey(repo, previous.ID)
+			}
+			if err != nil {
+				log.Warn(emoji.Sprintf(":warning: The previous deploy key %s could not be removed from %s: %s", previous.ID, previous.Repository, err))
+			}
+		}
+		rotated++
+	}
+
+	if rotated == 0 {
+		return errors.New("No deploy keys were found in " + name)
+	}
+
+	// The AKS ssh_public_key is left untouched since changing it would recreate the cluster nodes
+	log.Info(emoji.Sprintf(":white_check_mark: To roll out the new deploy key to Flux, run 'bedrock deploy %s'", name))
+	return err
+}
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage the deploy keys of an environment",
+	Long:  `Manage the deploy keys Flux uses to access the GitOps manifest repository`,
+}
+
+var keysRotateCmd = &cobra.Command{
+	Use:   "rotate <environment-name> [--type rsa|ed25519]",
+	Short: "Replace and re-register the deploy keys of an environment",
+	Long:  `Generate new deploy keys for every sub-environment, register them with the GitOps repository and remove the previously registered keys. Set GITHUB_TOKEN or GITLAB_TOKEN to authenticate with the git host. Azure DevOps has no read-only deploy keys, its keys are replaced manually.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if len(args) == 0 {
+			return errors.New("You need to specify the path of an environment")
+		}
+		return RotateDeployKeys(args[0])
+	},
+}
+
+func init() {
+	keysRotateCmd.Flags().StringVar(&sshKeyType, "type", RSA, "Type of SSH key to create (rsa or ed25519)")
+	keysCmd.AddCommand(keysRotateCmd)
+	rootCmd.AddCommand(keysCmd)
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	util "github.com/yradsmikham/bedrock-cli/util"
+)
+
+// fakeDeployKeyProvider keeps registered keys in memory
+type fakeDeployKeyProvider struct {
+	keys  map[string]string
+	count int
+	fail  bool
+}
+
+func (provider *fakeDeployKeyProvider) AddDeployKey(repo util.GitRepository, title string, publicKey string) (id string, err error) {
+	if provider.fail {
+		return "", errors.New("forbidden")
+	}
+	provider.count++
+	id = "key-" + strconv.Itoa(provider.count)
+	provider.keys[id] = publicKey
+	return id, err
+}
+
+func (provider *fakeDeployKeyProvider) RemoveDeployKey(repo util.GitRepository, id string) (err error) {
+	delete(provider.keys, id)
+	return err
+}
+
+func TestRotateDeployKeys(t *testing.T) {
+	provider := &fakeDeployKeyProvider{keys: map[string]string{}}
+	defaultProvider := newDeployKeyProvider
+	newDeployKeyProvider = func(repo util.GitRepository) (util.DeployKeyProvider, error) {
+		return provider, nil
+	}
+	defer func() { newDeployKeyProvider = defaultProvider }()
+
+	name, err := ioutil.TempDir("", "bedrock-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(name)
+
+	envPath := name + "/" + SIMPLE
+	os.MkdirAll(envPath, os.ModePerm)
+	ioutil.WriteFile(envPath+"/bedrock-config.tfvars", []byte("gitops_ssh_url = \"git@github.com:owner/manifests.git\"\n"), 0644)
+
+	original, err := SSH(envPath, "deploy-key", SSHKeyOptions{Type: ED25519})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RegisterDeployKey(envPath+"/deploy-key", "bedrock-test", "git@github.com:owner/manifests.git", original); err != nil {
+		t.Fatal(err)
+	}
+
+	sshKeyType = ED25519
+	defer func() { sshKeyType = RSA }()
+
+	// A failed registration keeps the key the cluster uses
+	provider.fail = true
+	if err := RotateDeployKeys(name); err == nil {
+		t.Fatal("Expected the failed registration to be reported")
+	}
+	if current, _ := ioutil.ReadFile(envPath + "/deploy-key.pub"); string(current) != original || fileExists(envPath+"/deploy-key.new") {
+		t.Error("Expected the deploy key to be kept when the new one cannot be registered")
+	}
+	provider.fail = false
+
+	if err := RotateDeployKeys(name); err != nil {
+		t.Fatal(err)
+	}
+
+	registration, err := readDeployKeyRegistration(envPath + "/deploy-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.keys) != 1 {
+		t.Errorf("Expected only the rotated key to be registered, found %d keys", len(provider.keys))
+	}
+	if provider.keys[registration.ID] == original {
+		t.Error("Deploy key was not replaced")
+	}
+	if registration.Title != "bedrock-test" || registration.Repository != "owner/manifests" {
+		t.Errorf("Unexpected registration %+v", registration)
+	}
+}
+
+func TestNewDeployKeyProvider(t *testing.T) {
+	var path, token string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, token = r.URL.Path, r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 42}`))
+	}))
+	defer server.Close()
+	os.Setenv("GITHUB_TOKEN", "enterprise-token")
+	defer os.Unsetenv("GITHUB_TOKEN")
+
+	// The keys of GitHub Enterprise repositories are registered with the API of their host
+	repo := util.GitRepository{Provider: util.GITHUB, Host: strings.TrimPrefix(server.URL, "https://"), Organization: "owner", Name: "manifests"}
+	provider, err := newDeployKeyProvider(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	github := provider.(util.GitHubProvider)
+	github.Client = server.Client()
+	if id, err := github.AddDeployKey(repo, "bedrock-test", "ssh-ed25519 AAAA test"); err != nil || id != "42" {
+		t.Fatalf("AddDeployKey returned %q, %v", id, err)
+	}
+	if path != "/api/v3/repos/owner/manifests/keys" || token != "token enterprise-token" {
+		t.Errorf("Expected the key to be registered with the enterprise API, got %s with %q", path, token)
+	}
+	if util.GitHubAPIURL("github.com") != "https://api.github.com" {
+		t.Error("Expected github.com keys to be registered with api.github.com")
+	}
+
+	// Azure DevOps keys would have the access of the token owner, they are not registered
+	if _, err := newDeployKeyProvider(util.GitRepository{Provider: util.AZUREDEVOPS, Host: "ssh.dev.azure.com"}); err == nil {
+		t.Error("Expected deploy keys not to be registered with Azure DevOps")
+	}
+}
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+)
+
+// environmentManifestFile records the environments an environment directory uses without copying them
+const environmentManifestFile = "bedrock-environment.json"
+
+// commonInfraSettings are the values dependents take from the common infra, with the variable of its template holding them
+var commonInfraSettings = map[string]string{
+	"vnet_name":                  "vnet_name",
+	"subnet_name":                "subnet_name",
+	"keyvault_name":              "keyvault_name",
+	"global_resource_group_name": "global_resource_group_name",
+}
+
+// EnvironmentManifest describes how an environment directory relates to environments in other directories
+type EnvironmentManifest struct {
+	Links []EnvironmentLink `json:"links"`
+}
+
+// EnvironmentLink is an environment of another directory, e.g. a shared azure-common-infra, referenced by its state
+type EnvironmentLink struct {
+	Environment    string            `json:"environment"` // Environment type
+	Path           string            `json:"path"`        // Environment directory it lives in
+	StorageAccount string            `json:"storage_account"`
+	Container      string            `json:"container"`
+	Key            string            `json:"key"`     // Key of its terraform state
+	Outputs        map[string]string `json:"outputs"` // Values read from it when the link was made
+}
+
+// readEnvironmentManifest reads the manifest of an environment directory, which is empty when there is none
+func readEnvironmentManifest(directory string) (manifest *EnvironmentManifest, err error) {
+	manifest = &EnvironmentManifest{}
+	if !fileExists(directory + "/" + environmentManifestFile) {
+		return manifest, err
+	}
+	content, err := ioutil.ReadFile(directory + "/" + environmentManifestFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, fmt.Errorf("Invalid %s in %s: %s", environmentManifestFile, directory, err)
+	}
+	return manifest, err
+}
+
+// writeEnvironmentManifest writes the manifest of an environment directory
+func writeEnvironmentManifest(directory string, manifest *EnvironmentManifest) (err error) {
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(directory+"/"+environmentManifestFile, append(content, '\n'), 0644)
+}
+
+// Link returns the link to an environment type, or nil
+func (manifest *EnvironmentManifest) Link(environment string) *EnvironmentLink {
+	for i := range manifest.Links {
+		if manifest.Links[i].Environment == environment {
+			return &manifest.Links[i]
+		}
+	}
+	return nil
+}
+
+// SetLink adds or replaces the link to an environment type
+func (manifest *EnvironmentManifest) SetLink(link EnvironmentLink) {
+	if existing := manifest.Link(link.Environment); existing != nil {
+		*existing = link
+		return
+	}
+	manifest.Links = append(manifest.Links, link)
+}
+
+// terraformOutputs reads the string outputs of an environment from its remote state with `terraform output -json`
+func terraformOutputs(directory string, environment EnvironmentType) (outputs map[string]string, err error) {
+	env, err := authEnvironment(filepath.Dir(directory), environment.Name())
+	if err != nil {
+		return nil, err
+	}
+	out := &prefixWriter{prefix: "[" + filepath.Base(filepath.Dir(directory)) + "/" + environment.Name() + "] "}
+	if !fileExists(directory + "/.terraform") {
+		if err := terraformRunner(directory, env, out, terraformInitArgs(environment)...); err != nil {
+			return nil, fmt.Errorf("terraform init: %s", err)
+		}
+	}
+	var show bytes.Buffer
+	if err := terraformRunner(directory, env, &show, "output", "-json"); err != nil {
+		return nil, fmt.Errorf("terraform output: %s", err)
+	}
+	var parsed map[string]struct {
+		Value interface{} `json:"value"`
+	}
+	if err := json.Unmarshal(show.Bytes(), &parsed); err != nil {
+		return nil, fmt.Errorf("Could not read the outputs of %s: %s", directory, err)
+	}
+	outputs = map[string]string{}
+	for name, output := range parsed {
+		if value, ok := output.Value.(string); ok {
+			outputs[name] = value
+		}
+	}
+	return outputs, err
+}
+
+// linkCommonInfra makes the environment use the common infra given with --common-infra-path where it is: its
+// keyvault and network come from its outputs, or its tfvars for the values the template does not output, and
+// the link is recorded in the environment manifest instead of copying the common infra
+func linkCommonInfra(environmentPath string) (err error) {
+	directory := strings.TrimSuffix(commonInfraPath, "/")
+	if filepath.Base(directory) != COMMON {
+		directory += "/" + COMMON
+	}
+	if !fileExists(directory+"/bedrock-config.tfvars") || !fileExists(directory+"/bedrock-backend-config.tfvars") {
+		return errors.New(directory + " is not an Azure Common Infra environment created by bedrock")
+	}
+	common, ok := GetEnvironment(COMMON)
+	if !ok {
+		return errors.New("The " + COMMON + " environment is not available")
+	}
+	log.Info(emoji.Sprintf(":link: Reading the outputs of Azure Common Infra %s...", directory))
+
+	outputs, err := terraformOutputs(directory, common)
+	if err != nil {
+		return err
+	}
+	config, err := ReadTfvarsFile(directory + "/bedrock-config.tfvars")
+	if err != nil {
+		return err
+	}
+	values := map[string]string{}
+	settings := make([]string, 0, len(commonInfraSettings))
+	for setting := range commonInfraSettings {
+		settings = append(settings, setting)
+	}
+	sort.Strings(settings)
+	for _, setting := range settings {
+		if value, ok := outputs[setting]; ok {
+			values[setting] = value
+			continue
+		}
+		// The common infra is not deployed yet, or its template does not output the value
+		variable := commonInfraSettings[setting]
+		value := strings.Trim(config[variable], "\"")
+		if value == "" {
+			return fmt.Errorf("Azure Common Infra %s has no %s output and no %s setting", directory, setting, variable)
+		}
+		log.Warn(emoji.Sprintf(":warning: Azure Common Infra %s has no %s output, using its %s setting %s, which may differ from what is deployed", directory, setting, variable, value))
+		values[setting] = value
+	}
+	subnet = values["subnet_name"]
+	vnet = values["vnet_name"]
+	keyvaultName = values["keyvault_name"]
+	keyvaultRG = values["global_resource_group_name"]
+
+	// Nothing to record when the common infra was created in the same directory
+	linkedPath := filepath.Dir(directory)
+	if filepath.Clean(linkedPath) == filepath.Clean(environmentPath) {
+		return err
+	}
+	backend, err := ReadTfvarsFile(directory + "/bedrock-backend-config.tfvars")
+	if err != nil {
+		return err
+	}
+	manifest, err := readEnvironmentManifest(environmentPath)
+	if err != nil {
+		return err
+	}
+	manifest.SetLink(EnvironmentLink{
+		Environment:    COMMON,
+		Path:           linkedPath,
+		StorageAccount: strings.Trim(backend["storage_account_name"], "\""),
+		Container:      strings.Trim(backend["container_name"], "\""),
+		Key:            strings.Trim(backend["key"], "\""),
+		Outputs:        values,
+	})
+	if err := writeEnvironmentManifest(environmentPath, manifest); err != nil {
+		return err
+	}
+	log.Info(emoji.Sprintf(":link: %s uses the Azure Common Infra of %s, see %s", environmentPath, linkedPath, environmentManifestFile))
+	return err
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLinkCommonInfra(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(registry []EnvironmentType) { environmentRegistry = registry }(environmentRegistry)
+	environmentRegistry = nil
+	RegisterEnvironment(&templateEnvironment{name: COMMON, backend: true})
+	RegisterEnvironment(&templateEnvironment{name: "test-kv", dependencies: []string{COMMON}})
+	deployFixture(t, dir, map[string][]string{"shared": {COMMON}, "app": {"test-kv"}})
+	common := dir + "/shared/" + COMMON
+	ioutil.WriteFile(common+"/bedrock-config.tfvars", []byte("global_resource_group_name = \"shared-rg\"\nkeyvault_name = \"tfvars-kv\"\nsubnet_name = \"shared-subnet\"\nvnet_name = \"shared-vnet\"\n"), 0644)
+	ioutil.WriteFile(common+"/bedrock-backend-config.tfvars", []byte("access_key = \"key\"\ncontainer_name = \"tfstate\"\nkey = \"tfstate-azure-common-infra-shared\"\nstorage_account_name = \"state\"\n"), 0644)
+
+	var calls []string
+	defer func(runner func(string, []string, io.Writer, ...string) error) { terraformRunner = runner }(terraformRunner)
+	terraformRunner = func(directory string, env []string, out io.Writer, args ...string) error {
+		calls = append(calls, strings.TrimPrefix(directory, dir+"/")+" "+args[0])
+		if args[0] == "output" {
+			out.Write([]byte(`{"keyvault_name": {"value": "output-kv", "type": "string"}, "vnet_id": {"value": "/subscriptions/sub/vnet", "type": "string"}}`))
+		}
+		return nil
+	}
+
+	defer func() { commonInfraPath, subnet, vnet, keyvaultName, keyvaultRG = "", "", "", "", "" }()
+	commonInfraPath = dir + "/shared"
+	if err := linkCommonInfra(dir + "/app"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Outputs of the remote state come first, the tfvars fill in what the template does not output
+	if keyvaultName != "output-kv" || vnet != "shared-vnet" || subnet != "shared-subnet" || keyvaultRG != "shared-rg" {
+		t.Errorf("Unexpected common infra settings %s %s %s %s", keyvaultName, vnet, subnet, keyvaultRG)
+	}
+	if strings.Join(calls, ", ") != "shared/azure-common-infra init, shared/azure-common-infra output" {
+		t.Errorf("Expected the outputs to be read from the backend, got %v", calls)
+	}
+	if _, err := os.Stat(dir + "/app/" + COMMON); !os.IsNotExist(err) {
+		t.Error("Expected the common infra not to be copied")
+	}
+	manifest, err := readEnvironmentManifest(dir + "/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := manifest.Link(COMMON)
+	if link == nil || link.Path != dir+"/shared" || link.Key != "tfstate-azure-common-infra-shared" || link.StorageAccount != "state" || link.Outputs["keyvault_name"] != "output-kv" {
+		t.Fatalf("Unexpected link %+v", link)
+	}
+
+	// The linked common infra is deployed first when it is deployed too, and is not deployed again otherwise
+	tasks, err := deploymentGraph([]string{dir + "/app", dir + "/shared"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 || tasks[0].ID != "app/test-kv" || strings.Join(tasks[0].DependsOn, ",") != "shared/"+COMMON {
+		t.Errorf("Expected app to depend on the linked common infra, got %+v", tasks[0])
+	}
+	if tasks, _ := deploymentGraph([]string{dir + "/app"}); len(tasks) != 1 || len(tasks[0].DependsOn) != 0 {
+		t.Errorf("Expected only app to be deployed, got %d tasks", len(tasks))
+	}
+
+	commonInfraPath = dir + "/app"
+	if err := linkCommonInfra(dir + "/other"); err == nil {
+		t.Error("Expected a directory without common infra to be rejected")
+	}
+
+	// A value neither output nor set in the tfvars cannot be linked
+	commonInfraPath = common
+	ioutil.WriteFile(common+"/bedrock-config.tfvars", []byte("global_resource_group_name = \"shared-rg\"\nsubnet_name = \"shared-subnet\"\n"), 0644)
+	if err := linkCommonInfra(dir + "/other"); err == nil || !strings.Contains(err.Error(), "no vnet_name output and no vnet_name setting") {
+		t.Errorf("Expected the missing vnet_name to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+)
+
+var namePrefix string
+var nameSuffix string
+var nameEnvironment string
+var namePatternFlags []string
+var checkNames bool
+
+// Types of Azure resources with their own naming rules, in addition to the ones in journal.go
+const (
+	VAULT          = "keyvault"
+	VNET           = "vnet"
+	SUBNET         = "subnet"
+	CLUSTER        = "cluster"
+	DNSPREFIX      = "dns-prefix"
+	TRAFFICMANAGER = "traffic-manager"
+	COSMOSDB       = "cosmosdb"
+)
+
+// nameRule describes the names Azure accepts for a type of resource
+type nameRule struct {
+	min     int
+	max     int
+	invalid *regexp.Regexp // Characters to strip from generated names
+	valid   *regexp.Regexp // Complete name validation
+	lower   bool
+	hyphen  bool // Whether the hash appended to truncated names may be separated by a hyphen
+}
+
+var nameRules = map[string]nameRule{
+	RESOURCEGROUP:    {1, 90, regexp.MustCompile(`[^\w\-.()]`), regexp.MustCompile(`^[\w\-.()]*[\w\-()]$`), false, true},
+	STORAGEACCOUNT:   {3, 24, regexp.MustCompile(`[^a-z0-9]`), regexp.MustCompile(`^[a-z0-9]+$`), true, false},
+	STORAGECONTAINER: {3, 63, regexp.MustCompile(`[^a-z0-9-]`), regexp.MustCompile(`^[a-z0-9](-?[a-z0-9])*$`), true, true},
+	VAULT:            {3, 24, regexp.MustCompile(`[^a-zA-Z0-9-]`), regexp.MustCompile(`^[a-zA-Z](-?[a-zA-Z0-9])*$`), false, true},
+	VNET:             {2, 64, regexp.MustCompile(`[^\w\-.]`), regexp.MustCompile(`^[a-zA-Z0-9][\w\-.]*[\w]$`), false, true},
+	SUBNET:           {1, 80, regexp.MustCompile(`[^\w\-.]`), regexp.MustCompile(`^[a-zA-Z0-9]([\w\-.]*[\w])?$`), false, true},
+	CLUSTER:          {1, 63, regexp.MustCompile(`[^\w-]`), regexp.MustCompile(`^[a-zA-Z0-9]([\w-]*[a-zA-Z0-9])?$`), false, true},
+	DNSPREFIX:        {1, 54, regexp.MustCompile(`[^a-zA-Z0-9-]`), regexp.MustCompile(`^[a-zA-Z]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`), false, true},
+	SERVICEPRINCIPAL: {1, 120, regexp.MustCompile(`[^\w\-.]`), regexp.MustCompile(`^[\w\-.]+$`), false, true},
+	TRAFFICMANAGER:   {1, 63, regexp.MustCompile(`[^a-z0-9-]`), regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`), true, true},
+	COSMOSDB:         {3, 44, regexp.MustCompile(`[^a-z0-9-]`), regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`), true, true},
+}
+
+// nameRole is a resource the CLI names, along with its type and default pattern
+type nameRole struct {
+	resourceType string
+	pattern      string
+}
+
+// Names generated by the CLI. Patterns support the {prefix}, {name}, {environment} and {suffix}
+// tokens, and {base} which joins the non empty ones with hyphens.
+var nameRoles = map[string]nameRole{
+	"resource-group":          {RESOURCEGROUP, "{base}-rg"},
+	"keyvault-resource-group": {RESOURCEGROUP, "{base}-kv-rg"},
+	"storage-resource-group":  {RESOURCEGROUP, "{base}-storage-rg"},
+	"west-resource-group":     {RESOURCEGROUP, "{base}-west-rg"},
+	"central-resource-group":  {RESOURCEGROUP, "{base}-central-rg"},
+	"east-resource-group":     {RESOURCEGROUP, "{base}-east-rg"},
+	"tm-resource-group":       {RESOURCEGROUP, "{base}-tm-rg"},
+	"storage-account":         {STORAGEACCOUNT, "{base}"},
+	"storage-container":       {STORAGECONTAINER, "{base}-container"},
+	"keyvault":                {VAULT, "{base}-kv"},
+	"vnet":                    {VNET, "{base}-vnet"},
+	"subnet":                  {SUBNET, "{base}-subnet"},
+	"cluster":                 {CLUSTER, "{base}"},
+	"dns-prefix":              {DNSPREFIX, "{base}"},
+	"traffic-manager":         {TRAFFICMANAGER, "{base}-tm"},
+	"service-principal":       {SERVICEPRINCIPAL, "bedrock-{base}"},
+	"cosmos-account":          {COSMOSDB, "{base}-cosmos"},
+}
+
+// Name roles used by each environment
+var environmentNameRoles = map[string][]string{
+	SIMPLE:   {"resource-group", "vnet", "subnet", "cluster", "dns-prefix"},
+	COMMON:   {"keyvault-resource-group", "storage-resource-group", "storage-account", "storage-container", "keyvault", "vnet", "subnet"},
+	KEYVAULT: {"resource-group", "storage-resource-group", "storage-account", "storage-container", "keyvault", "vnet", "subnet", "cluster", "dns-prefix"},
+	COSMOS:   {"resource-group", "storage-resource-group", "storage-account", "storage-container", "keyvault", "vnet", "subnet", "cluster", "dns-prefix", "cosmos-account"},
+	MULTIPLE: {"west-resource-group", "central-resource-group", "east-resource-group", "tm-resource-group", "storage-resource-group", "storage-account", "storage-container", "cluster", "dns-prefix", "traffic-manager"},
+}
+
+// NamingConvention derives the names of Azure resources from a cluster name
+type NamingConvention struct {
+	Prefix      string
+	Suffix      string
+	Environment string
+	Patterns    map[string]string // Overrides the default pattern of a name role
+}
+
+// naming is the convention set through the command line flags
+var naming NamingConvention
+
+// newNamingConvention builds the naming convention from the command line flags
+func newNamingConvention() (convention NamingConvention, err error) {
+	convention = NamingConvention{Prefix: namePrefix, Suffix: nameSuffix, Environment: nameEnvironment, Patterns: map[string]string{}}
+	for _, pair := range namePatternFlags {
+		equal := strings.Index(pair, "=")
+		if equal <= 0 {
+			return convention, errors.New("Invalid name pattern '" + pair + "', patterns must be specified as role=pattern")
+		}
+		role := pair[:equal]
+		if _, ok := nameRoles[role]; !ok {
+			return convention, errors.New("Unknown name role '" + role + "', use one of " + strings.Join(nameRoleList(), ", "))
+		}
+		convention.Patterns[role] = pair[equal+1:]
+	}
+	return convention, err
+}
+
+func nameRoleList() (roles []string) {
+	for role := range nameRoles {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// shortHash returns a short, stable hash of a name used to keep truncated names unique
+func shortHash(name string) string {
+	sum := sha1.Sum([]byte(name))
+	return hex.EncodeToString(sum[:])[:6]
+}
+
+// Name returns the name of a resource for the given cluster, truncated and validated for its resource type
+func (convention NamingConvention) Name(role string, clusterName string) (name string, err error) {
+	definition, ok := nameRoles[role]
+	if !ok {
+		return "", errors.New("Unknown name role '" + role + "'")
+	}
+	pattern := definition.pattern
+	if custom, ok := convention.Patterns[role]; ok {
+		pattern = custom
+	}
+
+	var parts []string
+	for _, part := range []string{convention.Prefix, clusterName, convention.Environment, convention.Suffix} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	name = strings.NewReplacer(
+		"{base}", strings.Join(parts, "-"),
+		"{prefix}", convention.Prefix,
+		"{name}", clusterName,
+		"{environment}", convention.Environment,
+		"{suffix}", convention.Suffix,
+	).Replace(pattern)
+
+	rule := nameRules[definition.resourceType]
+	if rule.lower {
+		name = strings.ToLower(name)
+	}
+	name = rule.invalid.ReplaceAllString(name, "")
+	for strings.Contains(name, "--") {
+		name = strings.Replace(name, "--", "-", -1)
+	}
+
+	// Names that are too long are truncated, with a hash of the full name to avoid collisions
+	if len(name) > rule.max {
+		hash := shortHash(name)
+		if rule.hyphen {
+			hash = "-" + hash
+		}
+		name = strings.TrimRight(name[:rule.max-len(hash)], "-.") + hash
+	}
+
+	if len(name) < rule.min || !rule.valid.MatchString(name) {
+		return name, errors.New("'" + name + "' is not a valid " + definition.resourceType + " name (" + strconv.Itoa(rule.min) + "-" + strconv.Itoa(rule.max) + " characters, matching " + rule.valid.String() + ")")
+	}
+	return name, err
+}
+
+// resourceName returns the name of a resource using the naming convention of the command line.
+// Names are validated by validateNames before any resource is created.
+func resourceName(role string, clusterName string) string {
+	name, _ := naming.Name(role, clusterName)
+	return name
+}
+
+// validateNames checks every name an environment will use before anything is created
+func validateNames(environment string, clusterName string) (err error) {
+	var errs []string
+	for _, role := range environmentNameRoles[environment] {
+		name, err := naming.Name(role, clusterName)
+		if err != nil {
+			errs = append(errs, role+": "+err.Error())
+			continue
+		}
+		log.Debug(emoji.Sprintf(":label: %s: %s", role, name))
+	}
+	if len(errs) > 0 {
+		for _, e := range errs {
+			log.Error(emoji.Sprintf(":no_entry_sign: %s", e))
+		}
+		return errors.New("Invalid resource names for cluster '" + clusterName + "', adjust --cluster-name or the naming flags")
+	}
+	return err
+}
+
+// namingRunner runs the az commands used to check name availability
+var namingRunner = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// nameAvailable asks Azure whether a globally unique name is still available
+func nameAvailable(resourceType string, name string) (available bool, reason string, err error) {
+	var output []byte
+	switch resourceType {
+	case STORAGEACCOUNT:
+		output, err = namingRunner("az", "storage", "account", "check-name", "--name", name, "--output", "json")
+	case VAULT:
+		body := `{"name": "` + name + `", "type": "Microsoft.KeyVault/vaults"}`
+		output, err = namingRunner("az", "rest", "--method", "post", "--url", "https://management.azure.com/subscriptions/"+subscription+"/providers/Microsoft.KeyVault/checkNameAvailability?api-version=2019-09-01", "--body", body, "--output", "json")
+	case TRAFFICMANAGER:
+		output, err = namingRunner("az", "network", "traffic-manager", "profile", "check-dns", "--name", name, "--output", "json")
+	case COSMOSDB:
+		// Only tells whether the name exists, as true or false
+		output, err = namingRunner("az", "cosmosdb", "check-name-exists", "--name", name, "--output", "json")
+		if err != nil {
+			return false, "", errors.New(strings.TrimSpace(string(output)))
+		}
+		if strings.TrimSpace(string(output)) == "true" {
+			return false, "AlreadyExists", err
+		}
+		return true, "", err
+	default:
+		return true, "", err
+	}
+	if err != nil {
+		return false, "", errors.New(strings.TrimSpace(string(output)))
+	}
+
+	var result struct {
+		NameAvailable bool   `json:"nameAvailable"`
+		Reason        string `json:"reason"`
+		Message       string `json:"message"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return false, "", err
+	}
+	if result.Message != "" {
+		return result.NameAvailable, result.Message, err
+	}
+	return result.NameAvailable, result.Reason, err
+}
+
+// checkNameAvailability verifies that the globally unique names of an environment are not taken
+func checkNameAvailability(environment string, clusterName string) (err error) {
+	log.Info(emoji.Sprintf(":mag_right: Checking availability of globally unique resource names..."))
+	var errs []string
+	for _, role := range environmentNameRoles[environment] {
+		resourceType := nameRoles[role].resourceType
+		// Names provided through flags or environment variables refer to existing resources,
+		// and only the common infrastructure creates the keyvault
+		if resourceType == STORAGEACCOUNT && (storageAccount != "" || os.Getenv("AZURE_STORAGE_ACCOUNT") != "") {
+			continue
+		}
+		if resourceType == VAULT && (keyvaultName != "" || environment != COMMON) {
+			continue
+		}
+		if resourceType == COSMOSDB && cosmosDBName != "" {
+			continue
+		}
+		name := resourceName(role, clusterName)
+		available, reason, err := nameAvailable(resourceType, name)
+		if err != nil {
+			log.Warn(emoji.Sprintf(":warning: Unable to check the availability of %s: %s", name, err))
+			continue
+		}
+		if !available {
+			errs = append(errs, name+" ("+reason+")")
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New("The following names are already taken: " + strings.Join(errs, ", "))
+	}
+	return err
+}
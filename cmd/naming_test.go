@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefaultNames(t *testing.T) {
+	// Without a prefix or suffix the names are the same as before the naming convention existed
+	convention := NamingConvention{}
+	expected := map[string]string{
+		"resource-group":         "my-cluster-rg",
+		"storage-resource-group": "my-cluster-storage-rg",
+		"storage-account":        "mycluster",
+		"storage-container":      "my-cluster-container",
+		"keyvault":               "my-cluster-kv",
+		"vnet":                   "my-cluster-vnet",
+		"cluster":                "my-cluster",
+		"traffic-manager":        "my-cluster-tm",
+	}
+	for role, name := range expected {
+		generated, err := convention.Name(role, "my-cluster")
+		if err != nil {
+			t.Error(err)
+		}
+		if generated != name {
+			t.Errorf("Expected %s name %s, got %s", role, name, generated)
+		}
+	}
+}
+
+func TestNamePatterns(t *testing.T) {
+	namePrefix, nameEnvironment, namePatternFlags = "contoso", "dev", []string{"keyvault={prefix}{name}vault"}
+	defer func() { namePrefix, nameEnvironment, namePatternFlags = "", "", nil }()
+
+	convention, err := newNamingConvention()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := convention.Name("resource-group", "app"); name != "contoso-app-dev-rg" {
+		t.Errorf("Unexpected resource group name %s", name)
+	}
+	if name, _ := convention.Name("storage-account", "App"); name != "contosoappdev" {
+		t.Errorf("Unexpected storage account name %s", name)
+	}
+	if name, _ := convention.Name("keyvault", "app"); name != "contosoappvault" {
+		t.Errorf("Unexpected keyvault name %s", name)
+	}
+
+	namePatternFlags = []string{"database=x"}
+	if _, err := newNamingConvention(); err == nil {
+		t.Error("An unknown name role should be rejected")
+	}
+}
+
+func TestNameTruncation(t *testing.T) {
+	convention := NamingConvention{}
+	long := "a-very-long-cluster-name-for-production"
+
+	storage, err := convention.Name("storage-account", long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(storage) != 24 || !strings.HasPrefix(storage, "averylongclusterna") {
+		t.Errorf("Unexpected storage account name %s", storage)
+	}
+	keyvault, err := convention.Name("keyvault", long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keyvault) > 24 {
+		t.Errorf("Keyvault name %s is longer than 24 characters", keyvault)
+	}
+
+	// Truncated names keep a hash of the full name so similar names do not collide
+	other, _ := convention.Name("storage-account", long+"-2")
+	if other == storage {
+		t.Errorf("Truncated names collide: %s", other)
+	}
+
+	// Names that can not be fixed are reported
+	if _, err := convention.Name("keyvault", "1"); err == nil {
+		t.Error("Keyvault names must start with a letter")
+	}
+	if err := func() error {
+		naming = convention
+		defer func() { naming = NamingConvention{} }()
+		return validateNames(KEYVAULT, "1")
+	}(); err == nil {
+		t.Error("Invalid names should fail the validation")
+	}
+}
+
+func TestCheckNameAvailability(t *testing.T) {
+	defer func(runner func(string, ...string) ([]byte, error)) { namingRunner = runner }(namingRunner)
+	var checked []string
+	namingRunner = func(name string, args ...string) ([]byte, error) {
+		checked = append(checked, strings.Join(args[:3], " "))
+		if args[0] == "storage" {
+			return []byte(`{"nameAvailable": false, "reason": "AlreadyExists", "message": "The storage account named mycluster is already taken."}`), nil
+		}
+		return []byte(`{"nameAvailable": true}`), nil
+	}
+
+	err := checkNameAvailability(COMMON, "my-cluster")
+	if err == nil || !strings.Contains(err.Error(), "mycluster") {
+		t.Errorf("Expected the storage account to be reported as taken, got %v", err)
+	}
+	if len(checked) != 2 {
+		t.Errorf("Expected the storage account and keyvault names to be checked, got %v", checked)
+	}
+
+	checked = nil
+	if err := checkNameAvailability(SIMPLE, "my-cluster"); err != nil || len(checked) != 0 {
+		t.Errorf("The simple environment has no globally unique names, got %v %v", err, checked)
+	}
+}
@@ -0,0 +1,322 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var policyDir string
+
+// Levels of a policy rule
+const (
+	POLICYWARN = "warn" // Reported, the environment is still deployed
+	POLICYDENY = "deny" // The environment is not deployed
+)
+
+// TFVARS is the resource name policy rules use to check bedrock-config.tfvars
+const TFVARS = "tfvars"
+
+// PolicyRule is a check of the planned resources or tfvars of an environment, read from the policy directory
+type PolicyRule struct {
+	Name      string   `mapstructure:"name"`
+	Level     string   `mapstructure:"level"`     // warn or deny (default)
+	Message   string   `mapstructure:"message"`   // Explains the rule in the report
+	Resource  string   `mapstructure:"resource"`  // Resource type, e.g. azurerm_key_vault, azurerm_* or *, or tfvars
+	Attribute string   `mapstructure:"attribute"` // e.g. location, tags.owner or agent_pool_profile.*.vm_size
+	Required  bool     `mapstructure:"required"`  // The attribute needs a value
+	In        []string `mapstructure:"in"`
+	NotIn     []string `mapstructure:"not_in"`
+	Equals    string   `mapstructure:"equals"`
+	Matches   string   `mapstructure:"matches"` // Regular expression
+	Max       *float64 `mapstructure:"max"`
+	Min       *float64 `mapstructure:"min"`
+	MaxCores  int      `mapstructure:"max_cores"` // Largest number of vCPUs of a VM size
+
+	matches *regexp.Regexp
+}
+
+// PolicyViolation is a resource or tfvars setting that does not follow a rule
+type PolicyViolation struct {
+	Rule        string
+	Level       string
+	Environment string
+	Resource    string
+	Attribute   string
+	Value       string
+	Message     string
+}
+
+// loadPolicies reads the rules of every policy file (yaml, json or toml) in a directory. There are no
+// rules when the directory does not exist.
+func loadPolicies(dir string) (rules []PolicyRule, err error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		log.Debug("No policy directory " + dir + ", skipping the policy checks")
+		return nil, nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		switch filepath.Ext(file.Name()) {
+		case ".yaml", ".yml", ".json", ".toml":
+		default:
+			continue
+		}
+		config := viper.New()
+		config.SetConfigFile(filepath.Join(dir, file.Name()))
+		if err := config.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("Unable to read policy file %s: %s", file.Name(), err)
+		}
+		var fileRules []PolicyRule
+		if err := config.UnmarshalKey("rules", &fileRules); err != nil {
+			return nil, fmt.Errorf("Invalid rules in policy file %s: %s", file.Name(), err)
+		}
+		for i := range fileRules {
+			if err := validatePolicyRule(&fileRules[i]); err != nil {
+				return nil, fmt.Errorf("Invalid rule in policy file %s: %s", file.Name(), err)
+			}
+		}
+		rules = append(rules, fileRules...)
+	}
+	if len(rules) > 0 {
+		log.Info(emoji.Sprintf(":scroll: Loaded %d policy rules from %s", len(rules), dir))
+	}
+	return rules, err
+}
+
+// validatePolicyRule checks a rule and fills in its defaults
+func validatePolicyRule(rule *PolicyRule) (err error) {
+	if rule.Name == "" || rule.Attribute == "" {
+		return errors.New("rules need a name and an attribute")
+	}
+	switch rule.Level {
+	case "":
+		rule.Level = POLICYDENY
+	case POLICYWARN, POLICYDENY:
+	default:
+		return errors.New(rule.Name + ": level must be warn or deny, not " + rule.Level)
+	}
+	if rule.Resource == "" {
+		rule.Resource = "*"
+	}
+	if rule.Matches != "" {
+		if rule.matches, err = regexp.Compile(rule.Matches); err != nil {
+			return errors.New(rule.Name + ": " + err.Error())
+		}
+	}
+	if !rule.Required && rule.In == nil && rule.NotIn == nil && rule.Equals == "" && rule.Matches == "" && rule.Max == nil && rule.Min == nil && rule.MaxCores == 0 {
+		return errors.New(rule.Name + ": the rule has no condition")
+	}
+	return err
+}
+
+// attributeValues returns the values at a dotted path, where * matches every key or list element
+func attributeValues(value interface{}, segments []string, prefix string, found map[string]interface{}) {
+	if len(segments) == 0 {
+		if value != nil {
+			found[prefix] = value
+		}
+		return
+	}
+	join := func(key string) string { return strings.TrimPrefix(prefix+"."+key, ".") }
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if segments[0] == "*" || segments[0] == key {
+				attributeValues(child, segments[1:], join(key), found)
+			}
+		}
+	case []interface{}:
+		for i, child := range value {
+			if segments[0] == "*" || segments[0] == strconv.Itoa(i) {
+				attributeValues(child, segments[1:], join(strconv.Itoa(i)), found)
+			}
+		}
+	}
+}
+
+// policyValue formats a value of a resource for comparisons and the report
+func policyValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+// checkPolicyValue returns why a value breaks a rule, or "" if it follows it
+func checkPolicyValue(rule *PolicyRule, value string) string {
+	contains := func(list []string) bool {
+		for _, item := range list {
+			if strings.EqualFold(item, value) {
+				return true
+			}
+		}
+		return false
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	switch {
+	case value == "" && rule.Required:
+		return "is not set"
+	case rule.In != nil && !contains(rule.In):
+		return "is not one of " + strings.Join(rule.In, ", ")
+	case rule.NotIn != nil && contains(rule.NotIn):
+		return "is not allowed"
+	case rule.Equals != "" && !strings.EqualFold(rule.Equals, value):
+		return "is not " + rule.Equals
+	case rule.matches != nil && !rule.matches.MatchString(value):
+		return "does not match " + rule.Matches
+	case rule.Max != nil && err == nil && number > *rule.Max:
+		return "is above " + strconv.FormatFloat(*rule.Max, 'f', -1, 64)
+	case rule.Min != nil && err == nil && number < *rule.Min:
+		return "is below " + strconv.FormatFloat(*rule.Min, 'f', -1, 64)
+	case rule.MaxCores > 0:
+		cores, known := vmSizeCores[value]
+		if !known {
+			return "is a VM size with an unknown number of vCPUs"
+		}
+		if cores > rule.MaxCores {
+			return fmt.Sprintf("has %d vCPUs, more than %d", cores, rule.MaxCores)
+		}
+	}
+	return ""
+}
+
+// checkPolicyRule checks the values of a resource, or of the tfvars, against a rule
+func checkPolicyRule(rule *PolicyRule, environment string, resource string, values map[string]interface{}) (violations []PolicyViolation) {
+	segments := strings.Split(rule.Attribute, ".")
+	// Terraform lists every attribute of a resource type in the plan, even unset ones, so
+	// resources without the attribute are of a type the rule does not apply to
+	if _, ok := values[segments[0]]; !ok && resource != TFVARS {
+		return nil
+	}
+	violation := func(attribute string, value string, reason string) PolicyViolation {
+		message := attribute + " " + reason
+		if value != "" {
+			message = attribute + " (" + value + ") " + reason
+		}
+		if rule.Message != "" {
+			message = rule.Message + ": " + message
+		}
+		return PolicyViolation{Rule: rule.Name, Level: rule.Level, Environment: environment, Resource: resource, Attribute: attribute, Value: value, Message: message}
+	}
+
+	found := map[string]interface{}{}
+	attributeValues(values, segments, "", found)
+	if len(found) == 0 {
+		if rule.Required {
+			violations = append(violations, violation(rule.Attribute, "", "is not set"))
+		}
+		return violations
+	}
+	attributes := []string{}
+	for attribute := range found {
+		attributes = append(attributes, attribute)
+	}
+	sort.Strings(attributes)
+	for _, attribute := range attributes {
+		value := policyValue(found[attribute])
+		if reason := checkPolicyValue(rule, value); reason != "" {
+			violations = append(violations, violation(attribute, value, reason))
+		}
+	}
+	return violations
+}
+
+// evaluatePolicies checks the planned resources (output of `terraform show -json`) and tfvars of an environment
+func evaluatePolicies(rules []PolicyRule, environment string, plan []byte, tfvars map[string]string) (violations []PolicyViolation, err error) {
+	var parsed terraformPlanChanges
+	if plan != nil {
+		if err := json.Unmarshal(plan, &parsed); err != nil {
+			return nil, err
+		}
+	}
+	variables := map[string]interface{}{}
+	for setting, value := range tfvars {
+		variables[setting] = strings.Trim(value, "\"")
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Resource == TFVARS {
+			violations = append(violations, checkPolicyRule(rule, environment, TFVARS, variables)...)
+			continue
+		}
+		for _, change := range parsed.ResourceChanges {
+			// Resources being destroyed do not need to follow the rules
+			if change.Mode == "data" || change.Change.After == nil {
+				continue
+			}
+			if matched, _ := path.Match(rule.Resource, change.Type); matched {
+				violations = append(violations, checkPolicyRule(rule, environment, change.Address, change.Change.After)...)
+			}
+		}
+	}
+	return violations, err
+}
+
+// policyDenied returns the number of violations of deny rules
+func policyDenied(violations []PolicyViolation) (denied int) {
+	for _, violation := range violations {
+		if violation.Level == POLICYDENY {
+			denied++
+		}
+	}
+	return denied
+}
+
+// writePolicyReport writes the violations of an environment as a table
+func writePolicyReport(out io.Writer, environment string, violations []PolicyViolation) {
+	if len(violations) == 0 {
+		fmt.Fprintf(out, "%s follows every policy rule\n", environment)
+		return
+	}
+	fmt.Fprintf(out, "%s breaks %d policy rules (%d denied):\n", environment, len(violations), policyDenied(violations))
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, violation := range violations {
+		fmt.Fprintf(table, "  %s\t%s\t%s\t%s\n", strings.ToUpper(violation.Level), violation.Rule, violation.Resource, violation.Message)
+	}
+	table.Flush()
+}
+
+// checkPolicies evaluates the rules for an environment and reports the violations to out
+func checkPolicies(rules []PolicyRule, environment string, directory string, plan []byte, out io.Writer) (err error) {
+	var tfvars map[string]string
+	if fileExists(directory + "/bedrock-config.tfvars") {
+		if tfvars, err = ReadTfvarsFile(directory + "/bedrock-config.tfvars"); err != nil {
+			return err
+		}
+	}
+	violations, err := evaluatePolicies(rules, environment, plan, tfvars)
+	if err != nil {
+		return fmt.Errorf("Could not read the plan: %s", err)
+	}
+	writePolicyReport(out, environment, violations)
+	if denied := policyDenied(violations); denied > 0 {
+		return fmt.Errorf("%d policy violations", denied)
+	}
+	return err
+}
@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const policyRules = `rules:
+  - name: approved-regions
+    message: Resources must be deployed in an approved region
+    attribute: location
+    in: [eastus, westus2]
+  - name: vm-size
+    resource: azurerm_kubernetes_cluster
+    attribute: agent_pool_profile.*.vm_size
+    max_cores: 4
+  - name: node-count
+    level: warn
+    resource: tfvars
+    attribute: agent_vm_count
+    max: 5
+  - name: owner-tag
+    attribute: tags.owner
+    required: true
+  - name: private-keyvault
+    resource: azurerm_key_vault
+    attribute: network_acls.*.default_action
+    required: true
+    equals: Deny
+`
+
+const policyPlanJSON = `{
+  "resource_changes": [
+    {
+      "address": "module.aks.azurerm_kubernetes_cluster.cluster",
+      "mode": "managed",
+      "type": "azurerm_kubernetes_cluster",
+      "change": {"after": {"location": "eastus", "tags": {"owner": "team-a"}, "agent_pool_profile": [{"count": 3, "vm_size": "Standard_D8s_v3"}]}}
+    },
+    {
+      "address": "azurerm_key_vault.keyvault",
+      "mode": "managed",
+      "type": "azurerm_key_vault",
+      "change": {"after": {"location": "northeurope", "tags": null, "network_acls": []}}
+    },
+    {
+      "address": "azurerm_subnet.subnet",
+      "mode": "managed",
+      "type": "azurerm_subnet",
+      "change": {"after": {"name": "subnet"}}
+    },
+    {
+      "address": "azurerm_resource_group.old",
+      "mode": "managed",
+      "type": "azurerm_resource_group",
+      "change": {"before": {"location": "northeurope"}, "after": null}
+    }
+  ]
+}`
+
+func TestPolicies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-policies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(dir+"/rules.yaml", []byte(policyRules), 0644)
+	ioutil.WriteFile(dir+"/README.md", []byte("Not a policy file"), 0644)
+
+	rules, err := loadPolicies(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 5 || rules[0].Level != POLICYDENY || rules[0].Resource != "*" {
+		t.Fatalf("Unexpected rules %v", rules)
+	}
+	if rules, err := loadPolicies(dir + "/missing"); rules != nil || err != nil {
+		t.Error("Expected no rules without a policy directory")
+	}
+
+	violations, err := evaluatePolicies(rules, "one/azure-simple", []byte(policyPlanJSON), map[string]string{"agent_vm_count": "\"8\""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := []string{}
+	for _, violation := range violations {
+		found = append(found, violation.Level+" "+violation.Rule+" "+violation.Resource+" "+violation.Attribute)
+	}
+	expected := []string{
+		"deny approved-regions azurerm_key_vault.keyvault location",
+		"deny vm-size module.aks.azurerm_kubernetes_cluster.cluster agent_pool_profile.0.vm_size",
+		"warn node-count tfvars agent_vm_count",
+		"deny owner-tag azurerm_key_vault.keyvault tags.owner",
+		"deny private-keyvault azurerm_key_vault.keyvault network_acls.*.default_action",
+	}
+	if strings.Join(found, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected violations:\n%s", strings.Join(found, "\n"))
+	}
+	if policyDenied(violations) != 4 {
+		t.Errorf("Expected 4 denied violations, got %d", policyDenied(violations))
+	}
+
+	var report bytes.Buffer
+	writePolicyReport(&report, "one/azure-simple", violations)
+	if !strings.Contains(report.String(), "Resources must be deployed in an approved region: location (northeurope) is not one of eastus, westus2") {
+		t.Errorf("Unexpected report:\n%s", report.String())
+	}
+
+	// Invalid rules are rejected
+	ioutil.WriteFile(dir+"/invalid.yaml", []byte("rules:\n  - name: typo\n    level: block\n    attribute: location\n    equals: eastus\n"), 0644)
+	if _, err := loadPolicies(dir); err == nil {
+		t.Error("Expected an unknown level to be rejected")
+	}
+}
+
+func TestDeployPolicies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-deploy-policies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(registry []EnvironmentType) { environmentRegistry = registry }(environmentRegistry)
+	environmentRegistry = nil
+	RegisterEnvironment(&templateEnvironment{name: "test-app"})
+	deployFixture(t, dir, map[string][]string{"one": {"test-app"}})
+
+	defer func(dir string) { policyDir = dir }(policyDir)
+	policyDir = dir + "/policies"
+	os.MkdirAll(policyDir, os.ModePerm)
+	ioutil.WriteFile(policyDir+"/rules.yaml", []byte(policyRules), 0644)
+
+	var applied []string
+	defer func(runner func(string, []string, io.Writer, ...string) error) { terraformRunner = runner }(terraformRunner)
+	terraformRunner = func(directory string, env []string, out io.Writer, args ...string) error {
+		switch args[0] {
+		case "show":
+			out.Write([]byte(policyPlanJSON))
+		case "apply":
+			applied = append(applied, strings.Join(args, " "))
+		}
+		return nil
+	}
+
+	// A plan breaking deny rules is not applied
+	if err := DeployAll([]string{dir + "/one"}, 1); err == nil || len(applied) > 0 {
+		t.Errorf("Expected the plan not to be applied: %v %v", err, applied)
+	}
+
+	// Warnings do not stop the deployment, which applies the plan that was checked
+	ioutil.WriteFile(policyDir+"/rules.yaml", []byte("rules:\n  - name: approved-regions\n    level: warn\n    attribute: location\n    in: [eastus, westus2]\n"), 0644)
+	if err := DeployAll([]string{dir + "/one"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || applied[0] != "apply -input=false "+deployPlan {
+		t.Errorf("Expected the checked plan to be applied, got %v", applied)
+	}
+}
+
+func TestPolicySimulation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-simulate-policies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(registry []EnvironmentType) { environmentRegistry = registry }(environmentRegistry)
+	environmentRegistry = nil
+	RegisterEnvironment(&templateEnvironment{name: "test-common", backend: true})
+	RegisterEnvironment(&templateEnvironment{name: "test-app", dependencies: []string{"test-common"}})
+	deployFixture(t, dir, map[string][]string{"one": {"test-common", "test-app"}})
+
+	defer func(dir string) { policyDir = dir }(policyDir)
+	policyDir = dir + "/policies"
+	os.MkdirAll(policyDir, os.ModePerm)
+	ioutil.WriteFile(policyDir+"/rules.yaml", []byte(policyRules), 0644)
+
+	var calls []string
+	defer func(runner func(string, []string, io.Writer, ...string) error) { terraformRunner = runner }(terraformRunner)
+	terraformRunner = func(directory string, env []string, out io.Writer, args ...string) error {
+		calls = append(calls, strings.TrimPrefix(directory, dir+"/")+" "+args[0]+" "+env[2])
+		if args[0] == "show" {
+			out.Write([]byte(policyPlanJSON))
+		}
+		return nil
+	}
+
+	// The dependencies are deployed before the plans that read them, with the credentials of the environment
+	if err := Simulate(dir + "/one"); err == nil || !strings.Contains(err.Error(), "policy") {
+		t.Errorf("Expected the plan breaking the policies to be reported, got %v", err)
+	}
+	expected := []string{"test-common init", "test-common plan", "test-common show", "test-common init", "test-common apply", "test-app init", "test-app plan", "test-app show"}
+	if strings.Join(calls, ", ") != "one/"+strings.Join(expected, " ARM_CLIENT_ID=app-one, one/")+" ARM_CLIENT_ID=app-one" {
+		t.Errorf("Unexpected terraform commands %v", calls)
+	}
+	if fileExists(dir + "/one/test-app/" + simulatePlan) {
+		t.Error("Expected the simulated plan to be removed")
+	}
+}
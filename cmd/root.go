@@ -22,7 +22,7 @@ var rootCmd = &cobra.Command{
 			log.SetLevel(log.InfoLevel)
 		}
 
-		return err
+		return loadConfigFile(cmd)
 	},
 }
 
@@ -37,4 +37,5 @@ func Execute() {
 
 func init() {
 	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "Use verbose output logs")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Config file with default flag values (defaults to ./"+defaultConfigFile+" if it exists)")
 }
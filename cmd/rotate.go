@@ -0,0 +1,610 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var rotateClusterName string
+var rotateRegion string
+var rotateSettings []string
+var rotateTimeout time.Duration
+var rotateYes bool
+var rotateIngressService string
+var rotateTMProfile string
+var rotateTMResourceGroup string
+var rotateTMEndpoint string
+var rotateDNSZone string
+var rotateDNSResourceGroup string
+var rotateDNSRecord string
+
+// rotatableEnvironments are the environments with a single cluster that can be rotated
+var rotatableEnvironments = []string{SIMPLE, KEYVAULT}
+
+// rotatePollInterval is the time between two checks of the new cluster
+var rotatePollInterval = 20 * time.Second
+
+// rotateRunner runs the kubectl and az commands of a rotation, replaced in tests
+var rotateRunner = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// rotationCopyExcluded are the files of the old environment that belong to its deployment, not its configuration
+var rotationCopyExcluded = map[string]bool{
+	".terraform":               true,
+	"terraform.tfstate":        true,
+	"terraform.tfstate.backup": true,
+	"output":                   true,
+	deployedTfvars:             true,
+}
+
+// siblingName returns the name of the cluster replacing a cluster, alternating between -blue and -green
+func siblingName(name string) string {
+	switch {
+	case strings.HasSuffix(name, "-blue"):
+		return strings.TrimSuffix(name, "-blue") + "-green"
+	case strings.HasSuffix(name, "-green"):
+		return strings.TrimSuffix(name, "-green") + "-blue"
+	}
+	return name + "-green"
+}
+
+// copyEnvironment copies the configuration of an environment, leaving out its state, plans and outputs
+func copyEnvironment(source string, dest string) (err error) {
+	files, err := ioutil.ReadDir(source)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dest, os.ModePerm); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if rotationCopyExcluded[file.Name()] || filepath.Ext(file.Name()) == ".tfplan" {
+			continue
+		}
+		if file.IsDir() {
+			err = CopyDir(source+"/"+file.Name(), dest+"/"+file.Name())
+		} else if err = CopyFile(source+"/"+file.Name(), dest+"/"+file.Name()); err == nil {
+			// e.g. the deploy key has to stay private
+			err = os.Chmod(dest+"/"+file.Name(), file.Mode())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// loadRotationSettings sets the flags Init would have been given from the tfvars of the old cluster, so the
+// new cluster gets the same gitops, network, keyvault and credential settings. It returns the ssh public key.
+func loadRotationSettings(directory string) (sshKey string, err error) {
+	config, err := ReadTfvarsFile(directory + "/bedrock-config.tfvars")
+	if err != nil {
+		return "", err
+	}
+	value := func(setting string) string { return strings.Trim(config[setting], "\"") }
+	gitopsSSHUrl = value("gitops_ssh_url")
+	gitopsPath = value("gitops_path")
+	gitopsURLBranch = value("gitops_url_branch")
+	gitopsPollInterval = value("gitops_poll_interval")
+	vmCount = value("agent_vm_count")
+	vmSize = value("agent_vm_size")
+	vnet = value("vnet_name")
+	subnet = value("subnet_name")
+	keyvaultName = value("keyvault_name")
+	keyvaultRG = value("keyvault_resource_group")
+	addressSpace = value("address_space")
+	subnetPrefix = value("subnet_prefixes")
+	servicePrincipal = value("service_principal_id")
+	secret = value("service_principal_secret")
+
+	spConfig := viper.New()
+	spConfig.SetConfigFile(directory + "/bedrock-sp-config.toml")
+	if err := spConfig.ReadInConfig(); err != nil {
+		return "", err
+	}
+	authMode = spConfig.GetString("auth")
+	if authMode == "" {
+		authMode = AUTHSP
+	}
+	subscription = spConfig.GetString("subscription")
+	tenant = spConfig.GetString("tenant_id")
+	if authMode == AUTHSP {
+		servicePrincipal = spConfig.GetString("service_principal")
+		secret = spConfig.GetString("secret")
+	}
+
+	// The new cluster keeps its state in the same storage account, under its own key
+	storageAccount, accessKey, containerName = "", "", ""
+	if err := loadBackendConfig(directory); err != nil {
+		return "", err
+	}
+	return value("ssh_public_key"), err
+}
+
+// applyRotationSettings sets the values given with --set in the tfvars of the new cluster. They are not part
+// of the generated values, so later runs of Init keep them like manual edits.
+func applyRotationSettings(directory string, settings []string) (err error) {
+	if len(settings) == 0 {
+		return err
+	}
+	config, err := ReadTfvarsFile(directory + "/bedrock-config.tfvars")
+	if err != nil {
+		return err
+	}
+	for _, setting := range settings {
+		equal := strings.Index(setting, "=")
+		if equal <= 0 {
+			return errors.New("Invalid setting '" + setting + "', settings must be specified as variable=value")
+		}
+		value := setting[equal+1:]
+		// Lists, maps and quoted values are written as given
+		if !strings.HasPrefix(value, "\"") && !strings.HasPrefix(value, "[") && !strings.HasPrefix(value, "{") {
+			value = "\"" + value + "\""
+		}
+		config[setting[:equal]] = value
+		log.Info(emoji.Sprintf(":pencil2: %s = %s", setting[:equal], maskSecret(setting[:equal], value)))
+	}
+	_, err = writeTfvars(directory+"/bedrock-config.tfvars", config)
+	return err
+}
+
+// prepareRotation creates the environment directory of the new cluster from the old one
+func prepareRotation(oldPath string, newPath string, environment EnvironmentType, newName string) (err error) {
+	environments, err := environmentsIn(oldPath)
+	if err != nil {
+		return err
+	}
+	// The common infra is copied with its backend config, or stays linked, both clusters share its state
+	for _, env := range environments {
+		if err := copyEnvironment(oldPath+"/"+env.Name(), newPath+"/"+env.Name()); err != nil {
+			return err
+		}
+	}
+	if fileExists(oldPath + "/" + environmentManifestFile) {
+		if err := CopyFile(oldPath+"/"+environmentManifestFile, newPath+"/"+environmentManifestFile); err != nil {
+			return err
+		}
+	}
+
+	oldDirectory := oldPath + "/" + environment.Name()
+	directory := newPath + "/" + environment.Name()
+	sshKey, err := loadRotationSettings(oldDirectory)
+	if err != nil {
+		return err
+	}
+	old, err := ReadTfvarsFile(oldDirectory + "/bedrock-config.tfvars")
+	if err != nil {
+		return err
+	}
+	dnsPrefix = resourceName("dns-prefix", newName)
+
+	// The new cluster goes to the region of the old one unless --region is given
+	region = rotateRegion
+	if region == "" {
+		output, err := rotateRunner("az", "group", "show", "--name", strings.Trim(old["resource_group_name"], "\""), "--query", "location", "--output", "tsv")
+		if err != nil {
+			return fmt.Errorf("Could not find the region of the old cluster, use --region: %s", strings.TrimSpace(string(output)))
+		}
+		region = strings.TrimSpace(string(output))
+	}
+	if err := environment.CreateResourceGroups(newName); err != nil {
+		return err
+	}
+
+	// The copied tfvars are reconciled like a rerun of Init, so manual edits of the old cluster are kept
+	if err := generateTfvars(directory, environment.Name(), newName, sshKey); err != nil {
+		return err
+	}
+	if err := applyRotationSettings(directory, rotateSettings); err != nil {
+		return err
+	}
+
+	config, err := ReadTfvarsFile(directory + "/bedrock-config.tfvars")
+	if err != nil {
+		return err
+	}
+	for _, setting := range []string{"cluster_name", "resource_group_name", "dns_prefix"} {
+		if config[setting] != "" && config[setting] == old[setting] {
+			return fmt.Errorf("The new cluster would reuse the %s %s of the old cluster, set another one with --set %s=value", setting, config[setting], setting)
+		}
+	}
+	return err
+}
+
+// kubernetesObject holds the fields of nodes, deployments and services that a rotation looks at
+type kubernetesObject struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec struct {
+		Replicas *int `json:"replicas"`
+	} `json:"spec"`
+	Status struct {
+		AvailableReplicas int `json:"availableReplicas"`
+		Conditions        []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+		LoadBalancer struct {
+			Ingress []struct {
+				IP       string `json:"ip"`
+				Hostname string `json:"hostname"`
+			} `json:"ingress"`
+		} `json:"loadBalancer"`
+	} `json:"status"`
+}
+
+// kubectl runs kubectl against the cluster of a kubeconfig and decodes its JSON output
+func kubectl(kubeconfig string, result interface{}, args ...string) (err error) {
+	output, err := rotateRunner("kubectl", append([]string{"--kubeconfig", kubeconfig}, append(args, "--output", "json")...)...)
+	if err != nil {
+		return errors.New(strings.TrimSpace(string(output)))
+	}
+	return json.Unmarshal(output, result)
+}
+
+// clusterKubeconfig returns the kubeconfig terraform wrote to the output directory of an environment
+func clusterKubeconfig(directory string) string {
+	matches, _ := filepath.Glob(directory + "/output/*kube_config")
+	if len(matches) == 0 {
+		return ""
+	}
+	return matches[0]
+}
+
+// clusterWorkloads returns whether each deployment of a cluster is available, by namespace/name.
+// Deployments managed by AKS in kube-system are left out.
+func clusterWorkloads(kubeconfig string) (workloads map[string]bool, err error) {
+	var deployments struct {
+		Items []kubernetesObject `json:"items"`
+	}
+	if err := kubectl(kubeconfig, &deployments, "get", "deployments", "--all-namespaces"); err != nil {
+		return nil, err
+	}
+	workloads = map[string]bool{}
+	for _, deployment := range deployments.Items {
+		if deployment.Metadata.Namespace == "kube-system" {
+			continue
+		}
+		replicas := 1
+		if deployment.Spec.Replicas != nil {
+			replicas = *deployment.Spec.Replicas
+		}
+		workloads[deployment.Metadata.Namespace+"/"+deployment.Metadata.Name] = deployment.Status.AvailableReplicas >= replicas
+	}
+	return workloads, err
+}
+
+// clusterReadiness returns what the new cluster is still waiting for: ready nodes, a running Flux and
+// the deployments the old cluster runs, which Flux syncs from the same manifest repository
+func clusterReadiness(kubeconfig string, expected []string) (waiting []string) {
+	var nodes struct {
+		Items []kubernetesObject `json:"items"`
+	}
+	if err := kubectl(kubeconfig, &nodes, "get", "nodes"); err != nil {
+		return []string{"the nodes could not be listed: " + err.Error()}
+	}
+	if len(nodes.Items) == 0 {
+		waiting = append(waiting, "the cluster has no nodes")
+	}
+	for _, node := range nodes.Items {
+		ready := false
+		for _, condition := range node.Status.Conditions {
+			ready = ready || (condition.Type == "Ready" && condition.Status == "True")
+		}
+		if !ready {
+			waiting = append(waiting, "node "+node.Metadata.Name+" is not ready")
+		}
+	}
+
+	workloads, err := clusterWorkloads(kubeconfig)
+	if err != nil {
+		return append(waiting, "the deployments could not be listed: "+err.Error())
+	}
+	flux := false
+	for workload, available := range workloads {
+		if strings.HasPrefix(workload, "flux/") {
+			flux = true
+			if !available {
+				waiting = append(waiting, "Flux deployment "+workload+" is not available")
+			}
+		}
+	}
+	if !flux {
+		waiting = append(waiting, "Flux is not running")
+	}
+	for _, workload := range expected {
+		available, synced := workloads[workload]
+		if !synced {
+			waiting = append(waiting, workload+" is not synced yet")
+		} else if !available {
+			waiting = append(waiting, workload+" is not available")
+		}
+	}
+	return waiting
+}
+
+// waitForCluster waits until the new cluster runs what the old cluster runs
+func waitForCluster(oldDirectory string, directory string, timeout time.Duration) (err error) {
+	kubeconfig := clusterKubeconfig(directory)
+	if kubeconfig == "" {
+		return errors.New("No kubeconfig was found in " + directory + "/output")
+	}
+
+	var expected []string
+	if oldKubeconfig := clusterKubeconfig(oldDirectory); oldKubeconfig == "" {
+		log.Warn(emoji.Sprintf(":warning: No kubeconfig was found for the old cluster, only waiting for the nodes and Flux"))
+	} else if workloads, err := clusterWorkloads(oldKubeconfig); err != nil {
+		log.Warn(emoji.Sprintf(":warning: Could not list the deployments of the old cluster, only waiting for the nodes and Flux: %s", err))
+	} else {
+		for workload, available := range workloads {
+			if available {
+				expected = append(expected, workload)
+			}
+		}
+		sort.Strings(expected)
+	}
+
+	log.Info(emoji.Sprintf(":hourglass: Waiting for the nodes to be ready and Flux to sync %d deployments...", len(expected)))
+	deadline := time.Now().Add(timeout)
+	for {
+		waiting := clusterReadiness(kubeconfig, expected)
+		if len(waiting) == 0 {
+			log.Info(emoji.Sprintf(":white_check_mark: The new cluster is ready"))
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("The new cluster was not ready after %s: %s", timeout, strings.Join(waiting, ", "))
+		}
+		log.Info(emoji.Sprintf(":hourglass: Waiting for %d things, e.g. %s", len(waiting), waiting[0]))
+		time.Sleep(rotatePollInterval)
+	}
+}
+
+// ingressAddress returns the external address of the --ingress-service of a cluster
+func ingressAddress(kubeconfig string) (address string, err error) {
+	parts := strings.SplitN(rotateIngressService, "/", 2)
+	if len(parts) != 2 {
+		return "", errors.New("--ingress-service must be specified as namespace/name")
+	}
+	var service kubernetesObject
+	if err := kubectl(kubeconfig, &service, "get", "service", parts[1], "--namespace", parts[0]); err != nil {
+		return "", err
+	}
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			return ingress.IP, err
+		}
+		if ingress.Hostname != "" {
+			return ingress.Hostname, err
+		}
+	}
+	return "", errors.New("The service " + rotateIngressService + " has no external address")
+}
+
+// az runs an az command of a rotation
+func az(args ...string) (err error) {
+	if output, err := rotateRunner("az", args...); err != nil {
+		return errors.New(strings.TrimSpace(string(output)))
+	}
+	return err
+}
+
+// switchTraffic points the Traffic Manager endpoint or DNS record of the old cluster to the new one
+func switchTraffic(oldDirectory string, directory string) (err error) {
+	if rotateTMProfile == "" && rotateDNSZone == "" {
+		log.Info(emoji.Sprintf(":information_source: Traffic was not switched, use --traffic-manager-profile or --dns-zone to switch it"))
+		return err
+	}
+	if rotateIngressService == "" {
+		return errors.New("--ingress-service is needed to find the address traffic is switched to")
+	}
+	address, err := ingressAddress(clusterKubeconfig(directory))
+	if err != nil {
+		return fmt.Errorf("Could not find the address of the new cluster: %s", err)
+	}
+	oldAddress := ""
+	if oldKubeconfig := clusterKubeconfig(oldDirectory); oldKubeconfig != "" {
+		if oldAddress, err = ingressAddress(oldKubeconfig); err != nil {
+			log.Warn(emoji.Sprintf(":warning: Could not find the address of the old cluster: %s", err))
+			err = nil
+		}
+	}
+
+	if rotateTMProfile != "" {
+		profile, err := trafficManagerStatus(rotateTMProfile, rotateTMResourceGroup)
+		if err != nil {
+			return err
+		}
+		var endpoint *TrafficManagerEndpoint
+		for i := range profile.Endpoints {
+			candidate := &profile.Endpoints[i]
+			if candidate.Name == rotateTMEndpoint || (rotateTMEndpoint == "" && oldAddress != "" && candidate.Target == oldAddress) {
+				endpoint = candidate
+			}
+		}
+		if endpoint == nil {
+			return fmt.Errorf("No endpoint of %s targets the old cluster, use --traffic-manager-endpoint", rotateTMProfile)
+		}
+		// Updating the target keeps the weight, priority or geo mapping of the endpoint
+		endpointType := filepath.Base(endpoint.Type)
+		target := []string{"--target", address}
+		if endpointType == "azureEndpoints" {
+			// Azure endpoints target the public IP resource rather than the address
+			output, err := rotateRunner("az", "network", "public-ip", "list", "--query", "[?ipAddress=='"+address+"'].id", "--output", "tsv")
+			if err != nil || strings.TrimSpace(string(output)) == "" {
+				return fmt.Errorf("Could not find the public IP resource of %s: %s", address, strings.TrimSpace(string(output)))
+			}
+			target = []string{"--target-resource-id", strings.TrimSpace(string(output))}
+		}
+		log.Info(emoji.Sprintf(":twisted_rightwards_arrows: Pointing Traffic Manager endpoint %s from %s to %s", endpoint.Name, endpoint.Target, address))
+		if err := az(append([]string{"network", "traffic-manager", "endpoint", "update", "--name", endpoint.Name, "--profile-name", rotateTMProfile, "--resource-group", rotateTMResourceGroup, "--type", endpointType}, target...)...); err != nil {
+			return fmt.Errorf("Could not update Traffic Manager endpoint %s: %s", endpoint.Name, err)
+		}
+	}
+
+	if rotateDNSZone != "" {
+		log.Info(emoji.Sprintf(":twisted_rightwards_arrows: Pointing %s.%s to %s", rotateDNSRecord, rotateDNSZone, address))
+		record := []string{"--resource-group", rotateDNSResourceGroup, "--zone-name", rotateDNSZone, "--record-set-name", rotateDNSRecord}
+		if err := az(append([]string{"network", "dns", "record-set", "a", "add-record", "--ipv4-address", address}, record...)...); err != nil {
+			return fmt.Errorf("Could not add %s to %s: %s", address, rotateDNSRecord, err)
+		}
+		if oldAddress != "" && oldAddress != address {
+			if err := az(append([]string{"network", "dns", "record-set", "a", "remove-record", "--ipv4-address", oldAddress, "--keep-empty-record-set"}, record...)...); err != nil {
+				return fmt.Errorf("Could not remove %s from %s: %s", oldAddress, rotateDNSRecord, err)
+			}
+		}
+	}
+	return err
+}
+
+// destroyCluster destroys the cluster of an environment and the resource group Init created for it. A
+// resource group the CLI did not create may hold other resources, it is left to terraform destroy.
+// The common infra is left alone, the new cluster uses it.
+func destroyCluster(name string, environment EnvironmentType) (err error) {
+	directory := name + "/" + environment.Name()
+	config, err := ReadTfvarsFile(directory + "/bedrock-config.tfvars")
+	if err != nil {
+		return err
+	}
+	task := &deployTask{ID: filepath.Base(name) + "/" + environment.Name(), Cluster: name, Environment: environment}
+	if err := destroyEnvironment(task); err != nil {
+		return err
+	}
+
+	resourceGroup := strings.Trim(config["resource_group_name"], "\"")
+	created, index, err := createdEntry(RESOURCEGROUP, resourceGroup)
+	if err != nil {
+		return err
+	}
+	if created == nil {
+		log.Info(emoji.Sprintf(":information_source: Resource group %s was not created by bedrock, it is kept", resourceGroup))
+		return err
+	}
+	log.Info(emoji.Sprintf(":boom: Deleting resource group %s", resourceGroup))
+	if output, err := rotateRunner("az", "group", "delete", "--name", resourceGroup, "--yes"); err != nil && !notFound(string(output)) {
+		return fmt.Errorf("Could not delete resource group %s: %s", resourceGroup, strings.TrimSpace(string(output)))
+	}
+	created.Entries[index].Status = DELETED
+	return created.Save()
+}
+
+// Rotate replaces the cluster of an environment directory with a new cluster deployed next to it: the new
+// cluster is deployed with the same settings, traffic is switched once Flux synced it and the old cluster
+// is destroyed after confirmation
+func Rotate(name string) (err error) {
+	name = strings.TrimSuffix(name, "/")
+	environments, err := environmentsIn(name)
+	if err != nil {
+		return err
+	}
+	var environment EnvironmentType
+	for _, env := range environments {
+		for _, rotatable := range rotatableEnvironments {
+			if env.Name() == rotatable {
+				environment = env
+			}
+		}
+	}
+	if environment == nil {
+		return fmt.Errorf("No %s environment was found in %s", strings.Join(rotatableEnvironments, " or "), name)
+	}
+
+	newName := rotateClusterName
+	if newName == "" {
+		newName = siblingName(filepath.Base(name))
+	}
+	newPath := filepath.Dir(name) + "/" + newName
+	if _, err := os.Stat(newPath); err == nil {
+		return errors.New(newPath + " already exists, remove it or use --new-cluster-name")
+	}
+	if naming, err = newNamingConvention(); err != nil {
+		return err
+	}
+	if err := validateNames(environment.Name(), newName); err != nil {
+		return err
+	}
+
+	// The resource group of the new cluster is recorded, so that the next rotation deletes it
+	log.Info(emoji.Sprintf(":arrows_counterclockwise: Rotating %s to the new cluster %s", name, newPath))
+	journal = NewJournal(newName)
+	if err := prepareRotation(name, newPath, environment, newName); err != nil {
+		os.RemoveAll(newPath)
+		rollback(journal)
+		journal = nil
+		return err
+	}
+	journal = nil
+	if err := DeployAll([]string{newPath}, deployParallelism); err != nil {
+		return fmt.Errorf("The new cluster was not deployed, %s is still serving traffic: %s", name, err)
+	}
+	oldDirectory := name + "/" + environment.Name()
+	directory := newPath + "/" + environment.Name()
+	if err := waitForCluster(oldDirectory, directory, rotateTimeout); err != nil {
+		return fmt.Errorf("%s, %s is still serving traffic", err, name)
+	}
+	if err := switchTraffic(oldDirectory, directory); err != nil {
+		return err
+	}
+
+	if !rotateYes && !confirm("Destroy the old cluster "+name+"?") {
+		log.Info(emoji.Sprintf(":information_source: The old cluster %s was kept", name))
+		return err
+	}
+	// The new cluster keeps using the service principal of the old one, it is not deleted with the old cluster
+	if err := os.Rename(name+"/"+servicePrincipalFile, newPath+"/"+servicePrincipalFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := destroyCluster(name, environment); err != nil {
+		return fmt.Errorf("The old cluster was not destroyed: %s", err)
+	}
+	log.Info(emoji.Sprintf(":raised_hands: %s replaced %s, the old environment directory can be removed", newPath, name))
+	return err
+}
+
+var rotateCmd = &cobra.Command{
+	Use:   "rotate <environment-path> [--new-cluster-name name] [--set variable=value] [--region region] [--timeout duration] [--ingress-service namespace/name] [--traffic-manager-profile profile --traffic-manager-resource-group group] [--traffic-manager-endpoint endpoint] [--dns-zone zone --dns-resource-group group --dns-record name] [--yes]",
+	Short: "Replace the cluster of an azure-simple or keyvault environment with a new one (blue/green)",
+	Long:  `Replace the cluster of an azure-simple or azure-single-keyvault environment with a new cluster: a sibling environment (-blue or -green) is generated with the same gitops, network and keyvault settings and the values given with --set, e.g. a new kubernetes_version, and deployed. Once its nodes are ready and Flux synced the deployments the old cluster runs, the Traffic Manager endpoint or DNS record of the old cluster is pointed to the --ingress-service of the new one, and the old cluster is destroyed after confirmation.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		return Rotate(args[0])
+	},
+}
+
+func init() {
+	rotateCmd.Flags().StringVar(&rotateClusterName, "new-cluster-name", "", "Name of the new cluster (defaults to the name of the old cluster ending in -blue or -green)")
+	rotateCmd.Flags().StringArrayVar(&rotateSettings, "set", []string{}, "Value of the new cluster, as variable=value (can be repeated)")
+	rotateCmd.Flags().StringVar(&rotateRegion, "region", "", "Region of the new cluster (defaults to the region of the old cluster)")
+	rotateCmd.Flags().DurationVar(&rotateTimeout, "timeout", 30*time.Minute, "How long to wait for the new cluster to be ready")
+	rotateCmd.Flags().StringVar(&rotateIngressService, "ingress-service", "", "Load balancer service receiving the traffic, as namespace/name")
+	rotateCmd.Flags().StringVar(&rotateTMProfile, "traffic-manager-profile", "", "Traffic Manager profile to switch to the new cluster")
+	rotateCmd.Flags().StringVar(&rotateTMResourceGroup, "traffic-manager-resource-group", "", "Resource group of the Traffic Manager profile")
+	rotateCmd.Flags().StringVar(&rotateTMEndpoint, "traffic-manager-endpoint", "", "Endpoint of the old cluster (defaults to the endpoint targeting its ingress address)")
+	rotateCmd.Flags().StringVar(&rotateDNSZone, "dns-zone", "", "Azure DNS zone to switch to the new cluster")
+	rotateCmd.Flags().StringVar(&rotateDNSResourceGroup, "dns-resource-group", "", "Resource group of the DNS zone")
+	rotateCmd.Flags().StringVar(&rotateDNSRecord, "dns-record", "@", "A record of the DNS zone pointing to the cluster")
+	rotateCmd.Flags().BoolVar(&rotateYes, "yes", false, "Destroy the old cluster without asking for confirmation")
+	rotateCmd.Flags().IntVar(&deployParallelism, "parallelism", 4, "Number of environments deployed at the same time")
+	rotateCmd.Flags().StringVar(&policyDir, "policy-dir", "policies", "Directory with the policy rules the plans are checked against")
+	rotateCmd.Flags().StringVar(&namePrefix, "name-prefix", "", "Prefix added to the names of the Azure resources created")
+	rotateCmd.Flags().StringVar(&nameSuffix, "name-suffix", "", "Suffix added to the names of the Azure resources created")
+	rotateCmd.Flags().StringVar(&nameEnvironment, "name-environment", "", "Environment token (e.g. dev, prod) added to the names of the Azure resources created")
+	rotateCmd.Flags().StringArrayVar(&namePatternFlags, "name-pattern", []string{}, "Naming pattern of a resource, as role=pattern (e.g. keyvault={prefix}{name}-vault, can be repeated)")
+	rootCmd.AddCommand(rotateCmd)
+}
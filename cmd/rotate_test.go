@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rotateFixture creates a deployed azure-simple environment named prod
+func rotateFixture(t *testing.T, dir string) string {
+	path := dir + "/prod/" + SIMPLE
+	os.MkdirAll(path+"/output", os.ModePerm)
+	os.MkdirAll(path+"/.terraform", os.ModePerm)
+	generated := "agent_vm_count = \"3\"\ncluster_name = \"prod\"\ndns_prefix = \"prod-dns\"\ngitops_path = \"prod\"\ngitops_poll_interval = \"5m\"\ngitops_ssh_key = \"deploy-key\"\ngitops_ssh_url = \"git@github.com:org/manifests.git\"\ngitops_url_branch = \"master\"\nresource_group_name = \"prod-rg\"\nservice_principal_id = \"app\"\nservice_principal_secret = \"secret\"\nssh_public_key = \"ssh-rsa key\"\nvnet_name = \"prod-vnet\"\n"
+	ioutil.WriteFile(path+"/.bedrock-config.generated.tfvars", []byte(generated), 0644)
+	ioutil.WriteFile(path+"/bedrock-config.tfvars", []byte(generated+"kubernetes_version = \"1.15.7\"\n"), 0644)
+	ioutil.WriteFile(path+"/"+deployedTfvars, []byte(generated), 0644)
+	ioutil.WriteFile(path+"/bedrock-sp-config.toml", []byte("auth = \"sp\"\nservice_principal = \"app\"\nsecret = \"secret\"\nsubscription = \"sub\"\ntenant_id = \"tenant\"\n"), 0644)
+	ioutil.WriteFile(path+"/bedrock-backend-config.tfvars", []byte("access_key = \"key\"\ncontainer_name = \"tfstate\"\nkey = \"tfstate-azure-simple-prod\"\nstorage_account_name = \"state\"\n"), 0644)
+	ioutil.WriteFile(path+"/deploy-key", []byte("private"), 0600)
+	ioutil.WriteFile(path+"/terraform.tfstate", []byte("{}"), 0644)
+	ioutil.WriteFile(path+"/output/prod_kube_config", []byte("old"), 0644)
+	return dir + "/prod"
+}
+
+func TestRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old := rotateFixture(t, dir)
+
+	if siblingName("prod") != "prod-green" || siblingName("prod-green") != "prod-blue" || siblingName("prod-blue") != "prod-green" {
+		t.Error("Expected rotations to alternate between -blue and -green")
+	}
+
+	var groups []string
+	defer func(registry []EnvironmentType) { environmentRegistry = registry }(environmentRegistry)
+	environmentRegistry = nil
+	RegisterEnvironment(&templateEnvironment{
+		name:           SIMPLE,
+		backend:        true,
+		gitops:         true,
+		resourceGroups: func(clusterName string) error { groups = append(groups, clusterName+" "+region); return nil },
+		template:       azureSimpleTemplate,
+	})
+
+	var terraform []string
+	defer func(runner func(string, []string, io.Writer, ...string) error) { terraformRunner = runner }(terraformRunner)
+	terraformRunner = func(directory string, env []string, out io.Writer, args ...string) error {
+		terraform = append(terraform, strings.TrimPrefix(directory, dir+"/")+" "+args[0])
+		if args[0] == "apply" {
+			os.MkdirAll(directory+"/output", os.ModePerm)
+			ioutil.WriteFile(directory+"/output/prod-green_kube_config", []byte("new"), 0644)
+		}
+		return nil
+	}
+
+	// Flux syncs the web deployment of the old cluster on the second check
+	var az []string
+	checks := 0
+	defer func(runner func(string, ...string) ([]byte, error)) { rotateRunner = runner }(rotateRunner)
+	rotateRunner = func(name string, args ...string) ([]byte, error) {
+		command := strings.Join(args, " ")
+		newCluster := strings.Contains(command, "prod-green")
+		switch {
+		case name == "az" && args[0] == "group" && args[1] == "show":
+			return []byte("westus2\n"), nil
+		case name == "az" && args[1] == "public-ip":
+			return []byte("/subscriptions/sub/publicIPAddresses/prod-green-ip\n"), nil
+		case name == "az":
+			az = append(az, command)
+			return nil, nil
+		case strings.Contains(command, "get nodes"):
+			return []byte(`{"items": [{"metadata": {"name": "node-0"}, "status": {"conditions": [{"type": "Ready", "status": "True"}]}}]}`), nil
+		case strings.Contains(command, "get deployments") && newCluster:
+			checks++
+			if checks == 1 {
+				return []byte(`{"items": [{"metadata": {"namespace": "flux", "name": "flux"}, "status": {"availableReplicas": 1}}]}`), nil
+			}
+			fallthrough
+		case strings.Contains(command, "get deployments"):
+			return []byte(`{"items": [{"metadata": {"namespace": "flux", "name": "flux"}, "status": {"availableReplicas": 1}}, {"metadata": {"namespace": "app", "name": "web"}, "spec": {"replicas": 2}, "status": {"availableReplicas": 2}}, {"metadata": {"namespace": "kube-system", "name": "coredns"}, "status": {"availableReplicas": 1}}]}`), nil
+		case strings.Contains(command, "get service") && newCluster:
+			return []byte(`{"status": {"loadBalancer": {"ingress": [{"ip": "10.0.1.1"}]}}}`), nil
+		case strings.Contains(command, "get service"):
+			return []byte(`{"status": {"loadBalancer": {"ingress": [{"ip": "10.0.0.1"}]}}}`), nil
+		}
+		t.Fatalf("Unexpected command %s %s", name, command)
+		return nil, nil
+	}
+	defer func(runner func(string, ...string) ([]byte, error)) { statusRunner = runner }(statusRunner)
+	statusRunner = func(name string, args ...string) ([]byte, error) { return []byte(trafficManagerJSON), nil }
+
+	defer func(interval time.Duration) { rotatePollInterval = interval }(rotatePollInterval)
+	rotatePollInterval = 0
+	rotateTimeout = time.Minute
+	rotateSettings = []string{"kubernetes_version=1.16.7"}
+	rotateIngressService = "ingress/nginx"
+	rotateTMProfile, rotateTMResourceGroup = "my-cluster-tm", "my-cluster-tm-rg"
+	rotateDNSZone, rotateDNSResourceGroup, rotateDNSRecord = "example.com", "dns-rg", "www"
+	defer func() {
+		rotateSettings, rotateIngressService, rotateTMProfile, rotateDNSZone = nil, "", "", ""
+	}()
+	stdin = strings.NewReader("y\n")
+	defer func() { stdin = os.Stdin }()
+
+	// Only the resource group the CLI created for the old cluster is deleted
+	defer func(directory string) { journalDirectory = directory }(journalDirectory)
+	journalDirectory = dir + "/journals"
+	(&Journal{Path: journalDirectory + "/prod.json", Cluster: "prod"}).Record(RESOURCEGROUP, "prod-rg", "")
+
+	if err := Rotate(old); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new environment has the settings of the old one, with names of its own
+	config, err := ReadTfvarsFile(dir + "/prod-green/" + SIMPLE + "/bedrock-config.tfvars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"cluster_name":        resourceName("cluster", "prod-green"),
+		"resource_group_name": resourceName("resource-group", "prod-green"),
+		"dns_prefix":          resourceName("dns-prefix", "prod-green"),
+		"gitops_ssh_url":      "git@github.com:org/manifests.git",
+		"gitops_path":         "prod",
+		"agent_vm_count":      "3",
+		"kubernetes_version":  "1.16.7",
+	}
+	for setting, value := range expected {
+		if config[setting] != "\""+value+"\"" {
+			t.Errorf("Expected %s = %q, got %s", setting, value, config[setting])
+		}
+	}
+	backend, _ := ReadTfvarsFile(dir + "/prod-green/" + SIMPLE + "/bedrock-backend-config.tfvars")
+	if backend["storage_account_name"] != "\"state\"" || backend["key"] != "\"tfstate-azure-simple-prod-green\"" {
+		t.Errorf("Expected the new cluster to keep its state next to the old one, got %v", backend)
+	}
+	if !fileExists(dir+"/prod-green/"+SIMPLE+"/deploy-key") || fileExists(dir+"/prod-green/"+SIMPLE+"/terraform.tfstate") || fileExists(dir+"/prod-green/"+SIMPLE+"/.terraform") {
+		t.Error("Expected the deploy key to be copied without the state of the old cluster")
+	}
+	if len(groups) != 1 || groups[0] != "prod-green westus2" {
+		t.Errorf("Expected the resource group to be created in the region of the old cluster, got %v", groups)
+	}
+
+	if strings.Join(terraform, ", ") != "prod-green/azure-simple init, prod-green/azure-simple apply, prod/azure-simple init, prod/azure-simple destroy" {
+		t.Errorf("Unexpected terraform commands %v", terraform)
+	}
+	if checks != 2 {
+		t.Errorf("Expected to wait for Flux to sync the web deployment, checked %d times", checks)
+	}
+	expectedAz := []string{
+		"network traffic-manager endpoint update --name west --profile-name my-cluster-tm --resource-group my-cluster-tm-rg --type azureEndpoints --target-resource-id /subscriptions/sub/publicIPAddresses/prod-green-ip",
+		"network dns record-set a add-record --ipv4-address 10.0.1.1 --resource-group dns-rg --zone-name example.com --record-set-name www",
+		"network dns record-set a remove-record --ipv4-address 10.0.0.1 --keep-empty-record-set --resource-group dns-rg --zone-name example.com --record-set-name www",
+		"group delete --name prod-rg --yes",
+	}
+	if strings.Join(az, "\n") != strings.Join(expectedAz, "\n") {
+		t.Errorf("Unexpected az commands:\n%s", strings.Join(az, "\n"))
+	}
+	if fileExists(old + "/" + SIMPLE + "/" + deployedTfvars) {
+		t.Error("Expected the old cluster not to be reported as deployed")
+	}
+	if recorded, _ := ReadJournal(journalDirectory + "/prod.json"); recorded.Entries[0].Status != DELETED {
+		t.Error("Expected the deleted resource group to be recorded in the journal")
+	}
+
+	// The sibling exists now, rotating the old environment again needs another name
+	if err := Rotate(old); err == nil {
+		t.Error("Expected an existing sibling environment to be rejected")
+	}
+}
@@ -0,0 +1,776 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/yradsmikham/bedrock-cli/pkg/bedrock"
+	"github.com/yradsmikham/bedrock-cli/util"
+)
+
+var serveAddress string
+var serveToken string
+var serveJobsDir string
+
+// Operations of the jobs run by bedrock serve
+const (
+	JOBCREATE   = "create"
+	JOBSIMULATE = "simulate"
+	JOBDEPLOY   = "deploy"
+	JOBDESTROY  = "destroy"
+)
+
+// Status of a job
+const (
+	JOBQUEUED    = "queued"
+	JOBRUNNING   = "running"
+	JOBSUCCEEDED = "succeeded"
+	JOBFAILED    = "failed"
+)
+
+// Flags that a job cannot set: the name of the environment comes from the request, and a job works
+// on one environment at a time
+var jobExcludedFlags = map[string]bool{"cluster-name": true, "all": true, "interactive": true}
+
+// Flags whose values are not written to the job store
+var jobSecretFlags = map[string]bool{"secret": true, "access-key": true}
+
+var environmentNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// Job is an operation on an environment requested through bedrock serve
+type Job struct {
+	ID          string              `json:"id"`
+	Operation   string              `json:"operation"`
+	Environment string              `json:"environment"`    // Name of the environment directory, e.g. my-cluster
+	Type        string              `json:"type,omitempty"` // Environment type created by a create job
+	Flags       map[string][]string `json:"flags,omitempty"`
+	Status      string              `json:"status"`
+	Error       string              `json:"error,omitempty"`
+	Created     time.Time           `json:"created"`
+	Started     *time.Time          `json:"started,omitempty"`
+	Finished    *time.Time          `json:"finished,omitempty"`
+
+	flags map[string][]string // Flags with the secrets, which are only kept in memory
+}
+
+// Done tells whether the job is over
+func (job *Job) Done() bool {
+	return job.Status == JOBSUCCEEDED || job.Status == JOBFAILED
+}
+
+// JobLogEntry is a log message of a job
+type JobLogEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+}
+
+// jobStore keeps the jobs in a directory, as <id>.json and the log entries of <id>.log. Jobs run one
+// at a time, the environment commands share the flag variables of the CLI.
+type jobStore struct {
+	dir     string
+	mutex   sync.Mutex
+	jobs    map[string]*Job
+	running *Job
+	changed chan struct{} // Closed and replaced when a job changes or logs
+	queue   chan *Job
+}
+
+// openJobStore loads the jobs of a directory. Jobs that were queued or running when the server
+// stopped are marked as failed.
+func openJobStore(dir string) (store *jobStore, err error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	store = &jobStore{dir: dir, jobs: map[string]*Job{}, changed: make(chan struct{}), queue: make(chan *Job, 100)}
+	files, err := filepath.Glob(dir + "/*.json")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		job := &Job{}
+		if err := json.Unmarshal(content, job); err != nil {
+			log.Warn(emoji.Sprintf(":warning: Skipping job %s: %s", file, err))
+			continue
+		}
+		if !job.Done() {
+			job.Status, job.Error = JOBFAILED, "Interrupted by a restart of bedrock serve"
+			if err := store.save(job); err != nil {
+				return nil, err
+			}
+		}
+		store.jobs[job.ID] = job
+	}
+	return store, err
+}
+
+// save writes a job to the store, replacing the previous version at once. Called with the mutex held.
+func (store *jobStore) save(job *Job) error {
+	content, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+	filename := store.dir + "/" + job.ID + ".json"
+	if err := ioutil.WriteFile(filename+".tmp", content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// notify wakes up the log streams. Called with the mutex held.
+func (store *jobStore) notify() {
+	close(store.changed)
+	store.changed = make(chan struct{})
+}
+
+// Submit records a job and queues it
+func (store *jobStore) Submit(job *Job) (err error) {
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	job.ID = time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(id)
+	job.Status, job.Created = JOBQUEUED, time.Now()
+	job.Flags = map[string][]string{}
+	for flag, values := range job.flags {
+		job.Flags[flag] = values
+		if jobSecretFlags[flag] {
+			job.Flags[flag] = []string{"********"}
+		}
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.save(job); err != nil {
+		return err
+	}
+	select {
+	case store.queue <- job:
+	default:
+		os.Remove(store.dir + "/" + job.ID + ".json")
+		return errors.New("Too many queued jobs, try again later")
+	}
+	store.jobs[job.ID] = job
+	return err
+}
+
+// Get returns a copy of a job, and the channel closed on its next change
+func (store *jobStore) Get(id string) (job Job, changed <-chan struct{}, ok bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if found, ok := store.jobs[id]; ok {
+		return *found, store.changed, ok
+	}
+	return job, store.changed, false
+}
+
+// List returns copies of the jobs, newest first
+func (store *jobStore) List() (jobs []Job) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	jobs = []Job{}
+	for _, job := range store.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.After(jobs[j].Created) })
+	return jobs
+}
+
+// update changes a job and saves it
+func (store *jobStore) update(job *Job, change func()) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	change()
+	if err := store.save(job); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to save job %s: %s\n", job.ID, err)
+	}
+	store.notify()
+}
+
+// Log adds an entry to the log of the running job, entries logged between jobs are dropped
+func (store *jobStore) Log(entry JobLogEntry) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.running == nil {
+		return
+	}
+	line, _ := json.Marshal(entry)
+	file, err := os.OpenFile(store.dir+"/"+store.running.ID+".log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	file.Write(append(line, '\n'))
+	store.notify()
+}
+
+// Logs returns the log entries of a job from the given line of its log on, and the next line
+func (store *jobStore) Logs(id string, from int) (entries []JobLogEntry, next int, err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	file, err := os.Open(store.dir + "/" + id + ".log")
+	if os.IsNotExist(err) {
+		return nil, from, nil
+	} else if err != nil {
+		return nil, from, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for ; scanner.Scan(); next++ {
+		var entry JobLogEntry
+		if next >= from && json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	if next < from {
+		next = from
+	}
+	return entries, next, scanner.Err()
+}
+
+// Work runs the queued jobs one after the other
+func (store *jobStore) Work() {
+	for job := range store.queue {
+		store.update(job, func() {
+			started := time.Now()
+			job.Status, job.Started = JOBRUNNING, &started
+			store.running = job
+		})
+		err := runJob(job)
+		store.update(job, func() {
+			finished := time.Now()
+			job.Status, job.Finished = JOBSUCCEEDED, &finished
+			if err != nil {
+				job.Status, job.Error = JOBFAILED, err.Error()
+			}
+			store.running = nil
+		})
+	}
+}
+
+// jobLogHook copies the log entries of the CLI to the log of the running job
+type jobLogHook struct {
+	store *jobStore
+}
+
+func (hook *jobLogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (hook *jobLogHook) Fire(entry *log.Entry) error {
+	hook.store.Log(JobLogEntry{Time: entry.Time, Level: entry.Level.String(), Message: entry.Message})
+	return nil
+}
+
+// resetFlags sets the flags of a command back to their defaults, so that a job does not get the
+// settings of the previous one
+func resetFlags(cmd *cobra.Command) {
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			slice.Replace([]string{})
+		} else {
+			flag.Value.Set(flag.DefValue)
+		}
+		flag.Changed = false
+	})
+}
+
+// resetJobState sets the flags of the commands, and the variables Init and Simulate fill in as they go,
+// back to how a new bedrock process starts. Commands share variables, e.g. --storage-account of
+// azure-single-keyvault is read by the init of every environment, so resetting the flags of the job's
+// command is not enough. The flags of the root and serve commands are the settings of the server.
+func resetJobState() {
+	var reset func(cmd *cobra.Command)
+	reset = func(cmd *cobra.Command) {
+		for _, child := range cmd.Commands() {
+			if child.Name() != "serve" {
+				resetFlags(child)
+				reset(child)
+			}
+		}
+	}
+	reset(rootCmd)
+	SSHKey, randomClusterName, subnet, resources = "", "", "", nil
+	journal, createdServicePrincipal = nil, nil
+	naming, tags, deployPolicies = NamingConvention{}, nil, nil
+}
+
+// jobCommand returns the command a job runs, with its arguments
+func jobCommand(job *Job) (cmd *cobra.Command, args []string, err error) {
+	switch job.Operation {
+	case JOBCREATE:
+		environment, ok := GetEnvironment(job.Type)
+		if !ok {
+			return nil, nil, fmt.Errorf("Unsupported environment %s, use one of: %s", job.Type, strings.Join(environmentNames(), ", "))
+		}
+		return environment.Command(), nil, err
+	case JOBSIMULATE:
+		return simulateCmd, []string{serveRoot + "/" + job.Environment}, err
+	case JOBDEPLOY:
+		return deployCmd, []string{serveRoot + "/" + job.Environment}, err
+	case JOBDESTROY:
+		return nil, nil, err
+	}
+	return nil, nil, fmt.Errorf("Unsupported operation %s", job.Operation)
+}
+
+// checkJobFlags returns an error for the flags that the command of a job does not have
+func checkJobFlags(job *Job) (err error) {
+	cmd, _, err := jobCommand(job)
+	if err != nil {
+		return err
+	}
+	for flag := range job.flags {
+		if cmd == nil || cmd.Flags().Lookup(flag) == nil || jobExcludedFlags[flag] {
+			return fmt.Errorf("Unsupported flag %s for %s", flag, job.Operation)
+		}
+	}
+	return err
+}
+
+// runJob runs the command of a job with its flags, as the CLI would
+func runJob(job *Job) (err error) {
+	// Errors that would end the CLI fail the job instead
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	// The commands set ARM_* variables for terraform, they must not leak into the next job
+	defer restoreEnvironment(os.Environ())
+	// Nobody answers prompts, a failed creation only rolls back with --rollback-on-failure
+	defer func(reader io.Reader) { stdin = reader }(stdin)
+	stdin = strings.NewReader("")
+
+	resetJobState()
+	if job.Operation == JOBDESTROY {
+		return destroyAll([]string{serveRoot + "/" + job.Environment})
+	}
+	cmd, args, err := jobCommand(job)
+	if err != nil {
+		return err
+	}
+	resetFlags(cmd)
+	if job.Operation == JOBCREATE {
+		job.flags["cluster-name"] = []string{job.Environment}
+	}
+	for flag, values := range job.flags {
+		for _, value := range values {
+			if err := cmd.Flags().Set(flag, value); err != nil {
+				return fmt.Errorf("Invalid value for %s: %s", flag, err)
+			}
+		}
+	}
+
+	// The checks and hooks cobra runs before a command, which reads the config file
+	if err := cmd.ValidateArgs(args); err != nil {
+		return err
+	}
+	for parent := cmd; parent != nil; parent = parent.Parent() {
+		if parent.PersistentPreRunE != nil {
+			if err := parent.PersistentPreRunE(cmd, args); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	if err := cmd.ValidateRequiredFlags(); err != nil {
+		return err
+	}
+	return cmd.RunE(cmd, args)
+}
+
+// restoreEnvironment sets the variables of the process back to a snapshot taken with os.Environ. Variables
+// are changed one by one rather than cleared, so that commands started meanwhile still find PATH.
+func restoreEnvironment(environ []string) {
+	saved := map[string]string{}
+	for _, variable := range environ {
+		pair := strings.SplitN(variable, "=", 2)
+		saved[pair[0]] = pair[1]
+	}
+	for _, variable := range os.Environ() {
+		if name := strings.SplitN(variable, "=", 2)[0]; name != "" {
+			if _, ok := saved[name]; !ok {
+				os.Unsetenv(name)
+			}
+		}
+	}
+	for name, value := range saved {
+		if current, ok := os.LookupEnv(name); !ok || current != value {
+			os.Setenv(name, value)
+		}
+	}
+}
+
+// destroyAll destroys the environments of environment directories, dependents first
+func destroyAll(names []string) (err error) {
+	tasks, err := deploymentGraph(names)
+	if err != nil {
+		return err
+	}
+	if len(tasks) == 0 {
+		return errors.New("No environments to destroy were found in " + strings.Join(names, ", "))
+	}
+	var order []*deployTask
+	runDeploymentGraph(tasks, 1, func(task *deployTask) error {
+		order = append(order, task)
+		return nil
+	})
+	for i := len(order) - 1; i >= 0; i-- {
+		log.Info(emoji.Sprintf(":bomb: Destroying %s", order[i].ID))
+		if err := destroyEnvironment(order[i]); err != nil {
+			return err
+		}
+	}
+	log.Info(emoji.Sprintf(":white_check_mark: Destroyed %s", strings.Join(names, ", ")))
+	return err
+}
+
+// serveRoot is the directory of the environments driven by bedrock serve, replaced in tests
+var serveRoot = "bedrock/cluster/environments"
+
+// serveTerraformRunner runs terraform for the status requests with its whole environment, replaced in tests
+var serveTerraformRunner = util.TerraformRunEnviron
+
+// server is the HTTP/JSON API of bedrock serve
+type server struct {
+	store   *jobStore
+	token   string
+	environ []string // Variables of the process when the server started, before any job changed them
+}
+
+// client returns the client of the status and list requests. They run while a job changes the variables
+// of the process, so terraform is given the variables the server started with instead.
+func (s *server) client() *bedrock.Client {
+	return bedrock.New(
+		bedrock.WithTerraform(bedrock.TerraformFunc(func(ctx context.Context, directory string, env []string, out io.Writer, args ...string) error {
+			return serveTerraformRunner(directory, append(append([]string{}, s.environ...), env...), out, args...)
+		})),
+		bedrock.WithEvents(logEvent),
+	)
+}
+
+// EnvironmentSummary is an environment directory and the state of its environments
+type EnvironmentSummary struct {
+	Name         string                  `json:"name"`
+	Environments []*bedrock.StatusResult `json:"environments"`
+}
+
+// jobRequest is the body of the requests starting a job
+type jobRequest struct {
+	Name  string                 `json:"name"`
+	Type  string                 `json:"type"`
+	Flags map[string]interface{} `json:"flags"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// flagValues turns the JSON value of a flag into the values given on the command line
+func flagValues(value interface{}) (values []string, err error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, err
+	case bool:
+		return []string{strconv.FormatBool(v)}, err
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}, err
+	case []interface{}:
+		for _, item := range v {
+			itemValues, err := flagValues(item)
+			if err != nil || len(itemValues) != 1 {
+				return nil, errors.New("lists can only hold strings, numbers and booleans")
+			}
+			values = append(values, itemValues...)
+		}
+		return values, err
+	}
+	return nil, errors.New("use a string, number, boolean or list")
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("Missing or invalid bearer token"))
+			return
+		}
+	}
+	// The API is not for browsers: a web page could otherwise drive the environments through the loopback
+	if r.Header.Get("Origin") != "" {
+		writeError(w, http.StatusForbidden, errors.New("Requests from browsers are not accepted"))
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); r.Method == http.MethodPost && mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, errors.New("Requests need a Content-Type: application/json header"))
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "v1" {
+		writeError(w, http.StatusNotFound, errors.New("Not found"))
+		return
+	}
+	switch {
+	case parts[1] == "environments" && len(parts) == 2 && r.Method == http.MethodGet:
+		s.listEnvironments(w, r)
+	case parts[1] == "environments" && len(parts) == 2 && r.Method == http.MethodPost:
+		s.submit(w, r, JOBCREATE, "")
+	case parts[1] == "environments" && len(parts) == 3 && r.Method == http.MethodGet:
+		s.environmentStatus(w, r, parts[2])
+	case parts[1] == "environments" && len(parts) == 4 && r.Method == http.MethodPost && (parts[3] == JOBSIMULATE || parts[3] == JOBDEPLOY || parts[3] == JOBDESTROY):
+		s.submit(w, r, parts[3], parts[2])
+	case parts[1] == "jobs" && len(parts) == 2 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.store.List())
+	case parts[1] == "jobs" && len(parts) == 3 && r.Method == http.MethodGet:
+		if job, _, ok := s.store.Get(parts[2]); ok {
+			writeJSON(w, http.StatusOK, job)
+		} else {
+			writeError(w, http.StatusNotFound, errors.New("No job "+parts[2]))
+		}
+	case parts[1] == "jobs" && len(parts) == 4 && parts[3] == "logs" && r.Method == http.MethodGet:
+		s.streamLogs(w, r, parts[2])
+	default:
+		writeError(w, http.StatusNotFound, errors.New("Not found"))
+	}
+}
+
+// environmentSummary returns the state of the environments of an environment directory
+func environmentSummary(client *bedrock.Client, name string, outputs bool) (summary *EnvironmentSummary, err error) {
+	environments, err := environmentsIn(serveRoot + "/" + name)
+	if err != nil || len(environments) == 0 {
+		return nil, err
+	}
+	summary = &EnvironmentSummary{Name: name}
+	for _, environment := range environments {
+		task := &deployTask{Cluster: serveRoot + "/" + name, Environment: environment}
+		status, err := client.Status(context.Background(), task.apiEnvironment(), bedrock.StatusOptions{Outputs: outputs})
+		if err != nil {
+			return nil, err
+		}
+		summary.Environments = append(summary.Environments, status)
+	}
+	return summary, err
+}
+
+func (s *server) listEnvironments(w http.ResponseWriter, r *http.Request) {
+	summaries := []*EnvironmentSummary{}
+	directories, _ := ioutil.ReadDir(serveRoot)
+	for _, directory := range directories {
+		if !directory.IsDir() {
+			continue
+		}
+		summary, err := environmentSummary(s.client(), directory.Name(), false)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if summary != nil {
+			summaries = append(summaries, summary)
+		}
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (s *server) environmentStatus(w http.ResponseWriter, r *http.Request, name string) {
+	if !environmentNameRegex.MatchString(name) {
+		writeError(w, http.StatusBadRequest, errors.New("Invalid environment name "+name))
+		return
+	}
+	summary, err := environmentSummary(s.client(), name, r.URL.Query().Get("outputs") == "true")
+	switch {
+	case err != nil && !os.IsNotExist(err):
+		writeError(w, http.StatusInternalServerError, err)
+	case summary == nil:
+		writeError(w, http.StatusNotFound, errors.New("No environment "+name))
+	default:
+		writeJSON(w, http.StatusOK, summary)
+	}
+}
+
+// submit queues a job for an environment, or for the environment named in the body for create
+func (s *server) submit(w http.ResponseWriter, r *http.Request, operation string, name string) {
+	var request jobRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid request body: %s", err))
+			return
+		}
+	}
+	job := &Job{Operation: operation, Environment: name, Type: request.Type, flags: map[string][]string{}}
+	if operation == JOBCREATE {
+		job.Environment = request.Name
+	}
+	if !environmentNameRegex.MatchString(job.Environment) {
+		writeError(w, http.StatusBadRequest, errors.New("Invalid environment name '"+job.Environment+"'"))
+		return
+	}
+	if environments, _ := environmentsIn(serveRoot + "/" + job.Environment); operation != JOBCREATE && len(environments) == 0 {
+		writeError(w, http.StatusNotFound, errors.New("No environment "+job.Environment))
+		return
+	}
+	for flag, value := range request.Flags {
+		values, err := flagValues(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid value for %s: %s", flag, err))
+			return
+		}
+		job.flags[flag] = values
+	}
+	if err := checkJobFlags(job); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.store.Submit(job); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	created, _, _ := s.store.Get(job.ID)
+	writeJSON(w, http.StatusAccepted, created)
+}
+
+// streamLogs sends the log entries of a job as server-sent events until the job is done. Each entry
+// is a "log" event, the job is sent as a "status" event when it changes.
+func (s *server) streamLogs(w http.ResponseWriter, r *http.Request, id string) {
+	_, _, ok := s.store.Get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("No job "+id))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("Streaming is not supported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	sent, status := 0, ""
+	for {
+		job, changed, _ := s.store.Get(id)
+		entries, next, err := s.store.Logs(id, sent)
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			data, _ := json.Marshal(entry)
+			fmt.Fprintf(w, "event: log\ndata: %s\n\n", data)
+		}
+		sent = next
+		if job.Status != status {
+			data, _ := json.Marshal(job)
+			fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+			status = job.Status
+		}
+		flusher.Flush()
+		if job.Done() {
+			return
+		}
+		select {
+		case <-changed:
+		case <-time.After(15 * time.Second):
+			// Keeps proxies from closing an idle stream
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// loopback tells whether an address only accepts local connections
+func loopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Serve runs the HTTP/JSON API driving the environments until the server fails
+func Serve(address string, token string, jobsDir string) (err error) {
+	if token == "" && !loopback(address) {
+		return errors.New("Serving on " + address + " needs a token, use --token or BEDROCK_SERVE_TOKEN")
+	}
+	environ := os.Environ()
+	store, err := openJobStore(jobsDir)
+	if err != nil {
+		return err
+	}
+	log.AddHook(&jobLogHook{store: store})
+	// log.Fatal fails the job instead of stopping the server
+	log.StandardLogger().ExitFunc = func(code int) {
+		panic(fmt.Sprintf("exit status %d", code))
+	}
+	go store.Work()
+
+	if token == "" {
+		log.Warn(emoji.Sprintf(":warning: No token given, any local process can drive the environments"))
+	}
+	log.Info(emoji.Sprintf(":satellite: Serving the bedrock API on http://%s, jobs are kept in %s", address, jobsDir))
+	return http.ListenAndServe(address, &server{store: store, token: token, environ: environ})
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [--listen address] [--token token] [--jobs-dir directory]",
+	Short: "Serve an HTTP/JSON API to create, simulate, deploy and destroy environments",
+	Long:  `Serve an HTTP/JSON API to create, simulate, deploy, destroy, list and check the status of environments from other tools. Operations run as jobs, one at a time, through the same code as the commands. Jobs and their logs are kept in --jobs-dir, and the logs of a job can be followed as server-sent events. Requests need an 'Authorization: Bearer <token>' header when --token or BEDROCK_SERVE_TOKEN is set, which is required to listen on other addresses than the loopback. Requests from browsers are rejected, and POST requests need a Content-Type: application/json header.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		token := serveToken
+		if token == "" {
+			token = os.Getenv("BEDROCK_SERVE_TOKEN")
+		}
+		return Serve(serveAddress, token, serveJobsDir)
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveAddress, "listen", "127.0.0.1:8080", "Address the API listens on")
+	serveCmd.Flags().StringVar(&serveToken, "token", "", "Bearer token the requests need (defaults to BEDROCK_SERVE_TOKEN)")
+	serveCmd.Flags().StringVar(&serveJobsDir, "jobs-dir", "bedrock-jobs", "Directory the jobs and their logs are kept in")
+	rootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func TestServe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(registry []EnvironmentType) { environmentRegistry = registry }(environmentRegistry)
+	environmentRegistry = nil
+	RegisterEnvironment(&templateEnvironment{name: "test-common", backend: true})
+	RegisterEnvironment(&templateEnvironment{name: "test-app", dependencies: []string{"test-common"}})
+	deployFixture(t, dir, map[string][]string{"one": {"test-common", "test-app"}})
+	defer func(root string) { serveRoot = root }(serveRoot)
+	serveRoot = dir
+
+	var lock sync.Mutex
+	var calls []string
+	defer func(runner func(string, []string, io.Writer, ...string) error) { terraformRunner = runner }(terraformRunner)
+	terraformRunner = func(directory string, env []string, out io.Writer, args ...string) error {
+		lock.Lock()
+		defer lock.Unlock()
+		calls = append(calls, strings.TrimPrefix(directory, dir+"/")+" "+args[0])
+		os.Setenv("BEDROCK_SERVE_TEST_LEAK", "job")
+		out.Write([]byte("output of " + args[0] + "\n"))
+		return nil
+	}
+
+	store, err := openJobStore(dir + "/jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.StandardLogger().ReplaceHooks(log.StandardLogger().ReplaceHooks(log.LevelHooks{}))
+	log.AddHook(&jobLogHook{store: store})
+	go store.Work()
+	defer close(store.queue)
+	var statusEnviron []string
+	defer func(runner func(string, []string, io.Writer, ...string) error) { serveTerraformRunner = runner }(serveTerraformRunner)
+	serveTerraformRunner = func(directory string, environ []string, out io.Writer, args ...string) error {
+		statusEnviron = environ
+		out.Write([]byte("{}"))
+		return nil
+	}
+	api := httptest.NewServer(&server{store: store, token: "secret-token", environ: []string{"ARM_CLIENT_ID=server"}})
+	defer api.Close()
+
+	request := func(method string, path string, body string, result interface{}) int {
+		req, _ := http.NewRequest(method, api.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret-token")
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if result != nil {
+			json.NewDecoder(resp.Body).Decode(result)
+		}
+		return resp.StatusCode
+	}
+	wait := func(id string) (job Job) {
+		for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+			if request("GET", "/v1/jobs/"+id, "", &job); job.Done() {
+				return job
+			}
+		}
+		t.Fatalf("Job %s did not finish", id)
+		return job
+	}
+
+	if resp, err := http.Get(api.URL + "/v1/environments"); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a request without the token to be rejected, got %v %v", resp.StatusCode, err)
+	}
+
+	// Without a token, requests a web page could send are rejected
+	noToken := httptest.NewServer(&server{store: store})
+	defer noToken.Close()
+	if resp, err := http.Post(noToken.URL+"/v1/environments/one/deploy", "text/plain", strings.NewReader("{}")); err != nil || resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected a request that is not JSON to be rejected, got %v %v", resp.StatusCode, err)
+	}
+	req, _ := http.NewRequest("GET", noToken.URL+"/v1/environments", nil)
+	req.Header.Set("Origin", "https://example.com")
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a request from a browser to be rejected, got %v %v", resp.StatusCode, err)
+	}
+
+	var environments []EnvironmentSummary
+	if status := request("GET", "/v1/environments", "", &environments); status != http.StatusOK || len(environments) != 1 || len(environments[0].Environments) != 2 || environments[0].Environments[0].Deployed {
+		t.Fatalf("Unexpected environments %d %+v", status, environments)
+	}
+
+	var job Job
+	if status := request("POST", "/v1/environments/one/deploy", `{"flags": {"unknown": "value"}}`, nil); status != http.StatusBadRequest {
+		t.Errorf("Expected an unknown flag to be rejected, got %d", status)
+	}
+	if status := request("POST", "/v1/environments/other/deploy", "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected a missing environment to be rejected, got %d", status)
+	}
+	if status := request("POST", "/v1/environments/one/deploy", `{"flags": {"parallelism": 1}}`, &job); status != http.StatusAccepted || job.Status != JOBQUEUED {
+		t.Fatalf("Unexpected response %d %+v", status, job)
+	}
+	if job = wait(job.ID); job.Status != JOBSUCCEEDED || job.Flags["parallelism"][0] != "1" {
+		t.Fatalf("Expected the deploy to succeed, got %+v", job)
+	}
+	if _, leaked := os.LookupEnv("BEDROCK_SERVE_TEST_LEAK"); leaked {
+		t.Error("Expected the variables set by a job to be restored after it")
+	}
+
+	// The log of a finished job is replayed, followed by its status
+	resp, err := http.NewRequest("GET", api.URL+"/v1/jobs/"+job.ID+"/logs", nil)
+	resp.Header.Set("Authorization", "Bearer secret-token")
+	stream, err := http.DefaultClient.Do(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, _ := ioutil.ReadAll(stream.Body)
+	stream.Body.Close()
+	if stream.Header.Get("Content-Type") != "text/event-stream" || !bytes.Contains(events, []byte("[one/test-app] output of apply")) || !bytes.Contains(events, []byte("event: status\ndata: {\"id\":\""+job.ID)) {
+		t.Errorf("Unexpected log stream:\n%s", events)
+	}
+
+	var summary EnvironmentSummary
+	if request("GET", "/v1/environments/one", "", &summary); len(summary.Environments) != 2 || !summary.Environments[0].Deployed || !summary.Environments[1].Deployed {
+		t.Errorf("Expected the environments to be deployed, got %+v", summary)
+	}
+
+	// Terraform gets the variables the server started with, not the ones a running job sets
+	defer restoreEnvironment(os.Environ())
+	os.Setenv("ARM_CLIENT_ID", "job")
+	if status := request("GET", "/v1/environments/one?outputs=true", "", &summary); status != http.StatusOK {
+		t.Fatalf("Unexpected status %d", status)
+	}
+	if environ := strings.Join(statusEnviron, " "); !strings.Contains(environ, "ARM_CLIENT_ID=server") || strings.Contains(environ, "ARM_CLIENT_ID=job") {
+		t.Errorf("Expected the status to run terraform with the variables of the server, got %v", statusEnviron)
+	}
+
+	lock.Lock()
+	calls = nil
+	lock.Unlock()
+	request("POST", "/v1/environments/one/destroy", "", &job)
+	if job = wait(job.ID); job.Status != JOBSUCCEEDED {
+		t.Fatalf("Expected the destroy to succeed, got %+v", job)
+	}
+	if strings.Join(calls, ", ") != "one/test-app init, one/test-app destroy, one/test-common init, one/test-common destroy" {
+		t.Errorf("Expected the dependents to be destroyed first, got %v", calls)
+	}
+
+	var jobs []Job
+	if request("GET", "/v1/jobs", "", &jobs); len(jobs) != 2 || jobs[0].Operation != JOBDESTROY {
+		t.Errorf("Expected the jobs newest first, got %+v", jobs)
+	}
+
+	// Jobs interrupted by a restart are failed when the store is opened again
+	store.update(&jobs[0], func() { jobs[0].Status = JOBRUNNING })
+	reopened, err := openJobStore(dir + "/jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if interrupted, _, ok := reopened.Get(jobs[0].ID); !ok || interrupted.Status != JOBFAILED || len(reopened.List()) != 2 {
+		t.Errorf("Expected the running job to be failed, got %+v", interrupted)
+	}
+
+	if loopback("0.0.0.0:8080") || !loopback("127.0.0.1:8080") || Serve("0.0.0.0:0", "", dir+"/jobs") == nil {
+		t.Error("Expected a token to be required on other addresses than the loopback")
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	defer func(registry []EnvironmentType) { environmentRegistry = registry }(environmentRegistry)
+	environmentRegistry = nil
+	var hooked, ran, confirmed bool
+	parent := &cobra.Command{Use: "bedrock", PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		hooked = true
+		return nil
+	}}
+	command := &cobra.Command{Use: "test-create", RunE: func(cmd *cobra.Command, args []string) error {
+		ran, confirmed = true, confirm("Would you like to delete the resources that were created?")
+		return nil
+	}}
+	command.Flags().String("cluster-name", "", "")
+	command.Flags().String("gitops-ssh-url", "", "")
+	command.MarkFlagRequired("gitops-ssh-url")
+	parent.AddCommand(command)
+	RegisterEnvironment(&templateEnvironment{name: "test-create", command: command})
+
+	// Jobs go through the checks of the CLI
+	job := &Job{Operation: JOBCREATE, Type: "test-create", Environment: "new", flags: map[string][]string{}}
+	if err := runJob(job); err == nil || ran {
+		t.Error("Expected a job without a required flag to be rejected")
+	}
+
+	// Prompts are not read from the stdin of the server
+	defer func() { stdin = os.Stdin }()
+	stdin = strings.NewReader("y\n")
+	job.flags["gitops-ssh-url"] = []string{"git@github.com:org/repo.git"}
+	if err := runJob(job); err != nil || !hooked || !ran || confirmed {
+		t.Errorf("Expected the job to run after the pre-run hook without answering prompts, got %v", err)
+	}
+}
+
+func TestRunJobState(t *testing.T) {
+	defer func(registry []EnvironmentType) { environmentRegistry = registry }(environmentRegistry)
+	environmentRegistry = nil
+	defer func(file string) { configFile = file }(configFile)
+	configFile = "server.yaml"
+	defer resetJobState()
+
+	var region string
+	seen := map[string][]string{}
+	command := &cobra.Command{Use: "test-create", RunE: func(cmd *cobra.Command, args []string) error {
+		name, _ := cmd.Flags().GetString("cluster-name")
+		seen[name] = []string{region, storageAccount, accessKey, subnet}
+		// As GetEnvVariables and Init do with the storage account and the subnet they create
+		storageAccount, accessKey, subnet = name+"account", name+"-key", name+"-subnet"
+		return nil
+	}}
+	command.Flags().String("cluster-name", "", "")
+	command.Flags().StringVar(&region, "region", "westus2", "")
+	RegisterEnvironment(&templateEnvironment{name: "test-create", command: command})
+
+	first := &Job{Operation: JOBCREATE, Type: "test-create", Environment: "one", flags: map[string][]string{"region": {"eastus"}}}
+	second := &Job{Operation: JOBCREATE, Type: "test-create", Environment: "two", flags: map[string][]string{}}
+	if err := runJob(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := runJob(second); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(seen["one"], ",") != "eastus,,," || strings.Join(seen["two"], ",") != "westus2,,," {
+		t.Errorf("Expected each job to start from the defaults, got %v", seen)
+	}
+	if configFile != "server.yaml" {
+		t.Errorf("Expected the settings of the server to be kept, got config file %q", configFile)
+	}
+}
@@ -1,131 +1,125 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"strings"
 
 	"github.com/kyokomi/emoji"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
-	util "github.com/yradsmikham/bedrock-cli/util"
+	"github.com/yradsmikham/bedrock-cli/pkg/bedrock"
 )
 
-func setEnv(name string, env string) {
-	// must retreive environment variables from bedrock-config and set them as environment variables
-	viper.SetConfigName("bedrock-sp-config") // name of config file (without extension)
-	viper.AddConfigPath(name + "/" + env)    // path to look for the config file in
+// simulatePlan is the file the plan is saved to while it is checked and its cost estimated
+const simulatePlan = "bedrock-simulate.tfplan"
 
-	errr := viper.ReadInConfig() // Find and read the config file
-	if errr != nil {             // Handle errors reading the config file
-		panic(fmt.Errorf("Fatal error config file: %s", errr))
+// authEnvironment returns the variables terraform needs to authenticate for an environment, from its
+// bedrock-sp-config.toml. Variables that need to be cleared have an empty value.
+func authEnvironment(name string, env string) (variables []string, err error) {
+	credentials, err := bedrock.ReadCredentials(name + "/" + env + "/" + bedrock.CredentialsFile)
+	if err != nil {
+		return nil, err
 	}
-	log.Info(emoji.Sprintf(":arrows_clockwise: Setting Environments Variables..."))
-	os.Setenv("ARM_SUBSCRIPTION_ID", viper.GetString("subscription"))
-	os.Setenv("ARM_CLIENT_ID", viper.GetString("service_principal"))
-	os.Setenv("ARM_CLIENT_SECRET", viper.GetString("secret"))
-	os.Setenv("ARM_TENANT_ID", viper.GetString("tenant_id"))
+	return credentials.Environ(), err
 }
 
 // Simulate or dry-run a bedrock environment creation (azure simple, multi-cluster, keyvault, etc.)
 func Simulate(name string) (err error) {
 	log.Info(emoji.Sprintf(":beginner: Starting Environment Deployment Simulation!"))
 
-	files, err := ioutil.ReadDir(name)
+	var pricing *PricingTable
+	var estimates []CostEstimate
+	if costEstimate {
+		if pricing, err = loadPricingTable(pricingFile); err != nil {
+			return err
+		}
+	}
+	policies, err := loadPolicies(policyDir)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	for _, f := range files {
-		log.Info(emoji.Sprintf(":eyes: Searching for Azure-Common-Infra environment..."))
-		if f.Name() == COMMON {
-			log.Info(emoji.Sprintf(":round_pushpin: Azure-Common-Infra environment found!"))
-			log.Info(emoji.Sprintf(":dancers: Simulating Azure-Common-Infra Environment"))
-			setEnv(name, COMMON)
-
-			// Terraform Init
-			if error := util.TerraformInitBackend(name + "/azure-common-infra"); error != nil {
-				return error
-			}
-
-			// Terraform Plan
-			if error := util.TerraformPlan(name + "/azure-common-infra"); error != nil {
-				return error
-			}
+	var policyErrors []string
 
-			break
-		}
+	environments, err := environmentsIn(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	found := map[string]EnvironmentType{}
+	for _, environment := range environments {
+		found[environment.Name()] = environment
 	}
 
-	// Run Terraform Init on everything else (e.g. azure-single-keyvault, azure-multi-cluster)
-	for _, f := range files {
-		if f.Name() == SIMPLE {
-			log.Info(emoji.Sprintf(":dancers: Simulating Azure-Simple Environment"))
-			setEnv(name, SIMPLE)
-
-			// Terraform Init
-			if error := util.TerraformInit(name + "/azure-simple"); error != nil {
-				return error
-			}
-
-			// Terraform Plan
-			if error := util.TerraformPlan(name + "/azure-simple"); error != nil {
-				return error
+	client := bedrockClient()
+	for _, environment := range environments {
+		// The plan of an environment reads resources of its dependencies, which need to be deployed first
+		for _, dependency := range environment.Dependencies() {
+			if found[dependency] != nil {
+				log.Info(emoji.Sprintf(":rocket: Deploying %s environment", dependency))
+				task := &deployTask{Cluster: name, Environment: found[dependency]}
+				if _, error := client.Apply(context.Background(), task.apiEnvironment(), bedrock.ApplyOptions{}); error != nil {
+					return error
+				}
 			}
-
-			break
 		}
-		if f.Name() == KEYVAULT {
-			log.Info(emoji.Sprintf(":rocket: Deploying Azure Common Infra environment"))
-
-			// Need to deploy azure-common-infra before you can run `terraform init` or `terraform apply`
-			if error := util.TerraformApply(name + "/azure-common-infra"); error != nil {
-				return error
-			}
-			log.Info(emoji.Sprintf(":dancers: Simulating Azure-Single-Keyvault Environment"))
-			setEnv(name, KEYVAULT)
-			// Terraform Init
-			if error := util.TerraformInitBackend(name + "/azure-single-keyvault"); error != nil {
-				return error
-			}
 
-			// Terraform Plan
-			if error := util.TerraformPlan(name + "/azure-single-keyvault"); error != nil {
+		log.Info(emoji.Sprintf(":dancers: Simulating %s environment", environment.Title()))
+		task := &deployTask{Cluster: name, Environment: environment}
+		directory := task.Directory()
+		if verifyGitops && environment.GitOps() {
+			if error := verifyGitopsConfig(directory, environment.Name()); error != nil {
 				return error
 			}
+		}
 
-			break
+		// Terraform Init and Plan, the plan is only kept to check it and estimate its cost
+		plan, error := client.Plan(context.Background(), task.apiEnvironment(), bedrock.PlanOptions{PlanFile: simulatePlan})
+		os.Remove(directory + "/" + simulatePlan)
+		if error != nil {
+			return error
 		}
-		if f.Name() == MULTIPLE {
-			log.Info(emoji.Sprintf(":dancers: Simulating Azure-Multiple-Clusters Environment"))
-			setEnv(name, MULTIPLE)
+		id := plan.Environment
+		log.Info(emoji.Sprintf(":thumbsup: %s plans to add %d, change %d and destroy %d resources", id, plan.Add, plan.Change, plan.Destroy))
 
-			// Terraform Init
-			if error := util.TerraformInit(name + "/azure-multiple-clusters"); error != nil {
-				return error
+		if len(policies) > 0 {
+			fmt.Println()
+			if error := checkPolicies(policies, id, directory, plan.JSON, os.Stdout); error != nil {
+				policyErrors = append(policyErrors, id+": "+error.Error())
 			}
-
-			// Terraform Plan
-			if error := util.TerraformPlan(name + "/azure-multiple-clusters"); error != nil {
+			fmt.Println()
+		}
+		if pricing != nil {
+			estimate, error := estimatePlanCost(id, plan.JSON, pricing)
+			if error != nil {
 				return error
 			}
-
-			break
+			estimates = append(estimates, estimate)
 		}
 	}
 
+	if pricing != nil {
+		fmt.Println()
+		writeCostEstimate(os.Stdout, estimates, pricing)
+		fmt.Println()
+	}
+	if len(policyErrors) > 0 {
+		return errors.New("The environment breaks policy rules, " + strings.Join(policyErrors, ", "))
+	}
+
 	if err == nil {
 		log.Info(emoji.Sprintf(":raised_hands: Completed simulated dry-run of environment deployment!"))
-		log.Info(emoji.Sprintf(":white_check_mark: To proceed, run 'bedrock deploy " + name + "'"))
+		log.Info(emoji.Sprint(":white_check_mark: To proceed, run 'bedrock deploy " + name + "'"))
 	}
 
 	return err
 }
 
 var simulateCmd = &cobra.Command{
-	Use:   "simulate <environment-name>",
+	Use:   "simulate <environment-name> [--verify-gitops] [--cost-estimate] [--pricing-file pricing-table] [--policy-dir directory]",
 	Short: "Simulate the environment deployment using Terraform",
-	Long:  `Simulate the environment deployment using terraform init and plan`,
+	Long:  `Simulate the environment deployment using terraform init and plan. With --cost-estimate, the monthly cost of the planned AKS node pools, keyvaults, storage accounts, Traffic Manager profiles and public IPs is estimated from the prices in a local pricing table, along with the change from the resources already deployed. The plan and tfvars are checked against the policy rules in --policy-dir.`,
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 
 		var name = "unique-environment-name"
@@ -138,5 +132,9 @@ var simulateCmd = &cobra.Command{
 }
 
 func init() {
+	simulateCmd.Flags().BoolVar(&verifyGitops, "verify-gitops", false, "Verify that the GitOps repository, branch and path can be reached with the deploy key")
+	simulateCmd.Flags().BoolVar(&costEstimate, "cost-estimate", false, "Estimate the monthly cost of the planned resources")
+	simulateCmd.Flags().StringVar(&pricingFile, "pricing-file", "pricing/bedrock-pricing.json", "Pricing table used by --cost-estimate")
+	simulateCmd.Flags().StringVar(&policyDir, "policy-dir", "policies", "Directory with the policy rules the plans are checked against")
 	rootCmd.AddCommand(simulateCmd)
 }
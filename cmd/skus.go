@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+)
+
+var skuCatalogFile string
+
+// SKUCatalog lists the VM sizes available in each region, see skus/bedrock-skus.json
+type SKUCatalog struct {
+	Updated string              `json:"updated"`
+	Regions map[string][]string `json:"regions"`
+}
+
+// loadSKUCatalog reads a SKU catalog from a local file
+func loadSKUCatalog(filename string) (catalog *SKUCatalog, err error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	catalog = &SKUCatalog{}
+	if err := json.Unmarshal(content, catalog); err != nil {
+		return nil, fmt.Errorf("Could not parse the SKU catalog %s: %s", filename, err)
+	}
+	return catalog, err
+}
+
+// Available reports whether a VM size can be deployed in a region
+func (catalog *SKUCatalog) Available(region string, size string) (available bool, err error) {
+	sizes, ok := catalog.Regions[strings.ToLower(strings.Replace(region, " ", "", -1))]
+	if !ok {
+		return false, errors.New("Region " + region + " is not in the SKU catalog")
+	}
+	for _, available := range sizes {
+		if strings.EqualFold(available, size) {
+			return true, err
+		}
+	}
+	return false, err
+}
+
+// validateClusterSizing checks the node counts of the clusters and, when a SKU catalog is available,
+// that their VM sizes exist in their regions
+func validateClusterSizing(clusters []regionalCluster, catalogFile string) (err error) {
+	var problems []string
+	for _, cluster := range clusters {
+		if count, err := strconv.Atoi(cluster.VMCount); err != nil || count < 1 {
+			problems = append(problems, "the "+cluster.Name+" node count "+cluster.VMCount+" is not a positive number")
+		}
+	}
+
+	catalog, err := loadSKUCatalog(catalogFile)
+	if err != nil {
+		log.Warn(emoji.Sprintf(":warning: VM sizes are not validated, the SKU catalog could not be read: %s", err))
+		err = nil
+	} else {
+		for _, cluster := range clusters {
+			available, err := catalog.Available(cluster.Region, cluster.VMSize)
+			if err != nil {
+				problems = append(problems, err.Error())
+			} else if !available {
+				problems = append(problems, cluster.VMSize+" is not available in "+cluster.Region+" for the "+cluster.Name+" cluster")
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			log.Error(emoji.Sprintf(":no_entry_sign: %s", problem))
+		}
+		return errors.New("Invalid cluster sizing: " + strings.Join(problems, ", "))
+	}
+	return err
+}
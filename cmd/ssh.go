@@ -1,52 +1,131 @@
 package cmd
 
 import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
 	"io/ioutil"
 	"os"
-	"os/exec"
 
 	"github.com/kyokomi/emoji"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
 )
 
-// SSH function will generate a new SSH Key using `ssh-keygen`
-func SSH(path string, name string) (key string, err error) {
-	log.Info(emoji.Sprintf(":lock_with_ink_pen: Creating new SSH with name " + name))
+var sshKeyType string
+var sshPassphrase string
+var sshForce bool
+
+// Supported SSH key types
+const (
+	RSA     = "rsa"
+	ED25519 = "ed25519"
+)
+
+// SSHKeyOptions controls how an SSH key is generated
+type SSHKeyOptions struct {
+	Type       string // rsa or ed25519
+	Bits       int    // Size of RSA keys
+	Passphrase string // Optional passphrase to encrypt the private key with
+	Force      bool   // Overwrite an existing key at the same path
+}
+
+// sshKeyOptions returns the key options set through command line flags
+func sshKeyOptions() SSHKeyOptions {
+	return SSHKeyOptions{Type: sshKeyType, Bits: 4096, Passphrase: sshPassphrase, Force: sshForce}
+}
+
+// generateKeyPair creates a new private key of the given type along with its public key
+func generateKeyPair(options SSHKeyOptions) (privateKey crypto.PrivateKey, publicKey crypto.PublicKey, err error) {
+	switch options.Type {
+	case ED25519:
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, publicKey, err
+	case RSA, "":
+		bits := options.Bits
+		if bits == 0 {
+			bits = 4096
+		}
+		privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, nil, err
+		}
+		return privateKey, &privateKey.PublicKey, err
+	}
+	return nil, nil, errors.New("Unsupported SSH key type '" + options.Type + "', use " + RSA + " or " + ED25519)
+}
+
+// SSH function will generate a new SSH Key pair at path/name and path/name.pub
+func SSH(path string, name string, options SSHKeyOptions) (key string, err error) {
+	log.Info(emoji.Sprint(":lock_with_ink_pen: Creating new SSH with name " + name))
 
 	keyPath := path + "/" + name
-	//log.Info(emoji.Sprintf(":door: Current wd is %s", path))
 
 	if _, err := os.Stat(keyPath); err == nil {
-		log.Info(emoji.Sprintf(":palm_tree: Path to %s exists, removing...", keyPath))
-		os.Remove(keyPath)
-		os.Remove(keyPath + ".pub")
+		if !options.Force {
+			log.Error(emoji.Sprintf(":no_entry_sign: An SSH key already exists at %s, use --force to overwrite it", keyPath))
+			return "", errors.New("SSH key " + keyPath + " already exists")
+		}
+		log.Info(emoji.Sprintf(":palm_tree: Path to %s exists, overwriting...", keyPath))
 	}
 
 	// Create SSH Keys
-	if output, err := exec.Command("ssh-keygen", "-t", "rsa", "-b", "4096", "-f", keyPath, "-P", "").CombinedOutput(); err != nil {
-		log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, output))
+	privateKey, publicKey, err := generateKeyPair(options)
+	if err != nil {
+		log.Error(emoji.Sprintf(":no_entry_sign: %s", err))
 		return "", err
 	}
 
-	log.Info(emoji.Sprintf(":key: SSH key " + name + " has been created!"))
-	log.Info(emoji.Sprintf(":rotating_light: Add the following SSH key to 'Deploy Keys' in your Manifest repository"))
-	file, err := ioutil.ReadFile(keyPath + ".pub")
+	var block *pem.Block
+	if options.Passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(privateKey, name, []byte(options.Passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(privateKey, name)
+	}
 	if err != nil {
-		log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, file))
+		log.Error(emoji.Sprintf(":no_entry_sign: %s", err))
+		return "", err
+	}
+
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		log.Error(emoji.Sprintf(":no_entry_sign: %s", err))
+		return "", err
+	}
+
+	// Remove existing files first so that the permissions of the new files are applied
+	os.Remove(keyPath)
+	os.Remove(keyPath + ".pub")
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		log.Error(emoji.Sprintf(":no_entry_sign: %s", err))
+		return "", err
+	}
+	file := ssh.MarshalAuthorizedKey(sshPublicKey)
+	if err := ioutil.WriteFile(keyPath+".pub", file, 0600); err != nil {
+		log.Error(emoji.Sprintf(":no_entry_sign: %s", err))
 		return "", err
 	}
+
+	log.Info(emoji.Sprint(":key: SSH key " + name + " has been created!"))
+	log.Info(emoji.Sprintf(":rotating_light: Add the following SSH key to 'Deploy Keys' in your Manifest repository"))
 	log.Info(string(file))
 	return string(file), nil
 
 }
 
 var sshCmd = &cobra.Command{
-	Use:   "ssh [ssh_key_name]",
+	Use:   "ssh [ssh_key_name] [--type rsa|ed25519] [--passphrase passphrase] [--force]",
 	Short: "Create an SSH key",
 	Long:  `Create an SSH key`,
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 		var name = "id_rsa"
+		if sshKeyType == ED25519 {
+			name = "id_ed25519"
+		}
 		if len(args) > 0 {
 			name = args[0]
 		}
@@ -57,12 +136,15 @@ var sshCmd = &cobra.Command{
 			panic(err)
 		}
 
-		_, err = SSH(currentPath, name)
+		_, err = SSH(currentPath, name, sshKeyOptions())
 
 		return err
 	},
 }
 
 func init() {
+	sshCmd.Flags().StringVar(&sshKeyType, "type", RSA, "Type of SSH key to create (rsa or ed25519)")
+	sshCmd.Flags().StringVar(&sshPassphrase, "passphrase", "", "Passphrase to encrypt the private key with")
+	sshCmd.Flags().BoolVar(&sshForce, "force", false, "Overwrite an existing SSH key with the same name")
 	rootCmd.AddCommand(sshCmd)
 }
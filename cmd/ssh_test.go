@@ -2,13 +2,18 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+
+	"golang.org/x/crypto/ssh"
 )
 
 func TestSSH(t *testing.T) {
 
-	if _, error := SSH(".", "testSSHKey"); error != nil {
+	if _, error := SSH(".", "testSSHKey", SSHKeyOptions{Type: RSA, Bits: 2048}); error != nil {
+		t.Error("There was an error creating the SSH key:", error)
 		return
 	}
 
@@ -22,16 +27,40 @@ func TestSSH(t *testing.T) {
 	} else {
 		t.Error("Public SSH Key was not generated.")
 	}
+	if info, err := os.Stat("testSSHKey"); err != nil || info.Mode().Perm() != 0600 {
+		t.Error("Private SSH Key does not have 0600 permissions.")
+	}
+
+	// An existing key must not be replaced unless forced
+	if _, error := SSH(".", "testSSHKey", SSHKeyOptions{Type: RSA, Bits: 2048}); error == nil {
+		t.Error("Existing SSH Key was overwritten without --force.")
+	}
+	if _, error := SSH(".", "testSSHKey", SSHKeyOptions{Type: ED25519, Force: true}); error != nil {
+		t.Error("Existing SSH Key was not overwritten with --force.")
+	}
 
 	os.Remove("testSSHKey")
 	os.Remove("testSSHKey.pub")
 }
 
-// fileExists checks if a file exists
-func fileExists(filename string) bool {
-	info, err := os.Stat(filename)
-	if os.IsNotExist(err) {
-		return false
+func TestSSHEd25519Passphrase(t *testing.T) {
+	key, error := SSH(".", "testSSHKeyEd25519", SSHKeyOptions{Type: ED25519, Passphrase: "bedrock"})
+	if error != nil {
+		t.Error("There was an error creating the SSH key:", error)
+		return
+	}
+	defer os.Remove("testSSHKeyEd25519")
+	defer os.Remove("testSSHKeyEd25519.pub")
+
+	if !strings.HasPrefix(key, "ssh-ed25519 ") {
+		t.Error("Public SSH Key is not an ed25519 key:", key)
+	}
+
+	privateKey, _ := ioutil.ReadFile("testSSHKeyEd25519")
+	if _, err := ssh.ParsePrivateKey(privateKey); err == nil {
+		t.Error("Private SSH Key was not encrypted with the passphrase.")
+	}
+	if _, err := ssh.ParsePrivateKeyWithPassphrase(privateKey, []byte("bedrock")); err != nil {
+		t.Error("Private SSH Key could not be decrypted with the passphrase:", err)
 	}
-	return !info.IsDir()
 }
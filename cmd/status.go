@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/yradsmikham/bedrock-cli/pkg/bedrock"
+)
+
+// statusRunner runs the az commands reading the state of deployed resources
+var statusRunner = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// TrafficManagerEndpoint is an endpoint of a Traffic Manager profile, as returned by az
+type TrafficManagerEndpoint struct {
+	Name                  string   `json:"name"`
+	Type                  string   `json:"type"`
+	Target                string   `json:"target"`
+	EndpointStatus        string   `json:"endpointStatus"`
+	EndpointMonitorStatus string   `json:"endpointMonitorStatus"`
+	Weight                *int     `json:"weight"`
+	Priority              *int     `json:"priority"`
+	GeoMapping            []string `json:"geoMapping"`
+}
+
+// TrafficManagerProfile is a Traffic Manager profile, as returned by `az network traffic-manager profile show`
+type TrafficManagerProfile struct {
+	Name                 string `json:"name"`
+	ProfileStatus        string `json:"profileStatus"`
+	TrafficRoutingMethod string `json:"trafficRoutingMethod"`
+	DNSConfig            struct {
+		Fqdn string `json:"fqdn"`
+	} `json:"dnsConfig"`
+	MonitorConfig struct {
+		Protocol             string `json:"protocol"`
+		Port                 int    `json:"port"`
+		Path                 string `json:"path"`
+		ProfileMonitorStatus string `json:"profileMonitorStatus"`
+	} `json:"monitorConfig"`
+	Endpoints []TrafficManagerEndpoint `json:"endpoints"`
+}
+
+// trafficManagerStatus reads a Traffic Manager profile and the health of its endpoints
+func trafficManagerStatus(profile string, resourceGroup string) (status *TrafficManagerProfile, err error) {
+	output, err := statusRunner("az", "network", "traffic-manager", "profile", "show", "--name", profile, "--resource-group", resourceGroup, "--output", "json")
+	if err != nil {
+		return nil, fmt.Errorf("Could not read Traffic Manager profile %s: %s", profile, strings.TrimSpace(string(output)))
+	}
+	status = &TrafficManagerProfile{}
+	if err := json.Unmarshal(output, status); err != nil {
+		return nil, err
+	}
+	return status, err
+}
+
+// unhealthyEndpoints returns the enabled endpoints that are not online
+func (profile *TrafficManagerProfile) unhealthyEndpoints() (unhealthy []string) {
+	for _, endpoint := range profile.Endpoints {
+		if endpoint.EndpointStatus != "Disabled" && endpoint.EndpointMonitorStatus != "Online" {
+			unhealthy = append(unhealthy, endpoint.Name)
+		}
+	}
+	return unhealthy
+}
+
+// writeTrafficManagerStatus writes the routing, probe and endpoint health of a profile
+func writeTrafficManagerStatus(out io.Writer, profile *TrafficManagerProfile) {
+	fmt.Fprintf(out, "  Traffic Manager %s (%s): %s, %s routing, monitor %s\n", profile.Name, profile.DNSConfig.Fqdn, profile.ProfileStatus, profile.TrafficRoutingMethod, profile.MonitorConfig.ProfileMonitorStatus)
+	fmt.Fprintf(out, "  Probe: %s port %d %s\n", profile.MonitorConfig.Protocol, profile.MonitorConfig.Port, profile.MonitorConfig.Path)
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "  ENDPOINT\tTYPE\tTARGET\tSTATUS\tHEALTH\tWEIGHT\tPRIORITY\tGEO")
+	for _, endpoint := range profile.Endpoints {
+		number := func(value *int) string {
+			if value == nil {
+				return "-"
+			}
+			return fmt.Sprint(*value)
+		}
+		fmt.Fprintf(table, "  %s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", endpoint.Name, filepath.Base(endpoint.Type), endpoint.Target, endpoint.EndpointStatus, endpoint.EndpointMonitorStatus, number(endpoint.Weight), number(endpoint.Priority), strings.Join(endpoint.GeoMapping, ","))
+	}
+	table.Flush()
+}
+
+// Status shows whether the environments of an environment directory are deployed and the health of
+// their Traffic Manager endpoints
+func Status(name string, out io.Writer) (err error) {
+	environments, err := environmentsIn(name)
+	if err != nil {
+		return err
+	}
+	if len(environments) == 0 {
+		return fmt.Errorf("No environments were found in %s", name)
+	}
+
+	unhealthy := 0
+	for _, environment := range environments {
+		directory := name + "/" + environment.Name()
+		deployed := "not deployed with bedrock deploy"
+		task := &deployTask{Cluster: name, Environment: environment}
+		if status, err := bedrockClient().Status(context.Background(), task.apiEnvironment(), bedrock.StatusOptions{}); err == nil && status.Deployed {
+			deployed = "deployed " + status.DeployedAt.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(out, "%s/%s: %s\n", filepath.Base(name), environment.Name(), deployed)
+
+		if !fileExists(directory + "/bedrock-config.tfvars") {
+			continue
+		}
+		config, err := ReadTfvarsFile(directory + "/bedrock-config.tfvars")
+		if err != nil {
+			return err
+		}
+		profile := strings.Trim(config["traffic_manager_profile_name"], "\"")
+		resourceGroup := strings.Trim(config["traffic_manager_resource_group_name"], "\"")
+		if profile == "" || resourceGroup == "" {
+			continue
+		}
+		status, err := trafficManagerStatus(profile, resourceGroup)
+		if err != nil {
+			log.Error(emoji.Sprintf(":no_entry_sign: %s", err))
+			unhealthy++
+			continue
+		}
+		writeTrafficManagerStatus(out, status)
+		for _, endpoint := range status.unhealthyEndpoints() {
+			log.Warn(emoji.Sprintf(":warning: Traffic Manager endpoint %s of %s is not online", endpoint, profile))
+			unhealthy++
+		}
+	}
+
+	if unhealthy > 0 {
+		return fmt.Errorf("%d Traffic Manager endpoints are not healthy", unhealthy)
+	}
+	return err
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status <environment-path>",
+	Short: "Show the state of a deployed environment",
+	Long:  `Show whether the environments of an environment directory were deployed and, for environments with Traffic Manager, the routing method, health probe and health of every endpoint. Exits with a non-zero status when an endpoint is not online.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		return Status(args[0], os.Stdout)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const trafficManagerJSON = `{
+  "name": "my-cluster-tm",
+  "profileStatus": "Enabled",
+  "trafficRoutingMethod": "Weighted",
+  "dnsConfig": {"fqdn": "my-cluster-tm.trafficmanager.net"},
+  "monitorConfig": {"protocol": "HTTP", "port": 80, "path": "/healthz", "profileMonitorStatus": "Degraded"},
+  "endpoints": [
+    {"name": "west", "type": "Microsoft.Network/trafficManagerProfiles/azureEndpoints", "target": "10.0.0.1", "endpointStatus": "Enabled", "endpointMonitorStatus": "Online", "weight": 3},
+    {"name": "east", "type": "Microsoft.Network/trafficManagerProfiles/azureEndpoints", "target": "10.0.0.2", "endpointStatus": "Enabled", "endpointMonitorStatus": "Degraded", "weight": 1},
+    {"name": "central", "type": "Microsoft.Network/trafficManagerProfiles/azureEndpoints", "target": "10.0.0.3", "endpointStatus": "Disabled", "endpointMonitorStatus": "Disabled", "weight": 1}
+  ]
+}`
+
+func TestStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(dir+"/"+MULTIPLE, os.ModePerm)
+	ioutil.WriteFile(dir+"/"+MULTIPLE+"/bedrock-config.tfvars", []byte("traffic_manager_profile_name = \"my-cluster-tm\"\ntraffic_manager_resource_group_name = \"my-cluster-tm-rg\"\n"), 0644)
+
+	var calls []string
+	defer func(runner func(string, ...string) ([]byte, error)) { statusRunner = runner }(statusRunner)
+	statusRunner = func(name string, args ...string) ([]byte, error) {
+		calls = append(calls, name+" "+strings.Join(args, " "))
+		return []byte(trafficManagerJSON), nil
+	}
+
+	var out bytes.Buffer
+	err = Status(dir, &out)
+	if err == nil || err.Error() != "1 Traffic Manager endpoints are not healthy" {
+		t.Errorf("Expected the degraded east endpoint to be reported, got %v", err)
+	}
+	if len(calls) != 1 || !strings.Contains(calls[0], "--name my-cluster-tm --resource-group my-cluster-tm-rg") {
+		t.Errorf("Unexpected az calls %v", calls)
+	}
+	for _, expected := range []string{"not deployed with bedrock deploy", "Weighted routing", "Probe: HTTP port 80 /healthz", "azureEndpoints", "Degraded"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in the status:\n%s", expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var tagFlags []string
+
+// tags applied to every resource group and storage account the CLI creates
+var tags map[string]string
+
+// parseTags parses a list of key=value pairs
+func parseTags(pairs []string) (parsed map[string]string, err error) {
+	parsed = make(map[string]string)
+	for _, pair := range pairs {
+		equal := strings.Index(pair, "=")
+		if equal <= 0 {
+			return nil, errors.New("Invalid tag '" + pair + "', tags must be specified as key=value")
+		}
+		parsed[strings.TrimSpace(pair[:equal])] = strings.TrimSpace(pair[equal+1:])
+	}
+	return parsed, err
+}
+
+// tagsTemplate formats tags as a terraform map on a single line
+func tagsTemplate(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, "\""+key+"\" = \""+tags[key]+"\"")
+	}
+	return "{ " + strings.Join(pairs, ", ") + " }"
+}
+
+// templateAcceptsVariable reports whether the terraform files of an environment declare the given variable
+func templateAcceptsVariable(envPath string, name string) bool {
+	declaration := regexp.MustCompile(`variable\s+"` + regexp.QuoteMeta(name) + `"`)
+	files, _ := filepath.Glob(envPath + "/*.tf")
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err == nil && declaration.Match(content) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestTagsFromConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := dir + "/bedrock.yaml"
+	ioutil.WriteFile(config, []byte("region: eastus\ntag:\n  - owner=alice\n  - cost-center=1234\n"), 0644)
+
+	var testRegion string
+	var testTags []string
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().StringVar(&testRegion, "region", "westus2", "")
+	cmd.Flags().StringArrayVar(&testTags, "tag", []string{}, "")
+	cmd.Flags().Set("tag", "environment=dev")
+
+	configFile = config
+	defer func() { configFile = "" }()
+	if err := loadConfigFile(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if testRegion != "eastus" {
+		t.Errorf("Region was not read from the config file: %s", testRegion)
+	}
+
+	// Tags given on the command line replace the ones of the config file
+	parsed, err := parseTags(testTags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 1 || parsed["environment"] != "dev" {
+		t.Errorf("Unexpected tags %v", parsed)
+	}
+
+	parsed, _ = parseTags([]string{"owner=alice", "cost-center=1234"})
+	if template := tagsTemplate(parsed); template != `{ "cost-center" = "1234", "owner" = "alice" }` {
+		t.Errorf("Unexpected tags template %s", template)
+	}
+	if _, err := parseTags([]string{"owner"}); err == nil {
+		t.Error("Expected an error for a tag without a value")
+	}
+}
+
+func TestTemplateAcceptsVariable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(dir+"/variables.tf", []byte("variable \"tags\" {\n  type = map(string)\n}\n"), 0644)
+	if !templateAcceptsVariable(dir, "tags") {
+		t.Error("Expected the tags variable to be found")
+	}
+	if templateAcceptsVariable(dir, "tag") {
+		t.Error("Did not expect the tag variable to be found")
+	}
+}
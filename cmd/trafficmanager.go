@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+)
+
+var tmRoutingMethod string
+var tmProbeProtocol string
+var tmProbePort string
+var tmProbePath string
+var endpointWeightWest string
+var endpointWeightCentral string
+var endpointWeightEast string
+var endpointPriorityWest string
+var endpointPriorityCentral string
+var endpointPriorityEast string
+var geoMappingsWest []string
+var geoMappingsCentral []string
+var geoMappingsEast []string
+
+// Routing methods and probe protocols supported by Traffic Manager
+var (
+	trafficManagerRoutingMethods = []string{"Performance", "Weighted", "Priority", "Geographic"}
+	trafficManagerProtocols      = []string{"HTTP", "HTTPS", "TCP"}
+)
+
+// trafficManagerVariables are the Traffic Manager settings of the azure-multiple-clusters template, with their flag
+var trafficManagerVariables = map[string]string{
+	"traffic_manager_routing_method":   "tm-routing-method",
+	"traffic_manager_monitor_protocol": "tm-probe-protocol",
+	"traffic_manager_monitor_port":     "tm-probe-port",
+	"traffic_manager_monitor_path":     "tm-probe-path",
+	"west_endpoint_weight":             "west-weight",
+	"west_endpoint_priority":           "west-priority",
+	"west_endpoint_geo_mappings":       "west-geo-mappings",
+	"central_endpoint_weight":          "central-weight",
+	"central_endpoint_priority":        "central-priority",
+	"central_endpoint_geo_mappings":    "central-geo-mappings",
+	"east_endpoint_weight":             "east-weight",
+	"east_endpoint_priority":           "east-priority",
+	"east_endpoint_geo_mappings":       "east-geo-mappings",
+}
+
+// choice returns the value from choices matching value regardless of case
+func choice(value string, choices []string) (string, bool) {
+	for _, candidate := range choices {
+		if strings.EqualFold(candidate, value) {
+			return candidate, true
+		}
+	}
+	return value, false
+}
+
+// validateTrafficManager checks the Traffic Manager flags against the routing method, and normalizes
+// the routing method and probe protocol to the values Azure expects
+func validateTrafficManager(clusters []regionalCluster) (err error) {
+	var problems []string
+	inRange := func(value string, min int, max int) bool {
+		number, err := strconv.Atoi(value)
+		return err == nil && number >= min && number <= max
+	}
+
+	if tmRoutingMethod != "" {
+		var ok bool
+		if tmRoutingMethod, ok = choice(tmRoutingMethod, trafficManagerRoutingMethods); !ok {
+			problems = append(problems, "the routing method must be one of "+strings.Join(trafficManagerRoutingMethods, ", "))
+		}
+	}
+	if tmProbeProtocol != "" {
+		var ok bool
+		if tmProbeProtocol, ok = choice(tmProbeProtocol, trafficManagerProtocols); !ok {
+			problems = append(problems, "the probe protocol must be one of "+strings.Join(trafficManagerProtocols, ", "))
+		}
+	}
+	if tmProbePort != "" && !inRange(tmProbePort, 1, 65535) {
+		problems = append(problems, "the probe port must be between 1 and 65535")
+	}
+	if tmProbePath != "" && tmProbeProtocol == "TCP" {
+		problems = append(problems, "TCP probes do not have a path")
+	} else if tmProbePath != "" && !strings.HasPrefix(tmProbePath, "/") {
+		problems = append(problems, "the probe path must start with /")
+	}
+
+	priorities := map[string]string{}
+	geoMappings := map[string]string{}
+	for _, cluster := range clusters {
+		if cluster.Weight != "" {
+			if tmRoutingMethod != "Weighted" {
+				problems = append(problems, "--"+cluster.Name+"-weight only applies to Weighted routing")
+			} else if !inRange(cluster.Weight, 1, 1000) {
+				problems = append(problems, "the "+cluster.Name+" weight must be between 1 and 1000")
+			}
+		}
+		if cluster.Priority != "" {
+			if tmRoutingMethod != "Priority" {
+				problems = append(problems, "--"+cluster.Name+"-priority only applies to Priority routing")
+			} else if !inRange(cluster.Priority, 1, 1000) {
+				problems = append(problems, "the "+cluster.Name+" priority must be between 1 and 1000")
+			} else if other, ok := priorities[cluster.Priority]; ok {
+				problems = append(problems, "the "+other+" and "+cluster.Name+" clusters have the same priority")
+			}
+			priorities[cluster.Priority] = cluster.Name
+		}
+		if len(cluster.GeoMappings) > 0 && tmRoutingMethod != "Geographic" {
+			problems = append(problems, "--"+cluster.Name+"-geo-mappings only applies to Geographic routing")
+		}
+		if len(cluster.GeoMappings) == 0 && tmRoutingMethod == "Geographic" {
+			problems = append(problems, "Geographic routing needs --"+cluster.Name+"-geo-mappings")
+		}
+		for _, code := range cluster.GeoMappings {
+			if other, ok := geoMappings[strings.ToUpper(code)]; ok {
+				problems = append(problems, code+" is mapped to both the "+other+" and "+cluster.Name+" clusters")
+			}
+			geoMappings[strings.ToUpper(code)] = cluster.Name
+		}
+	}
+
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			log.Error(emoji.Sprintf(":no_entry_sign: %s", problem))
+		}
+		return errors.New("Invalid Traffic Manager settings: " + strings.Join(problems, ", "))
+	}
+	return err
+}
+
+// trafficManagerTemplate adds the Traffic Manager settings that were given, the template defaults apply to the others
+func trafficManagerTemplate(config map[string]string, clusters []regionalCluster) {
+	set := func(variable string, value string) {
+		if value != "" {
+			config[variable] = "\"" + value + "\""
+		}
+	}
+	set("traffic_manager_routing_method", tmRoutingMethod)
+	set("traffic_manager_monitor_protocol", tmProbeProtocol)
+	set("traffic_manager_monitor_port", tmProbePort)
+	set("traffic_manager_monitor_path", tmProbePath)
+	for _, cluster := range clusters {
+		set(cluster.Name+"_endpoint_weight", cluster.Weight)
+		set(cluster.Name+"_endpoint_priority", cluster.Priority)
+		if len(cluster.GeoMappings) > 0 {
+			config[cluster.Name+"_endpoint_geo_mappings"] = "[\"" + strings.Join(cluster.GeoMappings, "\", \"") + "\"]"
+		}
+	}
+}
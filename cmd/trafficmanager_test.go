@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTrafficManagerSettings(t *testing.T) {
+	defer func(method, protocol, port, path string) {
+		tmRoutingMethod, tmProbeProtocol, tmProbePort, tmProbePath = method, protocol, port, path
+	}(tmRoutingMethod, tmProbeProtocol, tmProbePort, tmProbePath)
+	clusters := []regionalCluster{{Name: "west"}, {Name: "central"}, {Name: "east"}}
+
+	// Routing method and protocol are normalized to the values Azure expects
+	tmRoutingMethod, tmProbeProtocol, tmProbePort, tmProbePath = "priority", "https", "443", "/healthz"
+	clusters[0].Priority, clusters[2].Priority = "1", "2"
+	if err := validateTrafficManager(clusters); err != nil {
+		t.Fatal(err)
+	}
+	config := map[string]string{}
+	trafficManagerTemplate(config, clusters)
+	expected := map[string]string{
+		"traffic_manager_routing_method":   "\"Priority\"",
+		"traffic_manager_monitor_protocol": "\"HTTPS\"",
+		"traffic_manager_monitor_port":     "\"443\"",
+		"traffic_manager_monitor_path":     "\"/healthz\"",
+		"west_endpoint_priority":           "\"1\"",
+		"east_endpoint_priority":           "\"2\"",
+	}
+	if len(config) != len(expected) {
+		t.Errorf("Unexpected settings %v", config)
+	}
+	for setting, value := range expected {
+		if config[setting] != value {
+			t.Errorf("Expected %s = %s, got %s", setting, value, config[setting])
+		}
+	}
+
+	// Settings that do not fit the routing method are rejected
+	clusters[1].Priority = "2"
+	clusters[1].Weight = "10"
+	tmProbeProtocol = "tcp"
+	err := validateTrafficManager(clusters)
+	for _, problem := range []string{"same priority", "--central-weight only applies to Weighted routing", "TCP probes do not have a path"} {
+		if err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected %q in %v", problem, err)
+		}
+	}
+
+	// Geographic routing maps every cluster to distinct regions
+	tmRoutingMethod, tmProbeProtocol, tmProbePath = "Geographic", "", ""
+	clusters = []regionalCluster{{Name: "west", GeoMappings: []string{"GEO-NA"}}, {Name: "central", GeoMappings: []string{"geo-na"}}, {Name: "east"}}
+	err = validateTrafficManager(clusters)
+	if err == nil || !strings.Contains(err.Error(), "geo-na is mapped to both") || !strings.Contains(err.Error(), "needs --east-geo-mappings") {
+		t.Errorf("Unexpected validation result %v", err)
+	}
+	config = map[string]string{}
+	trafficManagerTemplate(config, clusters)
+	if config["west_endpoint_geo_mappings"] != "[\"GEO-NA\"]" {
+		t.Errorf("Unexpected geo mappings %s", config["west_endpoint_geo_mappings"])
+	}
+}
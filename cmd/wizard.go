@@ -0,0 +1,341 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var interactive bool
+
+// Prompter asks questions on a reader and writer, so that the wizard can be scripted in tests
+type Prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// NewPrompter returns a prompter reading answers from in and writing questions to out
+func NewPrompter(in io.Reader, out io.Writer) *Prompter {
+	return &Prompter{in: bufio.NewReader(in), out: out}
+}
+
+// Ask asks a question and returns the answer, or defaultValue when the answer is empty
+func (p *Prompter) Ask(question string, defaultValue string) string {
+	if defaultValue != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, defaultValue)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	answer, _ := p.in.ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return defaultValue
+	}
+	return answer
+}
+
+// Confirm asks a yes/no question
+func (p *Prompter) Confirm(question string, defaultValue bool) bool {
+	choices := "y/N"
+	if defaultValue {
+		choices = "Y/n"
+	}
+	answer := strings.ToLower(p.Ask(question+" ("+choices+")", ""))
+	if answer == "" {
+		return defaultValue
+	}
+	return answer == "y" || answer == "yes"
+}
+
+// Choose asks to pick one of the options, by number or by name
+func (p *Prompter) Choose(question string, options []string, descriptions map[string]string, defaultValue string) string {
+	fmt.Fprintln(p.out, question)
+	for i, option := range options {
+		fmt.Fprintf(p.out, "  %d) %s\n", i+1, option)
+		if description := descriptions[option]; description != "" {
+			fmt.Fprintf(p.out, "     %s\n", description)
+		}
+	}
+	for {
+		answer := p.Ask("Choice", defaultValue)
+		if index, err := strconv.Atoi(answer); err == nil && index >= 1 && index <= len(options) {
+			return options[index-1]
+		}
+		for _, option := range options {
+			if answer == option {
+				return option
+			}
+		}
+		if answer == "" {
+			// Nothing left to read, e.g. a script that ran out of answers
+			return defaultValue
+		}
+		fmt.Fprintf(p.out, "'%s' is not one of the options\n", answer)
+	}
+}
+
+// WizardSetting is a flag value chosen in the wizard
+type WizardSetting struct {
+	Flag   string
+	Value  string
+	Secret bool // Secrets are not written to the config file
+}
+
+// WizardResult is the environment and flags chosen in the wizard
+type WizardResult struct {
+	Environment string
+	Settings    []WizardSetting
+}
+
+// Set records the value of a flag, replacing a previous one. Empty values are left out.
+func (result *WizardResult) Set(flag string, value string, secret bool) {
+	if value == "" {
+		return
+	}
+	for i, setting := range result.Settings {
+		if setting.Flag == flag {
+			result.Settings[i].Value = value
+			return
+		}
+	}
+	result.Settings = append(result.Settings, WizardSetting{Flag: flag, Value: value, Secret: secret})
+}
+
+// environmentCommands are the commands that create each type of environment
+func environmentCommands() map[string]*cobra.Command {
+	commands := map[string]*cobra.Command{}
+	for _, environment := range Environments() {
+		commands[environment.Name()] = environment.Command()
+	}
+	return commands
+}
+
+// Settings asked in the network and gitops steps, when the command has the flag
+var wizardNetworkFlags = []string{"region", "region-west", "region-central", "region-east", "vm-count", "vm-size", "vnet", "address-space", "subnet-prefix", "dns-prefix"}
+var wizardGitopsFlags = []string{"gitops-ssh-url", "branch", "repo-path", "west-branch", "west-repo-path", "central-branch", "central-repo-path", "east-branch", "east-repo-path", "poll-interval"}
+
+// askFlag asks for the value of a flag, using its usage as the question and its default as the answer
+func askFlag(p *Prompter, cmd *cobra.Command, result *WizardResult, name string) {
+	flag := cmd.Flags().Lookup(name)
+	if flag == nil {
+		return
+	}
+	if value := p.Ask(flag.Usage, flag.DefValue); value != flag.DefValue {
+		result.Set(name, value, false)
+	}
+}
+
+// existingCommonInfra lists the environments that contain an azure-common-infra environment
+func existingCommonInfra() (paths []string) {
+	matches, _ := filepath.Glob("bedrock/cluster/environments/*/" + COMMON + "/bedrock-config.tfvars")
+	for _, match := range matches {
+		paths = append(paths, filepath.Dir(filepath.Dir(match)))
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// RunWizard asks for the type of environment and its settings
+func RunWizard(p *Prompter, getenv func(string) string) (result WizardResult, err error) {
+	environments := environmentNames()
+	descriptions := map[string]string{}
+	for _, environment := range environments {
+		descriptions[environment] = strings.Replace(infoMap[environment]["info"][0], "`", "", -1)
+	}
+	result.Environment = p.Choose("Which type of environment would you like to create?", environments, descriptions, "1")
+	cmd := environmentCommands()[result.Environment]
+
+	if name := p.Ask("Cluster name (leave empty for a random name)", ""); name != "" {
+		result.Set("cluster-name", name, false)
+	}
+
+	// Credentials, detected from the environment variables like VerifyEnvVariables
+	fmt.Fprintln(p.out, "\nCredentials")
+	auth := p.Choose("How should terraform authenticate with Azure?", []string{AUTHSP, AUTHCLI, AUTHMSI, AUTHOIDC}, map[string]string{
+		AUTHSP:   "Service principal and secret",
+		AUTHCLI:  "Account logged in with 'az login'",
+		AUTHMSI:  "Managed identity of this machine",
+		AUTHOIDC: "Workload identity federation",
+	}, "1")
+	if auth != AUTHSP {
+		result.Set("auth", auth, false)
+	}
+	if value := getenv("ARM_SUBSCRIPTION_ID"); value != "" {
+		fmt.Fprintf(p.out, "Using subscription %s from ARM_SUBSCRIPTION_ID\n", value)
+	} else if auth != AUTHCLI {
+		result.Set("subscription", p.Ask("Azure subscription id", ""), false)
+	}
+	if auth == AUTHSP && getenv("ARM_CLIENT_ID") != "" && getenv("ARM_CLIENT_SECRET") != "" {
+		fmt.Fprintf(p.out, "Using service principal %s from ARM_CLIENT_ID and ARM_CLIENT_SECRET\n", getenv("ARM_CLIENT_ID"))
+	} else if p.Confirm("Create a new service principal for the environment?", false) {
+		result.Set("create-sp", "true", false)
+	} else {
+		// With other modes ARM_CLIENT_ID is the identity of terraform, not of the AKS cluster
+		detected := ""
+		if auth == AUTHSP {
+			detected = getenv("ARM_CLIENT_ID")
+		}
+		result.Set("sp", p.Ask("Service principal app id", detected), false)
+		if getenv("ARM_CLIENT_SECRET") == "" {
+			result.Set("secret", p.Ask("Service principal password (input is visible, or export ARM_CLIENT_SECRET instead)", ""), true)
+		}
+	}
+	if value := getenv("ARM_TENANT_ID"); value != "" {
+		fmt.Fprintf(p.out, "Using tenant %s from ARM_TENANT_ID\n", value)
+	} else if auth == AUTHSP || auth == AUTHOIDC {
+		result.Set("tenant", p.Ask("Azure tenant id", ""), false)
+	}
+
+	// The keyvault and multi-cluster environments depend on a common infrastructure environment
+	reuse := false
+	environment, _ := GetEnvironment(result.Environment)
+	if dependsOn(environment, COMMON) {
+		existing := existingCommonInfra()
+		if len(existing) > 0 {
+			fmt.Fprintln(p.out, "\nCommon infrastructure")
+			choice := p.Choose("Reuse an existing "+COMMON+" environment?", append(existing, "new"), map[string]string{"new": "Create a new " + COMMON + " environment"}, "1")
+			if choice != "new" {
+				reuse = true
+				if err := reuseCommonInfra(cmd, choice, &result); err != nil {
+					return result, err
+				}
+			}
+		}
+	}
+
+	fmt.Fprintln(p.out, "\nCluster and network")
+	for _, name := range wizardNetworkFlags {
+		// The network of a reused environment is taken from its common infrastructure
+		if reuse && (name == "address-space" || name == "subnet-prefix" || name == "vnet") {
+			continue
+		}
+		askFlag(p, cmd, &result, name)
+	}
+
+	if environment.GitOps() {
+		fmt.Fprintln(p.out, "\nGitOps")
+		for _, name := range wizardGitopsFlags {
+			askFlag(p, cmd, &result, name)
+		}
+	}
+	return result, err
+}
+
+// reuseCommonInfra sets the flags that point an environment at an existing common infrastructure
+func reuseCommonInfra(cmd *cobra.Command, path string, result *WizardResult) (err error) {
+	if cmd.Flags().Lookup("common-infra-path") != nil {
+		result.Set("common-infra-path", path, false)
+		return err
+	}
+	config, err := ReadTfvarsFile(path + "/" + COMMON + "/bedrock-config.tfvars")
+	if err != nil {
+		return err
+	}
+	result.Set("keyvault", strings.Trim(config["keyvault_name"], "\""), false)
+	result.Set("keyvault-rg", strings.Trim(config["global_resource_group_name"], "\""), false)
+	return err
+}
+
+// CommandLine returns the command equivalent to the wizard, with secrets masked
+func (result WizardResult) CommandLine() string {
+	line := "bedrock " + result.Environment
+	for _, setting := range result.Settings {
+		switch {
+		case setting.Value == "true":
+			line += " --" + setting.Flag
+		case setting.Secret:
+			line += " --" + setting.Flag + " '<" + setting.Flag + ">'"
+		default:
+			line += " --" + setting.Flag + " " + strconv.Quote(setting.Value)
+		}
+	}
+	return line
+}
+
+// WriteConfig writes the settings to a config file that can be given with --config. Secrets are left out.
+func (result WizardResult) WriteConfig(path string) (err error) {
+	var content strings.Builder
+	content.WriteString("# bedrock " + result.Environment + " --config " + path + "\n")
+	for _, setting := range result.Settings {
+		if setting.Secret {
+			continue
+		}
+		if setting.Value == "true" {
+			content.WriteString(setting.Flag + ": true\n")
+		} else {
+			content.WriteString(setting.Flag + ": " + strconv.Quote(setting.Value) + "\n")
+		}
+	}
+	return ioutil.WriteFile(path, []byte(content.String()), 0644)
+}
+
+// Wizard asks for the settings of a new environment, writes them to a config file and optionally creates it
+func Wizard(p *Prompter) (err error) {
+	result, err := RunWizard(p, os.Getenv)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(p.out, "\nSummary")
+	fmt.Fprintf(p.out, "  environment: %s\n", result.Environment)
+	for _, setting := range result.Settings {
+		value := setting.Value
+		if setting.Secret {
+			value = "********"
+		}
+		fmt.Fprintf(p.out, "  %s: %s\n", setting.Flag, value)
+	}
+
+	path := p.Ask("\nWrite the settings to config file", defaultConfigFile)
+	if !fileExists(path) || p.Confirm(path+" already exists, overwrite it?", false) {
+		if err := result.WriteConfig(path); err != nil {
+			return err
+		}
+		log.Info(emoji.Sprintf(":page_with_curl: Settings have been written to %s", path))
+		log.Info(emoji.Sprintf(":bulb: To create the environment later, run 'bedrock %s --config %s'", result.Environment, path))
+	}
+	log.Info(emoji.Sprintf(":bulb: Equivalent command line: %s", result.CommandLine()))
+
+	if !p.Confirm("Create the environment now?", true) {
+		return err
+	}
+	cmd := environmentCommands()[result.Environment]
+	for _, setting := range result.Settings {
+		if err := cmd.Flags().Set(setting.Flag, setting.Value); err != nil {
+			return err
+		}
+	}
+	// RunE is called directly, so the flags cobra would check before running the command are checked here
+	if err := cmd.ValidateRequiredFlags(); err != nil {
+		return err
+	}
+	return cmd.RunE(cmd, nil)
+}
+
+var initCmd = &cobra.Command{
+	Use:   "init --interactive",
+	Short: "Create a new environment by answering questions",
+	Long:  `Create a new environment with a wizard that asks for the type of environment, the credentials, and the network and GitOps settings. The answers are written to a config file and shown as the equivalent command line.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if !interactive {
+			return errors.New("Use 'bedrock init --interactive', or one of the environment commands such as 'bedrock " + SIMPLE + "'")
+		}
+		return Wizard(NewPrompter(stdin, os.Stdout))
+	},
+}
+
+func init() {
+	initCmd.Flags().BoolVarP(&interactive, "interactive", "i", false, "Ask for the settings of the environment")
+	rootCmd.AddCommand(initCmd)
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// wizardAnswers returns a prompter that answers with one line per question
+func wizardAnswers(answers ...string) (*Prompter, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	return NewPrompter(strings.NewReader(strings.Join(answers, "\n")+"\n"), out), out
+}
+
+func TestWizardSimple(t *testing.T) {
+	env := map[string]string{"ARM_SUBSCRIPTION_ID": "sub", "ARM_TENANT_ID": "tenant"}
+	p, out := wizardAnswers(
+		"azure-simple", // environment
+		"my-cluster",   // cluster name
+		"2",            // az login
+		"n",            // do not create a service principal
+		"app-id",       // AKS service principal
+		"password",     // and its secret
+		"eastus",       // region
+		"", "", "",     // vm count, vnet and dns prefix keep their defaults
+		"git@github.com:org/manifests.git",
+		"", "prod", "", // branch, repo path, poll interval
+	)
+	result, err := RunWizard(p, func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Using subscription sub from ARM_SUBSCRIPTION_ID") {
+		t.Error("The subscription was not detected from the environment")
+	}
+
+	expected := `bedrock azure-simple --cluster-name "my-cluster" --auth "cli" --sp "app-id" --secret '<secret>' --region "eastus" --gitops-ssh-url "git@github.com:org/manifests.git" --repo-path "prod"`
+	if line := result.CommandLine(); line != expected {
+		t.Errorf("Unexpected command line:\n%s\nexpected:\n%s", line, expected)
+	}
+
+	// The config file can be read back with --config, without the secret
+	dir, err := ioutil.TempDir("", "bedrock-wizard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := result.WriteConfig(dir + "/bedrock.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadFile(dir + "/bedrock.yaml")
+	if strings.Contains(string(content), "password") {
+		t.Error("The secret was written to the config file")
+	}
+
+	var testRegion, testSecret string
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().StringVar(&testRegion, "region", "westus2", "")
+	cmd.Flags().StringVar(&testSecret, "secret", "", "")
+	configFile = dir + "/bedrock.yaml"
+	defer func() { configFile = "" }()
+	if err := loadConfigFile(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if testRegion != "eastus" || testSecret != "" {
+		t.Errorf("Unexpected settings read from the config file: %s %s", testRegion, testSecret)
+	}
+}
+
+func TestWizardReusesCommonInfra(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-wizard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+
+	os.MkdirAll("bedrock/cluster/environments/shared/"+COMMON, os.ModePerm)
+	ioutil.WriteFile("bedrock/cluster/environments/shared/"+COMMON+"/bedrock-config.tfvars", []byte("keyvault_name = \"shared-kv\"\nglobal_resource_group_name = \"shared-kv-rg\"\n"), 0644)
+
+	env := map[string]string{"ARM_SUBSCRIPTION_ID": "sub", "ARM_CLIENT_ID": "app-id", "ARM_CLIENT_SECRET": "password", "ARM_TENANT_ID": "tenant"}
+	p, _ := wizardAnswers("3", "", "1", "1")
+	result, err := RunWizard(p, func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Environment != MULTIPLE {
+		t.Fatalf("Expected %s, got %s", MULTIPLE, result.Environment)
+	}
+	if line := result.CommandLine(); line != `bedrock azure-multiple-clusters --keyvault "shared-kv" --keyvault-rg "shared-kv-rg"` {
+		t.Errorf("Unexpected command line %s", line)
+	}
+}
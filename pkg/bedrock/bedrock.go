@@ -0,0 +1,194 @@
+package bedrock
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DefaultRoot is the directory the bedrock CLI keeps the templates and environments in
+const DefaultRoot = "bedrock/cluster/environments"
+
+// Ways terraform authenticates with Azure, the same as the --auth flag of the bedrock CLI
+const (
+	AuthServicePrincipal = "sp"   // Service principal with a client secret
+	AuthCLI              = "cli"  // Account logged in with `az login`
+	AuthMSI              = "msi"  // Managed identity of the machine running terraform
+	AuthOIDC             = "oidc" // Workload identity federation
+)
+
+// authVariables tell the terraform azurerm provider how to authenticate
+var authVariables = map[string]string{
+	AuthCLI:  "ARM_USE_CLI",
+	AuthMSI:  "ARM_USE_MSI",
+	AuthOIDC: "ARM_USE_OIDC",
+}
+
+// Client runs the operations on the environments under a root directory. A Client holds no state
+// between operations and can be used by several goroutines, as long as they work on different
+// environments.
+type Client struct {
+	root      string
+	terraform Terraform
+	git       Git
+	events    EventHandler
+}
+
+// Option configures a Client
+type Option func(c *Client)
+
+// WithRoot sets the directory of the templates and environments, DefaultRoot by default
+func WithRoot(root string) Option {
+	return func(c *Client) { c.root = root }
+}
+
+// WithTerraform sets the client running terraform, ExecTerraform by default
+func WithTerraform(terraform Terraform) Option {
+	return func(c *Client) { c.terraform = terraform }
+}
+
+// WithGit sets the client cloning the templates, ExecGit by default
+func WithGit(git Git) Option {
+	return func(c *Client) { c.git = git }
+}
+
+// WithEvents sets the handler receiving the progress of the operations, none by default
+func WithEvents(handler EventHandler) Option {
+	return func(c *Client) { c.events = handler }
+}
+
+// New returns a Client running the terraform and git binaries found in the PATH, unless options
+// replace them
+func New(options ...Option) *Client {
+	c := &Client{root: DefaultRoot, terraform: ExecTerraform, git: ExecGit}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Root is the directory of the templates and environments
+func (c *Client) Root() string {
+	return c.root
+}
+
+// Credentials are the Azure credentials terraform uses for an environment, kept in CredentialsFile
+type Credentials struct {
+	Auth         string `json:"auth,omitempty"` // AuthServicePrincipal when empty
+	Subscription string `json:"subscription"`
+	Tenant       string `json:"tenant"`
+	ClientID     string `json:"clientId,omitempty"`     // Service principal, only with AuthServicePrincipal
+	ClientSecret string `json:"clientSecret,omitempty"` // Secret of the service principal, only with AuthServicePrincipal
+}
+
+// ReadCredentials reads the CredentialsFile of an environment
+func ReadCredentials(filename string) (credentials Credentials, err error) {
+	settings, err := readSettings(filename)
+	if err != nil {
+		return credentials, err
+	}
+	credentials = Credentials{
+		Auth:         unquote(settings["auth"]),
+		Subscription: unquote(settings["subscription"]),
+		Tenant:       unquote(settings["tenant_id"]),
+		ClientID:     unquote(settings["service_principal"]),
+		ClientSecret: unquote(settings["secret"]),
+	}
+	return credentials, err
+}
+
+// mode returns the authentication mode, environments created before --auth existed use a service principal
+func (credentials Credentials) mode() string {
+	if credentials.Auth == "" {
+		return AuthServicePrincipal
+	}
+	return credentials.Auth
+}
+
+// Environ returns the ARM_* variables the terraform azurerm provider reads the credentials from.
+// Variables that need to be cleared have an empty value.
+func (credentials Credentials) Environ() (variables []string) {
+	variables = append(variables, "ARM_SUBSCRIPTION_ID="+credentials.Subscription, "ARM_TENANT_ID="+credentials.Tenant)
+	mode := credentials.mode()
+	if mode == AuthServicePrincipal {
+		variables = append(variables, "ARM_CLIENT_ID="+credentials.ClientID, "ARM_CLIENT_SECRET="+credentials.ClientSecret)
+	} else {
+		// The client id of a managed or federated identity comes from the environment of the build agent
+		variables = append(variables, "ARM_CLIENT_SECRET=")
+	}
+	modes := make([]string, 0, len(authVariables))
+	for authentication := range authVariables {
+		modes = append(modes, authentication)
+	}
+	sort.Strings(modes)
+	for _, authentication := range modes {
+		if authentication == mode {
+			variables = append(variables, authVariables[authentication]+"=true")
+		} else {
+			variables = append(variables, authVariables[authentication]+"=")
+		}
+	}
+	return variables
+}
+
+// Backend is the storage account keeping the terraform state of an environment, kept in BackendFile
+type Backend struct {
+	StorageAccount string `json:"storageAccount"`
+	Container      string `json:"container"`
+	AccessKey      string `json:"accessKey,omitempty"`
+	Key            string `json:"key,omitempty"` // tfstate-<type>-<name> when empty
+}
+
+// Environment is a template copied to <root>/<name>/<type> with its settings
+type Environment struct {
+	Name    string `json:"name"`    // e.g. my-cluster
+	Type    string `json:"type"`    // e.g. azure-simple
+	Path    string `json:"path"`    // Directory terraform runs in
+	Backend bool   `json:"backend"` // The template keeps its state in a storage account, terraform init is given the BackendFile
+}
+
+// ID identifies the environment in events and errors, e.g. my-cluster/azure-simple
+func (e *Environment) ID() string {
+	return e.Name + "/" + e.Type
+}
+
+// Open returns the environment of a type in an environment directory, or ErrNotFound
+func (c *Client) Open(name string, environmentType string) (*Environment, error) {
+	path := filepath.Join(c.root, name, environmentType)
+	if !fileExists(filepath.Join(path, ConfigFile)) {
+		return nil, fmt.Errorf("%w: %s/%s", ErrNotFound, name, environmentType)
+	}
+	_, backend, err := templateVariables(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Environment{Name: name, Type: environmentType, Path: path, Backend: backend}, nil
+}
+
+// List returns the environments under the root, sorted by name and type
+func (c *Client) List() (environments []*Environment, err error) {
+	names, err := ioutil.ReadDir(c.root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if !name.IsDir() {
+			continue
+		}
+		types, err := ioutil.ReadDir(filepath.Join(c.root, name.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, environmentType := range types {
+			// Templates have no ConfigFile, only environments do
+			if environment, err := c.Open(name.Name(), environmentType.Name()); err == nil && environmentType.IsDir() {
+				environments = append(environments, environment)
+			}
+		}
+	}
+	return environments, err
+}
@@ -0,0 +1,179 @@
+package bedrock
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeTerraform records the terraform commands and answers show and output with canned JSON
+type fakeTerraform struct {
+	calls []string
+	env   []string
+	fail  string
+}
+
+func (f *fakeTerraform) Run(ctx context.Context, directory string, env []string, out io.Writer, args ...string) error {
+	f.calls = append(f.calls, strings.Join(args, " "))
+	f.env = env
+	switch args[0] {
+	case f.fail:
+		out.Write([]byte("Error: something went wrong\n"))
+		return errors.New("exit status 1")
+	case "plan", "apply":
+		out.Write([]byte("line one\nline two"))
+	case "show":
+		out.Write([]byte(`{"resource_changes": [{"address": "azurerm_resource_group.cluster", "type": "azurerm_resource_group", "change": {"actions": ["create"]}}, {"address": "azurerm_kubernetes_cluster.cluster", "type": "azurerm_kubernetes_cluster", "change": {"actions": ["delete", "create"]}}, {"address": "azurerm_key_vault.vault", "type": "azurerm_key_vault", "change": {"actions": ["no-op"]}}]}`))
+	case "output":
+		out.Write([]byte(`{"kube_config": {"value": "secret", "sensitive": true}, "cluster_name": {"value": "my-cluster", "sensitive": false}}`))
+	}
+	return nil
+}
+
+func TestClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := dir + "/bedrock/cluster/environments"
+
+	// The templates are cloned when the root does not exist yet
+	var clones []string
+	git := GitFunc(func(ctx context.Context, url string, branch string, directory string) error {
+		clones = append(clones, url+"@"+branch+" "+strings.TrimPrefix(directory, dir))
+		os.MkdirAll(directory+"/cluster/environments/azure-simple", os.ModePerm)
+		ioutil.WriteFile(directory+"/cluster/environments/azure-simple/variables.tf", []byte("variable \"cluster_name\" {\n  type = \"string\"\n}\n\nvariable \"agent_vm_count\" {\n  default = \"3\"\n}\n"), 0644)
+		ioutil.WriteFile(directory+"/cluster/environments/azure-simple/main.tf", []byte("terraform {\n  backend \"azurerm\" {}\n}\n"), 0644)
+		return nil
+	})
+	terraform := &fakeTerraform{}
+	var events []Event
+	client := New(WithRoot(root), WithGit(git), WithTerraform(terraform), WithEvents(func(event Event) { events = append(events, event) }))
+	ctx := context.Background()
+
+	cfg := Config{Name: "my-cluster", Type: "azure-simple", Variables: map[string]interface{}{"ssh_key": "key"}, Credentials: Credentials{Subscription: "sub", Tenant: "tenant"}}
+	if validation, err := client.Validate(ctx, cfg); err != nil || len(validation.Problems) != 4 {
+		t.Fatalf("Expected missing variable, unknown variable, service principal and backend problems, got %+v %v", validation, err)
+	}
+	if len(clones) != 1 || clones[0] != TemplatesURL+"@"+TemplatesVersion+" /bedrock" {
+		t.Errorf("Expected the templates to be cloned once, got %v", clones)
+	}
+	cfg.Variables = map[string]interface{}{"cluster_name": "my-cluster", "agent_vm_count": 5}
+	cfg.Credentials.ClientID, cfg.Credentials.ClientSecret = "app", "secret"
+	cfg.Backend = &Backend{StorageAccount: "state", Container: "tfstate"}
+	if validation, err := client.Validate(ctx, cfg); err != nil || !validation.Valid() || !validation.Backend || len(validation.Variables) != 2 {
+		t.Fatalf("Expected the configuration to be valid, got %+v %v", validation, err)
+	}
+
+	// The environment is written as bedrock init does
+	path := filepath.Join(root, "my-cluster", "azure-simple")
+	os.MkdirAll(path, os.ModePerm)
+	for file, content := range map[string]string{
+		"main.tf":       "terraform {\n  backend \"azurerm\" {}\n}\n",
+		ConfigFile:      "agent_vm_count = 5\ncluster_name = \"my-cluster\"\n",
+		BackendFile:     "access_key = \"\"\ncontainer_name = \"tfstate\"\nkey = \"tfstate-azure-simple-my-cluster\"\nstorage_account_name = \"state\"\n",
+		CredentialsFile: "auth = \"sp\"\nsecret = \"secret\"\nservice_principal = \"app\"\nsubscription = \"sub\"\ntenant_id = \"tenant\"\n",
+	} {
+		ioutil.WriteFile(filepath.Join(path, file), []byte(content), 0644)
+	}
+	environment, err := client.Open("my-cluster", "azure-simple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !environment.Backend || environment.ID() != "my-cluster/azure-simple" {
+		t.Errorf("Unexpected environment %+v", environment)
+	}
+
+	environments, err := client.List()
+	if err != nil || len(environments) != 1 || environments[0].ID() != "my-cluster/azure-simple" || !environments[0].Backend {
+		t.Fatalf("Expected the environment to be listed and not the template, got %v %v", environments, err)
+	}
+	if _, err := client.Open("other", "azure-simple"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	plan, err := client.Plan(ctx, environment, PlanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Add != 2 || plan.Change != 0 || plan.Destroy != 1 || len(plan.Changes) != 2 || plan.PlanFile != "bedrock.tfplan" {
+		t.Errorf("Unexpected plan %+v", plan)
+	}
+	if _, err := client.Apply(ctx, environment, ApplyOptions{PlanFile: plan.PlanFile, SkipInit: true}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"init -input=false -backend-config=./bedrock-backend-config.tfvars",
+		"plan -input=false -var-file=./bedrock-config.tfvars -out=bedrock.tfplan",
+		"show -json bedrock.tfplan",
+		"apply -input=false bedrock.tfplan",
+	}
+	if strings.Join(terraform.calls, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected terraform commands %v", terraform.calls)
+	}
+	if strings.Join(terraform.env, " ") != "ARM_SUBSCRIPTION_ID=sub ARM_TENANT_ID=tenant ARM_CLIENT_ID=app ARM_CLIENT_SECRET=secret ARM_USE_CLI= ARM_USE_MSI= ARM_USE_OIDC=" {
+		t.Errorf("Unexpected credentials %v", terraform.env)
+	}
+
+	var output []string
+	for _, event := range events {
+		if event.Step == "apply" && event.Type == EventOutput {
+			output = append(output, event.Message)
+		}
+	}
+	if strings.Join(output, "|") != "line one|line two" || events[len(events)-1].Type != EventCompleted {
+		t.Errorf("Expected the apply output line by line, got %v", output)
+	}
+
+	status, err := client.Status(ctx, environment, StatusOptions{Outputs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Deployed || status.Changed || len(status.Outputs) != 1 || status.Outputs["cluster_name"] != "my-cluster" {
+		t.Errorf("Unexpected status %+v", status)
+	}
+	ioutil.WriteFile(filepath.Join(environment.Path, ConfigFile), []byte("agent_vm_count = 6\ncluster_name = \"my-cluster\"\n"), 0644)
+	if status, _ := client.Status(ctx, environment, StatusOptions{}); !status.Changed {
+		t.Error("Expected the edited settings to differ from the deployed ones")
+	}
+
+	terraform.fail = "destroy"
+	err = client.Destroy(ctx, environment, DestroyOptions{SkipInit: true})
+	if command := (*CommandError)(nil); !errors.As(err, &command) || command.Command != "terraform destroy" || command.Environment != "my-cluster/azure-simple" {
+		t.Fatalf("Expected a terraform destroy error, got %v", err)
+	}
+	if last := events[len(events)-1]; last.Type != EventFailed || last.Step != "destroy" {
+		t.Errorf("Expected a failed event, got %+v", last)
+	}
+	terraform.fail = ""
+	if err := client.Destroy(ctx, environment, DestroyOptions{SkipInit: true}); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := client.Status(ctx, environment, StatusOptions{}); status.Deployed {
+		t.Error("Expected the environment not to be deployed after destroy")
+	}
+}
+
+func TestCredentialsEnviron(t *testing.T) {
+	environ := strings.Join(Credentials{Auth: AuthMSI, Subscription: "sub", Tenant: "tenant", ClientID: "ignored"}.Environ(), " ")
+	if environ != "ARM_SUBSCRIPTION_ID=sub ARM_TENANT_ID=tenant ARM_CLIENT_SECRET= ARM_USE_CLI= ARM_USE_MSI=true ARM_USE_OIDC=" {
+		t.Errorf("Unexpected variables %s", environ)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	content := string(snapshot([]byte("cluster_name = \"my-cluster\"\nservice_principal_secret = \"secret\"\n")))
+	if strings.Contains(content, "\"secret\"") || !strings.HasPrefix(content, "cluster_name = \"my-cluster\"\nservice_principal_secret = \"sha256:") {
+		t.Errorf("Expected the secret to be replaced by its digest, got %s", content)
+	}
+	digest := SnapshotValue("service_principal_secret", "\"secret\"")
+	if SnapshotValue("service_principal_secret", digest) != digest || SnapshotValue("cluster_name", "\"secret\"") != "\"secret\"" {
+		t.Error("Expected digests and other settings to be kept as they are")
+	}
+}
@@ -0,0 +1,53 @@
+package bedrock
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Terraform runs terraform in the directory of an environment. env is added to the environment of the
+// process and the output is written to out as it is produced.
+type Terraform interface {
+	Run(ctx context.Context, directory string, env []string, out io.Writer, args ...string) error
+}
+
+// TerraformFunc is a function used as a Terraform client
+type TerraformFunc func(ctx context.Context, directory string, env []string, out io.Writer, args ...string) error
+
+// Run calls f
+func (f TerraformFunc) Run(ctx context.Context, directory string, env []string, out io.Writer, args ...string) error {
+	return f(ctx, directory, env, out, args...)
+}
+
+// Git clones the Bedrock templates
+type Git interface {
+	Clone(ctx context.Context, url string, branch string, directory string) error
+}
+
+// GitFunc is a function used as a Git client
+type GitFunc func(ctx context.Context, url string, branch string, directory string) error
+
+// Clone calls f
+func (f GitFunc) Clone(ctx context.Context, url string, branch string, directory string) error {
+	return f(ctx, url, branch, directory)
+}
+
+// ExecTerraform runs the terraform binary found in the PATH
+var ExecTerraform = TerraformFunc(func(ctx context.Context, directory string, env []string, out io.Writer, args ...string) error {
+	cmd := exec.CommandContext(ctx, "terraform", args...)
+	cmd.Dir = directory
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	return cmd.Run()
+})
+
+// ExecGit runs the git binary found in the PATH
+var ExecGit = GitFunc(func(ctx context.Context, url string, branch string, directory string) error {
+	if output, err := exec.CommandContext(ctx, "git", "clone", "--branch", branch, url, directory).CombinedOutput(); err != nil {
+		return &CommandError{Command: "git clone", Err: err, Output: string(output)}
+	}
+	return nil
+})
@@ -0,0 +1,20 @@
+// Package bedrock plans, applies, destroys and checks Bedrock environments from Go programs, without
+// the command line. It holds no global state and does not log: terraform and git are reached through
+// the Terraform and Git clients given to New, results are returned as structs, and progress is reported
+// to an EventHandler.
+//
+// An environment lives in <root>/<name>/<type>, e.g. bedrock/cluster/environments/my-cluster/azure-simple,
+// and is created with bedrock init. Validate checks a Config against its template before that.
+//
+// The API follows semantic versioning, see Version. Until 1.0.0, minor versions may change it in
+// incompatible ways; patch versions never do.
+package bedrock
+
+// Version is the version of the API
+const Version = "0.2.0"
+
+// TemplatesURL and TemplatesVersion are the Bedrock repository and version environments are created from
+const (
+	TemplatesURL     = "https://github.com/microsoft/bedrock"
+	TemplatesVersion = "v0.12.0"
+)
@@ -0,0 +1,35 @@
+package bedrock
+
+import (
+	"errors"
+	"strings"
+)
+
+// Errors returned by the operations, compare them with errors.Is
+var (
+	ErrNotFound = errors.New("bedrock: environment not found")
+)
+
+// CommandError is a terraform or git command that failed
+type CommandError struct {
+	Environment string // e.g. my-cluster/azure-simple, empty for commands not run for an environment
+	Command     string // e.g. terraform apply
+	Err         error
+	Output      string // Output of the command, when it is not streamed as events
+}
+
+func (e *CommandError) Error() string {
+	message := e.Command + ": " + e.Err.Error()
+	if e.Environment != "" {
+		message = e.Environment + ": " + message
+	}
+	if output := strings.TrimSpace(e.Output); output != "" {
+		message += ": " + output
+	}
+	return message
+}
+
+// Unwrap returns the error of the command
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,73 @@
+package bedrock
+
+import (
+	"strings"
+	"time"
+)
+
+// EventType is the kind of progress an Event reports
+type EventType string
+
+// Types of events
+const (
+	EventStarted   EventType = "started"   // A step started, e.g. terraform apply
+	EventOutput    EventType = "output"    // A line of output of a step
+	EventWarning   EventType = "warning"   // Something did not work, the operation goes on
+	EventCompleted EventType = "completed" // A step completed
+	EventFailed    EventType = "failed"    // A step failed, the operation returns its error
+)
+
+// Event reports the progress of an operation on an environment
+type Event struct {
+	Time        time.Time `json:"time"`
+	Environment string    `json:"environment"` // e.g. my-cluster/azure-simple
+	Step        string    `json:"step"`        // e.g. init, plan, apply, destroy
+	Type        EventType `json:"type"`
+	Message     string    `json:"message"`
+}
+
+// EventHandler receives the events of the operations of a Client. It is called from the goroutine
+// running the operation.
+type EventHandler func(event Event)
+
+// ChannelHandler sends the events to a channel, e.g. to stream them to another goroutine
+func ChannelHandler(events chan<- Event) EventHandler {
+	return func(event Event) {
+		events <- event
+	}
+}
+
+// emit sends an event to the handler of the client, if it has one
+func (c *Client) emit(environment string, step string, eventType EventType, message string) {
+	if c.events != nil {
+		c.events(Event{Time: time.Now(), Environment: environment, Step: step, Type: eventType, Message: message})
+	}
+}
+
+// eventWriter turns the output of a step into one EventOutput per line
+type eventWriter struct {
+	client      *Client
+	environment string
+	step        string
+	buffer      []byte
+}
+
+func (w *eventWriter) Write(p []byte) (n int, err error) {
+	w.buffer = append(w.buffer, p...)
+	for {
+		newline := strings.IndexByte(string(w.buffer), '\n')
+		if newline < 0 {
+			return len(p), err
+		}
+		w.client.emit(w.environment, w.step, EventOutput, strings.TrimRight(string(w.buffer[:newline]), "\r"))
+		w.buffer = w.buffer[newline+1:]
+	}
+}
+
+// Flush sends the last line of output when it does not end with a newline
+func (w *eventWriter) Flush() {
+	if len(w.buffer) > 0 {
+		w.client.emit(w.environment, w.step, EventOutput, string(w.buffer))
+		w.buffer = nil
+	}
+}
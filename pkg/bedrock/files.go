@@ -0,0 +1,142 @@
+package bedrock
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Files of an environment, the same as the bedrock CLI
+const (
+	ConfigFile      = "bedrock-config.tfvars"
+	BackendFile     = "bedrock-backend-config.tfvars"
+	CredentialsFile = "bedrock-sp-config.toml"
+	DeployedFile    = ".bedrock-config.deployed.tfvars" // Copy of ConfigFile made at the last successful apply
+)
+
+// SecretSetting reports whether a setting holds a secret, e.g. service_principal_secret or access_key
+func SecretSetting(setting string) bool {
+	return strings.Contains(setting, "secret") || strings.Contains(setting, "access_key")
+}
+
+// SnapshotValue returns a setting as kept in DeployedFile and the generated settings. Secrets are replaced
+// by their SHA-256 digest, so the copies can be compared with ConfigFile without holding the secrets.
+func SnapshotValue(setting string, value string) string {
+	if !SecretSetting(setting) || strings.HasPrefix(value, "\"sha256:") {
+		return value
+	}
+	return fmt.Sprintf("\"sha256:%x\"", sha256.Sum256([]byte(value)))
+}
+
+// snapshot replaces the secrets of the key = value lines of a tfvars file by their SnapshotValue
+func snapshot(content []byte) []byte {
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		if equal := strings.Index(line, "="); equal >= 0 {
+			if key := strings.TrimSpace(line[:equal]); SecretSetting(key) {
+				lines[i] = line[:equal+1] + " " + SnapshotValue(key, strings.TrimSpace(line[equal+1:]))
+			}
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// readSettings reads the key = value lines of a tfvars or toml file, values are kept as written
+func readSettings(filename string) (settings map[string]string, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	settings = map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if equal := strings.Index(line, "="); equal >= 0 {
+			if key := strings.TrimSpace(line[:equal]); len(key) > 0 {
+				settings[key] = strings.TrimSpace(line[equal+1:])
+			}
+		}
+	}
+	return settings, scanner.Err()
+}
+
+// unquote returns the string a setting holds, or the setting as written when it is not a string
+func unquote(value string) string {
+	var s string
+	if err := json.Unmarshal([]byte(value), &s); err == nil {
+		return s
+	}
+	return value
+}
+
+// hclValue writes a variable as a terraform expression. Strings are quoted; numbers, booleans, lists
+// and maps use their JSON form, which terraform reads as the same value.
+func hclValue(value interface{}) (string, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("bedrock: cannot write %v as a terraform value: %s", value, err)
+	}
+	return string(encoded), nil
+}
+
+// Variable is a variable declared by a template
+type Variable struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required"` // The variable has no default value
+}
+
+var variableRegex = regexp.MustCompile(`(?m)^\s*variable\s+"([^"]+)"\s*\{`)
+var variableDefaultRegex = regexp.MustCompile(`(?m)^\s*default\s*=`)
+var variableDescriptionRegex = regexp.MustCompile(`(?m)^\s*description\s*=\s*"(.*)"\s*$`)
+var backendRegex = regexp.MustCompile(`(?m)^\s*backend\s+"azurerm"\s*\{`)
+
+// templateVariables returns the variables declared by the .tf files of a template, and whether it
+// keeps its state in a storage account
+func templateVariables(template string) (variables map[string]Variable, backend bool, err error) {
+	files, err := filepath.Glob(template + "/*.tf")
+	if err != nil {
+		return nil, false, err
+	}
+	variables = map[string]Variable{}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, false, err
+		}
+		text := string(content)
+		backend = backend || backendRegex.MatchString(text)
+		for _, match := range variableRegex.FindAllStringSubmatchIndex(text, -1) {
+			// The body of the block ends at the matching closing brace
+			depth, end := 1, match[1]
+			for ; end < len(text) && depth > 0; end++ {
+				switch text[end] {
+				case '{':
+					depth++
+				case '}':
+					depth--
+				}
+			}
+			body := text[match[1]:end]
+			variable := Variable{Name: text[match[2]:match[3]], Required: !variableDefaultRegex.MatchString(body)}
+			if m := variableDescriptionRegex.FindStringSubmatch(body); m != nil {
+				variable.Description = m[1]
+			}
+			variables[variable.Name] = variable
+		}
+	}
+	return variables, backend, err
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
@@ -0,0 +1,383 @@
+package bedrock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Config describes an environment to check before creating it with bedrock init
+type Config struct {
+	Name        string                 `json:"name"` // Environment directory, e.g. my-cluster
+	Type        string                 `json:"type"` // Template, e.g. azure-simple
+	Variables   map[string]interface{} `json:"variables"`
+	Credentials Credentials            `json:"credentials"`
+	Backend     *Backend               `json:"backend,omitempty"` // Required by templates keeping their state in a storage account
+}
+
+// ValidationResult lists the problems of a Config
+type ValidationResult struct {
+	Problems  []string   `json:"problems,omitempty"`
+	Variables []Variable `json:"variables,omitempty"` // Variables declared by the template
+	Backend   bool       `json:"backend"`             // The template keeps its state in a storage account
+}
+
+// Valid tells whether the Config is valid
+func (r *ValidationResult) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+var nameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// Validate checks a Config against its template, cloning the templates first when they are missing
+func (c *Client) Validate(ctx context.Context, cfg Config) (result *ValidationResult, err error) {
+	if err := c.templates(ctx); err != nil {
+		return nil, err
+	}
+	result = &ValidationResult{}
+	problem := func(format string, args ...interface{}) {
+		result.Problems = append(result.Problems, fmt.Sprintf(format, args...))
+	}
+
+	if !nameRegex.MatchString(cfg.Name) {
+		problem("name %q must start with a letter or digit and hold only letters, digits, - and _", cfg.Name)
+	}
+	template := filepath.Join(c.root, cfg.Type)
+	if !nameRegex.MatchString(cfg.Type) || !fileExists(template) {
+		problem("unknown environment type %q", cfg.Type)
+		return result, err
+	}
+	variables, backend, err := templateVariables(template)
+	if err != nil {
+		return nil, err
+	}
+	result.Backend = backend
+
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		variable := variables[name]
+		result.Variables = append(result.Variables, variable)
+		if _, ok := cfg.Variables[name]; variable.Required && !ok {
+			problem("missing variable %s", name)
+		}
+	}
+	for name, value := range cfg.Variables {
+		if _, ok := variables[name]; !ok {
+			problem("%s does not declare variable %s", cfg.Type, name)
+		} else if _, err := hclValue(value); err != nil {
+			problem("variable %s: %s", name, err)
+		}
+	}
+
+	switch cfg.Credentials.mode() {
+	case AuthServicePrincipal:
+		if cfg.Credentials.ClientID == "" || cfg.Credentials.ClientSecret == "" {
+			problem("a service principal needs a client id and secret")
+		}
+	case AuthCLI, AuthMSI, AuthOIDC:
+	default:
+		problem("unsupported authentication mode %q, use sp, cli, msi or oidc", cfg.Credentials.Auth)
+	}
+	if cfg.Credentials.Subscription == "" || cfg.Credentials.Tenant == "" {
+		problem("missing subscription or tenant")
+	}
+
+	if backend && (cfg.Backend == nil || cfg.Backend.StorageAccount == "" || cfg.Backend.Container == "") {
+		problem("%s keeps its state in a storage account, the backend needs a storage account and container", cfg.Type)
+	}
+	sort.Strings(result.Problems)
+	return result, err
+}
+
+// templates clones the Bedrock templates when the root has none
+func (c *Client) templates(ctx context.Context) error {
+	if fileExists(c.root) {
+		return nil
+	}
+	// The environments are a part of the Bedrock repository, e.g. bedrock/cluster/environments
+	repository := c.root
+	if strings.HasSuffix(filepath.ToSlash(c.root), "/cluster/environments") {
+		repository = filepath.Dir(filepath.Dir(c.root))
+	}
+	c.emit("", "templates", EventStarted, "Cloning Bedrock "+TemplatesVersion+" into "+repository)
+	if err := c.git.Clone(ctx, TemplatesURL, TemplatesVersion, repository); err != nil {
+		c.emit("", "templates", EventFailed, err.Error())
+		return err
+	}
+	c.emit("", "templates", EventCompleted, "Cloned Bedrock "+TemplatesVersion)
+	return nil
+}
+
+// run runs one terraform step of an operation, reporting its output as events
+func (c *Client) run(ctx context.Context, environment *Environment, env []string, step string, args ...string) error {
+	id := environment.ID()
+	c.emit(id, step, EventStarted, "terraform "+step)
+	out := &eventWriter{client: c, environment: id, step: step}
+	err := c.terraform.Run(ctx, environment.Path, env, out, args...)
+	out.Flush()
+	if err != nil {
+		err = &CommandError{Environment: id, Command: "terraform " + args[0], Err: err}
+		c.emit(id, step, EventFailed, err.Error())
+		return err
+	}
+	c.emit(id, step, EventCompleted, "terraform "+step)
+	return nil
+}
+
+// credentials returns the ARM_* variables of an environment
+func (c *Client) credentials(environment *Environment) ([]string, error) {
+	credentials, err := ReadCredentials(filepath.Join(environment.Path, CredentialsFile))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", environment.ID(), err)
+	}
+	return credentials.Environ(), nil
+}
+
+// Init runs terraform init, with the storage account backend when the environment has one
+func (c *Client) Init(ctx context.Context, environment *Environment) error {
+	env, err := c.credentials(environment)
+	if err != nil {
+		return err
+	}
+	return c.init(ctx, environment, env)
+}
+
+func (c *Client) init(ctx context.Context, environment *Environment, env []string) error {
+	args := []string{"init", "-input=false"}
+	if environment.Backend {
+		args = append(args, "-backend-config=./"+BackendFile)
+	}
+	return c.run(ctx, environment, env, "init", args...)
+}
+
+// PlanOptions configure Plan
+type PlanOptions struct {
+	PlanFile string   // File the plan is saved to in the environment, bedrock.tfplan by default
+	Args     []string // Added to terraform plan, e.g. -destroy
+	SkipInit bool     // The environment was initialized already
+}
+
+// ResourceChange is a change of one resource in a plan
+type ResourceChange struct {
+	Address string   `json:"address"`
+	Type    string   `json:"type"`
+	Actions []string `json:"actions"` // e.g. create, update, delete
+}
+
+// PlanResult is a plan saved in an environment, ready to be applied
+type PlanResult struct {
+	Environment string           `json:"environment"`
+	PlanFile    string           `json:"planFile"`
+	Add         int              `json:"add"`
+	Change      int              `json:"change"`
+	Destroy     int              `json:"destroy"`
+	Changes     []ResourceChange `json:"changes"`
+	JSON        json.RawMessage  `json:"-"` // Output of terraform show -json
+}
+
+// Plan saves a plan of an environment and returns its changes
+func (c *Client) Plan(ctx context.Context, environment *Environment, options PlanOptions) (*PlanResult, error) {
+	env, err := c.credentials(environment)
+	if err != nil {
+		return nil, err
+	}
+	if !options.SkipInit {
+		if err := c.init(ctx, environment, env); err != nil {
+			return nil, err
+		}
+	}
+	planFile := options.PlanFile
+	if planFile == "" {
+		planFile = "bedrock.tfplan"
+	}
+	args := append([]string{"plan", "-input=false", "-var-file=./" + ConfigFile, "-out=" + planFile}, options.Args...)
+	if err := c.run(ctx, environment, env, "plan", args...); err != nil {
+		return nil, err
+	}
+
+	var show bytes.Buffer
+	if err := c.terraform.Run(ctx, environment.Path, env, &show, "show", "-json", planFile); err != nil {
+		return nil, &CommandError{Environment: environment.ID(), Command: "terraform show", Err: err}
+	}
+	result := &PlanResult{Environment: environment.ID(), PlanFile: planFile, JSON: show.Bytes()}
+	var plan struct {
+		ResourceChanges []struct {
+			Address string
+			Type    string
+			Change  struct{ Actions []string }
+		} `json:"resource_changes"`
+	}
+	if err := json.Unmarshal(show.Bytes(), &plan); err != nil {
+		return nil, fmt.Errorf("%s: terraform show: %s", environment.ID(), err)
+	}
+	for _, resource := range plan.ResourceChanges {
+		change := ResourceChange{Address: resource.Address, Type: resource.Type, Actions: resource.Change.Actions}
+		for _, action := range change.Actions {
+			switch action {
+			case "create":
+				result.Add++
+			case "update":
+				result.Change++
+			case "delete":
+				result.Destroy++
+			}
+		}
+		if len(change.Actions) > 0 && change.Actions[0] != "no-op" && change.Actions[0] != "read" {
+			result.Changes = append(result.Changes, change)
+		}
+	}
+	return result, nil
+}
+
+// ApplyOptions configure Apply
+type ApplyOptions struct {
+	PlanFile string // Plan saved by Plan to apply, the environment is planned and applied at once when empty
+	SkipInit bool   // The environment was initialized already
+}
+
+// ApplyResult is the outcome of Apply
+type ApplyResult struct {
+	Environment string        `json:"environment"`
+	Duration    time.Duration `json:"duration"`
+}
+
+// Apply deploys an environment and records the applied settings in DeployedFile
+func (c *Client) Apply(ctx context.Context, environment *Environment, options ApplyOptions) (*ApplyResult, error) {
+	start := time.Now()
+	env, err := c.credentials(environment)
+	if err != nil {
+		return nil, err
+	}
+	if !options.SkipInit {
+		if err := c.init(ctx, environment, env); err != nil {
+			return nil, err
+		}
+	}
+	args := []string{"apply", "-input=false", "-var-file=./" + ConfigFile, "-auto-approve"}
+	if options.PlanFile != "" {
+		args = []string{"apply", "-input=false", options.PlanFile}
+	}
+	if err := c.run(ctx, environment, env, "apply", args...); err != nil {
+		return nil, err
+	}
+
+	// Keep the values that were applied, bedrock drift and status compare against them. Secrets are
+	// only kept as digests, the copy is not a second place to leak them from.
+	if content, err := ioutil.ReadFile(filepath.Join(environment.Path, ConfigFile)); err != nil {
+		c.emit(environment.ID(), "apply", EventWarning, "Could not record the deployed tfvars: "+err.Error())
+	} else if err := ioutil.WriteFile(filepath.Join(environment.Path, DeployedFile), snapshot(content), 0644); err != nil {
+		c.emit(environment.ID(), "apply", EventWarning, "Could not record the deployed tfvars: "+err.Error())
+	}
+	return &ApplyResult{Environment: environment.ID(), Duration: time.Since(start)}, nil
+}
+
+// DestroyOptions configure Destroy
+type DestroyOptions struct {
+	SkipInit bool // The environment was initialized already
+}
+
+// Destroy removes the resources of an environment, its directory is kept
+func (c *Client) Destroy(ctx context.Context, environment *Environment, options DestroyOptions) error {
+	env, err := c.credentials(environment)
+	if err != nil {
+		return err
+	}
+	if !options.SkipInit {
+		if err := c.init(ctx, environment, env); err != nil {
+			return err
+		}
+	}
+	if err := c.run(ctx, environment, env, "destroy", "destroy", "-input=false", "-var-file=./"+ConfigFile, "-auto-approve"); err != nil {
+		return err
+	}
+
+	// Nothing is deployed anymore, status and drift should not compare against the old values
+	if err := os.Remove(filepath.Join(environment.Path, DeployedFile)); err != nil && !os.IsNotExist(err) {
+		c.emit(environment.ID(), "destroy", EventWarning, "Could not remove the deployed tfvars: "+err.Error())
+	}
+	return nil
+}
+
+// StatusOptions configure Status
+type StatusOptions struct {
+	Outputs bool // Read the terraform outputs of deployed environments, which needs the credentials
+}
+
+// StatusResult is the state of an environment
+type StatusResult struct {
+	Environment string                 `json:"environment"`
+	Deployed    bool                   `json:"deployed"`
+	DeployedAt  time.Time              `json:"deployedAt,omitempty"`
+	Changed     bool                   `json:"changed"` // The settings differ from the deployed ones
+	Outputs     map[string]interface{} `json:"outputs,omitempty"`
+}
+
+// Status tells whether an environment is deployed and, with options.Outputs, returns its outputs
+func (c *Client) Status(ctx context.Context, environment *Environment, options StatusOptions) (*StatusResult, error) {
+	result := &StatusResult{Environment: environment.ID()}
+	info, err := os.Stat(filepath.Join(environment.Path, DeployedFile))
+	if os.IsNotExist(err) {
+		return result, nil
+	} else if err != nil {
+		return nil, err
+	}
+	result.Deployed, result.DeployedAt = true, info.ModTime()
+
+	deployed, err := readSettings(filepath.Join(environment.Path, DeployedFile))
+	if err != nil {
+		return nil, err
+	}
+	current, err := readSettings(filepath.Join(environment.Path, ConfigFile))
+	if err != nil {
+		return nil, err
+	}
+	result.Changed = len(deployed) != len(current)
+	for setting, value := range current {
+		if deployedValue, ok := deployed[setting]; !ok || SnapshotValue(setting, deployedValue) != SnapshotValue(setting, value) {
+			result.Changed = true
+		}
+	}
+
+	if options.Outputs {
+		env, err := c.credentials(environment)
+		if err != nil {
+			return nil, err
+		}
+		// The backend has to be initialized to read a remote state
+		if !fileExists(filepath.Join(environment.Path, ".terraform")) {
+			if err := c.init(ctx, environment, env); err != nil {
+				return nil, err
+			}
+		}
+		var output bytes.Buffer
+		if err := c.terraform.Run(ctx, environment.Path, env, &output, "output", "-json"); err != nil {
+			return nil, &CommandError{Environment: environment.ID(), Command: "terraform output", Err: err}
+		}
+		var outputs map[string]struct {
+			Value     interface{}
+			Sensitive bool
+		}
+		if err := json.Unmarshal(output.Bytes(), &outputs); err != nil {
+			return nil, fmt.Errorf("%s: terraform output: %s", environment.ID(), err)
+		}
+		result.Outputs = map[string]interface{}{}
+		for name, value := range outputs {
+			if !value.Sensitive {
+				result.Outputs[name] = value.Value
+			}
+		}
+	}
+	return result, nil
+}
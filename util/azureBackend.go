@@ -3,25 +3,44 @@ package util
 import (
 	"os"
 	"os/exec"
+	"sort"
 
 	"github.com/kyokomi/emoji"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
+// TagArgs returns the `--tags` arguments of az commands for the given tags
+func TagArgs(tags map[string]string) (args []string) {
+	if len(tags) == 0 {
+		return args
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	args = append(args, "--tags")
+	for _, key := range keys {
+		args = append(args, key+"="+tags[key])
+	}
+	return args
+}
+
 // CreateStorageAccount function will create an Azure Storage Account if not provided
-func CreateStorageAccount(storageAccount string, resourceGroup string, region string) (err error) {
+func CreateStorageAccount(storageAccount string, resourceGroup string, region string, tags map[string]string) (err error) {
 	log.Info(emoji.Sprintf(":computer: Creating a Storage Account"))
 
 	// Create Resource Group for Storage Account resources
 
-	rgCmd := exec.Command("az", "group", "create", "--location", "centralus", "--name", resourceGroup)
+	rgCmd := exec.Command("az", append([]string{"group", "create", "--location", "centralus", "--name", resourceGroup}, TagArgs(tags)...)...)
 	if output, err := rgCmd.CombinedOutput(); err != nil {
 		log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, output))
 		return err
 	}
 
-	storageCmd := exec.Command("az", "storage", "account", "create", "--name", storageAccount, "--resource-group", resourceGroup, "--location", region, "--sku", "Standard_LRS", "--encryption", "blob")
+	storageCmd := exec.Command("az", append([]string{"storage", "account", "create", "--name", storageAccount, "--resource-group", resourceGroup, "--location", region, "--sku", "Standard_LRS", "--encryption", "blob"}, TagArgs(tags)...)...)
 	if output, err := storageCmd.CombinedOutput(); err != nil {
 		log.Error(emoji.Sprintf(":no_entry_sign: %s: %s", err, output))
 		return err
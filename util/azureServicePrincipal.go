@@ -0,0 +1,40 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strings"
+
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+)
+
+// ServicePrincipal holds the credential returned by `az ad sp create-for-rbac`
+type ServicePrincipal struct {
+	AppID       string `json:"appId"`
+	DisplayName string `json:"displayName"`
+	Password    string `json:"password"`
+	Tenant      string `json:"tenant"`
+}
+
+// CreateServicePrincipal function will create a service principal with the given role on the given scope
+func CreateServicePrincipal(name string, role string, scope string) (sp ServicePrincipal, err error) {
+	log.Info(emoji.Sprintf(":bust_in_silhouette: Creating Service Principal %s with role %s on %s", name, role, scope))
+
+	output, err := exec.Command("az", "ad", "sp", "create-for-rbac", "--name", name, "--role", role, "--scopes", scope, "--output", "json").Output()
+	if err != nil {
+		message := err.Error()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			message = strings.TrimSpace(string(exitErr.Stderr))
+		}
+		log.Error(emoji.Sprintf(":no_entry_sign: There was an error creating the service principal: %s", message))
+		return sp, errors.New(message)
+	}
+	if err := json.Unmarshal(output, &sp); err != nil {
+		return sp, err
+	}
+
+	log.Info(emoji.Sprintf(":raised_hands: Service Principal %s created!", sp.AppID))
+	return sp, err
+}
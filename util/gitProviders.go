@@ -0,0 +1,231 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Supported GitOps repository hosts
+const (
+	GITHUB      = "github"
+	GITLAB      = "gitlab"
+	AZUREDEVOPS = "azure-devops"
+)
+
+// GitRepository identifies a repository on a git hosting service
+type GitRepository struct {
+	Provider     string // github, gitlab or azure-devops
+	Host         string // Host name of the SSH url (e.g. github.com)
+	Organization string // Owner, group path or Azure DevOps organization
+	Project      string // Azure DevOps project, empty for other providers
+	Name         string // Repository name
+}
+
+// Path returns the path of the repository on its host
+func (repo GitRepository) Path() string {
+	if repo.Project != "" {
+		return repo.Organization + "/" + repo.Project + "/" + repo.Name
+	}
+	return repo.Organization + "/" + repo.Name
+}
+
+// ParseGitopsURL identifies the provider and repository of an SSH url such as
+// git@github.com:owner/repo.git or git@ssh.dev.azure.com:v3/org/project/repo
+func ParseGitopsURL(sshURL string) (repo GitRepository, err error) {
+	var host, path string
+	if strings.HasPrefix(sshURL, "ssh://") {
+		parsed, err := url.Parse(sshURL)
+		if err != nil {
+			return repo, err
+		}
+		host = parsed.Hostname()
+		path = strings.TrimPrefix(parsed.Path, "/")
+	} else {
+		at := strings.Index(sshURL, "@")
+		colon := strings.Index(sshURL, ":")
+		if at < 0 || colon < at {
+			return repo, errors.New("'" + sshURL + "' is not a git url in ssh format")
+		}
+		host = sshURL[at+1 : colon]
+		path = sshURL[colon+1:]
+	}
+	path = strings.TrimSuffix(strings.TrimSuffix(path, "/"), ".git")
+	parts := strings.Split(path, "/")
+	repo.Host = host
+
+	switch {
+	case host == "ssh.dev.azure.com" || strings.HasSuffix(host, ".visualstudio.com"):
+		// Azure DevOps urls have the form v3/organization/project/repository
+		if len(parts) != 4 || parts[0] != "v3" {
+			return repo, errors.New("'" + sshURL + "' is not a valid Azure DevOps ssh url")
+		}
+		repo.Provider = AZUREDEVOPS
+		repo.Organization, repo.Project, repo.Name = parts[1], parts[2], parts[3]
+	case strings.Contains(host, "gitlab"):
+		if len(parts) < 2 {
+			return repo, errors.New("'" + sshURL + "' is not a valid GitLab ssh url")
+		}
+		repo.Provider = GITLAB
+		repo.Organization = strings.Join(parts[:len(parts)-1], "/")
+		repo.Name = parts[len(parts)-1]
+	case strings.Contains(host, "github"):
+		if len(parts) != 2 {
+			return repo, errors.New("'" + sshURL + "' is not a valid GitHub ssh url")
+		}
+		repo.Provider = GITHUB
+		repo.Organization, repo.Name = parts[0], parts[1]
+	default:
+		return repo, errors.New("The git host '" + host + "' is not supported, use GitHub, GitLab or Azure DevOps")
+	}
+	return repo, err
+}
+
+// DeployKeyProvider registers SSH keys with a git hosting service
+type DeployKeyProvider interface {
+	// AddDeployKey registers a read-only key with the repository and returns its id
+	AddDeployKey(repo GitRepository, title string, publicKey string) (id string, err error)
+	// RemoveDeployKey deletes a key previously registered with AddDeployKey
+	RemoveDeployKey(repo GitRepository, id string) (err error)
+}
+
+// sendJSON sends a request with an optional JSON body and decodes the JSON response into result
+func sendJSON(client *http.Client, request *http.Request, body interface{}, result interface{}) (err error) {
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		request.Body = ioutil.NopCloser(bytes.NewReader(payload))
+		request.ContentLength = int64(len(payload))
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("%s %s returned %s: %s", request.Method, request.URL.Path, response.Status, strings.TrimSpace(string(content)))
+	}
+	if result != nil && len(content) > 0 {
+		return json.Unmarshal(content, result)
+	}
+	return err
+}
+
+// GitHubAPIURL returns the REST API url of a GitHub host, GitHub Enterprise Server serves it under /api/v3
+func GitHubAPIURL(host string) string {
+	if host == "github.com" {
+		return "https://api.github.com"
+	}
+	return "https://" + host + "/api/v3"
+}
+
+// GitHubProvider registers deploy keys through the GitHub REST API
+type GitHubProvider struct {
+	BaseURL string // Defaults to https://api.github.com, see GitHubAPIURL for other hosts
+	Token   string
+	Client  *http.Client
+}
+
+func (provider GitHubProvider) request(method string, path string) (*http.Request, error) {
+	baseURL := provider.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.github.com"
+	}
+	request, err := http.NewRequest(method, strings.TrimSuffix(baseURL, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "token "+provider.Token)
+	request.Header.Set("Accept", "application/vnd.github.v3+json")
+	return request, nil
+}
+
+// AddDeployKey registers a read-only deploy key with a GitHub repository
+func (provider GitHubProvider) AddDeployKey(repo GitRepository, title string, publicKey string) (id string, err error) {
+	request, err := provider.request(http.MethodPost, "/repos/"+repo.Organization+"/"+repo.Name+"/keys")
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		ID int64 `json:"id"`
+	}
+	body := map[string]interface{}{"title": title, "key": strings.TrimSpace(publicKey), "read_only": true}
+	if err := sendJSON(provider.Client, request, body, &result); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(result.ID, 10), err
+}
+
+// RemoveDeployKey deletes a deploy key from a GitHub repository
+func (provider GitHubProvider) RemoveDeployKey(repo GitRepository, id string) (err error) {
+	request, err := provider.request(http.MethodDelete, "/repos/"+repo.Organization+"/"+repo.Name+"/keys/"+id)
+	if err != nil {
+		return err
+	}
+	return sendJSON(provider.Client, request, nil, nil)
+}
+
+// GitLabProvider registers deploy keys through the GitLab REST API
+type GitLabProvider struct {
+	BaseURL string // Defaults to https://<repository host>
+	Token   string
+	Client  *http.Client
+}
+
+func (provider GitLabProvider) request(method string, repo GitRepository, path string) (*http.Request, error) {
+	baseURL := provider.BaseURL
+	if baseURL == "" {
+		baseURL = "https://" + repo.Host
+	}
+	// GitLab addresses projects by their url encoded path (e.g. group%2Fproject)
+	project := url.PathEscape(repo.Path())
+	request, err := http.NewRequest(method, strings.TrimSuffix(baseURL, "/")+"/api/v4/projects/"+project+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("PRIVATE-TOKEN", provider.Token)
+	return request, nil
+}
+
+// AddDeployKey registers a read-only deploy key with a GitLab project
+func (provider GitLabProvider) AddDeployKey(repo GitRepository, title string, publicKey string) (id string, err error) {
+	request, err := provider.request(http.MethodPost, repo, "/deploy_keys")
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		ID int64 `json:"id"`
+	}
+	body := map[string]interface{}{"title": title, "key": strings.TrimSpace(publicKey), "can_push": false}
+	if err := sendJSON(provider.Client, request, body, &result); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(result.ID, 10), err
+}
+
+// RemoveDeployKey deletes a deploy key from a GitLab project
+func (provider GitLabProvider) RemoveDeployKey(repo GitRepository, id string) (err error) {
+	request, err := provider.request(http.MethodDelete, repo, "/deploy_keys/"+id)
+	if err != nil {
+		return err
+	}
+	return sendJSON(provider.Client, request, nil, nil)
+}
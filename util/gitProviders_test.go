@@ -0,0 +1,108 @@
+package util
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseGitopsURL(t *testing.T) {
+	cases := map[string]GitRepository{
+		"git@github.com:timfpark/fabrikate-cloud-native-manifests.git": {Provider: GITHUB, Host: "github.com", Organization: "timfpark", Name: "fabrikate-cloud-native-manifests"},
+		"ssh://git@github.com/microsoft/bedrock.git":                   {Provider: GITHUB, Host: "github.com", Organization: "microsoft", Name: "bedrock"},
+		"git@ssh.dev.azure.com:v3/epicstuff/bedrock/manifests":         {Provider: AZUREDEVOPS, Host: "ssh.dev.azure.com", Organization: "epicstuff", Project: "bedrock", Name: "manifests"},
+		"git@gitlab.com:group/subgroup/manifests.git":                  {Provider: GITLAB, Host: "gitlab.com", Organization: "group/subgroup", Name: "manifests"},
+	}
+	for sshURL, expected := range cases {
+		repo, err := ParseGitopsURL(sshURL)
+		if err != nil {
+			t.Errorf("Unable to parse %s: %s", sshURL, err)
+		}
+		if repo != expected {
+			t.Errorf("ParseGitopsURL(%s) = %+v, expected %+v", sshURL, repo, expected)
+		}
+	}
+
+	for _, sshURL := range []string{"https://github.com/microsoft/bedrock", "git@bitbucket.org:team/repo.git", "git@ssh.dev.azure.com:org/repo"} {
+		if _, err := ParseGitopsURL(sshURL); err == nil {
+			t.Errorf("Expected an error parsing %s", sshURL)
+		}
+	}
+}
+
+// deployKeyServer records the requests made to it and answers with the given response
+func deployKeyServer(t *testing.T, response string, requests *[]*http.Request, bodies *[]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		if r.Method == http.MethodPost {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error("Request body is not valid JSON:", err)
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(response))
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		*requests = append(*requests, r)
+		*bodies = append(*bodies, body)
+	}))
+}
+
+func TestDeployKeyProviders(t *testing.T) {
+	cases := []struct {
+		repo     GitRepository
+		provider func(baseURL string) DeployKeyProvider
+		response string
+		addPath  string
+		delPath  string
+		readOnly func(body map[string]interface{}) bool
+	}{
+		{
+			repo:     GitRepository{Provider: GITHUB, Host: "github.com", Organization: "owner", Name: "manifests"},
+			provider: func(baseURL string) DeployKeyProvider { return GitHubProvider{BaseURL: baseURL, Token: "token"} },
+			response: `{"id": 42}`,
+			addPath:  "/repos/owner/manifests/keys",
+			delPath:  "/repos/owner/manifests/keys/42",
+			readOnly: func(body map[string]interface{}) bool { return body["read_only"] == true },
+		},
+		{
+			repo:     GitRepository{Provider: GITLAB, Host: "gitlab.com", Organization: "group/sub", Name: "manifests"},
+			provider: func(baseURL string) DeployKeyProvider { return GitLabProvider{BaseURL: baseURL, Token: "token"} },
+			response: `{"id": 42}`,
+			addPath:  "/api/v4/projects/group%2Fsub%2Fmanifests/deploy_keys",
+			delPath:  "/api/v4/projects/group%2Fsub%2Fmanifests/deploy_keys/42",
+			readOnly: func(body map[string]interface{}) bool { return body["can_push"] == false },
+		},
+	}
+
+	for _, c := range cases {
+		var requests []*http.Request
+		var bodies []map[string]interface{}
+		server := deployKeyServer(t, c.response, &requests, &bodies)
+		provider := c.provider(server.URL)
+
+		id, err := provider.AddDeployKey(c.repo, "bedrock-test", "ssh-ed25519 AAAA test\n")
+		if err != nil || id != "42" {
+			t.Errorf("%s: AddDeployKey returned %q, %v", c.repo.Provider, id, err)
+		}
+		if err := provider.RemoveDeployKey(c.repo, id); err != nil {
+			t.Errorf("%s: RemoveDeployKey returned %v", c.repo.Provider, err)
+		}
+		server.Close()
+
+		if len(requests) != 2 {
+			t.Errorf("%s: expected 2 requests, got %d", c.repo.Provider, len(requests))
+			continue
+		}
+		if requests[0].Method != http.MethodPost || requests[0].URL.EscapedPath() != c.addPath {
+			t.Errorf("%s: unexpected request %s %s", c.repo.Provider, requests[0].Method, requests[0].URL.EscapedPath())
+		}
+		if requests[1].Method != http.MethodDelete || requests[1].URL.EscapedPath() != c.delPath {
+			t.Errorf("%s: unexpected request %s %s", c.repo.Provider, requests[1].Method, requests[1].URL.EscapedPath())
+		}
+		if !c.readOnly(bodies[0]) {
+			t.Errorf("%s: deploy key was not registered as read-only: %v", c.repo.Provider, bodies[0])
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+)
+
+// gitopsAuth returns the credentials used to reach the repository with the deploy key.
+// Local repositories (used for testing) do not need any credentials.
+func gitopsAuth(repoURL string, keyPath string) (auth transport.AuthMethod, err error) {
+	endpoint, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Protocol != "ssh" || keyPath == "" {
+		return nil, err
+	}
+	return gitssh.NewPublicKeysFromFile(endpoint.User, keyPath, "")
+}
+
+// VerifyGitopsRepository checks that the GitOps repository can be reached with the deploy key,
+// that the branch exists and that the path exists on that branch
+func VerifyGitopsRepository(repoURL string, keyPath string, branch string, path string) (err error) {
+	log.Info(emoji.Sprintf(":mag_right: Verifying access to GitOps repository %s", repoURL))
+
+	auth, err := gitopsAuth(repoURL, keyPath)
+	if err != nil {
+		log.Error(emoji.Sprintf(":no_entry_sign: Unable to load the deploy key %s: %s", keyPath, err))
+		return err
+	}
+
+	// Equivalent to `git ls-remote`
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: "origin", URLs: []string{repoURL}})
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		log.Error(emoji.Sprintf(":no_entry_sign: Unable to reach %s with the deploy key: %s", repoURL, err))
+		log.Error(emoji.Sprintf(":bulb: Make sure the deploy key is registered with the repository and the host is in ~/.ssh/known_hosts"))
+		return err
+	}
+
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	found := false
+	for _, ref := range refs {
+		if ref.Name() == branchRef {
+			found = true
+			break
+		}
+	}
+	if !found {
+		log.Error(emoji.Sprintf(":no_entry_sign: Branch '%s' does not exist in %s", branch, repoURL))
+		return errors.New("Branch '" + branch + "' does not exist in " + repoURL)
+	}
+
+	path = strings.Trim(path, "/")
+	if path == "" || path == "." {
+		log.Info(emoji.Sprintf(":white_check_mark: GitOps repository and branch '%s' are reachable", branch))
+		return err
+	}
+
+	// Fetch only the tip of the branch to look up the path
+	repo, err := git.Clone(memory.NewStorage(), nil, &git.CloneOptions{
+		URL:           repoURL,
+		Auth:          auth,
+		ReferenceName: branchRef,
+		SingleBranch:  true,
+		Depth:         1,
+		NoCheckout:    true,
+	})
+	if err != nil {
+		log.Error(emoji.Sprintf(":no_entry_sign: Unable to fetch branch '%s' of %s: %s", branch, repoURL, err))
+		return err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	if _, err := tree.FindEntry(path); err != nil {
+		log.Error(emoji.Sprintf(":no_entry_sign: Path '%s' does not exist on branch '%s' of %s", path, branch, repoURL))
+		return errors.New("Path '" + path + "' does not exist on branch '" + branch + "' of " + repoURL)
+	}
+
+	log.Info(emoji.Sprintf(":white_check_mark: GitOps repository, branch '%s' and path '%s' are reachable", branch, path))
+	return err
+}
@@ -0,0 +1,63 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// bareManifestRepository creates a bare repository containing prod/app.yaml on its default branch
+func bareManifestRepository(t *testing.T, dir string) (url string, branch string) {
+	workDir := filepath.Join(dir, "work")
+	repo, err := git.PlainInit(workDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(workDir, "prod"), os.ModePerm)
+	if err := ioutil.WriteFile(filepath.Join(workDir, "prod", "app.yaml"), []byte("kind: Namespace\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	worktree, _ := repo.Worktree()
+	worktree.Add("prod/app.yaml")
+	signature := &object.Signature{Name: "bedrock", Email: "bedrock@example.com", When: time.Now()}
+	if _, err := worktree.Commit("Add manifests", &git.CommitOptions{Author: signature}); err != nil {
+		t.Fatal(err)
+	}
+	head, _ := repo.Head()
+
+	bareDir := filepath.Join(dir, "manifests.git")
+	if _, err := git.PlainClone(bareDir, true, &git.CloneOptions{URL: workDir}); err != nil {
+		t.Fatal(err)
+	}
+	return bareDir, head.Name().Short()
+}
+
+func TestVerifyGitopsRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bedrock-gitops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	url, branch := bareManifestRepository(t, dir)
+
+	if err := VerifyGitopsRepository(url, "", branch, ""); err != nil {
+		t.Error("Repository root could not be verified:", err)
+	}
+	if err := VerifyGitopsRepository(url, "", branch, "prod"); err != nil {
+		t.Error("Existing path could not be verified:", err)
+	}
+	if err := VerifyGitopsRepository(url, "", "does-not-exist", ""); err == nil {
+		t.Error("Expected missing branch to fail verification")
+	}
+	if err := VerifyGitopsRepository(url, "", branch, "staging"); err == nil {
+		t.Error("Expected missing path to fail verification")
+	}
+	if err := VerifyGitopsRepository(filepath.Join(dir, "missing.git"), "", branch, ""); err == nil {
+		t.Error("Expected unreachable repository to fail verification")
+	}
+}
@@ -2,6 +2,8 @@ package util
 
 import (
 	"bufio"
+	"io"
+	"os"
 	"os/exec"
 
 	"github.com/kyokomi/emoji"
@@ -105,3 +107,20 @@ func TerraformApply(directory string) (err error) {
 	log.Info(emoji.Sprintf(":thumbsup: Terraform Apply Complete!"))
 	return runErr
 }
+
+// TerraformRun runs terraform with the given arguments in directory. env is added to the environment
+// of the process, and the output is written to out as it is produced.
+func TerraformRun(directory string, env []string, out io.Writer, args ...string) (err error) {
+	return TerraformRunEnviron(directory, append(os.Environ(), env...), out, args...)
+}
+
+// TerraformRunEnviron runs terraform like TerraformRun, with environ as its whole environment instead of
+// the one of the process
+func TerraformRunEnviron(directory string, environ []string, out io.Writer, args ...string) (err error) {
+	cmd := exec.Command("terraform", args...)
+	cmd.Dir = directory
+	cmd.Env = environ
+	cmd.Stdout = out
+	cmd.Stderr = out
+	return cmd.Run()
+}